@@ -3,11 +3,25 @@ module github.com/tphoney/best_practice
 go 1.18
 
 require (
+	github.com/BurntSushi/toml v1.3.2
 	github.com/Masterminds/semver v1.5.0
+	github.com/google/go-jsonnet v0.20.0
 	github.com/kelseyhightower/envconfig v1.4.0
+	github.com/moby/buildkit v0.11.6
+	github.com/pmezard/go-difflib v1.0.0
 	github.com/sirupsen/logrus v1.9.0
+	go.starlark.net v0.0.0-20230525235612-a134d8f9ddca
 	golang.org/x/exp v0.0.0-20220722155223-a9213eeb770e
+	golang.org/x/mod v0.14.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
-require golang.org/x/sys v0.0.0-20220730100132-1609e554cd39 // indirect
+require (
+	github.com/containerd/typeurl v1.0.2 // indirect
+	github.com/gogo/protobuf v1.3.2 // indirect
+	github.com/pkg/errors v0.9.1 // indirect
+	golang.org/x/sys v0.3.0 // indirect
+	google.golang.org/protobuf v1.28.1 // indirect
+	gopkg.in/yaml.v2 v2.2.7 // indirect
+	sigs.k8s.io/yaml v1.1.0 // indirect
+)
@@ -6,6 +6,7 @@ package main
 
 import (
 	"context"
+	"flag"
 
 	"github.com/tphoney/best_practice/plugin"
 
@@ -20,6 +21,10 @@ func main() {
 	if err := envconfig.Process("", &args); err != nil {
 		logrus.Fatalln(err)
 	}
+	flag.BoolVar(&args.Fix, "fix", args.Fix, "apply the drone build analysis suggestions to the drone file")
+	flag.BoolVar(&args.Merge, "merge", args.Merge, "add the generated steps to an existing drone file")
+	flag.BoolVar(&args.Offline, "offline", args.Offline, "skip the checks that need network access")
+	flag.Parse()
 
 	switch args.Level {
 	case "debug":
@@ -11,26 +11,22 @@ import (
 )
 
 const (
-	Name           = outputter.BuildMaker
-	droneBuildRoot = `kind: pipeline
-type: docker
-name: default
+	Name                  = outputter.BuildMaker
+	defaultPipelineName   = "default"
+	droneFileName         = ".drone.yml"
+	droneStarlarkFileName = ".drone.star"
+	cieFileName           = ".cie.yml"
+	githubFileName        = ".github/workflows/ci.yml"
+	gitlabFileName        = ".gitlab-ci.yml"
+	jenkinsFileName       = "Jenkinsfile"
+	newFileSuffix         = ".new"
 
-platform:
-  os: linux
-  arch: amd64
-
-steps:`
-	cieBuildRoot = `pipeline:
-  stages:
-    - identifier: default
-      name: default
-      steps:`
-)
-
-var (
-	droneFileName = ".drone.yml"
-	cieFileName   = ".cie.yml"
+	// build systems that can be generated
+	TargetDrone   = "drone"
+	TargetCIE     = "cie"
+	TargetGitHub  = "github"
+	TargetGitLab  = "gitlab"
+	TargetJenkins = "jenkins"
 )
 
 type (
@@ -41,11 +37,44 @@ type (
 	}
 
 	Build struct {
-		Name        string   `json:"name" yaml:"name"`
-		Image       string   `json:"image" yaml:"image"`
-		Commands    []string `json:"commands" yaml:"commands"`
-		DroneAppend string   `json:"drone_append" yaml:"drone_append"`
-		CIEAppend   string   `json:"cie_append" yaml:"cie_append"`
+		Name     string                 `json:"name" yaml:"name"`
+		Image    string                 `json:"image" yaml:"image"`
+		Commands []string               `json:"commands" yaml:"commands"`
+		Settings map[string]interface{} `json:"settings,omitempty" yaml:"settings,omitempty"`
+		// Secrets are environment variables of the step read from the secret store, keyed by the variable name.
+		Secrets    map[string]Secret `json:"secrets,omitempty" yaml:"secrets,omitempty"`
+		Privileged bool              `json:"privileged,omitempty" yaml:"privileged,omitempty"`
+		Volumes    []VolumeMount     `json:"volumes,omitempty" yaml:"volumes,omitempty"`
+		DependsOn  []string          `json:"depends_on,omitempty" yaml:"depends_on,omitempty"`
+		When       *Condition        `json:"when,omitempty" yaml:"when,omitempty"`
+		// Phase is used to order the steps, see the Phase constants.
+		Phase string `json:"phase,omitempty" yaml:"phase,omitempty"`
+		// Cache names the language cache the step uses, see the Cache constants.
+		Cache string `json:"cache,omitempty" yaml:"cache,omitempty"`
+		// Language and LanguageVersion tell build systems that install a toolchain which one to set up.
+		Language        string `json:"language,omitempty" yaml:"language,omitempty"`
+		LanguageVersion string `json:"language_version,omitempty" yaml:"language_version,omitempty"`
+		// TestReports are junit report globs written by the step.
+		TestReports []string `json:"test_reports,omitempty" yaml:"test_reports,omitempty"`
+		// Artifacts are files written by the step that are kept after the build, eg a coverage profile.
+		Artifacts []string `json:"artifacts,omitempty" yaml:"artifacts,omitempty"`
+		// LanguageVersions are all of the versions the project supports, oldest first. More than one builds a matrix.
+		LanguageVersions []string `json:"language_versions,omitempty" yaml:"language_versions,omitempty"`
+		// Service runs the image in the background for the whole pipeline rather than as a step, eg a database for the
+		// tests. Environment and Ports are only used by services, the test steps wait for the ports to open.
+		Service     bool              `json:"service,omitempty" yaml:"service,omitempty"`
+		Environment map[string]string `json:"environment,omitempty" yaml:"environment,omitempty"`
+		Ports       []int             `json:"ports,omitempty" yaml:"ports,omitempty"`
+		// Platform is where the step has to run, steps for a platform other than linux/amd64 get a pipeline of their
+		// own. Steps in the manifest phase run after the pipelines of every platform.
+		Platform *Platform `json:"platform,omitempty" yaml:"platform,omitempty"`
+		// Binaries are the release binaries the image of a platform copies in, eg 'release/linux/arm/plugin'. The go
+		// build steps cross compile them for the platform.
+		Binaries []string `json:"binaries,omitempty" yaml:"binaries,omitempty"`
+		// matrixVersion is set on the copies of the build made for each matrix version.
+		matrixVersion string
+		// waitFor is set on the step that waits for the services.
+		waitFor []string
 	}
 
 	outputterConfig struct {
@@ -56,6 +85,17 @@ type (
 		outputToFile     bool
 		outputDrone      bool
 		outputCIE        bool
+		outputGitHub     bool
+		outputGitLab     bool
+		outputJenkins    bool
+		mergeDrone       bool
+		droneStarlark    bool
+		cieConfig        CIEConfig
+		templateDir      string
+		availableChecks  map[string][]string
+		templates        *stepTemplates
+		digestFile       string
+		digests          map[string]string
 	}
 )
 
@@ -67,6 +107,20 @@ func New(opts ...Option) (types.Outputter, error) {
 	for _, opt := range opts {
 		opt(oc)
 	}
+	if oc.templateDir != "" {
+		templates, err := loadTemplates(oc.templateDir, oc.availableChecks)
+		if err != nil {
+			return nil, err
+		}
+		oc.templates = templates
+	}
+	if oc.digestFile != "" {
+		digests, err := loadDigests(oc.digestFile)
+		if err != nil {
+			return nil, err
+		}
+		oc.digests = digests
+	}
 
 	return oc, nil
 }
@@ -96,74 +150,130 @@ func (oc outputterConfig) Output(ctx context.Context, scanResults []types.Scanle
 		fmt.Printf("- %s, %s\n", result.ScannerFamily, result.Description)
 	}
 	fmt.Println("")
-	droneBuildOutput := droneBuildRoot
-	cieBuildOutput := cieBuildRoot
-	// add the steps to the build file
+	builds := make([]Build, 0, len(results))
+	for _, result := range results {
+		builds = append(builds, result.Spec.(OutputFields).Build)
+	}
+	matrix := findMatrix(builds)
+	builds = builds[:0]
 	for _, result := range results {
-		dbo := result.Spec.(OutputFields)
-		// build drone step
-		if oc.outputDrone {
-			droneBuildOutput += fmt.Sprintf(`
-  - name: %s
-    image: %s`, dbo.Name, dbo.Image)
-			if len(dbo.Commands) > 0 {
-				droneBuildOutput += "\n    commands:"
-				for _, command := range dbo.Commands {
-					droneBuildOutput += fmt.Sprintf("\n      - %s", command)
+		for _, build := range expandBuild(result.Spec.(OutputFields).Build, matrix) {
+			if oc.templates != nil {
+				var err error
+				if build, err = oc.templates.apply(result.ScannerFamily, result.Name, build); err != nil {
+					return err
 				}
 			}
-			if dbo.DroneAppend != "" {
-				droneBuildOutput += fmt.Sprintf(`
-  %s`, dbo.DroneAppend)
+			if digest, ok := oc.digests[build.Image]; ok {
+				build.Image += "@" + digest
 			}
+			builds = append(builds, build)
+		}
+	}
+	pipeline := buildPipeline(defaultPipelineName, builds, matrix)
+	if oc.outputDrone && oc.outputToFile && oc.mergeDrone {
+		merged, err := oc.mergeDroneFile(&pipeline)
+		if err != nil {
+			return err
 		}
-		// build cie step
-		if oc.outputCIE {
-			cieBuildOutput += fmt.Sprintf(`
-      - identifier: %s
-        name: %s
-        spec:
-          connectorRef: account.docker
-          image: %s
-          type: Plugin`, dbo.Name, dbo.Name, dbo.Image)
+		if merged {
+			oc.outputDrone = false
 		}
 	}
-
-	if oc.stdOutput {
-		if oc.outputDrone {
-			fmt.Printf("Drone build file:\n%s\n", droneBuildOutput)
-			fmt.Println(droneBuildOutput)
+	if oc.outputDrone && oc.droneStarlark && pipeline.Matrix != nil {
+		content, err := renderDroneStarlark(&pipeline, oc.workingDirectory)
+		if err != nil {
+			return err
+		}
+		if err = oc.writeBuildFile("Drone", droneStarlarkFileName, content); err != nil {
+			return err
 		}
-		if oc.outputCIE {
-			fmt.Printf("CIE build file:\n%s\n", cieBuildOutput)
-			fmt.Println(cieBuildOutput)
+	} else if oc.outputDrone {
+		content, err := renderDrone(&pipeline)
+		if err != nil {
+			return err
+		}
+		if err = oc.writeBuildFile("Drone", droneFileName, content); err != nil {
+			return err
 		}
 	}
-	if oc.outputToFile {
-		if oc.outputDrone {
-			_, err := os.Stat(filepath.Join(oc.workingDirectory, droneFileName))
-			if err == nil {
-				// file exists append .new to the file name
-				droneFileName += ".new"
-			}
-			fmt.Printf("Created a new Drone Build file '%s'\n", filepath.Join(oc.workingDirectory, droneFileName))
-			writeErr := outputter.WriteToFile(filepath.Join(oc.workingDirectory, droneFileName), droneBuildOutput)
-			if writeErr != nil {
-				return writeErr
-			}
+	if oc.outputCIE {
+		content, err := renderCIE(&pipeline, &oc.cieConfig)
+		if err != nil {
+			return err
 		}
-		if oc.outputCIE {
-			_, err := os.Stat(filepath.Join(oc.workingDirectory, cieFileName))
-			if err == nil {
-				// file exists append .new to the file name
-				droneFileName += ".new"
-			}
-			fmt.Printf("Created a new CIE Build file '%s'\n", filepath.Join(oc.workingDirectory, cieFileName))
-			writeErr := outputter.WriteToFile(filepath.Join(oc.workingDirectory, cieFileName), cieBuildOutput)
-			if writeErr != nil {
-				return writeErr
-			}
+		if err = oc.writeBuildFile("CIE", cieFileName, content); err != nil {
+			return err
+		}
+	}
+	if oc.outputGitHub {
+		content, err := renderGitHub(&pipeline)
+		if err != nil {
+			return err
+		}
+		if err = oc.writeBuildFile("GitHub Actions", githubFileName, content); err != nil {
+			return err
+		}
+	}
+	if oc.outputGitLab {
+		content, err := renderGitLab(&pipeline)
+		if err != nil {
+			return err
+		}
+		if err = oc.writeBuildFile("GitLab", gitlabFileName, content); err != nil {
+			return err
+		}
+	}
+	if oc.outputJenkins {
+		content, err := renderJenkins(&pipeline)
+		if err != nil {
+			return err
+		}
+		if err = oc.writeBuildFile("Jenkins", jenkinsFileName, content); err != nil {
+			return err
 		}
 	}
 	return nil
 }
+
+// mergeDroneFile adds the missing steps to an existing drone file, it returns false if there is no file to merge into.
+func (oc outputterConfig) mergeDroneFile(pipeline *Pipeline) (bool, error) {
+	path := filepath.Join(oc.workingDirectory, droneFileName)
+	if _, err := os.Stat(path); err != nil {
+		return false, nil
+	}
+	pipeline.printSkippedPlatforms("merged drone file")
+	changes, diff, err := mergeDrone(path, pipeline)
+	if err != nil {
+		return true, err
+	}
+	if len(changes) == 0 {
+		fmt.Printf("Drone Build file '%s' already has all of the generated steps\n", path)
+		return true, nil
+	}
+	fmt.Printf("Merged the following into the Drone Build file '%s':\n", path)
+	for _, change := range changes {
+		fmt.Printf("- %s\n", change)
+	}
+	fmt.Println(diff)
+	return true, nil
+}
+
+func (oc outputterConfig) writeBuildFile(buildSystem, fileName string, content []byte) error {
+	if oc.stdOutput {
+		fmt.Printf("%s build file:\n%s\n", buildSystem, content)
+	}
+	if !oc.outputToFile {
+		return nil
+	}
+	path := filepath.Join(oc.workingDirectory, fileName)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil { //nolint:gosec
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		// file exists append .new to the file name
+		path += newFileSuffix
+	}
+	fmt.Printf("Created a new %s Build file '%s'\n", buildSystem, path)
+	return outputter.WriteToFile(path, string(content))
+}
@@ -0,0 +1,175 @@
+package buildmaker
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// testBuilds are the builds of a go project with a database for its tests, a docker image and a tag release.
+func testBuilds() []Build {
+	return []Build{
+		{
+			Name: "go unit tests", Phase: PhaseTest, Cache: CacheGo, Image: "golang:1",
+			Language: LanguageGo, LanguageVersion: "1.21",
+			Commands: []string{"go test -race ./..."},
+		},
+		{
+			Name: "go lint", Phase: PhaseLint, Cache: CacheGo, Image: "golangci/golangci-lint",
+			Language: LanguageGo, LanguageVersion: "1.21",
+			Commands: []string{"golangci-lint run --timeout 500s"},
+		},
+		{
+			Name: "postgres", Image: "postgres:16", Service: true,
+			Environment: map[string]string{"POSTGRES_PASSWORD": "postgres"},
+			Ports:       []int{5432},
+		},
+		{
+			Name: "docker build Dockerfile", Phase: PhasePackage, Image: "plugins/docker", Privileged: true,
+			Settings: map[string]interface{}{
+				"repo":       "organization/docker-image-name",
+				"dockerfile": "Dockerfile",
+				"username":   Secret("docker_username"),
+				"password":   Secret("docker_password"),
+			},
+		},
+		{
+			Name: "goreleaser", Phase: PhaseRelease, Image: "goreleaser/goreleaser",
+			Commands: []string{"goreleaser release --clean"},
+			Secrets:  map[string]Secret{"GITHUB_TOKEN": "github_token"},
+			When:     &Condition{Ref: []string{"refs/tags/*"}},
+		},
+		{
+			Name: "coverage", Phase: PhaseTest, Image: "alpine:3",
+			Commands: []string{"./upload-coverage.sh"},
+			Secrets:  map[string]Secret{"COVERAGE_TOKEN": "coverage_token"},
+			When:     &Condition{Branch: []string{"main", "develop"}, Event: []string{"push"}},
+		},
+	}
+}
+
+// testPipeline builds a pipeline the way Output does, expanding the builds for the matrix versions.
+func testPipeline(builds []Build) Pipeline {
+	matrix := findMatrix(builds)
+	var expanded []Build
+	for i := range builds {
+		expanded = append(expanded, expandBuild(builds[i], matrix)...)
+	}
+	return buildPipeline(defaultPipelineName, expanded, matrix)
+}
+
+// readDrone reads every pipeline of a drone file.
+func readDrone(t *testing.T, content []byte) (pipelines []dronePipeline) {
+	t.Helper()
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	for {
+		var pipeline dronePipeline
+		err := decoder.Decode(&pipeline)
+		if errors.Is(err, io.EOF) {
+			return pipelines
+		}
+		if err != nil {
+			t.Fatalf("invalid drone file: %s\n%s", err, content)
+		}
+		pipelines = append(pipelines, pipeline)
+	}
+}
+
+func stepNames(steps []Step) (names []string) {
+	for i := range steps {
+		names = append(names, steps[i].Name)
+	}
+	return names
+}
+
+func TestBuildPipeline(t *testing.T) {
+	pipeline := testPipeline(testBuilds())
+	want := []string{"go lint", "wait for services", "go unit tests", "coverage", "docker build Dockerfile", "goreleaser"}
+	if got := stepNames(pipeline.Steps); !reflect.DeepEqual(got, want) {
+		t.Errorf("got steps %v, want %v", got, want)
+	}
+	if len(pipeline.Services) != 1 || pipeline.Services[0].Name != "postgres" {
+		t.Errorf("got services %v", pipeline.Services)
+	}
+	if pipeline.Matrix != nil {
+		t.Errorf("expected no matrix for a single go version, got %v", pipeline.Matrix)
+	}
+}
+
+func TestBuildPipelineWithoutTests(t *testing.T) {
+	var builds []Build
+	for _, build := range testBuilds() {
+		if build.Phase != PhaseTest {
+			builds = append(builds, build)
+		}
+	}
+	// the services are only started for the tests
+	if pipeline := testPipeline(builds); len(pipeline.Services) != 0 {
+		t.Errorf("expected no services, got %v", pipeline.Services)
+	}
+}
+
+func TestJoinConditions(t *testing.T) {
+	tests := []struct {
+		groups [][]string
+		want   string
+	}{
+		{groups: nil, want: ""},
+		{groups: [][]string{{"a", "b"}}, want: "a || b"},
+		{groups: [][]string{{"a"}, nil, {"c"}}, want: "a && c"},
+		{groups: [][]string{{"a", "b"}, {"c"}}, want: "(a || b) && c"},
+		{groups: [][]string{{"f(x)"}, {"c", "d"}}, want: "f(x) && (c || d)"},
+	}
+	for _, test := range tests {
+		if got := joinConditions(test.groups, " || ", " && "); got != test.want {
+			t.Errorf("joinConditions(%v) = '%s', want '%s'", test.groups, got, test.want)
+		}
+	}
+}
+
+func TestRenderDrone(t *testing.T) {
+	pipeline := testPipeline(testBuilds())
+	content, err := renderDrone(&pipeline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipelines := readDrone(t, content)
+	if len(pipelines) != 1 {
+		t.Fatalf("expected one pipeline, got %d", len(pipelines))
+	}
+	out := pipelines[0]
+	if out.Kind != "pipeline" || out.Type != "docker" || out.Name != defaultPipelineName {
+		t.Errorf("unexpected pipeline header %s/%s/%s", out.Kind, out.Type, out.Name)
+	}
+	if len(out.Steps) != len(pipeline.Steps) {
+		t.Fatalf("got %d steps, want %d", len(out.Steps), len(pipeline.Steps))
+	}
+	if len(out.Services) != 1 || out.Services[0].Image != "postgres:16" {
+		t.Errorf("got services %v", out.Services)
+	}
+	for i := range out.Steps {
+		step := &out.Steps[i]
+		switch step.Name {
+		case "goreleaser":
+			if step.When == nil || !reflect.DeepEqual(step.When.Ref, []string{"refs/tags/*"}) {
+				t.Errorf("goreleaser runs when %v", step.When)
+			}
+			want := map[string]interface{}{"GITHUB_TOKEN": map[string]interface{}{"from_secret": "github_token"}}
+			if !reflect.DeepEqual(step.Environment, want) {
+				t.Errorf("goreleaser has the environment %v", step.Environment)
+			}
+		case "go unit tests":
+			if len(step.Volumes) == 0 {
+				t.Error("the go tests do not mount the go cache")
+			}
+		case "docker build Dockerfile":
+			if !step.Privileged {
+				t.Error("docker build is not privileged")
+			}
+		}
+	}
+}
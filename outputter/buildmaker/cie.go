@@ -0,0 +1,549 @@
+package buildmaker
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultCIEDockerConnector   = "account.harnessImage"
+	defaultCIECodebaseConnector = "<+input>"
+	defaultCIEOrg               = "default"
+	defaultCIEProject           = "default_project"
+	// cieMaxLength is the longest name or identifier harness accepts
+	cieMaxLength = 128
+)
+
+var (
+	cieIdentifierPattern = regexp.MustCompile(`^[a-zA-Z_][0-9a-zA-Z_$]{0,127}$`)
+	// cieArchitectures are the cpu architectures harness builds on
+	cieArchitectures = map[string]string{"amd64": "Amd64", "arm64": "Arm64"}
+)
+
+type (
+	// CIEConfig holds the account specific parts of a harness pipeline.
+	CIEConfig struct {
+		OrgIdentifier     string
+		ProjectIdentifier string
+		// DockerConnector is used to pull step images.
+		DockerConnector string
+		// CodebaseConnector is the git connector used to clone the repository.
+		CodebaseConnector string
+		// RepoName is needed when the codebase connector is an account level connector.
+		RepoName string
+		// KubernetesConnector runs the stage on a kubernetes cluster instead of harness cloud.
+		KubernetesConnector string
+		Namespace           string
+	}
+
+	ciePipeline struct {
+		Pipeline cieSpec `yaml:"pipeline"`
+	}
+
+	cieSpec struct {
+		Name              string        `yaml:"name"`
+		Identifier        string        `yaml:"identifier"`
+		OrgIdentifier     string        `yaml:"orgIdentifier"`
+		ProjectIdentifier string        `yaml:"projectIdentifier"`
+		Properties        cieProperties `yaml:"properties"`
+		Stages            []cieStageRef `yaml:"stages"`
+	}
+
+	cieProperties struct {
+		CI struct {
+			Codebase cieCodebase `yaml:"codebase"`
+		} `yaml:"ci"`
+	}
+
+	cieCodebase struct {
+		ConnectorRef string `yaml:"connectorRef"`
+		RepoName     string `yaml:"repoName,omitempty"`
+		Build        string `yaml:"build"`
+	}
+
+	cieStageRef struct {
+		Stage cieStage `yaml:"stage"`
+	}
+
+	cieStage struct {
+		Name       string       `yaml:"name"`
+		Identifier string       `yaml:"identifier"`
+		Type       string       `yaml:"type"`
+		Strategy   *cieStrategy `yaml:"strategy,omitempty"`
+		Spec       cieStageSpec `yaml:"spec"`
+	}
+
+	cieStrategy struct {
+		Matrix map[string][]string `yaml:"matrix"`
+	}
+
+	cieStageSpec struct {
+		CloneCodebase  bool               `yaml:"cloneCodebase"`
+		Platform       *ciePlatform       `yaml:"platform,omitempty"`
+		Runtime        *cieRuntime        `yaml:"runtime,omitempty"`
+		Infrastructure *cieInfrastructure `yaml:"infrastructure,omitempty"`
+		SharedPaths    []string           `yaml:"sharedPaths,omitempty"`
+		Execution      cieExecution       `yaml:"execution"`
+	}
+
+	ciePlatform struct {
+		OS   string `yaml:"os"`
+		Arch string `yaml:"arch"`
+	}
+
+	cieRuntime struct {
+		Type string   `yaml:"type"`
+		Spec struct{} `yaml:"spec"`
+	}
+
+	cieInfrastructure struct {
+		Type string `yaml:"type"`
+		Spec struct {
+			ConnectorRef string            `yaml:"connectorRef"`
+			Namespace    string            `yaml:"namespace"`
+			OS           string            `yaml:"os"`
+			NodeSelector map[string]string `yaml:"nodeSelector,omitempty"`
+		} `yaml:"spec"`
+	}
+
+	cieExecution struct {
+		Steps []cieExecutionElement `yaml:"steps"`
+	}
+
+	// cieExecutionElement is either a single step or a group of parallel steps.
+	cieExecutionElement struct {
+		Step     *cieStep              `yaml:"step,omitempty"`
+		Parallel []cieExecutionElement `yaml:"parallel,omitempty"`
+	}
+
+	cieStep struct {
+		Identifier string       `yaml:"identifier"`
+		Name       string       `yaml:"name"`
+		Type       string       `yaml:"type"`
+		Spec       cieStepSpec  `yaml:"spec"`
+		When       *cieStepWhen `yaml:"when,omitempty"`
+	}
+
+	cieStepSpec struct {
+		ConnectorRef string                 `yaml:"connectorRef"`
+		Image        string                 `yaml:"image"`
+		Shell        string                 `yaml:"shell,omitempty"`
+		Command      string                 `yaml:"command,omitempty"`
+		Privileged   bool                   `yaml:"privileged,omitempty"`
+		Settings     map[string]interface{} `yaml:"settings,omitempty"`
+		EnvVariables map[string]string      `yaml:"envVariables,omitempty"`
+	}
+
+	cieStepWhen struct {
+		StageStatus string `yaml:"stageStatus"`
+		Condition   string `yaml:"condition,omitempty"`
+	}
+)
+
+func (c *CIEConfig) withDefaults() CIEConfig {
+	config := *c
+	if config.OrgIdentifier == "" {
+		config.OrgIdentifier = defaultCIEOrg
+	}
+	if config.ProjectIdentifier == "" {
+		config.ProjectIdentifier = defaultCIEProject
+	}
+	if config.DockerConnector == "" {
+		config.DockerConnector = defaultCIEDockerConnector
+	}
+	if config.CodebaseConnector == "" {
+		config.CodebaseConnector = defaultCIECodebaseConnector
+	}
+	if config.Namespace == "" {
+		config.Namespace = "default"
+	}
+	return config
+}
+
+func renderCIE(pipeline *Pipeline, cieConfig *CIEConfig) ([]byte, error) {
+	config := cieConfig.withDefaults()
+	// the stages are named like the drone pipelines
+	build := cieStage{
+		Name:       cieName(pipeline.Name),
+		Identifier: cieIdentifier(pipeline.Name),
+		Type:       "CI",
+		Spec:       cieStageSpecFromPipeline(pipeline, &config, "Amd64"),
+	}
+	if pipeline.Matrix != nil {
+		// the stage is run once for every version
+		build.Strategy = &cieStrategy{Matrix: map[string][]string{pipeline.Matrix.Language: pipeline.Matrix.Versions}}
+	}
+	stages := []cieStageRef{{Stage: build}}
+	// stages run one after another, so the manifest stage runs after the stages of every platform
+	skipped := false
+	for i := range pipeline.Platforms {
+		platform := &pipeline.Platforms[i]
+		arch, ok := cieArchitectures[platform.Platform.Arch]
+		if !ok || platform.Platform.OS != "linux" {
+			fmt.Printf("harness does not build on %s, skipping pipeline '%s'\n", platform.Platform, platform.Name)
+			skipped = true
+			continue
+		}
+		stages = append(stages, cieStageRef{Stage: cieStage{
+			Name:       cieName(platform.Name),
+			Identifier: cieIdentifier(platform.Name),
+			Type:       "CI",
+			Spec:       cieStageSpecFromPipeline(platform, &config, arch),
+		}})
+	}
+	switch {
+	case pipeline.Manifest != nil && skipped:
+		fmt.Printf("not all of the platform images are built, skipping pipeline '%s'\n", pipeline.Manifest.Name)
+	case pipeline.Manifest != nil:
+		stages = append(stages, cieStageRef{Stage: cieStage{
+			Name:       cieName(pipeline.Manifest.Name),
+			Identifier: cieIdentifier(pipeline.Manifest.Name),
+			Type:       "CI",
+			Spec:       cieStageSpecFromPipeline(pipeline.Manifest, &config, "Amd64"),
+		}})
+	}
+	out := ciePipeline{Pipeline: cieSpec{
+		Name:              cieName(pipeline.Name),
+		Identifier:        cieIdentifier(pipeline.Name),
+		OrgIdentifier:     config.OrgIdentifier,
+		ProjectIdentifier: config.ProjectIdentifier,
+		Stages:            stages,
+	}}
+	out.Pipeline.Properties.CI.Codebase = cieCodebase{
+		ConnectorRef: config.CodebaseConnector,
+		RepoName:     config.RepoName,
+		Build:        "<+input>",
+	}
+	content, err := marshalYAML(out)
+	if err != nil {
+		return nil, err
+	}
+	// make sure what we generated can be read back and follows the pipeline schema
+	if err = validateCIE(content); err != nil {
+		return nil, fmt.Errorf("generated CIE file is not valid: %s", err)
+	}
+	return content, nil
+}
+
+// cieStageSpecFromPipeline returns the stage for a pipeline, arch is the harness name of the cpu architecture.
+func cieStageSpecFromPipeline(pipeline *Pipeline, config *CIEConfig, arch string) cieStageSpec {
+	spec := cieStageSpec{CloneCodebase: true}
+	if config.KubernetesConnector != "" {
+		infrastructure := &cieInfrastructure{Type: "KubernetesDirect"}
+		infrastructure.Spec.ConnectorRef = config.KubernetesConnector
+		infrastructure.Spec.Namespace = config.Namespace
+		infrastructure.Spec.OS = "Linux"
+		if arch != "Amd64" {
+			infrastructure.Spec.NodeSelector = map[string]string{"kubernetes.io/arch": strings.ToLower(arch)}
+		}
+		spec.Infrastructure = infrastructure
+	} else {
+		spec.Platform = &ciePlatform{OS: "Linux", Arch: arch}
+		spec.Runtime = &cieRuntime{Type: "Cloud"}
+	}
+	steps := pipeline.primarySteps()
+	// harness shares paths between steps instead of using volumes
+	for i := range steps {
+		for _, mount := range steps[i].Volumes {
+			if !slices.Contains(spec.SharedPaths, mount.Path) {
+				spec.SharedPaths = append(spec.SharedPaths, mount.Path)
+			}
+		}
+	}
+	identifiers := map[string]int{}
+	// services are background steps that start before the other steps, the steps reach them by their identifier or
+	// on localhost in a kubernetes pod
+	hosts := map[string]string{}
+	for i := range pipeline.Services {
+		cs := cieBackgroundStep(&pipeline.Services[i], config, identifiers)
+		hosts[pipeline.Services[i].Name] = cs.Identifier
+		if config.KubernetesConnector != "" {
+			hosts[pipeline.Services[i].Name] = "localhost"
+		}
+		spec.Execution.Steps = append(spec.Execution.Steps, cieExecutionElement{Step: cs})
+	}
+	var group []cieExecutionElement
+	for i := range steps {
+		step := &steps[i]
+		cs := cieStepFromStep(step, config, identifiers)
+		if len(step.WaitFor) > 0 {
+			cs.Spec.Command = strings.Join(waitCommands(pipeline.Services, func(service *Service) string { return hosts[service.Name] }), "\n")
+		}
+		if pipeline.Matrix != nil {
+			cieMatrixStep(cs, step, pipeline.Matrix)
+		}
+		group = append(group, cieExecutionElement{Step: cs})
+		// steps in the same phase run in parallel
+		last := i == len(steps)-1
+		if last || !pipeline.Parallel || phaseIndex(steps[i+1].Phase) != phaseIndex(step.Phase) {
+			if len(group) == 1 {
+				spec.Execution.Steps = append(spec.Execution.Steps, group[0])
+			} else {
+				spec.Execution.Steps = append(spec.Execution.Steps, cieExecutionElement{Parallel: group})
+			}
+			group = nil
+		}
+	}
+	return spec
+}
+
+func cieStepFromStep(step *Step, config *CIEConfig, identifiers map[string]int) *cieStep {
+	cs := &cieStep{
+		Identifier: cieUniqueIdentifier(step.Name, identifiers),
+		Name:       cieName(step.Name),
+		Type:       "Plugin",
+		Spec: cieStepSpec{
+			ConnectorRef: config.DockerConnector,
+			Image:        step.Image,
+			Privileged:   step.Privileged,
+			Settings:     cieSettings(step.Settings),
+		},
+		When: cieWhen(step.When),
+	}
+	if len(step.Commands) > 0 {
+		cs.Type = "Run"
+		cs.Spec.Shell = "Sh"
+		cs.Spec.Command = strings.Join(step.Commands, "\n")
+		// run steps take environment variables rather than settings
+		cs.Spec.Settings = nil
+		cs.Spec.EnvVariables = cieEnvironment(step.Secrets)
+	}
+	return cs
+}
+
+// cieBackgroundStep runs a service for the rest of the stage.
+func cieBackgroundStep(service *Service, config *CIEConfig, identifiers map[string]int) *cieStep {
+	return &cieStep{
+		Identifier: cieUniqueIdentifier(service.Name, identifiers),
+		Name:       cieName(service.Name),
+		Type:       "Background",
+		Spec: cieStepSpec{
+			ConnectorRef: config.DockerConnector,
+			Image:        service.Image,
+			EnvVariables: service.Environment,
+		},
+	}
+}
+
+// cieMatrixStep uses the matrix version in the image of versioned steps, the other steps only run for the newest
+// version.
+func cieMatrixStep(cs *cieStep, step *Step, matrix *Matrix) {
+	variable := fmt.Sprintf("<+matrix.%s>", matrix.Language)
+	if step.MatrixVersion != "" {
+		cs.Spec.Image = matrixImage(step.Image, step.MatrixVersion, variable)
+		return
+	}
+	if !step.runsOnce() {
+		return
+	}
+	primary := fmt.Sprintf("%s == %q", variable, matrix.primary())
+	if cs.When == nil {
+		cs.When = &cieStepWhen{StageStatus: "Success", Condition: primary}
+	} else {
+		cs.When.Condition = fmt.Sprintf("(%s) && %s", cs.When.Condition, primary)
+	}
+}
+
+// cieWhen converts drone style conditions into a harness JEXL condition.
+func cieWhen(when *Condition) *cieStepWhen {
+	if when == nil {
+		return nil
+	}
+	var branches, refs, events []string
+	for _, branch := range when.Branch {
+		branches = append(branches, fmt.Sprintf("<+codebase.branch> == %q", branch))
+	}
+	for _, ref := range when.Ref {
+		switch {
+		case strings.HasPrefix(ref, "refs/tags/"):
+			refs = append(refs, `<+codebase.build.type> == "tag"`)
+		case strings.HasPrefix(ref, "refs/heads/"):
+			refs = append(refs, fmt.Sprintf("<+codebase.branch> == %q", strings.TrimPrefix(ref, "refs/heads/")))
+		}
+	}
+	for _, event := range when.Event {
+		switch event {
+		case "tag":
+			events = append(events, `<+codebase.build.type> == "tag"`)
+		case "pull_request":
+			events = append(events, `<+codebase.build.type> == "PR"`)
+		case "push":
+			events = append(events, `<+codebase.build.type> == "branch"`)
+		}
+	}
+	condition := joinConditions([][]string{branches, refs, events}, " || ", " && ")
+	if condition == "" {
+		return nil
+	}
+	return &cieStepWhen{StageStatus: "Success", Condition: condition}
+}
+
+// validateCIE checks the generated file against the parts of the harness pipeline schema that the renderer writes:
+// names and identifiers, required connectors, stage infrastructure and the step types. It is not the full schema,
+// fields the renderer never sets are not checked.
+func validateCIE(content []byte) error {
+	var check ciePipeline
+	if err := yaml.Unmarshal(content, &check); err != nil {
+		return err
+	}
+	if !cieIdentifierPattern.MatchString(check.Pipeline.Identifier) {
+		return fmt.Errorf("invalid pipeline identifier '%s'", check.Pipeline.Identifier)
+	}
+	if !validCIEName(check.Pipeline.Name) {
+		return fmt.Errorf("invalid pipeline name '%s'", check.Pipeline.Name)
+	}
+	if check.Pipeline.OrgIdentifier == "" || check.Pipeline.ProjectIdentifier == "" {
+		return fmt.Errorf("pipeline is missing the org or project identifier")
+	}
+	if check.Pipeline.Properties.CI.Codebase.ConnectorRef == "" {
+		return fmt.Errorf("pipeline is missing a codebase connector")
+	}
+	if len(check.Pipeline.Stages) == 0 {
+		return fmt.Errorf("pipeline has no stages")
+	}
+	stages := map[string]bool{}
+	for _, stageRef := range check.Pipeline.Stages {
+		stage := stageRef.Stage
+		if stages[stage.Identifier] {
+			return fmt.Errorf("duplicate stage identifier '%s'", stage.Identifier)
+		}
+		stages[stage.Identifier] = true
+		// step identifiers only have to be unique in their stage
+		identifiers := map[string]bool{}
+		if stage.Type != "CI" || !cieIdentifierPattern.MatchString(stage.Identifier) || !validCIEName(stage.Name) {
+			return fmt.Errorf("invalid stage '%s'", stage.Identifier)
+		}
+		if stage.Spec.Infrastructure == nil && (stage.Spec.Platform == nil || stage.Spec.Runtime == nil) {
+			return fmt.Errorf("stage '%s' needs either infrastructure or a platform and runtime", stage.Identifier)
+		}
+		if len(stage.Spec.Execution.Steps) == 0 {
+			return fmt.Errorf("stage '%s' has no steps", stage.Identifier)
+		}
+		if err := validateCIESteps(stage.Spec.Execution.Steps, identifiers); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateCIESteps(elements []cieExecutionElement, identifiers map[string]bool) error {
+	for _, element := range elements {
+		if element.Step == nil {
+			if len(element.Parallel) == 0 {
+				return fmt.Errorf("empty step element")
+			}
+			if err := validateCIESteps(element.Parallel, identifiers); err != nil {
+				return err
+			}
+			continue
+		}
+		step := element.Step
+		if !cieIdentifierPattern.MatchString(step.Identifier) {
+			return fmt.Errorf("invalid step identifier '%s'", step.Identifier)
+		}
+		if !validCIEName(step.Name) {
+			return fmt.Errorf("invalid step name '%s'", step.Name)
+		}
+		if identifiers[step.Identifier] {
+			return fmt.Errorf("duplicate step identifier '%s'", step.Identifier)
+		}
+		identifiers[step.Identifier] = true
+		if step.Spec.ConnectorRef == "" || step.Spec.Image == "" {
+			return fmt.Errorf("step '%s' needs a connector and an image", step.Identifier)
+		}
+		switch step.Type {
+		case "Run":
+			if step.Spec.Command == "" {
+				return fmt.Errorf("run step '%s' has no command", step.Identifier)
+			}
+		case "Plugin", "Background":
+		default:
+			return fmt.Errorf("step '%s' has unsupported type '%s'", step.Identifier, step.Type)
+		}
+	}
+	return nil
+}
+
+// cieUniqueIdentifier returns the identifier of a name, numbering the names that convert to the same identifier. The
+// number is added before the identifier is shortened so that it is never cut off.
+func cieUniqueIdentifier(name string, identifiers map[string]int) string {
+	base := cieIdentifierBase(name)
+	identifier := cieTruncate(base, "")
+	for count := 2; identifiers[identifier] > 0; count++ {
+		identifier = cieTruncate(base, fmt.Sprintf("_%d", count))
+	}
+	identifiers[identifier]++
+	return identifier
+}
+
+// cieIdentifier converts a step name into a valid harness identifier.
+func cieIdentifier(name string) string {
+	return cieTruncate(cieIdentifierBase(name), "")
+}
+
+// cieTruncate shortens a name with a suffix to the length harness allows, keeping the suffix.
+func cieTruncate(name, suffix string) string {
+	if len(name)+len(suffix) > cieMaxLength {
+		name = name[:cieMaxLength-len(suffix)]
+	}
+	return name + suffix
+}
+
+// cieName returns the display name of a step, names keep every character, eg the slash of
+// 'docker build docker/Dockerfile', and are only shortened to the length harness allows.
+func cieName(name string) string {
+	return cieTruncate(name, "")
+}
+
+// validCIEName reports whether harness accepts a display name.
+func validCIEName(name string) bool {
+	return strings.TrimSpace(name) != "" && len(name) <= cieMaxLength
+}
+
+// cieIdentifierBase replaces the characters harness does not allow in identifiers.
+func cieIdentifierBase(name string) string {
+	identifier := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+	if identifier == "" || (identifier[0] >= '0' && identifier[0] <= '9') {
+		identifier = "_" + identifier
+	}
+	return identifier
+}
+
+// cieEnvironment reads the environment variables of a run step from harness secrets.
+func cieEnvironment(secrets map[string]Secret) map[string]string {
+	if len(secrets) == 0 {
+		return nil
+	}
+	environment := make(map[string]string, len(secrets))
+	for name, secret := range secrets {
+		environment[name] = fmt.Sprintf("<+secrets.getValue(%q)>", string(secret))
+	}
+	return environment
+}
+
+// cieSettings converts secrets into the harness secret expression syntax.
+func cieSettings(settings map[string]interface{}) map[string]interface{} {
+	if len(settings) == 0 {
+		return nil
+	}
+	converted := make(map[string]interface{}, len(settings))
+	for key, value := range settings {
+		if secret, ok := value.(Secret); ok {
+			converted[key] = fmt.Sprintf("<+secrets.getValue(%q)>", string(secret))
+		} else {
+			converted[key] = value
+		}
+	}
+	return converted
+}
@@ -0,0 +1,167 @@
+package buildmaker
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// cieSteps returns the steps of a stage by identifier, including the steps in parallel groups.
+func cieSteps(elements []cieExecutionElement, steps map[string]*cieStep) map[string]*cieStep {
+	for _, element := range elements {
+		if element.Step != nil {
+			steps[element.Step.Identifier] = element.Step
+		}
+		cieSteps(element.Parallel, steps)
+	}
+	return steps
+}
+
+func TestRenderCIE(t *testing.T) {
+	pipeline := testPipeline(testBuilds())
+	content, err := renderCIE(&pipeline, &CIEConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out ciePipeline
+	if err = yaml.Unmarshal(content, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Pipeline.OrgIdentifier != defaultCIEOrg || out.Pipeline.ProjectIdentifier != defaultCIEProject {
+		t.Errorf("got org '%s' and project '%s'", out.Pipeline.OrgIdentifier, out.Pipeline.ProjectIdentifier)
+	}
+	if len(out.Pipeline.Stages) != 1 {
+		t.Fatalf("expected a single stage, got %d", len(out.Pipeline.Stages))
+	}
+	stage := out.Pipeline.Stages[0].Stage
+	if stage.Spec.Platform == nil || stage.Spec.Runtime == nil || stage.Spec.Runtime.Type != "Cloud" {
+		t.Errorf("expected the stage to run on harness cloud: %+v", stage.Spec)
+	}
+	steps := cieSteps(stage.Spec.Execution.Steps, map[string]*cieStep{})
+	tests := []struct {
+		identifier string
+		stepType   string
+		condition  string
+	}{
+		{identifier: "postgres", stepType: "Background"},
+		{identifier: "go_lint", stepType: "Run"},
+		{identifier: "go_unit_tests", stepType: "Run"},
+		{identifier: "docker_build_Dockerfile", stepType: "Plugin"},
+		{identifier: "goreleaser", stepType: "Run", condition: `<+codebase.build.type> == "tag"`},
+		{
+			identifier: "coverage", stepType: "Run",
+			condition: `(<+codebase.branch> == "main" || <+codebase.branch> == "develop") && <+codebase.build.type> == "branch"`,
+		},
+	}
+	for _, test := range tests {
+		step, ok := steps[test.identifier]
+		if !ok {
+			t.Errorf("missing step '%s'", test.identifier)
+			continue
+		}
+		if step.Type != test.stepType {
+			t.Errorf("step '%s' is a %s step, want %s", test.identifier, step.Type, test.stepType)
+		}
+		condition := ""
+		if step.When != nil {
+			condition = step.When.Condition
+		}
+		if condition != test.condition {
+			t.Errorf("step '%s' runs when '%s', want '%s'", test.identifier, condition, test.condition)
+		}
+	}
+	if token := steps["goreleaser"].Spec.EnvVariables["GITHUB_TOKEN"]; token != `<+secrets.getValue("github_token")>` {
+		t.Errorf("goreleaser reads its token from '%s'", token)
+	}
+	// the steps reach the background step by its identifier
+	if wait := steps["wait_for_services"]; wait == nil || !strings.Contains(wait.Spec.Command, "nc -z postgres 5432") {
+		t.Errorf("the wait step does not wait for postgres: %+v", wait)
+	}
+}
+
+func TestRenderCIEKubernetes(t *testing.T) {
+	pipeline := testPipeline(testBuilds())
+	content, err := renderCIE(&pipeline, &CIEConfig{KubernetesConnector: "cluster", Namespace: "builds"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out ciePipeline
+	if err = yaml.Unmarshal(content, &out); err != nil {
+		t.Fatal(err)
+	}
+	spec := out.Pipeline.Stages[0].Stage.Spec
+	if spec.Infrastructure == nil || spec.Infrastructure.Spec.ConnectorRef != "cluster" || spec.Infrastructure.Spec.Namespace != "builds" {
+		t.Errorf("expected the stage to run on the cluster: %+v", spec.Infrastructure)
+	}
+	// the containers of a pod share the network
+	wait := cieSteps(spec.Execution.Steps, map[string]*cieStep{})["wait_for_services"]
+	if wait == nil || !strings.Contains(wait.Spec.Command, "nc -z localhost 5432") {
+		t.Errorf("the wait step does not wait on localhost: %+v", wait)
+	}
+}
+
+func TestCIEUniqueIdentifier(t *testing.T) {
+	identifiers := map[string]int{}
+	for name, want := range map[string]string{
+		"go build ./cmd/a": "go_build___cmd_a",
+		"1 step":           "_1_step",
+	} {
+		if got := cieUniqueIdentifier(name, identifiers); got != want {
+			t.Errorf("cieUniqueIdentifier(%s) = '%s', want '%s'", name, got, want)
+		}
+	}
+	// names that convert to the same identifier are numbered
+	if got := cieUniqueIdentifier("go build .-cmd-a", identifiers); got != "go_build___cmd_a_2" {
+		t.Errorf("got '%s' for a repeated identifier", got)
+	}
+	// the number is kept when a long identifier is shortened
+	long := strings.Repeat("a", 200)
+	first := cieUniqueIdentifier(long, identifiers)
+	second := cieUniqueIdentifier(long, identifiers)
+	if len(first) != cieMaxLength || len(second) != cieMaxLength || !strings.HasSuffix(second, "_2") {
+		t.Errorf("got '%s' and '%s' for a long name", first, second)
+	}
+	for _, identifier := range []string{first, second} {
+		if !cieIdentifierPattern.MatchString(identifier) {
+			t.Errorf("'%s' is not a valid identifier", identifier)
+		}
+	}
+}
+
+func TestCIEName(t *testing.T) {
+	long := strings.Repeat("a", 200)
+	for name, want := range map[string]string{
+		"docker build docker/Dockerfile": "docker build docker/Dockerfile",
+		"go test (integration)":          "go test (integration)",
+		long:                             long[:cieMaxLength],
+	} {
+		if got := cieName(name); got != want {
+			t.Errorf("cieName(%s) = '%s', want '%s'", name, got, want)
+		}
+	}
+}
+
+func TestValidateCIE(t *testing.T) {
+	pipeline := testPipeline(testBuilds())
+	content, err := renderCIE(&pipeline, &CIEConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]struct{ from, to string }{
+		"invalid step identifier": {from: "identifier: go_lint", to: "identifier: go-lint"},
+		"empty step name":         {from: "name: go lint", to: "name: ' '"},
+		"duplicate identifier":    {from: "identifier: go_lint", to: "identifier: go_unit_tests"},
+		"missing connector":       {from: "connectorRef: " + defaultCIEDockerConnector, to: "connectorRef: ''"},
+		"unsupported step type":   {from: "type: Plugin", to: "type: Action"},
+	}
+	for name, test := range tests {
+		broken := strings.Replace(string(content), test.from, test.to, 1)
+		if broken == string(content) {
+			t.Fatalf("%s: '%s' is not in the pipeline", name, test.from)
+		}
+		if err := validateCIE([]byte(broken)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package buildmaker
+
+import (
+	"bytes"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+type (
+	dronePipeline struct {
+		Kind      string         `yaml:"kind"`
+		Type      string         `yaml:"type"`
+		Name      string         `yaml:"name"`
+		Platform  dronePlatform  `yaml:"platform"`
+		Steps     []droneStep    `yaml:"steps"`
+		Services  []droneService `yaml:"services,omitempty"`
+		Volumes   []droneVolume  `yaml:"volumes,omitempty"`
+		DependsOn []string       `yaml:"depends_on,omitempty"`
+	}
+
+	dronePlatform struct {
+		OS      string `yaml:"os"`
+		Arch    string `yaml:"arch"`
+		Variant string `yaml:"variant,omitempty"`
+	}
+
+	droneStep struct {
+		Name        string                 `yaml:"name"`
+		Image       string                 `yaml:"image"`
+		Privileged  bool                   `yaml:"privileged,omitempty"`
+		Commands    []string               `yaml:"commands,omitempty"`
+		Environment map[string]interface{} `yaml:"environment,omitempty"`
+		Settings    map[string]interface{} `yaml:"settings,omitempty"`
+		Volumes     []VolumeMount          `yaml:"volumes,omitempty"`
+		DependsOn   []string               `yaml:"depends_on,omitempty"`
+		When        *Condition             `yaml:"when,omitempty"`
+	}
+
+	// droneService is reached by its name from the steps, drone does not need the ports to be published.
+	droneService struct {
+		Name        string            `yaml:"name"`
+		Image       string            `yaml:"image"`
+		Environment map[string]string `yaml:"environment,omitempty"`
+	}
+
+	droneVolume struct {
+		Name string    `yaml:"name"`
+		Temp *struct{} `yaml:"temp,omitempty"`
+	}
+
+	droneSecret struct {
+		FromSecret string `yaml:"from_secret"`
+	}
+)
+
+// renderDrone writes a drone pipeline, a matrix build is written as one pipeline per version. The other platforms
+// get a pipeline each and the manifest pipeline waits for all of them.
+func renderDrone(pipeline *Pipeline) ([]byte, error) {
+	var content []byte
+	for _, out := range dronePipelines(pipeline) {
+		document, err := marshalYAML(out)
+		if err != nil {
+			return nil, err
+		}
+		// make sure what we generated can be read back
+		var check dronePipeline
+		if err = yaml.Unmarshal(document, &check); err != nil {
+			return nil, fmt.Errorf("generated drone file is not valid yaml: %s", err)
+		}
+		if len(check.Steps) != len(out.Steps) {
+			return nil, fmt.Errorf("generated drone file has %d steps, expected %d", len(check.Steps), len(out.Steps))
+		}
+		if len(content) > 0 {
+			content = append(content, "---\n"...)
+		}
+		content = append(content, document...)
+	}
+	return content, nil
+}
+
+func dronePipelines(pipeline *Pipeline) (pipelines []dronePipeline) {
+	for _, variant := range pipeline.variants() {
+		pipelines = append(pipelines, dronePipelineFromPipeline(&variant))
+	}
+	for i := range pipeline.Platforms {
+		pipelines = append(pipelines, dronePipelineFromPipeline(&pipeline.Platforms[i]))
+	}
+	if pipeline.Manifest != nil {
+		manifest := dronePipelineFromPipeline(pipeline.Manifest)
+		manifest.DependsOn = pipeline.pipelineNames()
+		pipelines = append(pipelines, manifest)
+	}
+	return pipelines
+}
+
+func dronePipelineFromPipeline(pipeline *Pipeline) dronePipeline {
+	out := dronePipeline{
+		Kind: "pipeline",
+		Type: "docker",
+		Name: pipeline.Name,
+		Platform: dronePlatform{
+			OS:   "linux",
+			Arch: "amd64",
+		},
+	}
+	if pipeline.Platform != nil {
+		out.Platform = dronePlatform{OS: pipeline.Platform.OS, Arch: pipeline.Platform.Arch, Variant: pipeline.Platform.Variant}
+	}
+	for i := range pipeline.Steps {
+		out.Steps = append(out.Steps, droneStepFromStep(&pipeline.Steps[i]))
+	}
+	for i := range pipeline.Services {
+		out.Services = append(out.Services, droneServiceFromService(&pipeline.Services[i]))
+	}
+	for _, volume := range pipeline.Volumes {
+		dv := droneVolume{Name: volume.Name}
+		if volume.Temp {
+			dv.Temp = &struct{}{}
+		}
+		out.Volumes = append(out.Volumes, dv)
+	}
+	return out
+}
+
+func droneStepFromStep(step *Step) droneStep {
+	return droneStep{
+		Name:        step.Name,
+		Image:       step.Image,
+		Privileged:  step.Privileged,
+		Commands:    step.Commands,
+		Environment: droneEnvironment(step.Secrets),
+		Settings:    droneSettings(step.Settings),
+		Volumes:     step.Volumes,
+		DependsOn:   step.DependsOn,
+		When:        step.When,
+	}
+}
+
+func droneServiceFromService(service *Service) droneService {
+	return droneService{
+		Name:        service.Name,
+		Image:       service.Image,
+		Environment: service.Environment,
+	}
+}
+
+// droneSettings converts secrets into the drone from_secret syntax.
+func droneSettings(settings map[string]interface{}) map[string]interface{} {
+	if len(settings) == 0 {
+		return nil
+	}
+	converted := make(map[string]interface{}, len(settings))
+	for key, value := range settings {
+		if secret, ok := value.(Secret); ok {
+			converted[key] = droneSecret{FromSecret: string(secret)}
+		} else {
+			converted[key] = value
+		}
+	}
+	return converted
+}
+
+// droneEnvironment reads the environment variables of a step from drone secrets.
+func droneEnvironment(secrets map[string]Secret) map[string]interface{} {
+	if len(secrets) == 0 {
+		return nil
+	}
+	environment := make(map[string]interface{}, len(secrets))
+	for name, secret := range secrets {
+		environment[name] = droneSecret{FromSecret: string(secret)}
+	}
+	return environment
+}
+
+func marshalYAML(in interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2) //nolint:gomnd
+	if err := encoder.Encode(in); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,486 @@
+package buildmaker
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	githubDefaultJavaVersion = "17"
+	githubJobName            = "build"
+	githubArmRunner          = "ubuntu-24.04-arm"
+)
+
+type (
+	githubWorkflow struct {
+		Name string               `yaml:"name"`
+		On   githubTriggers       `yaml:"on"`
+		Jobs map[string]githubJob `yaml:"jobs"`
+	}
+
+	githubTriggers struct {
+		Push        githubTrigger `yaml:"push"`
+		PullRequest githubTrigger `yaml:"pull_request"`
+	}
+
+	githubTrigger struct {
+		Branches []string `yaml:"branches,omitempty"`
+		Tags     []string `yaml:"tags,omitempty"`
+	}
+
+	githubJob struct {
+		Name   string   `yaml:"name,omitempty"`
+		RunsOn string   `yaml:"runs-on"`
+		Needs  []string `yaml:"needs,omitempty"`
+		// Permissions of the workflow token, only set when a step writes to the repository.
+		Permissions map[string]string        `yaml:"permissions,omitempty"`
+		Strategy    *githubStrategy          `yaml:"strategy,omitempty"`
+		Services    map[string]githubService `yaml:"services,omitempty"`
+		Steps       []githubStep             `yaml:"steps"`
+	}
+
+	// githubService publishes its ports on the runner, the steps run on the runner and reach it on localhost.
+	githubService struct {
+		Image string            `yaml:"image"`
+		Env   map[string]string `yaml:"env,omitempty"`
+		Ports []string          `yaml:"ports,omitempty"`
+	}
+
+	githubStrategy struct {
+		Matrix map[string][]string `yaml:"matrix"`
+	}
+
+	githubStep struct {
+		Name string                 `yaml:"name,omitempty"`
+		If   string                 `yaml:"if,omitempty"`
+		Uses string                 `yaml:"uses,omitempty"`
+		With map[string]interface{} `yaml:"with,omitempty"`
+		Env  map[string]string      `yaml:"env,omitempty"`
+		Run  string                 `yaml:"run,omitempty"`
+	}
+)
+
+func renderGitHub(pipeline *Pipeline) ([]byte, error) {
+	jobs := map[string]githubJob{githubJobName: githubJobFromPipeline(pipeline)}
+	needs := []string{githubJobName}
+	for i := range pipeline.Platforms {
+		platform := &pipeline.Platforms[i]
+		name := githubJobName + "-" + strings.ReplaceAll(platform.Platform.String(), "/", "-")
+		job := githubJobFromPipeline(platform)
+		job.Name = platform.Name
+		job.Needs = []string{githubJobName}
+		if platform.Platform.Arch == "arm64" {
+			job.RunsOn = githubArmRunner
+		} else {
+			// other platforms are emulated
+			job.Steps = append(job.Steps[:1], append([]githubStep{{Name: "set up qemu", Uses: "docker/setup-qemu-action@v3"}}, job.Steps[1:]...)...)
+		}
+		jobs[name] = job
+		needs = append(needs, name)
+	}
+	if pipeline.Manifest != nil {
+		manifest := githubJobFromPipeline(pipeline.Manifest)
+		manifest.Name = pipeline.Manifest.Name
+		manifest.Needs = needs
+		jobs["manifest"] = manifest
+	}
+	out := githubWorkflow{
+		Name: "ci",
+		On: githubTriggers{
+			// every branch and tag, so it works whatever the default branch is called
+			Push:        githubTrigger{},
+			PullRequest: githubTrigger{},
+		},
+		Jobs: jobs,
+	}
+	content, err := marshalYAML(out)
+	if err != nil {
+		return nil, err
+	}
+	// make sure what we generated can be read back
+	if err = validateGitHub(content); err != nil {
+		return nil, fmt.Errorf("generated github workflow is not valid: %s", err)
+	}
+	return content, nil
+}
+
+func githubJobFromPipeline(pipeline *Pipeline) githubJob {
+	job := githubJob{
+		RunsOn: "ubuntu-latest",
+		Steps:  []githubStep{{Uses: "actions/checkout@v4"}},
+	}
+	steps := pipeline.primarySteps()
+	if pipeline.Matrix != nil {
+		job.Strategy = &githubStrategy{Matrix: map[string][]string{pipeline.Matrix.Language: pipeline.Matrix.Versions}}
+	}
+	job.Steps = append(job.Steps, githubSetupSteps(steps, pipeline.Matrix)...)
+	dockerRun := "docker run --rm"
+	if len(pipeline.Services) > 0 {
+		job.Services = map[string]githubService{}
+		for i := range pipeline.Services {
+			service := &pipeline.Services[i]
+			gs := githubService{Image: service.Image, Env: service.Environment}
+			for _, port := range service.Ports {
+				gs.Ports = append(gs.Ports, fmt.Sprintf("%d:%d", port, port))
+			}
+			job.Services[service.Name] = gs
+		}
+		// containers started by a step reach the services through the ports published on the runner
+		dockerRun += " --network host"
+	}
+	buildxAdded := false
+	for i := range steps {
+		step := &steps[i]
+		var converted []githubStep
+		switch {
+		case imageName(step.Image) == "plugins/docker":
+			if !buildxAdded {
+				converted = append(converted, githubStep{Name: "set up docker buildx", Uses: "docker/setup-buildx-action@v3"})
+				buildxAdded = true
+			}
+			converted = append(converted, githubDockerSteps(step, pipeline.Platform)...)
+		case imageName(step.Image) == "plugins/drone-snyk":
+			// snyk scans a local image, so it is built and loaded into docker first
+			converted = append(converted, githubStep{
+				Name: "build " + fmt.Sprint(step.Settings["image"]),
+				Uses: "docker/build-push-action@v6",
+				With: map[string]interface{}{
+					"context":    ".",
+					"file":       step.Settings["dockerfile"],
+					"load":       true,
+					"tags":       step.Settings["image"],
+					"cache-from": "type=gha",
+				},
+			})
+			converted = append(converted, githubStep{
+				Name: step.Name,
+				Uses: "snyk/actions/docker@master",
+				With: map[string]interface{}{
+					"image": step.Settings["image"],
+					"args":  fmt.Sprintf("--file=%v", step.Settings["dockerfile"]),
+				},
+				Env: map[string]string{"SNYK_TOKEN": githubSecret(step.Settings["snyk"])},
+			})
+		case imageName(step.Image) == "golangci/golangci-lint":
+			commands, with := step.Commands, map[string]interface{}{}
+			if len(commands) > 0 && strings.HasPrefix(commands[0], "cd ") {
+				// a module in a sub folder of the repository
+				with["working-directory"] = strings.TrimPrefix(commands[0], "cd ")
+				commands = commands[1:]
+			}
+			with["args"] = strings.TrimSpace(strings.TrimPrefix(strings.Join(commands, " "), "golangci-lint run"))
+			converted = append(converted, githubStep{
+				Name: step.Name,
+				Uses: "golangci/golangci-lint-action@v6",
+				With: with,
+			})
+		case imageName(step.Image) == "goreleaser/goreleaser":
+			job.Permissions = map[string]string{"contents": "write"}
+			converted = append(converted, githubStep{
+				Name: step.Name,
+				Uses: "goreleaser/goreleaser-action@v6",
+				With: map[string]interface{}{
+					"version": "~> v2",
+					"args":    strings.TrimSpace(strings.TrimPrefix(strings.Join(step.Commands, " "), "goreleaser")),
+				},
+				Env: githubEnvironment(step.Secrets),
+			})
+		case imageName(step.Image) == "plugins/github-release":
+			// the release action uploads with the token of the workflow
+			job.Permissions = map[string]string{"contents": "write"}
+			converted = append(converted, githubStep{
+				Name: step.Name,
+				Uses: "softprops/action-gh-release@v2",
+				With: map[string]interface{}{"files": githubFiles(step.Settings["files"])},
+			})
+		case len(step.WaitFor) > 0:
+			commands := waitCommands(pipeline.Services, func(*Service) string { return "localhost" })
+			converted = append(converted, githubStep{Name: step.Name, Run: strings.Join(commands, "\n")})
+		case len(step.Commands) > 0 && step.Language != "":
+			// the toolchain is installed by the setup actions
+			converted = append(converted, githubStep{Name: step.Name, Run: strings.Join(step.Commands, "\n"), Env: githubEnvironment(step.Secrets)})
+		case len(step.Commands) > 0:
+			run := dockerRun
+			for _, name := range step.secretNames() {
+				// docker passes the variable through from the runner
+				run += " -e " + name
+			}
+			converted = append(converted, githubStep{
+				Name: step.Name,
+				Run: fmt.Sprintf("%s -v \"${{ github.workspace }}:/workspace\" -w /workspace %s sh -c %s",
+					run, step.Image, shellQuote(strings.Join(step.Commands, " && "))),
+				Env: githubEnvironment(step.Secrets),
+			})
+		default:
+			// drone plugins read their settings from PLUGIN_ environment variables
+			converted = append(converted, githubStep{Name: step.Name, Uses: "docker://" + step.Image, Env: githubPluginEnv(step.Settings)})
+		}
+		if len(step.Artifacts) > 0 {
+			converted = append(converted, githubUploadStep(step, pipeline.Matrix))
+		}
+		condition := githubCondition(step.When)
+		if pipeline.Matrix != nil && step.runsOnce() {
+			// steps that do not depend on the version only run once
+			primary := fmt.Sprintf("matrix.%s == '%s'", pipeline.Matrix.Language, pipeline.Matrix.primary())
+			if condition == "" {
+				condition = primary
+			} else {
+				condition = fmt.Sprintf("(%s) && %s", condition, primary)
+			}
+		}
+		for j := range converted {
+			converted[j].If = condition
+		}
+		job.Steps = append(job.Steps, converted...)
+		if imageName(step.Image) == "goreleaser/goreleaser" {
+			// goreleaser writes the changelog from the git history
+			job.Steps[0].With = map[string]interface{}{"fetch-depth": 0}
+		}
+	}
+	return job
+}
+
+// githubSetupSteps installs each language toolchain once, with caching enabled. The matrix language is installed at
+// the version of the matrix job.
+func githubSetupSteps(steps []Step, matrix *Matrix) (setup []githubStep) {
+	var languages []string
+	versions := map[string]string{}
+	caches := map[string]string{}
+	for i := range steps {
+		language := steps[i].Language
+		if language == "" {
+			continue
+		}
+		if !slices.Contains(languages, language) {
+			languages = append(languages, language)
+		}
+		if versions[language] == "" {
+			versions[language] = steps[i].LanguageVersion
+		}
+		if caches[language] == "" {
+			caches[language] = steps[i].Cache
+		}
+	}
+	for _, language := range languages {
+		version := versions[language]
+		if matrix != nil && matrix.Language == language {
+			version = fmt.Sprintf("${{ matrix.%s }}", language)
+		}
+		switch language {
+		case LanguageGo:
+			with := map[string]interface{}{"cache": true}
+			if version != "" {
+				with["go-version"] = version
+			} else {
+				with["go-version-file"] = "go.mod"
+			}
+			setup = append(setup, githubStep{Name: "set up go", Uses: "actions/setup-go@v5", With: with})
+		case LanguageNode:
+			with := map[string]interface{}{"cache": "npm"}
+			if version != "" {
+				with["node-version"] = version
+			}
+			setup = append(setup, githubStep{Name: "set up node", Uses: "actions/setup-node@v4", With: with})
+		case LanguageJava:
+			if version == "" {
+				version = githubDefaultJavaVersion
+			}
+			with := map[string]interface{}{"distribution": "temurin", "java-version": version}
+			switch caches[language] {
+			case CacheMaven:
+				with["cache"] = "maven"
+			case CacheGradle:
+				with["cache"] = "gradle"
+			}
+			setup = append(setup, githubStep{Name: "set up java", Uses: "actions/setup-java@v4", With: with})
+		case LanguageRuby:
+			if version == "" || version == "latest" {
+				version = "ruby"
+			}
+			setup = append(setup, githubStep{Name: "set up ruby", Uses: "ruby/setup-ruby@v1", With: map[string]interface{}{
+				"ruby-version":  version,
+				"bundler-cache": true,
+			}})
+		}
+	}
+	return setup
+}
+
+// githubDockerSteps builds the image with buildx using the github actions cache, it only pushes when the drone step
+// was not a dry run.
+func githubDockerSteps(step *Step, platform *Platform) (steps []githubStep) {
+	dryRun, _ := step.Settings["dry_run"].(bool)
+	repo, _ := step.Settings["repo"].(string)
+	if !dryRun {
+		steps = append(steps, githubStep{
+			Name: "log in to the registry",
+			Uses: "docker/login-action@v3",
+			With: map[string]interface{}{
+				"username": githubSecret(step.Settings["username"]),
+				"password": githubSecret(step.Settings["password"]),
+			},
+		})
+	}
+	with := map[string]interface{}{
+		"context":    ".",
+		"push":       !dryRun,
+		"tags":       repo + ":latest",
+		"cache-from": "type=gha",
+		"cache-to":   "type=gha,mode=max",
+	}
+	if dockerfile, ok := step.Settings["dockerfile"]; ok {
+		with["file"] = dockerfile
+	}
+	// the images of each platform are tagged with their suffix, the manifest job joins them
+	if suffix, ok := step.Settings["auto_tag_suffix"]; ok {
+		with["tags"] = fmt.Sprintf("%s:%v", repo, suffix)
+	}
+	if !platform.isDefault() {
+		with["platforms"] = platform.String()
+	} else if dryRun {
+		// keep the image for the steps after it, docker can only load the image of its own platform
+		with["load"] = true
+	}
+	steps = append(steps, githubStep{Name: step.Name, Uses: "docker/build-push-action@v6", With: with})
+	return steps
+}
+
+func githubPluginEnv(settings map[string]interface{}) map[string]string {
+	if len(settings) == 0 {
+		return nil
+	}
+	env := make(map[string]string, len(settings))
+	for key, value := range settings {
+		name := "PLUGIN_" + strings.ToUpper(key)
+		if _, ok := value.(Secret); ok {
+			env[name] = githubSecret(value)
+		} else {
+			env[name] = fmt.Sprint(value)
+		}
+	}
+	return env
+}
+
+func githubSecret(value interface{}) string {
+	if secret, ok := value.(Secret); ok {
+		return fmt.Sprintf("${{ secrets.%s }}", strings.ToUpper(string(secret)))
+	}
+	return fmt.Sprint(value)
+}
+
+// githubEnvironment reads the environment variables of a step from github secrets.
+func githubEnvironment(secrets map[string]Secret) map[string]string {
+	if len(secrets) == 0 {
+		return nil
+	}
+	environment := make(map[string]string, len(secrets))
+	for name, secret := range secrets {
+		environment[name] = githubSecret(secret)
+	}
+	return environment
+}
+
+// githubFiles joins a list of file globs, one per line as the actions expect.
+func githubFiles(files interface{}) string {
+	if list, ok := files.([]string); ok {
+		return strings.Join(list, "\n")
+	}
+	return fmt.Sprint(files)
+}
+
+// githubUploadStep keeps the artifacts of a step, artifact names have to be unique so the matrix version is part of
+// the name for steps run for every version.
+func githubUploadStep(step *Step, matrix *Matrix) githubStep {
+	name := strings.NewReplacer(" ", "-", "/", "-").Replace(step.Name)
+	if matrix != nil && !step.runsOnce() {
+		name += fmt.Sprintf("-${{ matrix.%s }}", matrix.Language)
+	}
+	return githubStep{
+		Name: "upload " + step.Name,
+		Uses: "actions/upload-artifact@v4",
+		With: map[string]interface{}{
+			"name": name,
+			"path": strings.Join(step.Artifacts, "\n"),
+		},
+	}
+}
+
+// githubCondition converts drone style conditions into a github expression.
+func githubCondition(when *Condition) string {
+	if when == nil {
+		return ""
+	}
+	var branches, refs, events []string
+	for _, branch := range when.Branch {
+		branches = append(branches, fmt.Sprintf("github.ref == 'refs/heads/%s'", branch))
+	}
+	for _, ref := range when.Ref {
+		if strings.HasSuffix(ref, "*") {
+			refs = append(refs, fmt.Sprintf("startsWith(github.ref, '%s')", strings.TrimSuffix(ref, "*")))
+		} else {
+			refs = append(refs, fmt.Sprintf("github.ref == '%s'", ref))
+		}
+	}
+	for _, event := range when.Event {
+		switch event {
+		case "tag":
+			events = append(events, "startsWith(github.ref, 'refs/tags/')")
+		case "pull_request":
+			events = append(events, "github.event_name == 'pull_request'")
+		case "push":
+			events = append(events, "github.event_name == 'push'")
+		}
+	}
+	return joinConditions([][]string{branches, refs, events}, " || ", " && ")
+}
+
+func validateGitHub(content []byte) error {
+	var check githubWorkflow
+	if err := yaml.Unmarshal(content, &check); err != nil {
+		return err
+	}
+	if len(check.Jobs) == 0 {
+		return fmt.Errorf("workflow has no jobs")
+	}
+	names := make([]string, 0, len(check.Jobs))
+	for name := range check.Jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		job := check.Jobs[name]
+		if job.RunsOn == "" {
+			return fmt.Errorf("job '%s' has no runs-on", name)
+		}
+		for _, need := range job.Needs {
+			if _, ok := check.Jobs[need]; !ok {
+				return fmt.Errorf("job '%s' needs unknown job '%s'", name, need)
+			}
+		}
+		for i := range job.Steps {
+			if (job.Steps[i].Uses == "") == (job.Steps[i].Run == "") {
+				return fmt.Errorf("job '%s' step %d must have exactly one of uses or run", name, i)
+			}
+		}
+	}
+	return nil
+}
+
+// imageName strips the tag and digest from an image.
+func imageName(image string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[:i]
+	}
+	return image
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
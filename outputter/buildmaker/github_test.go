@@ -0,0 +1,152 @@
+package buildmaker
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func readGitHub(t *testing.T, pipeline *Pipeline) githubWorkflow {
+	t.Helper()
+	content, err := renderGitHub(pipeline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out githubWorkflow
+	if err = yaml.Unmarshal(content, &out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// githubStepNamed returns the step with a name, or an empty step.
+func githubStepNamed(job *githubJob, name string) githubStep {
+	for _, step := range job.Steps {
+		if step.Name == name {
+			return step
+		}
+	}
+	return githubStep{}
+}
+
+func TestRenderGitHub(t *testing.T) {
+	pipeline := testPipeline(testBuilds())
+	out := readGitHub(t, &pipeline)
+	job, ok := out.Jobs[githubJobName]
+	if !ok {
+		t.Fatalf("missing job '%s'", githubJobName)
+	}
+	if job.Steps[0].Uses != "actions/checkout@v4" {
+		t.Errorf("the first step is '%s'", job.Steps[0].Uses)
+	}
+	if setup := githubStepNamed(&job, "set up go"); setup.With["go-version"] != "1.21" {
+		t.Errorf("go is set up with %v", setup.With)
+	}
+	if service := job.Services["postgres"]; len(service.Ports) != 1 || service.Ports[0] != "5432:5432" {
+		t.Errorf("postgres publishes the ports %v", service.Ports)
+	}
+	tests := []struct {
+		name, uses, run, condition string
+	}{
+		{name: "go lint", uses: "golangci/golangci-lint-action@v6"},
+		// the toolchain is installed by the setup action
+		{name: "go unit tests", run: "go test -race ./..."},
+		{name: "wait for services", run: "timeout 60 sh -c 'until nc -z localhost 5432; do sleep 1; done'"},
+		{
+			name: "coverage",
+			run: `docker run --rm --network host -e COVERAGE_TOKEN -v "${{ github.workspace }}:/workspace" -w /workspace ` +
+				`alpine:3 sh -c './upload-coverage.sh'`,
+			condition: "(github.ref == 'refs/heads/main' || github.ref == 'refs/heads/develop') && github.event_name == 'push'",
+		},
+		{name: "docker build Dockerfile", uses: "docker/build-push-action@v6"},
+		{name: "goreleaser", uses: "goreleaser/goreleaser-action@v6", condition: "startsWith(github.ref, 'refs/tags/')"},
+	}
+	for _, test := range tests {
+		step := githubStepNamed(&job, test.name)
+		if step.Uses != test.uses || step.Run != test.run || step.If != test.condition {
+			t.Errorf("step '%s' is %+v", test.name, step)
+		}
+	}
+	if args := githubStepNamed(&job, "go lint").With["args"]; args != "--timeout 500s" {
+		t.Errorf("golangci-lint is run with '%v'", args)
+	}
+	if token := githubStepNamed(&job, "coverage").Env["COVERAGE_TOKEN"]; token != "${{ secrets.COVERAGE_TOKEN }}" {
+		t.Errorf("coverage reads its token from '%s'", token)
+	}
+	// goreleaser writes the changelog from the git history and uploads the release
+	if job.Steps[0].With["fetch-depth"] != 0 || job.Permissions["contents"] != "write" {
+		t.Errorf("goreleaser needs the full history and write access: %v %v", job.Steps[0].With, job.Permissions)
+	}
+}
+
+func TestRenderGitHubTriggers(t *testing.T) {
+	pipeline := testPipeline(testBuilds())
+	content, err := renderGitHub(&pipeline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every branch is built, whatever the default branch is called
+	for _, trigger := range []string{"push: {}", "pull_request: {}"} {
+		if !strings.Contains(string(content), trigger) {
+			t.Errorf("the workflow is not triggered by '%s':\n%s", trigger, content)
+		}
+	}
+}
+
+func TestRenderGitHubSnyk(t *testing.T) {
+	pipeline := testPipeline([]Build{{
+		Name: "docker scan Dockerfile", Phase: PhaseScan, Image: "plugins/drone-snyk", Privileged: true,
+		Settings: map[string]interface{}{
+			"dockerfile": "Dockerfile",
+			"image":      "organization/docker-image-name",
+			"snyk":       Secret("snyk_token"),
+		},
+	}})
+	out := readGitHub(t, &pipeline)
+	job := out.Jobs[githubJobName]
+	// the image is built and loaded into docker before it is scanned
+	build := githubStepNamed(&job, "build organization/docker-image-name")
+	if build.Uses != "docker/build-push-action@v6" || build.With["load"] != true || build.With["file"] != "Dockerfile" {
+		t.Errorf("the image is built with %+v", build)
+	}
+	scan := githubStepNamed(&job, "docker scan Dockerfile")
+	if scan.Uses != "snyk/actions/docker@master" || scan.Env["SNYK_TOKEN"] != "${{ secrets.SNYK_TOKEN }}" {
+		t.Errorf("the image is scanned with %+v", scan)
+	}
+}
+
+func TestGitHubCondition(t *testing.T) {
+	tests := []struct {
+		when *Condition
+		want string
+	}{
+		{when: nil, want: ""},
+		{when: &Condition{Event: []string{"pull_request"}}, want: "github.event_name == 'pull_request'"},
+		{when: &Condition{Ref: []string{"refs/heads/main"}}, want: "github.ref == 'refs/heads/main'"},
+		{
+			when: &Condition{Branch: []string{"main"}, Event: []string{"push", "tag"}},
+			want: "github.ref == 'refs/heads/main' && (github.event_name == 'push' || startsWith(github.ref, 'refs/tags/'))",
+		},
+	}
+	for _, test := range tests {
+		if got := githubCondition(test.when); got != test.want {
+			t.Errorf("githubCondition(%+v) = '%s', want '%s'", test.when, got, test.want)
+		}
+	}
+}
+
+func TestValidateGitHub(t *testing.T) {
+	tests := map[string]string{
+		"no jobs":     "name: ci\njobs: {}\n",
+		"no runs-on":  "jobs:\n  build:\n    steps:\n      - run: make\n",
+		"unknown job": "jobs:\n  build:\n    runs-on: ubuntu-latest\n    needs: [test]\n    steps:\n      - run: make\n",
+		"uses and run": "jobs:\n  build:\n    runs-on: ubuntu-latest\n    steps:\n" +
+			"      - uses: actions/checkout@v4\n        run: make\n",
+	}
+	for name, content := range tests {
+		if err := validateGitHub([]byte(content)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
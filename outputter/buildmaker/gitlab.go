@@ -0,0 +1,311 @@
+package buildmaker
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	gitlabKanikoImage = "gcr.io/kaniko-project/executor:debug"
+	gitlabDockerImage = "docker:24"
+	gitlabDindService = "docker:24-dind"
+)
+
+type (
+	gitlabJob struct {
+		Stage     string            `yaml:"stage"`
+		Image     gitlabImage       `yaml:"image"`
+		Services  []gitlabService   `yaml:"services,omitempty"`
+		Variables map[string]string `yaml:"variables,omitempty"`
+		Script    []string          `yaml:"script"`
+		Cache     *gitlabCache      `yaml:"cache,omitempty"`
+		Needs     []gitlabNeed      `yaml:"needs,omitempty"`
+		Rules     []gitlabRule      `yaml:"rules,omitempty"`
+		Parallel  *gitlabParallel   `yaml:"parallel,omitempty"`
+		Artifacts *gitlabArtifacts  `yaml:"artifacts,omitempty"`
+	}
+
+	// gitlabNeed is optional when the job it needs has rules, gitlab refuses to create a pipeline where a job needs a
+	// job that the rules left out.
+	gitlabNeed struct {
+		Job      string `yaml:"job"`
+		Optional bool   `yaml:"optional,omitempty"`
+	}
+
+	gitlabArtifacts struct {
+		Paths []string `yaml:"paths"`
+	}
+
+	gitlabParallel struct {
+		Matrix []map[string][]string `yaml:"matrix"`
+	}
+
+	// gitlabService is reached by its alias from the job.
+	gitlabService struct {
+		Name      string            `yaml:"name"`
+		Alias     string            `yaml:"alias,omitempty"`
+		Variables map[string]string `yaml:"variables,omitempty"`
+	}
+
+	gitlabImage struct {
+		Name       string   `yaml:"name"`
+		Entrypoint []string `yaml:"entrypoint,omitempty,flow"`
+	}
+
+	gitlabCache struct {
+		Key   string   `yaml:"key"`
+		Paths []string `yaml:"paths"`
+	}
+
+	gitlabRule struct {
+		If string `yaml:"if"`
+	}
+
+	// gitlabCacheConfig points a language cache inside the project directory, gitlab can only cache paths there.
+	gitlabCacheConfig struct {
+		paths     []string
+		variables map[string]string
+	}
+)
+
+// gitlabKeywords are top level keys that can not be used as job names.
+var gitlabKeywords = []string{"default", "include", "stages", "variables", "workflow", "image", "services", "cache", "before_script", "after_script", "pages"}
+
+var gitlabCaches = map[string]gitlabCacheConfig{
+	CacheGo: {
+		paths:     []string{".go/pkg/mod", ".go-build"},
+		variables: map[string]string{"GOPATH": "$CI_PROJECT_DIR/.go", "GOCACHE": "$CI_PROJECT_DIR/.go-build"},
+	},
+	CacheMaven: {
+		paths:     []string{".m2/repository"},
+		variables: map[string]string{"MAVEN_OPTS": "-Dmaven.repo.local=$CI_PROJECT_DIR/.m2/repository"},
+	},
+	CacheGradle: {
+		paths:     []string{".gradle"},
+		variables: map[string]string{"GRADLE_USER_HOME": "$CI_PROJECT_DIR/.gradle"},
+	},
+	CacheNPM: {
+		paths:     []string{".npm", "node_modules"},
+		variables: map[string]string{"npm_config_cache": "$CI_PROJECT_DIR/.npm"},
+	},
+	CacheBundler: {
+		paths:     []string{"vendor/bundle"},
+		variables: map[string]string{"BUNDLE_PATH": "$CI_PROJECT_DIR/vendor/bundle"},
+	},
+}
+
+func renderGitLab(pipeline *Pipeline) ([]byte, error) {
+	// yaml.Node keeps the stages first and the jobs in pipeline order
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	pipeline.printSkippedPlatforms("gitlab file")
+	// gitlab starts the services for each job and waits for their ports itself, so the test jobs get the services and
+	// the step that waits for them is not needed
+	primary := pipeline.primarySteps()
+	steps := make([]Step, 0, len(primary))
+	for i := range primary {
+		if len(primary[i].WaitFor) == 0 {
+			steps = append(steps, primary[i])
+		}
+	}
+	steps = keepDependencies(steps, primary)
+	var stages []string
+	for i := range steps {
+		stage := stageName(steps[i].Phase)
+		if !slices.Contains(stages, stage) {
+			stages = append(stages, stage)
+		}
+	}
+	if err := appendMapping(root, "stages", stages); err != nil {
+		return nil, err
+	}
+	var names []string
+	var jobs []gitlabJob
+	for i := range steps {
+		step := &steps[i]
+		job, ok := gitlabJobFromStep(step)
+		if !ok {
+			fmt.Printf("step '%s' uses the drone plugin '%s' which has no gitlab equivalent, skipping\n", step.Name, step.Image)
+			continue
+		}
+		if step.Phase == PhaseTest {
+			for j := range pipeline.Services {
+				service := &pipeline.Services[j]
+				job.Services = append(job.Services, gitlabService{Name: service.Image, Alias: service.Name, Variables: service.Environment})
+			}
+		}
+		if pipeline.Matrix != nil && step.MatrixVersion != "" {
+			// the job is run once for every version
+			variable := strings.ToUpper(pipeline.Matrix.Language) + "_VERSION"
+			job.Image.Name = matrixImage(step.Image, step.MatrixVersion, "$"+variable)
+			job.Parallel = &gitlabParallel{Matrix: []map[string][]string{{variable: pipeline.Matrix.Versions}}}
+		}
+		name := step.Name
+		if slices.Contains(gitlabKeywords, name) {
+			name += " job"
+		}
+		names = append(names, name)
+		jobs = append(jobs, job)
+	}
+	for i := range jobs {
+		// a job can only need jobs that exist
+		var needs []gitlabNeed
+		for _, need := range jobs[i].Needs {
+			if slices.Contains(gitlabKeywords, need.Job) {
+				need.Job += " job"
+			}
+			if index := slices.Index(names, need.Job); index >= 0 {
+				need.Optional = len(jobs[index].Rules) > 0
+				needs = append(needs, need)
+			}
+		}
+		jobs[i].Needs = needs
+		if err := appendMapping(root, names[i], jobs[i]); err != nil {
+			return nil, err
+		}
+	}
+	content, err := marshalYAML(root)
+	if err != nil {
+		return nil, err
+	}
+	// make sure what we generated can be read back
+	var check map[string]interface{}
+	if err = yaml.Unmarshal(content, &check); err != nil {
+		return nil, fmt.Errorf("generated gitlab file is not valid yaml: %s", err)
+	}
+	if len(check) != len(jobs)+1 {
+		return nil, fmt.Errorf("generated gitlab file has %d jobs, expected %d", len(check)-1, len(jobs))
+	}
+	return content, nil
+}
+
+func gitlabJobFromStep(step *Step) (job gitlabJob, ok bool) {
+	job = gitlabJob{
+		Stage: stageName(step.Phase),
+		Image: gitlabImage{Name: step.Image},
+		Rules: gitlabRules(step.When),
+	}
+	for _, need := range step.DependsOn {
+		job.Needs = append(job.Needs, gitlabNeed{Job: need})
+	}
+	switch {
+	case imageName(step.Image) == "plugins/docker":
+		// kaniko builds images without needing a privileged docker daemon
+		dockerfile := fmt.Sprint(step.Settings["dockerfile"])
+		repo := fmt.Sprint(step.Settings["repo"])
+		job.Image = gitlabImage{Name: gitlabKanikoImage, Entrypoint: []string{""}}
+		command := fmt.Sprintf(`/kaniko/executor --context "$CI_PROJECT_DIR" --dockerfile "$CI_PROJECT_DIR/%s" --destination "%s:$CI_COMMIT_SHORT_SHA"`, dockerfile, repo)
+		if dryRun, _ := step.Settings["dry_run"].(bool); dryRun {
+			command += " --no-push"
+		}
+		job.Script = []string{command}
+	case imageName(step.Image) == "plugins/drone-snyk":
+		job.Image = gitlabImage{Name: gitlabDockerImage}
+		job.Services = []gitlabService{{Name: gitlabDindService}}
+		// the job reaches the dind service over tls, see https://docs.gitlab.com/ee/ci/docker/using_docker_build.html
+		job.Variables = map[string]string{
+			"SNYK_TOKEN":         gitlabSecret(step.Settings["snyk"]),
+			"DOCKER_HOST":        "tcp://docker:2376",
+			"DOCKER_TLS_CERTDIR": "/certs",
+			"DOCKER_TLS_VERIFY":  "1",
+			"DOCKER_CERT_PATH":   "$DOCKER_TLS_CERTDIR/client",
+		}
+		image := fmt.Sprint(step.Settings["image"])
+		job.Script = []string{
+			fmt.Sprintf(`docker build -t %s -f "%v" .`, image, step.Settings["dockerfile"]),
+			// the scan container runs on the dind daemon, it shares its network and client certificates
+			fmt.Sprintf(`docker run --rm --network host -e SNYK_TOKEN -e DOCKER_HOST=tcp://localhost:2376 -e DOCKER_TLS_VERIFY=1 `+
+				`-e DOCKER_CERT_PATH=/certs/client -v /certs/client:/certs/client snyk/snyk:docker snyk container test %s`, image),
+		}
+	case len(step.Commands) > 0:
+		job.Script = step.Commands
+		if imageName(step.Image) == "goreleaser/goreleaser" {
+			// the image runs goreleaser as its entrypoint
+			job.Image.Entrypoint = []string{""}
+		}
+	default:
+		return job, false
+	}
+	for name, secret := range step.Secrets {
+		value := gitlabSecret(secret)
+		if value == "$"+name {
+			// the variable is already there
+			continue
+		}
+		if job.Variables == nil {
+			job.Variables = map[string]string{}
+		}
+		job.Variables[name] = value
+	}
+	if len(step.Artifacts) > 0 {
+		job.Artifacts = &gitlabArtifacts{Paths: step.Artifacts}
+	}
+	if cache, found := gitlabCaches[step.Cache]; found {
+		job.Cache = &gitlabCache{Key: step.Cache, Paths: cache.paths}
+		if job.Variables == nil {
+			job.Variables = map[string]string{}
+		}
+		for key, value := range cache.variables {
+			job.Variables[key] = value
+		}
+	}
+	return job, true
+}
+
+// gitlabRules converts drone style conditions into a gitlab rule, the condition types have to match together.
+func gitlabRules(when *Condition) []gitlabRule {
+	if when == nil {
+		return nil
+	}
+	var branches, refs, events []string
+	for _, branch := range when.Branch {
+		branches = append(branches, fmt.Sprintf(`$CI_COMMIT_BRANCH == "%s"`, branch))
+	}
+	for _, ref := range when.Ref {
+		switch {
+		case strings.HasPrefix(ref, "refs/tags/"):
+			refs = append(refs, "$CI_COMMIT_TAG")
+		case strings.HasPrefix(ref, "refs/heads/"):
+			refs = append(refs, fmt.Sprintf(`$CI_COMMIT_BRANCH == "%s"`, strings.TrimPrefix(ref, "refs/heads/")))
+		}
+	}
+	for _, event := range when.Event {
+		switch event {
+		case "tag":
+			events = append(events, "$CI_COMMIT_TAG")
+		case "pull_request":
+			events = append(events, `$CI_PIPELINE_SOURCE == "merge_request_event"`)
+		case "push":
+			events = append(events, `$CI_PIPELINE_SOURCE == "push"`)
+		}
+	}
+	condition := joinConditions([][]string{branches, refs, events}, " || ", " && ")
+	if condition == "" {
+		return nil
+	}
+	return []gitlabRule{{If: condition}}
+}
+
+func gitlabSecret(value interface{}) string {
+	if secret, ok := value.(Secret); ok {
+		// gitlab exposes masked CI/CD variables as environment variables
+		return "$" + strings.ToUpper(string(secret))
+	}
+	return fmt.Sprint(value)
+}
+
+// stageName returns the phase used to group steps, steps without a phase are build steps.
+func stageName(phase string) string {
+	return phaseOrder[phaseIndex(phase)]
+}
+
+func appendMapping(mapping *yaml.Node, key string, value interface{}) error {
+	valueNode := new(yaml.Node)
+	if err := valueNode.Encode(value); err != nil {
+		return err
+	}
+	mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, valueNode)
+	return nil
+}
@@ -0,0 +1,160 @@
+package buildmaker
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// readGitLab returns the stages and the jobs of a gitlab file.
+func readGitLab(t *testing.T, pipeline *Pipeline) (stages []string, jobs map[string]gitlabJob) {
+	t.Helper()
+	content, err := renderGitLab(pipeline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var file map[string]yaml.Node
+	if err = yaml.Unmarshal(content, &file); err != nil {
+		t.Fatal(err)
+	}
+	jobs = map[string]gitlabJob{}
+	for key, node := range file {
+		if key == "stages" {
+			err = node.Decode(&stages)
+		} else {
+			var job gitlabJob
+			err = node.Decode(&job)
+			jobs[key] = job
+		}
+		if err != nil {
+			t.Fatalf("invalid '%s': %s\n%s", key, err, content)
+		}
+	}
+	return stages, jobs
+}
+
+func TestRenderGitLab(t *testing.T) {
+	pipeline := testPipeline(testBuilds())
+	stages, jobs := readGitLab(t, &pipeline)
+	if want := []string{PhaseLint, PhaseTest, PhasePackage, PhaseRelease}; !reflect.DeepEqual(stages, want) {
+		t.Errorf("got stages %v, want %v", stages, want)
+	}
+	// gitlab waits for the services itself
+	if _, ok := jobs["wait for services"]; ok {
+		t.Error("the wait step should not be a job")
+	}
+	tests := []struct {
+		name, image, condition string
+		needs                  []gitlabNeed
+		services               bool
+	}{
+		{name: "go lint", image: "golangci/golangci-lint"},
+		{name: "go unit tests", image: "golang:1.21", needs: []gitlabNeed{{Job: "go lint"}}, services: true},
+		{
+			name: "coverage", image: "alpine:3", needs: []gitlabNeed{{Job: "go lint"}}, services: true,
+			condition: `($CI_COMMIT_BRANCH == "main" || $CI_COMMIT_BRANCH == "develop") && $CI_PIPELINE_SOURCE == "push"`,
+		},
+		// coverage only runs on main and develop, so the docker build does not wait for it elsewhere
+		{
+			name: "docker build Dockerfile", image: gitlabKanikoImage,
+			needs: []gitlabNeed{{Job: "go unit tests"}, {Job: "coverage", Optional: true}},
+		},
+		{
+			name: "goreleaser", image: "goreleaser/goreleaser",
+			needs: []gitlabNeed{{Job: "docker build Dockerfile"}}, condition: "$CI_COMMIT_TAG",
+		},
+	}
+	for _, test := range tests {
+		job, ok := jobs[test.name]
+		if !ok {
+			t.Errorf("missing job '%s'", test.name)
+			continue
+		}
+		if job.Image.Name != test.image {
+			t.Errorf("job '%s' runs in '%s', want '%s'", test.name, job.Image.Name, test.image)
+		}
+		if !reflect.DeepEqual(job.Needs, test.needs) {
+			t.Errorf("job '%s' needs %v, want %v", test.name, job.Needs, test.needs)
+		}
+		condition := ""
+		if len(job.Rules) > 0 {
+			condition = job.Rules[0].If
+		}
+		if len(job.Rules) > 1 || condition != test.condition {
+			t.Errorf("job '%s' has the rules %v, want '%s'", test.name, job.Rules, test.condition)
+		}
+		if services := len(job.Services) == 1 && job.Services[0].Alias == "postgres"; services != test.services {
+			t.Errorf("job '%s' has the services %v", test.name, job.Services)
+		}
+	}
+	if job := jobs["go lint"]; job.Cache == nil || job.Variables["GOPATH"] != "$CI_PROJECT_DIR/.go" {
+		t.Errorf("the go cache is not in the project directory: %+v", job)
+	}
+	// the token is already a variable of the project, it is not set to itself
+	if _, ok := jobs["goreleaser"].Variables["GITHUB_TOKEN"]; ok {
+		t.Errorf("goreleaser sets GITHUB_TOKEN to itself")
+	}
+}
+
+func TestRenderGitLabSnyk(t *testing.T) {
+	pipeline := testPipeline([]Build{{
+		Name: "docker scan Dockerfile", Phase: PhaseScan, Image: "plugins/drone-snyk", Privileged: true,
+		Settings: map[string]interface{}{
+			"dockerfile": "Dockerfile",
+			"image":      "organization/docker-image-name",
+			"snyk":       Secret("snyk_token"),
+		},
+	}})
+	_, jobs := readGitLab(t, &pipeline)
+	job := jobs["docker scan Dockerfile"]
+	if len(job.Services) != 1 || job.Services[0].Name != gitlabDindService {
+		t.Errorf("the scan does not run docker in docker: %v", job.Services)
+	}
+	if job.Variables["DOCKER_HOST"] != "tcp://docker:2376" || job.Variables["SNYK_TOKEN"] != "$SNYK_TOKEN" {
+		t.Errorf("got variables %v", job.Variables)
+	}
+	if len(job.Script) != 2 || strings.Contains(job.Script[1], "docker.sock") {
+		t.Errorf("the scan does not use the dind daemon: %v", job.Script)
+	}
+}
+
+func TestRenderGitLabKeywords(t *testing.T) {
+	pipeline := testPipeline([]Build{
+		{Name: "image", Phase: PhasePackage, Image: "alpine:3", Commands: []string{"make image"}},
+		{Name: "pages", Phase: PhaseRelease, Image: "alpine:3", Commands: []string{"make pages"}, DependsOn: []string{"image"}},
+	})
+	_, jobs := readGitLab(t, &pipeline)
+	for _, name := range []string{"image job", "pages job"} {
+		if _, ok := jobs[name]; !ok {
+			t.Errorf("missing job '%s', got %v", name, jobs)
+		}
+	}
+	if needs := jobs["pages job"].Needs; !reflect.DeepEqual(needs, []gitlabNeed{{Job: "image job"}}) {
+		t.Errorf("the pages job needs %v", needs)
+	}
+}
+
+func TestGitLabRules(t *testing.T) {
+	tests := []struct {
+		when *Condition
+		want string
+	}{
+		{when: &Condition{Event: []string{"pull_request"}}, want: `$CI_PIPELINE_SOURCE == "merge_request_event"`},
+		{when: &Condition{Ref: []string{"refs/heads/main"}}, want: `$CI_COMMIT_BRANCH == "main"`},
+		{
+			when: &Condition{Branch: []string{"main"}, Event: []string{"push", "tag"}},
+			want: `$CI_COMMIT_BRANCH == "main" && ($CI_PIPELINE_SOURCE == "push" || $CI_COMMIT_TAG)`,
+		},
+	}
+	for _, test := range tests {
+		rules := gitlabRules(test.when)
+		if len(rules) != 1 || rules[0].If != test.want {
+			t.Errorf("gitlabRules(%+v) = %v, want '%s'", test.when, rules, test.want)
+		}
+	}
+	if rules := gitlabRules(nil); rules != nil {
+		t.Errorf("got rules %v without a condition", rules)
+	}
+}
@@ -0,0 +1,262 @@
+package buildmaker
+
+import (
+	"fmt"
+	"strings"
+)
+
+type jenkinsWriter struct {
+	builder strings.Builder
+	depth   int
+}
+
+func (w *jenkinsWriter) line(format string, args ...interface{}) {
+	w.builder.WriteString(strings.Repeat("    ", w.depth))
+	fmt.Fprintf(&w.builder, format, args...)
+	w.builder.WriteString("\n")
+}
+
+func (w *jenkinsWriter) open(format string, args ...interface{}) {
+	w.line(format+" {", args...)
+	w.depth++
+}
+
+func (w *jenkinsWriter) close() {
+	w.depth--
+	w.line("}")
+}
+
+func renderJenkins(pipeline *Pipeline) ([]byte, error) {
+	if pipeline.Matrix != nil {
+		fmt.Printf("the Jenkinsfile only builds %s %s\n", pipeline.Matrix.Language, pipeline.Matrix.primary())
+	}
+	pipeline.printSkippedPlatforms("Jenkinsfile")
+	if len(pipeline.Services) > 0 {
+		names := make([]string, 0, len(pipeline.Services))
+		for i := range pipeline.Services {
+			names = append(names, pipeline.Services[i].Name)
+		}
+		fmt.Printf("the Jenkinsfile does not start the services %s, the tests need them to be running on the agent\n", strings.Join(names, ", "))
+	}
+	primary := pipeline.primarySteps()
+	steps := make([]Step, 0, len(primary))
+	for i := range primary {
+		if len(primary[i].WaitFor) > 0 {
+			continue
+		}
+		switch imageName(primary[i].Image) {
+		case "plugins/docker", "plugins/drone-snyk":
+		default:
+			if len(primary[i].Commands) == 0 {
+				fmt.Printf("step '%s' uses the drone plugin '%s' which has no jenkins equivalent, skipping\n", primary[i].Name, primary[i].Image)
+				continue
+			}
+		}
+		steps = append(steps, primary[i])
+	}
+	w := new(jenkinsWriter)
+	w.open("pipeline")
+	w.line("agent none")
+	w.open("stages")
+	for start := 0; start < len(steps); {
+		// steps in the same phase are independent and run in parallel
+		end := start + 1
+		for pipeline.Parallel && end < len(steps) && phaseIndex(steps[end].Phase) == phaseIndex(steps[start].Phase) {
+			end++
+		}
+		if end-start == 1 {
+			writeJenkinsStage(w, &steps[start])
+		} else {
+			w.open("stage(%s)", groovyString(stageName(steps[start].Phase)))
+			w.open("parallel")
+			for i := start; i < end; i++ {
+				writeJenkinsStage(w, &steps[i])
+			}
+			w.close()
+			w.close()
+		}
+		start = end
+	}
+	w.close()
+	w.close()
+	content := []byte(w.builder.String())
+	// make sure what we generated is well formed
+	if err := validateJenkins(content); err != nil {
+		return nil, fmt.Errorf("generated Jenkinsfile is not valid: %s", err)
+	}
+	return content, nil
+}
+
+func writeJenkinsStage(w *jenkinsWriter, step *Step) {
+	w.open("stage(%s)", groovyString(step.Name))
+	switch imageName(step.Image) {
+	case "plugins/docker", "plugins/drone-snyk":
+		// these need a docker daemon, so they run on an agent that has one
+		w.line("agent any")
+	default:
+		w.open("agent")
+		w.open("docker")
+		w.line("image %s", groovyString(step.Image))
+		var args []string
+		if step.Privileged {
+			args = append(args, "--privileged")
+		}
+		if imageName(step.Image) == "goreleaser/goreleaser" {
+			// jenkins keeps the container running with its own command
+			args = append(args, "--entrypoint=")
+		}
+		// named docker volumes keep the language caches between builds
+		for _, mount := range step.Volumes {
+			args = append(args, fmt.Sprintf("-v %s:%s", mount.Name, mount.Path))
+		}
+		if len(args) > 0 {
+			w.line("args %s", groovyString(strings.Join(args, " ")))
+		}
+		w.close()
+		w.close()
+	}
+	writeJenkinsWhen(w, step.When)
+	w.open("steps")
+	switch imageName(step.Image) {
+	case "plugins/docker":
+		dockerfile := fmt.Sprint(step.Settings["dockerfile"])
+		repo := fmt.Sprint(step.Settings["repo"])
+		w.line("sh %s", groovyString(fmt.Sprintf("docker build -t %s:${BUILD_NUMBER} -f %s .", repo, dockerfile)))
+		if dryRun, _ := step.Settings["dry_run"].(bool); !dryRun {
+			w.open("withCredentials([usernamePassword(credentialsId: %s, usernameVariable: 'DOCKER_USERNAME', passwordVariable: 'DOCKER_PASSWORD')])",
+				groovyString(fmt.Sprint(step.Settings["username"])))
+			w.line("sh %s", groovyString(`echo "$DOCKER_PASSWORD" | docker login -u "$DOCKER_USERNAME" --password-stdin`))
+			w.line("sh %s", groovyString(fmt.Sprintf("docker push %s:${BUILD_NUMBER}", repo)))
+			w.close()
+		}
+	case "plugins/drone-snyk":
+		w.open("withCredentials([string(credentialsId: %s, variable: 'SNYK_TOKEN')])", groovyString(fmt.Sprint(step.Settings["snyk"])))
+		w.line("sh %s", groovyString(fmt.Sprintf("docker build -t %v -f %v .", step.Settings["image"], step.Settings["dockerfile"])))
+		w.line("sh %s", groovyString(fmt.Sprintf(
+			"docker run --rm -e SNYK_TOKEN -v /var/run/docker.sock:/var/run/docker.sock snyk/snyk:docker snyk container test %v", step.Settings["image"])))
+		w.close()
+	default:
+		commands := step.Commands
+		folder := len(commands) > 0 && strings.HasPrefix(commands[0], "cd ")
+		if folder {
+			// every sh is a new shell, so the folder change has to wrap the commands
+			w.open("dir(%s)", groovyString(strings.TrimPrefix(commands[0], "cd ")))
+			commands = commands[1:]
+		}
+		if len(step.Secrets) > 0 {
+			credentials := make([]string, 0, len(step.Secrets))
+			for _, name := range step.secretNames() {
+				credentials = append(credentials, fmt.Sprintf("string(credentialsId: %s, variable: %s)", groovyString(string(step.Secrets[name])), groovyString(name)))
+			}
+			w.open("withCredentials([%s])", strings.Join(credentials, ", "))
+		}
+		for _, command := range commands {
+			w.line("sh %s", groovyString(command))
+		}
+		if len(step.Secrets) > 0 {
+			w.close()
+		}
+		if folder {
+			w.close()
+		}
+	}
+	w.close()
+	if len(step.TestReports) > 0 || len(step.Artifacts) > 0 {
+		w.open("post")
+		w.open("always")
+		if len(step.TestReports) > 0 {
+			w.line("junit allowEmptyResults: true, testResults: %s", groovyString(strings.Join(step.TestReports, ",")))
+		}
+		if len(step.Artifacts) > 0 {
+			w.line("archiveArtifacts allowEmptyArchive: true, artifacts: %s", groovyString(strings.Join(step.Artifacts, ",")))
+		}
+		w.close()
+		w.close()
+	}
+	w.close()
+}
+
+// writeJenkinsWhen writes the condition types one after another, jenkins requires all of them to match. The values of
+// one type are wrapped in anyOf.
+func writeJenkinsWhen(w *jenkinsWriter, when *Condition) {
+	if when == nil {
+		return
+	}
+	var branches, refs, events []string
+	for _, branch := range when.Branch {
+		branches = append(branches, fmt.Sprintf("branch %s", groovyString(branch)))
+	}
+	for _, ref := range when.Ref {
+		switch {
+		case strings.HasPrefix(ref, "refs/tags/"):
+			refs = append(refs, "buildingTag()")
+		case strings.HasPrefix(ref, "refs/heads/"):
+			refs = append(refs, fmt.Sprintf("branch %s", groovyString(strings.TrimPrefix(ref, "refs/heads/"))))
+		}
+	}
+	for _, event := range when.Event {
+		switch event {
+		case "tag":
+			events = append(events, "buildingTag()")
+		case "pull_request":
+			events = append(events, "changeRequest()")
+		}
+	}
+	if len(branches)+len(refs)+len(events) == 0 {
+		return
+	}
+	w.open("when")
+	for _, conditions := range [][]string{branches, refs, events} {
+		switch len(conditions) {
+		case 0:
+		case 1:
+			w.line(conditions[0])
+		default:
+			w.open("anyOf")
+			for _, condition := range conditions {
+				w.line(condition)
+			}
+			w.close()
+		}
+	}
+	w.close()
+}
+
+// groovyString quotes a value as a groovy single quoted string, these are not interpolated by groovy.
+func groovyString(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `'`, `\'`)
+	return "'" + s + "'"
+}
+
+// validateJenkins checks that the braces and strings in the generated file are balanced.
+func validateJenkins(content []byte) error {
+	depth := 0
+	inString := false
+	escaped := false
+	for i, c := range string(content) {
+		switch {
+		case escaped:
+			escaped = false
+		case inString && c == '\\':
+			escaped = true
+		case c == '\'':
+			inString = !inString
+		case inString:
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+			if depth < 0 {
+				return fmt.Errorf("unexpected '}' at offset %d", i)
+			}
+		}
+	}
+	if inString {
+		return fmt.Errorf("unterminated string")
+	}
+	if depth != 0 {
+		return fmt.Errorf("%d unclosed blocks", depth)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package buildmaker
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderJenkins(t *testing.T) {
+	pipeline := testPipeline(testBuilds())
+	content, err := renderJenkins(&pipeline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jenkinsfile := string(content)
+	for _, want := range []string{
+		"agent none",
+		"stage('go lint')",
+		"image 'golang:1.21'",
+		// the go steps share the cache volumes
+		"args '-v gocache:/root/.cache/go-build -v gomodcache:/go/pkg/mod'",
+		// the test steps run in parallel
+		"stage('test') {\n            parallel {",
+		"withCredentials([string(credentialsId: 'coverage_token', variable: 'COVERAGE_TOKEN')])",
+		"sh 'docker build -t organization/docker-image-name:${BUILD_NUMBER} -f Dockerfile .'",
+		"usernamePassword(credentialsId: 'docker_username'",
+		// the goreleaser image runs goreleaser as its entrypoint
+		"args '--entrypoint='",
+		"when {\n                buildingTag()\n            }",
+	} {
+		if !strings.Contains(jenkinsfile, want) {
+			t.Errorf("the Jenkinsfile does not contain '%s':\n%s", want, jenkinsfile)
+		}
+	}
+	// jenkins does not start services, so there is nothing to wait for
+	if strings.Contains(jenkinsfile, "wait for services") {
+		t.Errorf("the Jenkinsfile waits for the services:\n%s", jenkinsfile)
+	}
+}
+
+func TestRenderJenkinsFolder(t *testing.T) {
+	pipeline := testPipeline([]Build{{
+		Name: "go test api", Phase: PhaseTest, Image: "golang:1", Commands: []string{"cd api", "go test ./..."},
+	}})
+	content, err := renderJenkins(&pipeline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every sh is a new shell, the folder change wraps the commands
+	if want := "dir('api') {\n                    sh 'go test ./...'\n                }"; !strings.Contains(string(content), want) {
+		t.Errorf("the Jenkinsfile does not contain '%s':\n%s", want, content)
+	}
+}
+
+func TestWriteJenkinsWhen(t *testing.T) {
+	tests := []struct {
+		when *Condition
+		want string
+	}{
+		{when: nil, want: ""},
+		// jenkins has no push event
+		{when: &Condition{Event: []string{"push"}}, want: ""},
+		{when: &Condition{Event: []string{"pull_request"}}, want: "when {\n    changeRequest()\n}\n"},
+		{
+			when: &Condition{Branch: []string{"main", "develop"}, Event: []string{"tag"}},
+			want: "when {\n    anyOf {\n        branch 'main'\n        branch 'develop'\n    }\n    buildingTag()\n}\n",
+		},
+	}
+	for _, test := range tests {
+		w := new(jenkinsWriter)
+		writeJenkinsWhen(w, test.when)
+		if got := w.builder.String(); got != test.want {
+			t.Errorf("writeJenkinsWhen(%+v) = '%s', want '%s'", test.when, got, test.want)
+		}
+	}
+}
+
+func TestGroovyString(t *testing.T) {
+	tests := map[string]string{
+		"make":              "'make'",
+		"echo 'hi'":         `'echo \'hi\''`,
+		`printf "a\n"`:      `'printf "a\\n"'`,
+		"echo ${BUILD_TAG}": "'echo ${BUILD_TAG}'",
+	}
+	for in, want := range tests {
+		if got := groovyString(in); got != want {
+			t.Errorf("groovyString(%s) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestValidateJenkins(t *testing.T) {
+	tests := map[string]string{
+		"unclosed block":      "pipeline {\n  stages {\n}\n",
+		"unexpected brace":    "pipeline {\n}\n}\n",
+		"unterminated string": "pipeline {\n  sh 'make\n}\n",
+	}
+	for name, content := range tests {
+		if err := validateJenkins([]byte(content)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if err := validateJenkins([]byte("pipeline {\n  sh 'echo \\'}\\''\n}\n")); err != nil {
+		t.Errorf("a brace in a string is not a block: %s", err)
+	}
+}
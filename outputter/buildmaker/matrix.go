@@ -0,0 +1,144 @@
+package buildmaker
+
+import (
+	"fmt"
+
+	"golang.org/x/exp/slices"
+)
+
+// findMatrix returns the matrix for the first language that supports more than one version, only one language is
+// built as a matrix.
+func findMatrix(builds []Build) *Matrix {
+	for i := range builds {
+		build := &builds[i]
+		if len(build.LanguageVersions) < 2 { //nolint:gomnd
+			continue
+		}
+		if _, versioned := pinnedImage(build.Image, build.Language, build.LanguageVersions[0]); !versioned {
+			continue
+		}
+		matrix := &Matrix{Language: build.Language}
+		for _, version := range build.LanguageVersions {
+			if version = tagVersion(build.Language, version); version != "" && !slices.Contains(matrix.Versions, version) {
+				matrix.Versions = append(matrix.Versions, version)
+			}
+		}
+		if len(matrix.Versions) > 1 {
+			return matrix
+		}
+	}
+	return nil
+}
+
+// expandBuild returns a copy of the build for every version in the matrix, builds that do not use a versioned image
+// of the matrix language are pinned to their own version.
+func expandBuild(build Build, matrix *Matrix) []Build {
+	if matrix != nil && build.Language == matrix.Language {
+		if _, versioned := pinnedImage(build.Image, build.Language, matrix.primary()); versioned {
+			expanded := make([]Build, 0, len(matrix.Versions))
+			for _, version := range matrix.Versions {
+				variant := build
+				variant.LanguageVersion = version
+				variant.Image, _ = pinnedImage(build.Image, build.Language, version)
+				variant.matrixVersion = version
+				expanded = append(expanded, variant)
+			}
+			return expanded
+		}
+	}
+	pinImage(&build)
+	return []Build{build}
+}
+
+// primary is the newest version, steps that do not depend on the version are only run with it.
+func (m *Matrix) primary() string {
+	return m.Versions[len(m.Versions)-1]
+}
+
+// variants splits a matrix pipeline into one pipeline per version, for build systems without a matrix.
+func (p *Pipeline) variants() []Pipeline {
+	if p.Matrix == nil {
+		return []Pipeline{*p}
+	}
+	variants := make([]Pipeline, 0, len(p.Matrix.Versions))
+	for _, version := range p.Matrix.Versions {
+		variant := Pipeline{
+			Name:     fmt.Sprintf("%s %s %s", p.Name, p.Matrix.Language, version),
+			Parallel: p.Parallel,
+			Services: p.Services,
+		}
+		for i := range p.Steps {
+			step := p.Steps[i]
+			if step.MatrixVersion != version && (step.MatrixVersion != "" || (version != p.Matrix.primary() && step.runsOnce())) {
+				continue
+			}
+			variant.Steps = append(variant.Steps, step)
+		}
+		variant.Steps = keepDependencies(variant.Steps, p.Steps)
+		for _, volume := range p.Volumes {
+			if stepsMount(variant.Steps, volume.Name) {
+				variant.Volumes = append(variant.Volumes, volume)
+			}
+		}
+		variants = append(variants, variant)
+	}
+	return variants
+}
+
+// primarySteps returns the steps of the newest version and the steps that do not depend on the version.
+func (p *Pipeline) primarySteps() []Step {
+	if p.Matrix == nil {
+		return p.Steps
+	}
+	var steps []Step
+	for i := range p.Steps {
+		if p.Steps[i].MatrixVersion == "" || p.Steps[i].MatrixVersion == p.Matrix.primary() {
+			steps = append(steps, p.Steps[i])
+		}
+	}
+	return keepDependencies(steps, p.Steps)
+}
+
+// keepDependencies replaces the dependencies on steps that are not in the list with their own dependencies, so the
+// order of the remaining steps is kept.
+func keepDependencies(steps, all []Step) []Step {
+	names := make([]string, 0, len(steps))
+	for i := range steps {
+		names = append(names, steps[i].Name)
+	}
+	dependencies := map[string][]string{}
+	for i := range all {
+		dependencies[all[i].Name] = append(dependencies[all[i].Name], all[i].DependsOn...)
+	}
+	for i := range steps {
+		var dependsOn []string
+		pending := append([]string{}, steps[i].DependsOn...)
+		visited := map[string]bool{}
+		for len(pending) > 0 {
+			dependency := pending[0]
+			pending = pending[1:]
+			if visited[dependency] {
+				continue
+			}
+			visited[dependency] = true
+			if slices.Contains(names, dependency) {
+				dependsOn = append(dependsOn, dependency)
+			} else {
+				pending = append(pending, dependencies[dependency]...)
+			}
+		}
+		steps[i].DependsOn = dependsOn
+	}
+	return steps
+}
+
+func stepsMount(steps []Step, volume string) bool {
+	for i := range steps {
+		for _, mount := range steps[i].Volumes {
+			if mount.Name == volume {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package buildmaker
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// testMatrixBuilds are the test builds for a project that supports go 1.21 and 1.22.
+func testMatrixBuilds() []Build {
+	builds := testBuilds()
+	for i := range builds {
+		if builds[i].Language == LanguageGo {
+			builds[i].LanguageVersions = []string{"1.21.0", "1.22", "1.22.3"}
+		}
+	}
+	return builds
+}
+
+func TestFindMatrix(t *testing.T) {
+	matrix := findMatrix(testMatrixBuilds())
+	if matrix == nil || matrix.Language != LanguageGo || !reflect.DeepEqual(matrix.Versions, []string{"1.21", "1.22"}) {
+		t.Errorf("got matrix %+v", matrix)
+	}
+	// a single version, or one that is not in the image tag, is not a matrix
+	if matrix := findMatrix(testBuilds()); matrix != nil {
+		t.Errorf("got matrix %+v for a single version", matrix)
+	}
+	lint := Build{Image: "golangci/golangci-lint", Language: LanguageGo, LanguageVersions: []string{"1.21", "1.22"}}
+	if matrix := findMatrix([]Build{lint}); matrix != nil {
+		t.Errorf("got matrix %+v for an unversioned image", matrix)
+	}
+}
+
+func TestExpandBuild(t *testing.T) {
+	matrix := &Matrix{Language: LanguageGo, Versions: []string{"1.21", "1.22"}}
+	expanded := expandBuild(Build{Name: "test", Image: "golang:1-alpine", Language: LanguageGo}, matrix)
+	if len(expanded) != 2 || expanded[0].Image != "golang:1.21-alpine" || expanded[1].matrixVersion != "1.22" {
+		t.Errorf("got builds %+v", expanded)
+	}
+	// other languages are pinned to their own version
+	expanded = expandBuild(Build{Name: "web", Image: "node:lts", Language: LanguageNode, LanguageVersion: "20"}, matrix)
+	if len(expanded) != 1 || expanded[0].Image != "node:20" || expanded[0].matrixVersion != "" {
+		t.Errorf("got builds %+v", expanded)
+	}
+}
+
+func TestRenderDroneMatrix(t *testing.T) {
+	pipeline := testPipeline(testMatrixBuilds())
+	content, err := renderDrone(&pipeline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipelines := readDrone(t, content)
+	if len(pipelines) != 2 {
+		t.Fatalf("expected a pipeline for each go version, got %d\n%s", len(pipelines), content)
+	}
+	// the steps that do not depend on the go version only run in the pipeline of the newest version
+	older, newest := pipelines[0], pipelines[1]
+	if newest.Name != "default go 1.22" || len(newest.Steps) != 6 {
+		t.Errorf("pipeline '%s' has %d steps", newest.Name, len(newest.Steps))
+	}
+	for _, step := range older.Steps {
+		if step.Name == "goreleaser" || step.Name == "docker build Dockerfile" {
+			t.Errorf("pipeline '%s' runs '%s'", older.Name, step.Name)
+		}
+		if step.Name == "go unit tests" && step.Image != "golang:1.21" {
+			t.Errorf("go 1.21 tests run in '%s'", step.Image)
+		}
+	}
+}
+
+func TestRenderGitHubMatrix(t *testing.T) {
+	pipeline := testPipeline(testMatrixBuilds())
+	job := readGitHub(t, &pipeline).Jobs[githubJobName]
+	if job.Strategy == nil || !reflect.DeepEqual(job.Strategy.Matrix[LanguageGo], []string{"1.21", "1.22"}) {
+		t.Fatalf("got strategy %+v", job.Strategy)
+	}
+	if setup := githubStepNamed(&job, "set up go"); setup.With["go-version"] != "${{ matrix.go }}" {
+		t.Errorf("go is set up with %v", setup.With)
+	}
+	// the steps that do not depend on the version only run once
+	if condition := githubStepNamed(&job, "goreleaser").If; condition != "(startsWith(github.ref, 'refs/tags/')) && matrix.go == '1.22'" {
+		t.Errorf("goreleaser runs if '%s'", condition)
+	}
+	if condition := githubStepNamed(&job, "go unit tests").If; condition != "" {
+		t.Errorf("the tests run if '%s'", condition)
+	}
+}
+
+func TestRenderGitLabMatrix(t *testing.T) {
+	pipeline := testPipeline(testMatrixBuilds())
+	_, jobs := readGitLab(t, &pipeline)
+	job := jobs["go unit tests"]
+	if job.Image.Name != "golang:$GO_VERSION" || job.Parallel == nil {
+		t.Fatalf("the tests are not a matrix job: %+v", job)
+	}
+	if versions := job.Parallel.Matrix[0]["GO_VERSION"]; !reflect.DeepEqual(versions, []string{"1.21", "1.22"}) {
+		t.Errorf("the tests run for %v", versions)
+	}
+	if jobs["goreleaser"].Parallel != nil {
+		t.Error("goreleaser runs for every version")
+	}
+}
+
+func TestRenderCIEMatrix(t *testing.T) {
+	pipeline := testPipeline(testMatrixBuilds())
+	content, err := renderCIE(&pipeline, &CIEConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out ciePipeline
+	if err = yaml.Unmarshal(content, &out); err != nil {
+		t.Fatal(err)
+	}
+	stage := out.Pipeline.Stages[0].Stage
+	if stage.Strategy == nil || !reflect.DeepEqual(stage.Strategy.Matrix[LanguageGo], []string{"1.21", "1.22"}) {
+		t.Fatalf("got strategy %+v", stage.Strategy)
+	}
+	steps := cieSteps(stage.Spec.Execution.Steps, map[string]*cieStep{})
+	if image := steps["go_unit_tests"].Spec.Image; image != "golang:<+matrix.go>" {
+		t.Errorf("the tests run in '%s'", image)
+	}
+	if when := steps["goreleaser"].When; when == nil || when.Condition != `(<+codebase.build.type> == "tag") && <+matrix.go> == "1.22"` {
+		t.Errorf("goreleaser runs when %+v", when)
+	}
+}
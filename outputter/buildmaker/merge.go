@@ -0,0 +1,239 @@
+package buildmaker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tphoney/best_practice/outputter"
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+)
+
+// mergeDrone adds the generated steps that are missing from the existing drone file, existing steps are never
+// changed so user customisations and comments are kept. It returns a description of every change made.
+func mergeDrone(droneFile string, pipeline *Pipeline) (changes []string, diff string, err error) {
+	original, err := os.ReadFile(droneFile)
+	if err != nil {
+		return nil, "", err
+	}
+	documents, err := outputter.DecodeYAMLDocuments(original)
+	if err != nil {
+		return nil, "", fmt.Errorf("error reading %s '%s'", droneFile, err)
+	}
+	var pipelines []*yaml.Node
+	for _, document := range documents {
+		if len(document.Content) == 0 {
+			continue
+		}
+		kind := outputter.YAMLMappingValue(document.Content[0], "kind")
+		steps := outputter.YAMLMappingValue(document.Content[0], "steps")
+		if kind != nil && kind.Value == "pipeline" && steps != nil && steps.Kind == yaml.SequenceNode {
+			pipelines = append(pipelines, document.Content[0])
+		}
+	}
+	if len(pipelines) == 0 {
+		return nil, "", fmt.Errorf("no pipelines found in %s", droneFile)
+	}
+	// existing step names of equivalent steps, so depends_on can point at them
+	renamed := map[string]string{}
+	// an existing pipeline is not split up for a matrix, it gets the steps of the newest version
+	primary := pipeline.primarySteps()
+	for i := range primary {
+		step := &primary[i]
+		target := pipelineForStep(pipelines, step)
+		pipelineName := outputter.YAMLMappingValue(target, "name").Value
+		steps := outputter.YAMLMappingValue(target, "steps")
+		if existing := equivalentStep(steps, step); existing != "" {
+			renamed[step.Name] = existing
+			continue
+		}
+		ds := droneStepFromStep(step)
+		// only depend on steps that exist in the pipeline, and only if the pipeline is already a graph. Adding
+		// depends_on to a sequential pipeline would make its existing steps run in parallel.
+		var dependsOn []string
+		if !usesDependsOn(steps) {
+			ds.DependsOn = nil
+		}
+		for _, dependency := range ds.DependsOn {
+			if name, ok := renamed[dependency]; ok {
+				dependency = name
+			}
+			if outputter.FindDroneStep(steps, dependency) != nil && !slices.Contains(dependsOn, dependency) {
+				dependsOn = append(dependsOn, dependency)
+			}
+		}
+		ds.DependsOn = dependsOn
+		node := new(yaml.Node)
+		if err = node.Encode(ds); err != nil {
+			return nil, "", err
+		}
+		steps.Content = append(steps.Content, node)
+		changes = append(changes, fmt.Sprintf("pipeline '%s' added step '%s'", pipelineName, step.Name))
+		for _, mount := range step.Volumes {
+			if addTempVolume(target, mount.Name) {
+				changes = append(changes, fmt.Sprintf("pipeline '%s' added temporary volume '%s'", pipelineName, mount.Name))
+			}
+		}
+		for j := range pipeline.Services {
+			if slices.Contains(step.WaitFor, pipeline.Services[j].Name) && addService(target, &pipeline.Services[j]) {
+				changes = append(changes, fmt.Sprintf("pipeline '%s' added service '%s'", pipelineName, pipeline.Services[j].Name))
+			}
+		}
+	}
+	if len(changes) == 0 {
+		return nil, "", nil
+	}
+	updated, err := outputter.EncodeYAMLDocuments(original, documents)
+	if err != nil {
+		return nil, "", err
+	}
+	// make sure what we generated can be read back
+	if _, err = outputter.DecodeYAMLDocuments(updated); err != nil {
+		return nil, "", fmt.Errorf("merged drone file is not valid yaml: %s", err)
+	}
+	diff, err = outputter.UnifiedDiff(filepath.Base(droneFile), original, updated)
+	if err != nil {
+		return nil, "", err
+	}
+	return changes, diff, outputter.WriteToFile(droneFile, string(updated))
+}
+
+// pipelineForStep picks the existing pipeline that already builds the same language, falling back to the first one.
+func pipelineForStep(pipelines []*yaml.Node, step *Step) *yaml.Node {
+	best := pipelines[0]
+	bestScore := 0
+	for _, pipeline := range pipelines {
+		score := 0
+		for _, existing := range outputter.YAMLMappingValue(pipeline, "steps").Content {
+			image := outputter.YAMLMappingValue(existing, "image")
+			if image == nil {
+				continue
+			}
+			if imageName(image.Value) == imageName(step.Image) || (step.Language != "" && strings.Contains(imageName(image.Value), step.Language)) {
+				score++
+			}
+		}
+		if score > bestScore {
+			best = pipeline
+			bestScore = score
+		}
+	}
+	return best
+}
+
+// equivalentStep returns the name of an existing step that does the same job as the generated step.
+func equivalentStep(steps *yaml.Node, step *Step) string {
+	key := commandKey(step.Commands)
+	for _, node := range steps.Content {
+		var existing droneStep
+		if err := node.Decode(&existing); err != nil {
+			continue
+		}
+		if existing.Name == step.Name {
+			return existing.Name
+		}
+		// plugins are the same if they use the same image on the same dockerfile
+		if len(step.Commands) == 0 && imageName(existing.Image) == imageName(step.Image) {
+			if existing.Settings["dockerfile"] == nil || step.Settings["dockerfile"] == nil || existing.Settings["dockerfile"] == step.Settings["dockerfile"] {
+				return existing.Name
+			}
+		}
+		if key == "" {
+			continue
+		}
+		for _, command := range existing.Commands {
+			if strings.Contains(command, key) {
+				return existing.Name
+			}
+		}
+	}
+	return ""
+}
+
+// setupCommands get a step ready, many steps start with them so they do not tell steps apart.
+var setupCommands = []string{"cd ", "bundle install", "npm ci", "npm install", "yarn install", "pnpm install", "go install ", "go mod download"}
+
+// commandKey returns the tool and sub command of the first command that is not set up, eg 'go test',
+// 'npm run lint' or 'bundle exec rspec'.
+func commandKey(commands []string) string {
+	command := ""
+	for _, candidate := range commands {
+		setup := false
+		for _, prefix := range setupCommands {
+			if strings.HasPrefix(candidate, prefix) {
+				setup = true
+				break
+			}
+		}
+		if !setup {
+			command = candidate
+			break
+		}
+	}
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return ""
+	}
+	length := 2
+	switch {
+	case len(fields) > 2 && fields[1] == "run" && slices.Contains([]string{"npm", "yarn", "pnpm"}, fields[0]):
+		length = 3
+	case len(fields) > 2 && fields[0] == "bundle" && fields[1] == "exec":
+		length = 3
+	}
+	if len(fields) < length {
+		length = len(fields)
+	}
+	return strings.Join(fields[:length], " ")
+}
+
+func usesDependsOn(steps *yaml.Node) bool {
+	for _, step := range steps.Content {
+		if outputter.YAMLMappingValue(step, "depends_on") != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// addTempVolume declares a temporary volume on the pipeline if it is not already there.
+func addTempVolume(pipeline *yaml.Node, name string) bool {
+	volumes := outputter.YAMLMappingValue(pipeline, "volumes")
+	if volumes == nil {
+		volumes = &yaml.Node{Kind: yaml.SequenceNode}
+		pipeline.Content = append(pipeline.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "volumes"}, volumes)
+	}
+	for _, volume := range volumes.Content {
+		if existing := outputter.YAMLMappingValue(volume, "name"); existing != nil && existing.Value == name {
+			return false
+		}
+	}
+	node := new(yaml.Node)
+	if err := node.Encode(droneVolume{Name: name, Temp: &struct{}{}}); err != nil {
+		return false
+	}
+	volumes.Content = append(volumes.Content, node)
+	return true
+}
+
+// addService adds a service to the pipeline if there is not already one with the same name.
+func addService(pipeline *yaml.Node, service *Service) bool {
+	services := outputter.YAMLMappingValue(pipeline, "services")
+	if services == nil {
+		services = &yaml.Node{Kind: yaml.SequenceNode}
+		pipeline.Content = append(pipeline.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "services"}, services)
+	}
+	for _, existing := range services.Content {
+		if name := outputter.YAMLMappingValue(existing, "name"); name != nil && name.Value == service.Name {
+			return false
+		}
+	}
+	node := new(yaml.Node)
+	if err := node.Encode(droneServiceFromService(service)); err != nil {
+		return false
+	}
+	services.Content = append(services.Content, node)
+	return true
+}
@@ -0,0 +1,155 @@
+package buildmaker
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testDroneFile = `# the build of the project
+kind: pipeline
+type: docker
+name: default
+
+steps:
+  - name: test
+    image: golang:1.21
+    commands:
+      - go mod download
+      - go test -v ./... # keep the verbose output
+  - name: publish
+    image: plugins/docker
+    settings:
+      repo: organization/docker-image-name
+`
+
+func writeDroneFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), droneFileName)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestMergeDrone(t *testing.T) {
+	path := writeDroneFile(t, testDroneFile)
+	pipeline := testPipeline(testBuilds())
+	changes, diff, err := mergeDrone(path, &pipeline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"pipeline 'default' added step 'go lint'",
+		"pipeline 'default' added temporary volume 'gocache'",
+		"pipeline 'default' added temporary volume 'gomodcache'",
+		"pipeline 'default' added step 'wait for services'",
+		"pipeline 'default' added service 'postgres'",
+		"pipeline 'default' added step 'coverage'",
+		"pipeline 'default' added step 'goreleaser'",
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("got changes\n%s\nwant\n%s", strings.Join(changes, "\n"), strings.Join(want, "\n"))
+	}
+	if !strings.Contains(diff, "+  - name: go lint") {
+		t.Errorf("the diff does not add the lint step:\n%s", diff)
+	}
+	merged, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the existing steps and comments are kept
+	for _, kept := range []string{"# the build of the project", "go test -v ./... # keep the verbose output", "name: publish"} {
+		if !strings.Contains(string(merged), kept) {
+			t.Errorf("the merged file lost '%s':\n%s", kept, merged)
+		}
+	}
+	pipelines := readDrone(t, merged)
+	if len(pipelines) != 1 {
+		t.Fatalf("expected a single pipeline, got %d", len(pipelines))
+	}
+	var names []string
+	for _, step := range pipelines[0].Steps {
+		names = append(names, step.Name)
+		// the existing pipeline runs its steps in order, depends_on would make them run in parallel
+		if len(step.DependsOn) > 0 {
+			t.Errorf("step '%s' depends on %v", step.Name, step.DependsOn)
+		}
+	}
+	if want := []string{"test", "publish", "go lint", "wait for services", "coverage", "goreleaser"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got steps %v, want %v", names, want)
+	}
+	// merging again finds every step
+	changes, _, err = mergeDrone(path, &pipeline)
+	if err != nil || len(changes) != 0 {
+		t.Errorf("merging twice made the changes %v, %v", changes, err)
+	}
+}
+
+func TestMergeDroneDependsOn(t *testing.T) {
+	path := writeDroneFile(t, `kind: pipeline
+type: docker
+name: default
+
+steps:
+  - name: unit
+    image: golang:1.21
+    commands:
+      - go test ./...
+  - name: image
+    image: alpine:3
+    commands:
+      - make image
+    depends_on:
+      - unit
+`)
+	pipeline := testPipeline([]Build{
+		{Name: "go unit tests", Phase: PhaseTest, Image: "golang:1", Commands: []string{"go test ./..."}},
+		{Name: "go vet", Phase: PhaseTest, Image: "golang:1", Commands: []string{"go vet ./..."}},
+		{Name: "go build", Phase: PhaseBuild, Image: "golang:1", Commands: []string{"go build ./cmd/app"}},
+	})
+	if _, _, err := mergeDrone(path, &pipeline); err != nil {
+		t.Fatal(err)
+	}
+	merged, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, step := range readDrone(t, merged)[0].Steps {
+		// the generated unit test step is the existing 'unit' step
+		if step.Name == "go build" && !reflect.DeepEqual(step.DependsOn, []string{"unit", "go vet"}) {
+			t.Errorf("go build depends on %v", step.DependsOn)
+		}
+	}
+}
+
+func TestMergeDroneWithoutPipeline(t *testing.T) {
+	path := writeDroneFile(t, "kind: secret\nname: token\n")
+	pipeline := testPipeline(testBuilds())
+	if _, _, err := mergeDrone(path, &pipeline); err == nil {
+		t.Error("expected an error for a file without pipelines")
+	}
+}
+
+func TestCommandKey(t *testing.T) {
+	tests := []struct {
+		commands []string
+		want     string
+	}{
+		{commands: nil, want: ""},
+		{commands: []string{"go test -race ./..."}, want: "go test"},
+		{commands: []string{"cd api", "go mod download", "go vet ./..."}, want: "go vet"},
+		{commands: []string{"npm ci", "npm run lint -- --fix"}, want: "npm run lint"},
+		{commands: []string{"bundle install", "bundle exec rspec spec/"}, want: "bundle exec rspec"},
+		{commands: []string{"make"}, want: "make"},
+		// a step that only sets up has nothing to compare
+		{commands: []string{"npm install"}, want: ""},
+	}
+	for _, test := range tests {
+		if got := commandKey(test.commands); got != test.want {
+			t.Errorf("commandKey(%v) = '%s', want '%s'", test.commands, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package buildmaker
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type (
+	// Pipeline is the build file independent representation of a generated build.
+	Pipeline struct {
+		Name     string
+		Steps    []Step
+		Volumes  []Volume
+		Services []Service
+		// Parallel is set when steps in the same phase run at the same time.
+		Parallel bool
+		// Matrix is set when the versioned steps are run for more than one language version.
+		Matrix *Matrix
+		// Platform is the platform the pipeline runs on, it is linux/amd64 when empty.
+		Platform *Platform
+		// Platforms are the pipelines that build the images for the other platforms.
+		Platforms []Pipeline
+		// Manifest joins the images of every platform, it runs after all of the other pipelines.
+		Manifest *Pipeline
+	}
+
+	// Platform is an os and cpu architecture, the variant tells apart versions of arm.
+	Platform struct {
+		OS      string `json:"os" yaml:"os"`
+		Arch    string `json:"arch" yaml:"arch"`
+		Variant string `json:"variant,omitempty" yaml:"variant,omitempty"`
+	}
+
+	// Matrix lists the versions of a language the pipeline is built with, oldest first.
+	Matrix struct {
+		Language string
+		Versions []string
+	}
+
+	// Step is a single unit of work in a pipeline.
+	Step struct {
+		Name     string
+		Image    string
+		Commands []string
+		Settings map[string]interface{}
+		// Secrets maps environment variables to the secrets they are read from.
+		Secrets    map[string]Secret
+		Privileged bool
+		Volumes    []VolumeMount
+		DependsOn  []string
+		When       *Condition
+		Phase      string
+		Cache      string
+		// Language and LanguageVersion are used by build systems that install a toolchain rather than use an image.
+		Language        string
+		LanguageVersion string
+		TestReports     []string
+		Artifacts       []string
+		// MatrixVersion is the matrix version the step is run for, it is empty for steps that do not depend on it.
+		MatrixVersion string
+		// WaitFor names the services the step waits for, it is run for every matrix version.
+		WaitFor []string
+	}
+
+	// Service is a container that runs next to the steps for the whole pipeline.
+	Service struct {
+		Name        string
+		Image       string
+		Environment map[string]string
+		// Ports are the tcp ports the container listens on.
+		Ports []int
+	}
+
+	// Volume is a volume shared between the steps of a pipeline, temporary volumes only live as long as the pipeline.
+	Volume struct {
+		Name string
+		Temp bool
+	}
+
+	// VolumeMount mounts a pipeline volume into a step.
+	VolumeMount struct {
+		Name string `json:"name" yaml:"name"`
+		Path string `json:"path" yaml:"path"`
+	}
+
+	// Condition limits when a step runs.
+	Condition struct {
+		Branch []string `json:"branch,omitempty" yaml:"branch,omitempty"`
+		Event  []string `json:"event,omitempty" yaml:"event,omitempty"`
+		Ref    []string `json:"ref,omitempty" yaml:"ref,omitempty"`
+	}
+
+	// Secret is a setting value that is read from the secret store of the build system.
+	Secret string
+)
+
+// buildPipeline converts the scan results into a pipeline.
+func buildPipeline(name string, builds []Build, matrix *Matrix) Pipeline {
+	pipeline := Pipeline{Name: name}
+	runnable := make([]Build, 0, len(builds))
+	var platformBuilds, manifestBuilds []Build
+	for i := range builds {
+		switch {
+		case builds[i].Image == "":
+			// results without an image only carry advice, they can not be run
+		case builds[i].Service:
+			pipeline.Services = appendService(pipeline.Services, &builds[i])
+		case !builds[i].Platform.isDefault():
+			platformBuilds = append(platformBuilds, builds[i])
+		case builds[i].Phase == PhaseManifest:
+			manifestBuilds = append(manifestBuilds, builds[i])
+		default:
+			runnable = append(runnable, builds[i])
+		}
+	}
+	if len(manifestBuilds) > 0 && !pushesImages(builds) {
+		fmt.Println("the platform images are built with dry_run and not pushed, so there is no manifest to join them")
+		manifestBuilds = nil
+	}
+	if len(platformBuilds) == 0 {
+		// with a single platform the manifest is the last step of the pipeline
+		runnable = append(runnable, manifestBuilds...)
+	} else {
+		pipeline.Platforms = platformPipelines(name, runnable, platformBuilds, matrix)
+		manifest := Pipeline{Name: name + " manifest"}
+		manifestBuilds, manifest.Parallel = arrangeSteps(manifestBuilds)
+		for i := range manifestBuilds {
+			manifest.Steps = append(manifest.Steps, stepFromBuild(&manifestBuilds[i]))
+		}
+		if len(manifest.Steps) > 0 {
+			pipeline.Manifest = &manifest
+		}
+	}
+	// the linux/amd64 image of a multi platform build copies in the linux/amd64 binaries
+	var images []Build
+	for i := range runnable {
+		if runnable[i].Platform != nil {
+			images = append(images, runnable[i])
+		}
+	}
+	if len(images) > 0 {
+		runnable = crossCompileBuilds(runnable, &Platform{OS: "linux", Arch: "amd64"}, images)
+	}
+	if !runsTests(runnable) {
+		// the services are only started for the tests
+		pipeline.Services = nil
+	} else if wait, ok := waitBuild(pipeline.Services); ok {
+		runnable = append(runnable, wait)
+	}
+	runnable, pipeline.Parallel = arrangeSteps(runnable)
+	pipeline.Volumes = cacheVolumes(runnable)
+	for i := range runnable {
+		pipeline.Steps = append(pipeline.Steps, stepFromBuild(&runnable[i]))
+		if runnable[i].matrixVersion != "" {
+			pipeline.Matrix = matrix
+		}
+	}
+	return pipeline
+}
+
+// joinConditions combines the conditions like drone does, the values of one type (branch, ref or event) are or-ed
+// and the types are and-ed.
+func joinConditions(groups [][]string, or, and string) string {
+	var types [][]string
+	for _, group := range groups {
+		if len(group) > 0 {
+			types = append(types, group)
+		}
+	}
+	joined := make([]string, 0, len(types))
+	for _, group := range types {
+		if len(group) > 1 && len(types) > 1 {
+			joined = append(joined, "("+strings.Join(group, or)+")")
+		} else {
+			joined = append(joined, strings.Join(group, or))
+		}
+	}
+	return strings.Join(joined, and)
+}
+
+// secretNames are the environment variables the step reads from secrets, in order.
+func (s *Step) secretNames() []string {
+	names := make([]string, 0, len(s.Secrets))
+	for name := range s.Secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func stepFromBuild(build *Build) Step {
+	return Step{
+		Name:            build.Name,
+		Image:           build.Image,
+		Commands:        build.Commands,
+		Settings:        build.Settings,
+		Secrets:         build.Secrets,
+		Privileged:      build.Privileged,
+		Volumes:         build.Volumes,
+		DependsOn:       build.DependsOn,
+		When:            build.When,
+		Phase:           build.Phase,
+		Cache:           build.Cache,
+		Language:        build.Language,
+		LanguageVersion: build.LanguageVersion,
+		TestReports:     build.TestReports,
+		Artifacts:       build.Artifacts,
+		MatrixVersion:   build.matrixVersion,
+		WaitFor:         build.waitFor,
+	}
+}
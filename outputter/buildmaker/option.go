@@ -31,3 +31,62 @@ func WithCIEOutput(i bool) Option {
 		p.outputCIE = i
 	}
 }
+
+func WithGitHubOutput(i bool) Option {
+	return func(p *outputterConfig) {
+		p.outputGitHub = i
+	}
+}
+
+func WithGitLabOutput(i bool) Option {
+	return func(p *outputterConfig) {
+		p.outputGitLab = i
+	}
+}
+
+func WithJenkinsOutput(i bool) Option {
+	return func(p *outputterConfig) {
+		p.outputJenkins = i
+	}
+}
+
+// WithDroneMerge adds the missing steps to an existing drone file, instead of writing a .new file.
+func WithDroneMerge(i bool) Option {
+	return func(p *outputterConfig) {
+		p.mergeDrone = i
+	}
+}
+
+// WithDroneStarlark writes matrix builds as a .drone.star file that generates a pipeline per version.
+func WithDroneStarlark(i bool) Option {
+	return func(p *outputterConfig) {
+		p.droneStarlark = i
+	}
+}
+
+func WithCIEConfig(i CIEConfig) Option {
+	return func(p *outputterConfig) {
+		p.cieConfig = i
+	}
+}
+
+// WithTemplateDirectory overrides the generated steps with the templates in the directory, see loadTemplates.
+func WithTemplateDirectory(i string) Option {
+	return func(p *outputterConfig) {
+		p.templateDir = i
+	}
+}
+
+// WithAvailableChecks lists the checks of each scanner family, templates that do not match a check are rejected.
+func WithAvailableChecks(i map[string][]string) Option {
+	return func(p *outputterConfig) {
+		p.availableChecks = i
+	}
+}
+
+// WithImageDigests pins images to the digests in a yaml file that maps 'image:tag' to 'sha256:...'.
+func WithImageDigests(i string) Option {
+	return func(p *outputterConfig) {
+		p.digestFile = i
+	}
+}
@@ -0,0 +1,172 @@
+package buildmaker
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"golang.org/x/exp/slices"
+)
+
+// phases of a build, steps are run in this order. Steps in the same phase do not depend on each other.
+const (
+	PhaseDeps     = "deps"
+	PhaseLint     = "lint"
+	PhaseServices = "services"
+	PhaseTest     = "test"
+	PhaseBuild    = "build"
+	PhasePackage  = "package"
+	PhaseScan     = "scan"
+	PhaseRelease  = "release"
+	PhaseManifest = "manifest"
+)
+
+// caches that can be shared between the steps of a pipeline.
+const (
+	CacheGo      = "go"
+	CacheMaven   = "maven"
+	CacheGradle  = "gradle"
+	CacheNPM     = "npm"
+	CacheBundler = "bundler"
+)
+
+// languages that have a toolchain to set up.
+const (
+	LanguageGo   = "go"
+	LanguageNode = "node"
+	LanguageJava = "java"
+	LanguageRuby = "ruby"
+)
+
+var (
+	phaseOrder = []string{PhaseDeps, PhaseLint, PhaseServices, PhaseTest, PhaseBuild, PhasePackage, PhaseScan, PhaseRelease, PhaseManifest}
+
+	// cacheMounts are the locations each language keeps its caches in the official images. node_modules lives in the
+	// workspace which drone already shares between steps, so we cache the npm download cache instead.
+	cacheMounts = map[string][]VolumeMount{
+		CacheGo: {
+			{Name: "gocache", Path: "/root/.cache/go-build"},
+			{Name: "gomodcache", Path: "/go/pkg/mod"},
+		},
+		CacheMaven:   {{Name: "m2", Path: "/root/.m2"}},
+		CacheGradle:  {{Name: "gradle", Path: "/home/gradle/.gradle"}},
+		CacheNPM:     {{Name: "npm", Path: "/root/.npm"}},
+		CacheBundler: {{Name: "bundler", Path: "/usr/local/bundle"}},
+	}
+)
+
+// arrangeSteps orders the steps by phase, removes duplicates, gives every step a unique name and wires up depends_on
+// so that steps in the same phase can run in parallel.
+func arrangeSteps(builds []Build) (arranged []Build, parallel bool) {
+	arranged = make([]Build, 0, len(builds))
+	for i := range builds {
+		duplicate := false
+		for j := range arranged {
+			if sameStep(&arranged[j], &builds[i]) {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			arranged = append(arranged, builds[i])
+		}
+	}
+	sort.SliceStable(arranged, func(i, j int) bool {
+		return phaseIndex(arranged[i].Phase) < phaseIndex(arranged[j].Phase)
+	})
+	// make the step names unique, the copies of a step for each matrix version keep the same name. A numbered name
+	// must not be the name of another step either.
+	names := map[string]bool{}
+	for i := range arranged {
+		names[arranged[i].Name+"@"+arranged[i].matrixVersion] = true
+	}
+	used := map[string]bool{}
+	for i := range arranged {
+		name := arranged[i].Name
+		if used[name+"@"+arranged[i].matrixVersion] {
+			for count := 2; ; count++ {
+				name = fmt.Sprintf("%s %d", arranged[i].Name, count)
+				if key := name + "@" + arranged[i].matrixVersion; !used[key] && !names[key] {
+					break
+				}
+			}
+			arranged[i].Name = name
+		}
+		used[name+"@"+arranged[i].matrixVersion] = true
+	}
+	// group the steps into phases
+	var groups [][]int
+	for i := range arranged {
+		if i == 0 || phaseIndex(arranged[i].Phase) != phaseIndex(arranged[i-1].Phase) {
+			groups = append(groups, nil)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], i)
+	}
+	for _, group := range groups {
+		if len(group) > 1 {
+			parallel = true
+		}
+	}
+	// if every phase has a single step the order of the steps is enough
+	if !parallel {
+		return arranged, false
+	}
+	for g := 1; g < len(groups); g++ {
+		for _, i := range groups[g] {
+			if len(arranged[i].DependsOn) > 0 {
+				continue
+			}
+			for _, j := range groups[g-1] {
+				// a matrix step only depends on steps for the same version
+				version := arranged[j].matrixVersion
+				if version != "" && arranged[i].matrixVersion != "" && version != arranged[i].matrixVersion {
+					continue
+				}
+				if !slices.Contains(arranged[i].DependsOn, arranged[j].Name) {
+					arranged[i].DependsOn = append(arranged[i].DependsOn, arranged[j].Name)
+				}
+			}
+		}
+	}
+	return arranged, true
+}
+
+// cacheVolumes mounts a temporary volume into every step that uses a cache, a volume is only worth it if more than one
+// step shares the cache.
+func cacheVolumes(builds []Build) (volumes []Volume) {
+	users := map[string]int{}
+	for i := range builds {
+		if builds[i].Cache != "" {
+			users[builds[i].Cache]++
+		}
+	}
+	added := map[string]bool{}
+	for i := range builds {
+		cache := builds[i].Cache
+		if users[cache] < 2 { //nolint:gomnd
+			continue
+		}
+		for _, mount := range cacheMounts[cache] {
+			builds[i].Volumes = append(builds[i].Volumes, mount)
+			if !added[mount.Name] {
+				added[mount.Name] = true
+				volumes = append(volumes, Volume{Name: mount.Name, Temp: true})
+			}
+		}
+	}
+	return volumes
+}
+
+func phaseIndex(phase string) int {
+	for i := range phaseOrder {
+		if phaseOrder[i] == phase {
+			return i
+		}
+	}
+	// steps without a phase are treated as build steps
+	return phaseIndex(PhaseBuild)
+}
+
+func sameStep(a, b *Build) bool {
+	return a.Image == b.Image && a.matrixVersion == b.matrixVersion && reflect.DeepEqual(a.Commands, b.Commands) && reflect.DeepEqual(a.Settings, b.Settings)
+}
@@ -0,0 +1,100 @@
+package buildmaker
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestArrangeStepsOrder(t *testing.T) {
+	builds := []Build{
+		{Name: "image", Phase: PhasePackage, Image: "plugins/docker"},
+		{Name: "test", Phase: PhaseTest, Image: "golang:1", Commands: []string{"go test ./..."}},
+		{Name: "lint", Phase: PhaseLint, Image: "golangci/golangci-lint", Commands: []string{"golangci-lint run"}},
+		// the same step suggested twice is only added once
+		{Name: "test again", Phase: PhaseTest, Image: "golang:1", Commands: []string{"go test ./..."}},
+	}
+	arranged, parallel := arrangeSteps(builds)
+	var names []string
+	for i := range arranged {
+		names = append(names, arranged[i].Name)
+	}
+	if want := []string{"lint", "test", "image"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got steps %v, want %v", names, want)
+	}
+	if parallel {
+		t.Error("expected a single step in each phase to run in order")
+	}
+	for i := range arranged {
+		if len(arranged[i].DependsOn) > 0 {
+			t.Errorf("step '%s' depends on %v", arranged[i].Name, arranged[i].DependsOn)
+		}
+	}
+}
+
+func TestArrangeStepsDependsOn(t *testing.T) {
+	builds := []Build{
+		{Name: "lint", Phase: PhaseLint, Image: "golangci/golangci-lint", Commands: []string{"golangci-lint run"}},
+		{Name: "test", Phase: PhaseTest, Image: "golang:1", Commands: []string{"go test ./..."}},
+		{Name: "race", Phase: PhaseTest, Image: "golang:1", Commands: []string{"go test -race ./..."}},
+		{Name: "build", Phase: PhaseBuild, Image: "golang:1", Commands: []string{"go build"}},
+	}
+	arranged, parallel := arrangeSteps(builds)
+	if !parallel {
+		t.Fatal("expected the test steps to run in parallel")
+	}
+	want := map[string][]string{
+		"lint":  nil,
+		"test":  {"lint"},
+		"race":  {"lint"},
+		"build": {"test", "race"},
+	}
+	for i := range arranged {
+		if !reflect.DeepEqual(arranged[i].DependsOn, want[arranged[i].Name]) {
+			t.Errorf("step '%s' depends on %v, want %v", arranged[i].Name, arranged[i].DependsOn, want[arranged[i].Name])
+		}
+	}
+}
+
+func TestArrangeStepsUniqueNames(t *testing.T) {
+	builds := []Build{
+		{Name: "go build", Phase: PhaseBuild, Image: "golang:1", Commands: []string{"go build ./cmd/a"}},
+		{Name: "go build", Phase: PhaseBuild, Image: "golang:1", Commands: []string{"go build ./cmd/b"}},
+		// a step that already has the name the second build would be numbered with
+		{Name: "go build 2", Phase: PhaseBuild, Image: "golang:1", Commands: []string{"go build ./cmd/c"}},
+	}
+	arranged, _ := arrangeSteps(builds)
+	seen := map[string]bool{}
+	for i := range arranged {
+		if seen[arranged[i].Name] {
+			t.Errorf("duplicate step name '%s'", arranged[i].Name)
+		}
+		seen[arranged[i].Name] = true
+	}
+	if !seen["go build 3"] {
+		t.Errorf("expected the second 'go build' to be numbered 3, got %v", seen)
+	}
+}
+
+func TestCacheVolumes(t *testing.T) {
+	builds := []Build{
+		{Name: "lint", Cache: CacheGo},
+		{Name: "test", Cache: CacheGo},
+		{Name: "image"},
+	}
+	volumes := cacheVolumes(builds)
+	if len(volumes) != len(cacheMounts[CacheGo]) {
+		t.Errorf("got volumes %v", volumes)
+	}
+	for _, volume := range volumes {
+		if !volume.Temp {
+			t.Errorf("volume '%s' is not temporary", volume.Name)
+		}
+	}
+	if len(builds[0].Volumes) == 0 || len(builds[1].Volumes) == 0 || len(builds[2].Volumes) != 0 {
+		t.Errorf("only the go steps should mount the cache: %v", builds)
+	}
+	// a cache used by a single step is not worth a volume
+	if volumes := cacheVolumes(builds[:1]); len(volumes) != 0 {
+		t.Errorf("got volumes %v for a single step", volumes)
+	}
+}
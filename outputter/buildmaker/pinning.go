@@ -0,0 +1,130 @@
+package buildmaker
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/tphoney/best_practice/scanner"
+	"gopkg.in/yaml.v3"
+)
+
+var digestPattern = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+// languageImages are the official images whose tag is the language version, eg 'golang:1.21-alpine'.
+var languageImages = map[string]string{
+	"golang": LanguageGo,
+	"node":   LanguageNode,
+	"ruby":   LanguageRuby,
+}
+
+// pinImage replaces a floating language image tag with the major.minor version the project declares, any variant
+// suffix such as '-alpine' is kept.
+func pinImage(build *Build) {
+	if build.LanguageVersion == "" {
+		return
+	}
+	build.Image, _ = pinnedImage(build.Image, build.Language, build.LanguageVersion)
+}
+
+// pinnedImage returns the image for a language version, versioned is false if the image does not follow the language
+// version.
+func pinnedImage(image, language, version string) (pinned string, versioned bool) {
+	if strings.Contains(image, "@") {
+		return image, false
+	}
+	name := imageName(image)
+	tag := strings.TrimPrefix(image[len(name):], ":")
+	imageLanguage, official := languageImages[name]
+	switch {
+	case official && imageLanguage == language:
+		variant := ""
+		if i := strings.Index(tag, "-"); i >= 0 {
+			variant = tag[i:]
+		}
+		if version = majorMinor(version); version != "" {
+			image = fmt.Sprintf("%s:%s%s", name, version, variant)
+		}
+		return image, true
+	case name == "maven" && language == LanguageJava:
+		if major := javaMajor(version); major != "" {
+			image = fmt.Sprintf("maven:3-eclipse-temurin-%s", major)
+		}
+		return image, true
+	case name == "gradle" && language == LanguageJava:
+		if major := javaMajor(version); major != "" {
+			image = fmt.Sprintf("gradle:jdk%s", major)
+		}
+		return image, true
+	}
+	return image, false
+}
+
+// tagVersion is the part of a language version that is used in image tags.
+func tagVersion(language, version string) string {
+	if language == LanguageJava {
+		return javaMajor(version)
+	}
+	return majorMinor(version)
+}
+
+// matrixImage replaces the version in the image tag with a build system variable, digests are dropped as they only
+// match a single version.
+func matrixImage(image, version, variable string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	name := imageName(image)
+	tag := image[len(name):]
+	i := strings.LastIndex(tag, version)
+	if i < 0 {
+		return image
+	}
+	return name + tag[:i] + variable + tag[i+len(version):]
+}
+
+// majorMinor returns the first two parts of the first version in a version string or constraint, eg '>=18.2.1' is
+// '18.2'.
+func majorMinor(version string) string {
+	match := scanner.FindVersion(version)
+	if match == "" {
+		return ""
+	}
+	parts := strings.Split(match, ".")
+	if len(parts) > 2 { //nolint:gomnd
+		parts = parts[:2]
+	}
+	return strings.Join(parts, ".")
+}
+
+// javaMajor returns the java major version, old versions are written as '1.8'.
+func javaMajor(version string) string {
+	match := scanner.FindVersion(version)
+	if match == "" {
+		return ""
+	}
+	parts := strings.Split(match, ".")
+	if parts[0] == "1" && len(parts) > 1 {
+		return parts[1]
+	}
+	return parts[0]
+}
+
+// loadDigests reads a yaml file mapping 'image:tag' to the 'sha256:' digest it should be pinned to.
+func loadDigests(path string) (map[string]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	digests := map[string]string{}
+	if err = yaml.Unmarshal(content, &digests); err != nil {
+		return nil, fmt.Errorf("error reading image digests '%s': %s", path, err)
+	}
+	for image, digest := range digests {
+		if !digestPattern.MatchString(digest) {
+			return nil, fmt.Errorf("image digests '%s' has an invalid digest '%s' for '%s'", path, digest, image)
+		}
+	}
+	return digests, nil
+}
@@ -0,0 +1,66 @@
+package buildmaker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPinnedImage(t *testing.T) {
+	tests := []struct {
+		image, language, version string
+		want                     string
+		versioned                bool
+	}{
+		{image: "golang:1", language: LanguageGo, version: "1.21.5", want: "golang:1.21", versioned: true},
+		{image: "golang:1-alpine", language: LanguageGo, version: "1.22", want: "golang:1.22-alpine", versioned: true},
+		{image: "node:lts", language: LanguageNode, version: ">=18.2", want: "node:18.2", versioned: true},
+		{image: "maven:3", language: LanguageJava, version: "1.8", want: "maven:3-eclipse-temurin-8", versioned: true},
+		{image: "gradle:8", language: LanguageJava, version: "17", want: "gradle:jdk17", versioned: true},
+		// images that are not the language image keep their tag
+		{image: "golangci/golangci-lint", language: LanguageGo, version: "1.21", want: "golangci/golangci-lint"},
+		{image: "node:20", language: LanguageGo, version: "1.21", want: "node:20"},
+		// a digest is already pinned
+		{image: "golang:1@sha256:abc", language: LanguageGo, version: "1.21", want: "golang:1@sha256:abc"},
+	}
+	for _, test := range tests {
+		got, versioned := pinnedImage(test.image, test.language, test.version)
+		if got != test.want || versioned != test.versioned {
+			t.Errorf("pinnedImage(%s, %s, %s) = %s %t, want %s %t", test.image, test.language, test.version, got, versioned, test.want, test.versioned)
+		}
+	}
+}
+
+func TestVersionParts(t *testing.T) {
+	for version, want := range map[string]string{"1.21.5": "1.21", "~> 3.2": "3.2", "18": "18", "latest": ""} {
+		if got := majorMinor(version); got != want {
+			t.Errorf("majorMinor(%s) = '%s', want '%s'", version, got, want)
+		}
+	}
+	for version, want := range map[string]string{"1.8": "8", "17.0.2": "17", "21": "21", "": ""} {
+		if got := javaMajor(version); got != want {
+			t.Errorf("javaMajor(%s) = '%s', want '%s'", version, got, want)
+		}
+	}
+}
+
+func TestLoadDigests(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "digests.yml")
+	digest := "sha256:" + "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	if err := os.WriteFile(path, []byte("golang:1.21: "+digest+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	digests, err := loadDigests(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digests["golang:1.21"] != digest {
+		t.Errorf("got digests %v", digests)
+	}
+	if err = os.WriteFile(path, []byte("golang:1.21: latest\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = loadDigests(path); err == nil {
+		t.Error("expected an error for a value that is not a digest")
+	}
+}
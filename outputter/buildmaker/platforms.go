@@ -0,0 +1,177 @@
+package buildmaker
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// goBuildCommand is how the go build steps compile, see crossCompile.
+const goBuildCommand = "go build "
+
+// isDefault returns true for linux/amd64, the platform pipelines run on when they do not set one.
+func (p *Platform) isDefault() bool {
+	return p == nil || (p.OS == "linux" && p.Arch == "amd64")
+}
+
+// String returns the platform as docker writes it, eg 'linux/arm/v7'.
+func (p *Platform) String() string {
+	if p.Variant != "" {
+		return p.OS + "/" + p.Arch + "/" + p.Variant
+	}
+	return p.OS + "/" + p.Arch
+}
+
+// platformPipelines returns a pipeline for each platform. The images usually copy in what the build steps made, so
+// the build steps are run on every platform too, cross compiling the go binaries for it.
+func platformPipelines(name string, builds, platformBuilds []Build, matrix *Matrix) (pipelines []Pipeline) {
+	var shared []Build
+	for i := range builds {
+		build := builds[i]
+		if phaseIndex(build.Phase) != phaseIndex(PhaseBuild) || (build.matrixVersion != "" && build.matrixVersion != matrix.primary()) {
+			continue
+		}
+		build.matrixVersion = ""
+		shared = append(shared, build)
+	}
+	var platforms []*Platform
+	grouped := map[string][]Build{}
+	for i := range platformBuilds {
+		key := platformBuilds[i].Platform.String()
+		if _, found := grouped[key]; !found {
+			platforms = append(platforms, platformBuilds[i].Platform)
+		}
+		grouped[key] = append(grouped[key], platformBuilds[i])
+	}
+	for _, platform := range platforms {
+		pipeline := Pipeline{
+			Name:     name + " " + strings.ReplaceAll(platform.String(), "/", " "),
+			Platform: platform,
+		}
+		images := grouped[platform.String()]
+		var arranged []Build
+		arranged, pipeline.Parallel = arrangeSteps(append(crossCompileBuilds(shared, platform, images), images...))
+		for i := range arranged {
+			pipeline.Steps = append(pipeline.Steps, stepFromBuild(&arranged[i]))
+		}
+		pipelines = append(pipelines, pipeline)
+	}
+	return pipelines
+}
+
+// crossCompileBuilds returns the builds with their go build commands compiling for a platform, into the binaries the
+// images copy in.
+func crossCompileBuilds(builds []Build, platform *Platform, images []Build) []Build {
+	var binaries []string
+	for i := range images {
+		binaries = append(binaries, images[i].Binaries...)
+	}
+	goBuilds := 0
+	for i := range builds {
+		if isGoBuild(&builds[i]) {
+			goBuilds++
+		}
+	}
+	compiled := make([]Build, len(builds))
+	for i := range builds {
+		compiled[i] = builds[i]
+		if isGoBuild(&builds[i]) {
+			compiled[i].Commands = crossCompile(builds[i].Commands, platform, binaries, goBuilds == 1)
+		}
+	}
+	return compiled
+}
+
+func isGoBuild(build *Build) bool {
+	if build.Language != LanguageGo {
+		return false
+	}
+	for _, command := range build.Commands {
+		if strings.Contains(command, goBuildCommand) {
+			return true
+		}
+	}
+	return false
+}
+
+// crossCompile sets the platform of the go build commands, and writes the binary to the release binary with the same
+// name as the package. A single go build writes the only binary whatever its name, otherwise the binaries are written
+// to 'release/<os>/<arch>/'.
+func crossCompile(commands []string, platform *Platform, binaries []string, single bool) []string {
+	env := fmt.Sprintf("CGO_ENABLED=0 GOOS=%s GOARCH=%s", platform.OS, platform.Arch)
+	if platform.Arch == "arm" && platform.Variant != "" {
+		env += " GOARM=" + strings.TrimPrefix(platform.Variant, "v")
+	}
+	dir := "."
+	compiled := make([]string, 0, len(commands))
+	for _, command := range commands {
+		if folder, found := strings.CutPrefix(command, "cd "); found {
+			dir = path.Clean(folder)
+		}
+		index := strings.Index(command, goBuildCommand)
+		if index < 0 {
+			compiled = append(compiled, command)
+			continue
+		}
+		args := strings.Fields(command[index+len(goBuildCommand):])
+		if slices.Contains(args, "-o") {
+			// the command already says where the binary goes
+			compiled = append(compiled, command)
+			continue
+		}
+		pkg := "."
+		if len(args) > 0 {
+			pkg = args[len(args)-1]
+		}
+		output := path.Join("release", platform.OS, platform.Arch) + "/"
+		for _, binary := range binaries {
+			if path.Base(binary) == path.Base(path.Join(dir, pkg)) || (single && len(binaries) == 1) {
+				output = binary
+			}
+		}
+		// the output is relative to the folder the command runs in
+		if dir != "." {
+			output = strings.Repeat("../", strings.Count(dir, "/")+1) + output
+		}
+		compiled = append(compiled, command[:index]+env+" "+goBuildCommand+"-o "+output+" "+command[index+len(goBuildCommand):])
+	}
+	return compiled
+}
+
+// pushesImages returns false if an image of a platform is only built, the manifest can only join pushed images.
+func pushesImages(builds []Build) bool {
+	for i := range builds {
+		if builds[i].Platform == nil || imageName(builds[i].Image) != "plugins/docker" {
+			continue
+		}
+		if dryRun, _ := builds[i].Settings["dry_run"].(bool); dryRun {
+			return false
+		}
+	}
+	return true
+}
+
+// pipelineNames returns the names of the pipelines the manifest depends on, a matrix pipeline has one per version.
+func (p *Pipeline) pipelineNames() (names []string) {
+	for _, variant := range p.variants() {
+		names = append(names, variant.Name)
+	}
+	for i := range p.Platforms {
+		names = append(names, p.Platforms[i].Name)
+	}
+	return names
+}
+
+// printSkippedPlatforms tells the user that a build file only builds for linux/amd64.
+func (p *Pipeline) printSkippedPlatforms(buildFile string) {
+	if len(p.Platforms) == 0 {
+		return
+	}
+	platforms := make([]string, 0, len(p.Platforms))
+	for i := range p.Platforms {
+		platforms = append(platforms, p.Platforms[i].Platform.String())
+	}
+	fmt.Printf("the %s only builds linux/amd64, the images for %s and their manifest are not built\n", buildFile, strings.Join(platforms, ", "))
+}
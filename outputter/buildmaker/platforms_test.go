@@ -0,0 +1,205 @@
+package buildmaker
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// testPlatformBuilds are the builds of a go plugin whose image is built for three platforms and joined by a manifest.
+func testPlatformBuilds(dryRun bool) []Build {
+	image := func(platform Platform, binary string) Build {
+		settings := map[string]interface{}{"repo": "organization/docker-image-name", "dockerfile": "docker/Dockerfile." + platform.OS + "." + platform.Arch}
+		if dryRun {
+			settings["dry_run"] = true
+		}
+		return Build{
+			Name: "docker build " + platform.String(), Phase: PhasePackage, Image: "plugins/docker", Privileged: true,
+			Settings: settings, Platform: &platform, Binaries: []string{binary},
+			When: &Condition{Event: []string{"push", "tag"}},
+		}
+	}
+	return []Build{
+		{
+			Name: "go build", Phase: PhaseBuild, Cache: CacheGo, Image: "golang:1",
+			Language: LanguageGo, LanguageVersion: "1.21",
+			Commands: []string{"go build ."},
+		},
+		image(Platform{OS: "linux", Arch: "amd64"}, "release/linux/amd64/plugin"),
+		image(Platform{OS: "linux", Arch: "arm64"}, "release/linux/arm64/plugin"),
+		image(Platform{OS: "linux", Arch: "arm", Variant: "v7"}, "release/linux/arm/plugin"),
+		{
+			Name: "docker manifest docker", Phase: PhaseManifest, Image: "plugins/manifest",
+			Settings: map[string]interface{}{"spec": "docker/manifest.tmpl"},
+			When:     &Condition{Event: []string{"push", "tag"}},
+		},
+	}
+}
+
+func stepCommands(steps []Step, name string) []string {
+	for i := range steps {
+		if steps[i].Name == name {
+			return steps[i].Commands
+		}
+	}
+	return nil
+}
+
+func TestCrossCompile(t *testing.T) {
+	arm := &Platform{OS: "linux", Arch: "arm", Variant: "v7"}
+	arm64 := &Platform{OS: "linux", Arch: "arm64"}
+	tests := []struct {
+		commands []string
+		platform *Platform
+		binaries []string
+		single   bool
+		want     []string
+	}{
+		{
+			commands: []string{"go build ."}, platform: arm64, binaries: []string{"release/linux/arm64/plugin"}, single: true,
+			want: []string{"CGO_ENABLED=0 GOOS=linux GOARCH=arm64 go build -o release/linux/arm64/plugin ."},
+		},
+		{
+			commands: []string{"go build ."}, platform: arm, binaries: []string{"release/linux/arm/plugin"}, single: true,
+			want: []string{"CGO_ENABLED=0 GOOS=linux GOARCH=arm GOARM=7 go build -o release/linux/arm/plugin ."},
+		},
+		// several go builds only write the binary named after their package
+		{
+			commands: []string{"go build ./cmd/server"}, platform: arm64, binaries: []string{"release/linux/arm64/server"},
+			want: []string{"CGO_ENABLED=0 GOOS=linux GOARCH=arm64 go build -o release/linux/arm64/server ./cmd/server"},
+		},
+		{
+			commands: []string{"go build ./cmd/client"}, platform: arm64, binaries: []string{"release/linux/arm64/server"},
+			want: []string{"CGO_ENABLED=0 GOOS=linux GOARCH=arm64 go build -o release/linux/arm64/ ./cmd/client"},
+		},
+		{
+			commands: []string{"cd tools/plugin", "go build ."}, platform: arm64, binaries: []string{"release/linux/arm64/plugin"}, single: true,
+			want: []string{"cd tools/plugin", "CGO_ENABLED=0 GOOS=linux GOARCH=arm64 go build -o ../../release/linux/arm64/plugin ."},
+		},
+		// the command already says where the binary goes
+		{
+			commands: []string{"go build -o bin/plugin ."}, platform: arm64, binaries: []string{"release/linux/arm64/plugin"}, single: true,
+			want: []string{"go build -o bin/plugin ."},
+		},
+	}
+	for _, test := range tests {
+		if got := crossCompile(test.commands, test.platform, test.binaries, test.single); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("crossCompile(%v, %s) = %v, want %v", test.commands, test.platform, got, test.want)
+		}
+	}
+}
+
+func TestPushesImages(t *testing.T) {
+	tests := []struct {
+		builds []Build
+		want   bool
+	}{
+		{builds: testPlatformBuilds(false), want: true},
+		{builds: testPlatformBuilds(true), want: false},
+		// only the platform images are joined by the manifest
+		{builds: []Build{{Image: "plugins/docker", Settings: map[string]interface{}{"dry_run": true}}}, want: true},
+	}
+	for i, test := range tests {
+		if got := pushesImages(test.builds); got != test.want {
+			t.Errorf("pushesImages(%d) = %t, want %t", i, got, test.want)
+		}
+	}
+}
+
+func TestPlatformPipelines(t *testing.T) {
+	pipeline := testPipeline(testPlatformBuilds(false))
+	tests := []struct {
+		name     string
+		steps    []string
+		commands []string
+	}{
+		{
+			name: "default linux arm64", steps: []string{"go build", "docker build linux/arm64"},
+			commands: []string{"CGO_ENABLED=0 GOOS=linux GOARCH=arm64 go build -o release/linux/arm64/plugin ."},
+		},
+		{
+			name: "default linux arm v7", steps: []string{"go build", "docker build linux/arm/v7"},
+			commands: []string{"CGO_ENABLED=0 GOOS=linux GOARCH=arm GOARM=7 go build -o release/linux/arm/plugin ."},
+		},
+	}
+	if len(pipeline.Platforms) != len(tests) {
+		t.Fatalf("got %d platform pipelines, want %d", len(pipeline.Platforms), len(tests))
+	}
+	for i, test := range tests {
+		platform := pipeline.Platforms[i]
+		if platform.Name != test.name {
+			t.Errorf("got pipeline '%s', want '%s'", platform.Name, test.name)
+		}
+		if got := stepNames(platform.Steps); !reflect.DeepEqual(got, test.steps) {
+			t.Errorf("pipeline '%s' has steps %v, want %v", test.name, got, test.steps)
+		}
+		if got := stepCommands(platform.Steps, "go build"); !reflect.DeepEqual(got, test.commands) {
+			t.Errorf("pipeline '%s' builds with %v, want %v", test.name, got, test.commands)
+		}
+	}
+	// the linux/amd64 image is built by the default pipeline
+	want := []string{"CGO_ENABLED=0 GOOS=linux GOARCH=amd64 go build -o release/linux/amd64/plugin ."}
+	if got := stepCommands(pipeline.Steps, "go build"); !reflect.DeepEqual(got, want) {
+		t.Errorf("the default pipeline builds with %v, want %v", got, want)
+	}
+	content, err := renderDrone(&pipeline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipelines := readDrone(t, content)
+	if len(pipelines) != 4 { //nolint:gomnd
+		t.Fatalf("got %d drone pipelines, want 4", len(pipelines))
+	}
+	manifest := pipelines[3]
+	wantDepends := []string{"default", "default linux arm64", "default linux arm v7"}
+	if manifest.Name != "default manifest" || !reflect.DeepEqual(manifest.DependsOn, wantDepends) {
+		t.Errorf("got manifest pipeline '%s' depending on %v, want %v", manifest.Name, manifest.DependsOn, wantDepends)
+	}
+	if arm := pipelines[2].Platform; arm.Arch != "arm" || arm.Variant != "v7" {
+		t.Errorf("the arm pipeline runs on %+v", arm)
+	}
+}
+
+func TestPlatformPipelinesDryRun(t *testing.T) {
+	// the manifest can only join pushed images
+	pipeline := testPipeline(testPlatformBuilds(true))
+	if pipeline.Manifest != nil {
+		t.Errorf("got a manifest pipeline for images that are not pushed: %+v", pipeline.Manifest)
+	}
+	if len(pipeline.Platforms) != 2 { //nolint:gomnd
+		t.Errorf("got %d platform pipelines, want 2", len(pipeline.Platforms))
+	}
+}
+
+func TestRenderCIEPlatforms(t *testing.T) {
+	tests := []struct {
+		builds []Build
+		stages []string
+	}{
+		// harness does not build linux/arm, so the manifest would miss its image
+		{builds: testPlatformBuilds(false), stages: []string{"default", "default_linux_arm64"}},
+		{
+			builds: append(testPlatformBuilds(false)[:3], testPlatformBuilds(false)[4]),
+			stages: []string{"default", "default_linux_arm64", "default_manifest"},
+		},
+	}
+	for _, test := range tests {
+		pipeline := testPipeline(test.builds)
+		content, err := renderCIE(&pipeline, &CIEConfig{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out ciePipeline
+		if err = yaml.Unmarshal(content, &out); err != nil {
+			t.Fatal(err)
+		}
+		var stages []string
+		for _, stage := range out.Pipeline.Stages {
+			stages = append(stages, stage.Stage.Identifier)
+		}
+		if !reflect.DeepEqual(stages, test.stages) {
+			t.Errorf("got stages %v, want %v", stages, test.stages)
+		}
+	}
+}
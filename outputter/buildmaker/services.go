@@ -0,0 +1,67 @@
+package buildmaker
+
+import (
+	"fmt"
+)
+
+const (
+	waitStepName = "wait for services"
+	// waitImage has nc, which the language images do not always have
+	waitImage = "busybox:1.36"
+	// waitTimeout is the number of seconds a service has to open its port
+	waitTimeout = 60
+)
+
+// appendService adds a service build to the services, the first service with a name wins.
+func appendService(services []Service, build *Build) []Service {
+	for i := range services {
+		if services[i].Name == build.Name {
+			return services
+		}
+	}
+	return append(services, Service{
+		Name:        build.Name,
+		Image:       build.Image,
+		Environment: build.Environment,
+		Ports:       build.Ports,
+	})
+}
+
+// waitBuild returns a step that waits for the ports of the services to open, the test steps run after it.
+func waitBuild(services []Service) (Build, bool) {
+	wait := Build{
+		Name:     waitStepName,
+		Image:    waitImage,
+		Commands: waitCommands(services, func(service *Service) string { return service.Name }),
+		Phase:    PhaseServices,
+	}
+	for i := range services {
+		wait.waitFor = append(wait.waitFor, services[i].Name)
+	}
+	return wait, len(wait.Commands) > 0
+}
+
+func runsTests(builds []Build) bool {
+	for i := range builds {
+		if builds[i].Phase == PhaseTest {
+			return true
+		}
+	}
+	return false
+}
+
+// waitCommands waits for every port of the services, host returns the host name a service is reached at.
+func waitCommands(services []Service, host func(*Service) string) (commands []string) {
+	for i := range services {
+		for _, port := range services[i].Ports {
+			commands = append(commands, fmt.Sprintf("timeout %d sh -c 'until nc -z %s %d; do sleep 1; done'", waitTimeout, host(&services[i]), port))
+		}
+	}
+	return commands
+}
+
+// runsOnce returns true for steps that do not depend on the matrix version, they are only run for the newest version.
+// The services run for every version, so the step that waits for them does too.
+func (s *Step) runsOnce() bool {
+	return s.MatrixVersion == "" && len(s.WaitFor) == 0
+}
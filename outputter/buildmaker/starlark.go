@@ -0,0 +1,209 @@
+package buildmaker
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/tphoney/best_practice/scanner/dronescanner"
+	"gopkg.in/yaml.v3"
+)
+
+// starlarkVersion is replaced with the version variable in image names.
+const starlarkVersion = "\x00version\x00"
+
+type starlarkWriter struct {
+	builder strings.Builder
+	depth   int
+}
+
+func (w *starlarkWriter) line(format string, args ...interface{}) {
+	w.builder.WriteString(strings.Repeat("    ", w.depth))
+	fmt.Fprintf(&w.builder, format, args...)
+	w.builder.WriteString("\n")
+}
+
+// renderDroneStarlark writes a matrix pipeline as a drone starlark file that generates one pipeline per version.
+func renderDroneStarlark(pipeline *Pipeline, workingDirectory string) ([]byte, error) {
+	variants := pipeline.variants()
+	primary := variants[len(variants)-1]
+	// every version other than the newest has the same steps
+	other := variants[0]
+	versions := make([]string, 0, len(pipeline.Matrix.Versions))
+	for _, version := range pipeline.Matrix.Versions {
+		versions = append(versions, strconv.Quote(version))
+	}
+	w := new(starlarkWriter)
+	w.line("# builds every %s version, steps that do not depend on the version only run with the newest one", pipeline.Matrix.Language)
+	w.line("versions = [%s]", strings.Join(versions, ", "))
+	// the pipelines of the other platforms and the manifest do not depend on the version
+	extra := dronePipelines(pipeline)[len(variants):]
+	main := "[pipeline(version) for version in versions]"
+	if len(extra) > 0 {
+		node := new(yaml.Node)
+		if err := node.Encode(extra); err != nil {
+			return nil, err
+		}
+		w.line("platforms = %s", starlarkValue(node, 0, nil))
+		main += " + platforms"
+	}
+	w.line("")
+	w.line("def main(ctx):")
+	w.line("    return %s", main)
+	w.line("")
+	w.line("def pipeline(version):")
+	w.depth++
+	w.line("primary = version == versions[-1]")
+	w.line("steps = []")
+	for i := range primary.Steps {
+		step := &primary.Steps[i]
+		node := new(yaml.Node)
+		if err := node.Encode(droneStepFromStep(step)); err != nil {
+			return nil, err
+		}
+		overrides := map[string]string{}
+		if step.MatrixVersion != "" {
+			overrides["image"] = starlarkVersioned(matrixImage(step.Image, step.MatrixVersion, starlarkVersion))
+		}
+		if step.runsOnce() {
+			w.line("if primary:")
+			w.depth++
+		} else {
+			for j := range other.Steps {
+				if other.Steps[j].Name == step.Name && !reflect.DeepEqual(other.Steps[j].DependsOn, step.DependsOn) {
+					overrides["depends_on"] = fmt.Sprintf("%s if primary else %s", starlarkList(step.DependsOn), starlarkList(other.Steps[j].DependsOn))
+				}
+			}
+		}
+		w.line("steps.append(%s)", starlarkValue(node, w.depth, overrides))
+		if step.runsOnce() {
+			w.depth--
+		}
+	}
+	out := map[string]string{
+		"name":    starlarkVersioned(fmt.Sprintf("%s %s %s", pipeline.Name, pipeline.Matrix.Language, starlarkVersion)),
+		"volumes": starlarkVolumes(primary.Volumes),
+	}
+	if otherVolumes := starlarkVolumes(other.Volumes); otherVolumes != out["volumes"] {
+		out["volumes"] = fmt.Sprintf("%s if primary else %s", out["volumes"], otherVolumes)
+	}
+	w.line("return {")
+	w.line(`    "kind": "pipeline",`)
+	w.line(`    "type": "docker",`)
+	w.line(`    "name": %s,`, out["name"])
+	w.line(`    "platform": {"os": "linux", "arch": "amd64"},`)
+	w.line(`    "steps": steps,`)
+	if len(pipeline.Services) > 0 {
+		services := make([]droneService, 0, len(pipeline.Services))
+		for i := range pipeline.Services {
+			services = append(services, droneServiceFromService(&pipeline.Services[i]))
+		}
+		node := new(yaml.Node)
+		if err := node.Encode(services); err != nil {
+			return nil, err
+		}
+		w.line(`    "services": %s,`, starlarkValue(node, w.depth+1, nil))
+	}
+	w.line(`    "volumes": %s,`, out["volumes"])
+	w.line("}")
+	content := []byte(w.builder.String())
+	// make sure what we generated runs and creates the same pipelines as the yaml
+	pipelines, err := dronescanner.EvaluateStarlark(workingDirectory, droneStarlarkFileName, content, nil)
+	if err != nil {
+		return nil, fmt.Errorf("generated drone starlark file is not valid: %s", err)
+	}
+	expected := dronePipelines(pipeline)
+	if len(pipelines) != len(expected) {
+		return nil, fmt.Errorf("generated drone starlark file has %d pipelines, expected %d", len(pipelines), len(expected))
+	}
+	for i := range expected {
+		if len(pipelines[i].Steps) != len(expected[i].Steps) {
+			return nil, fmt.Errorf("generated drone starlark pipeline '%s' has %d steps, expected %d", pipelines[i].Name, len(pipelines[i].Steps), len(expected[i].Steps))
+		}
+	}
+	return content, nil
+}
+
+// starlarkValue converts a yaml node into a starlark literal, the values of overridden keys are used as they are.
+func starlarkValue(node *yaml.Node, depth int, overrides map[string]string) string {
+	indent := strings.Repeat("    ", depth)
+	switch node.Kind {
+	case yaml.DocumentNode:
+		return starlarkValue(node.Content[0], depth, overrides)
+	case yaml.MappingNode:
+		if len(node.Content) == 0 {
+			return "{}"
+		}
+		var b strings.Builder
+		b.WriteString("{\n")
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			value, ok := overrides[key]
+			if !ok {
+				value = starlarkValue(node.Content[i+1], depth+1, nil)
+			}
+			fmt.Fprintf(&b, "%s    %s: %s,\n", indent, strconv.Quote(key), value)
+		}
+		b.WriteString(indent + "}")
+		return b.String()
+	case yaml.SequenceNode:
+		items := make([]string, 0, len(node.Content))
+		for _, item := range node.Content {
+			items = append(items, starlarkValue(item, depth+1, nil))
+		}
+		if len(items) == 0 {
+			return "[]"
+		}
+		return "[\n" + indent + "    " + strings.Join(items, ",\n"+indent+"    ") + ",\n" + indent + "]"
+	default:
+		switch node.ShortTag() {
+		case "!!bool":
+			if node.Value == "true" {
+				return "True"
+			}
+			return "False"
+		case "!!int", "!!float":
+			return node.Value
+		case "!!null":
+			return "None"
+		default:
+			return strconv.Quote(node.Value)
+		}
+	}
+}
+
+// starlarkVersioned returns an expression for a string that contains the version.
+func starlarkVersioned(s string) string {
+	parts := strings.Split(s, starlarkVersion)
+	quoted := make([]string, 0, len(parts))
+	for i, part := range parts {
+		if part != "" {
+			quoted = append(quoted, strconv.Quote(part))
+		}
+		if i < len(parts)-1 {
+			quoted = append(quoted, "version")
+		}
+	}
+	return strings.Join(quoted, " + ")
+}
+
+func starlarkList(items []string) string {
+	quoted := make([]string, 0, len(items))
+	for _, item := range items {
+		quoted = append(quoted, strconv.Quote(item))
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+func starlarkVolumes(volumes []Volume) string {
+	items := make([]string, 0, len(volumes))
+	for _, volume := range volumes {
+		if volume.Temp {
+			items = append(items, fmt.Sprintf(`{"name": %s, "temp": {}}`, strconv.Quote(volume.Name)))
+		} else {
+			items = append(items, fmt.Sprintf(`{"name": %s}`, strconv.Quote(volume.Name)))
+		}
+	}
+	return "[" + strings.Join(items, ", ") + "]"
+}
@@ -0,0 +1,61 @@
+package buildmaker
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tphoney/best_practice/scanner/dronescanner"
+	"gopkg.in/yaml.v3"
+)
+
+func TestRenderDroneStarlark(t *testing.T) {
+	directory := t.TempDir()
+	pipeline := testPipeline(testMatrixBuilds())
+	content, err := renderDroneStarlark(&pipeline, directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipelines, err := dronescanner.EvaluateStarlark(directory, droneStarlarkFileName, content, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the starlark file generates the same pipelines as the yaml file
+	expected := dronePipelines(&pipeline)
+	if len(pipelines) != len(expected) {
+		t.Fatalf("got %d pipelines, want %d", len(pipelines), len(expected))
+	}
+	for i := range expected {
+		if pipelines[i].Name != expected[i].Name || len(pipelines[i].Steps) != len(expected[i].Steps) {
+			t.Errorf("got pipeline '%s' with %d steps, want '%s' with %d", pipelines[i].Name, len(pipelines[i].Steps), expected[i].Name, len(expected[i].Steps))
+			continue
+		}
+		for j := range expected[i].Steps {
+			got, want := pipelines[i].Steps[j], expected[i].Steps[j]
+			if got.Name != want.Name || got.Image != want.Image || strings.Join(got.DependsOn, ",") != strings.Join(want.DependsOn, ",") {
+				t.Errorf("pipeline '%s' step %d is %+v, want %s %s %v", expected[i].Name, j, got, want.Name, want.Image, want.DependsOn)
+			}
+		}
+	}
+}
+
+func TestStarlarkValue(t *testing.T) {
+	var node yaml.Node
+	if err := node.Encode(map[string]interface{}{
+		"name":     `say "hi"`,
+		"commands": []string{"echo \\n"},
+		"detach":   true,
+		"ports":    []int{80},
+		"when":     nil,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	got := starlarkValue(&node, 0, nil)
+	for _, want := range []string{`"name": "say \"hi\""`, `"echo \\n"`, `"detach": True`, "80", `"when": None`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("'%s' is not in\n%s", want, got)
+		}
+	}
+	if versioned := starlarkVersioned("golang:" + starlarkVersion + "-alpine"); versioned != `"golang:" + version + "-alpine"` {
+		t.Errorf("got '%s'", versioned)
+	}
+}
@@ -0,0 +1,260 @@
+package buildmaker
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	templateExtension = ".tmpl"
+	imageMapFileName  = "images.yml"
+)
+
+type (
+	// templateData is passed to a step template, the fields of the generated build can be used directly eg '{{ .Image }}'.
+	templateData struct {
+		Build
+		ScannerFamily string
+		Check         string
+	}
+
+	// stepTemplates holds the user supplied step templates, keyed by scanner family and check, and the image mapping.
+	stepTemplates struct {
+		templates map[string]*template.Template
+		images    map[string]string
+	}
+)
+
+var templateFuncs = template.FuncMap{
+	"join":  strings.Join,
+	"quote": func(s string) string { return fmt.Sprintf("%q", s) },
+}
+
+// sampleBuild checks that a template renders, every field is set so templates can use them all.
+var sampleBuild = Build{
+	Name:             "step",
+	Image:            "image:1",
+	Commands:         []string{"command"},
+	Settings:         map[string]interface{}{"setting": "value"},
+	Secrets:          map[string]Secret{"TOKEN": "token"},
+	Volumes:          []VolumeMount{{Name: "cache", Path: "/cache"}},
+	DependsOn:        []string{"build"},
+	When:             &Condition{Branch: []string{"main"}, Event: []string{"push"}, Ref: []string{"refs/heads/main"}},
+	Phase:            PhaseBuild,
+	Language:         LanguageGo,
+	LanguageVersion:  "1",
+	LanguageVersions: []string{"1"},
+	TestReports:      []string{"report.xml"},
+	Artifacts:        []string{"artifact"},
+	Environment:      map[string]string{"NAME": "value"},
+	Ports:            []int{80},
+	Binaries:         []string{"release/linux/amd64/step"},
+}
+
+// loadTemplates reads the templates in '<directory>/<scanner family>/<check>.tmpl' and the image mapping in
+// '<directory>/images.yml'. Every template is parsed and rendered with an empty build, so mistakes are found before
+// any scanning is done. availableChecks maps every scanner family to its checks, templates must be for a family in the
+// map and match one of its checks. Families without checks are not being scanned, their check names are not known.
+func loadTemplates(directory string, availableChecks map[string][]string) (*stepTemplates, error) {
+	st := &stepTemplates{
+		templates: map[string]*template.Template{},
+		images:    map[string]string{},
+	}
+	families := map[string]bool{}
+	known := map[string]bool{}
+	for family, checks := range availableChecks {
+		families[templateKey(family, "")] = len(checks) > 0
+		for _, check := range checks {
+			known[templateKey(family, check)] = true
+		}
+	}
+	paths, err := filepath.Glob(filepath.Join(directory, "*", "*"+templateExtension))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		family := filepath.Base(filepath.Dir(path))
+		check := strings.TrimSuffix(filepath.Base(path), templateExtension)
+		key := templateKey(family, check)
+		if _, ok := families[templateKey(family, "")]; !ok {
+			return nil, fmt.Errorf("template '%s' does not match a scanner family", path)
+		}
+		if families[templateKey(family, "")] && !known[key] {
+			return nil, fmt.Errorf("template '%s' does not match a check of '%s'", path, family)
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		tmpl, err := template.New(filepath.Base(path)).Funcs(templateFuncs).Option("missingkey=error").Parse(string(content))
+		if err != nil {
+			return nil, fmt.Errorf("error parsing template '%s': %s", path, err)
+		}
+		if _, err = renderTemplate(tmpl, &templateData{Build: sampleBuild, ScannerFamily: family, Check: check}); err != nil {
+			return nil, fmt.Errorf("error in template '%s': %s", path, err)
+		}
+		st.templates[key] = tmpl
+	}
+	content, err := os.ReadFile(filepath.Join(directory, imageMapFileName))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err = yaml.Unmarshal(content, &st.images); err != nil {
+			return nil, fmt.Errorf("error reading image mapping '%s': %s", imageMapFileName, err)
+		}
+		for from, to := range st.images {
+			if from == "" || to == "" {
+				return nil, fmt.Errorf("image mapping '%s' has an empty image for '%s'", imageMapFileName, from)
+			}
+		}
+	}
+	return st, nil
+}
+
+// apply renders the template for the check over the build, then maps the image to its replacement.
+func (st *stepTemplates) apply(family, check string, build Build) (Build, error) {
+	if tmpl, ok := st.templates[templateKey(family, check)]; ok {
+		override, err := renderTemplate(tmpl, &templateData{Build: build, ScannerFamily: family, Check: check})
+		if err != nil {
+			return build, fmt.Errorf("error rendering template for '%s': %s", check, err)
+		}
+		mergeOverride(&build, &override)
+	}
+	build.Image = st.mapImage(build.Image)
+	return build, nil
+}
+
+// mapImage rewrites an image using the image mapping. An exact match wins, otherwise the image name is matched and
+// the original tag is kept if the replacement does not have one.
+func (st *stepTemplates) mapImage(image string) string {
+	if mapped, ok := st.images[image]; ok {
+		return mapped
+	}
+	name := imageName(image)
+	mapped, ok := st.images[name]
+	if !ok {
+		return image
+	}
+	if imageName(mapped) == mapped && name != image {
+		return mapped + image[len(name):]
+	}
+	return mapped
+}
+
+// renderTemplate executes the template and reads the output as a step definition.
+func renderTemplate(tmpl *template.Template, data *templateData) (Build, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return Build{}, err
+	}
+	var override Build
+	if err := yaml.Unmarshal(buf.Bytes(), &override); err != nil {
+		return Build{}, fmt.Errorf("output is not a valid step: %s", err)
+	}
+	// these decide where the step runs and how often, they come from the scanners
+	switch {
+	case override.Service:
+		return Build{}, fmt.Errorf("a template can not turn a step into a service")
+	case override.LanguageVersions != nil:
+		return Build{}, fmt.Errorf("a template can not set language_versions, set language_version instead")
+	case override.Platform != nil:
+		return Build{}, fmt.Errorf("a template can not set the platform")
+	}
+	return override, nil
+}
+
+// mergeOverride replaces the fields set by the template, settings and environment variables are merged so a template
+// can change one of them.
+func mergeOverride(build, override *Build) {
+	if override.Name != "" {
+		build.Name = override.Name
+	}
+	if override.Image != "" {
+		build.Image = override.Image
+	}
+	if override.Commands != nil {
+		build.Commands = override.Commands
+	}
+	if override.Settings != nil {
+		settings := make(map[string]interface{}, len(build.Settings)+len(override.Settings))
+		for key, value := range build.Settings {
+			settings[key] = value
+		}
+		for key, value := range override.Settings {
+			settings[key] = templateSetting(value)
+		}
+		build.Settings = settings
+	}
+	if override.Secrets != nil {
+		build.Secrets = override.Secrets
+	}
+	if override.Privileged {
+		build.Privileged = true
+	}
+	if override.Volumes != nil {
+		build.Volumes = override.Volumes
+	}
+	if override.DependsOn != nil {
+		build.DependsOn = override.DependsOn
+	}
+	if override.When != nil {
+		build.When = override.When
+	}
+	if override.Phase != "" {
+		build.Phase = override.Phase
+	}
+	if override.Cache != "" {
+		build.Cache = override.Cache
+	}
+	if override.TestReports != nil {
+		build.TestReports = override.TestReports
+	}
+	if override.Artifacts != nil {
+		build.Artifacts = override.Artifacts
+	}
+	if override.Language != "" {
+		build.Language = override.Language
+	}
+	if override.LanguageVersion != "" {
+		build.LanguageVersion = override.LanguageVersion
+	}
+	if override.Environment != nil {
+		environment := make(map[string]string, len(build.Environment)+len(override.Environment))
+		for key, value := range build.Environment {
+			environment[key] = value
+		}
+		for key, value := range override.Environment {
+			environment[key] = value
+		}
+		build.Environment = environment
+	}
+	if override.Ports != nil {
+		build.Ports = override.Ports
+	}
+	if override.Binaries != nil {
+		build.Binaries = override.Binaries
+	}
+}
+
+// templateSetting turns the drone 'from_secret' syntax back into a secret, so every build system can use it.
+func templateSetting(value interface{}) interface{} {
+	if mapping, ok := value.(map[string]interface{}); ok && len(mapping) == 1 {
+		if secret, ok := mapping["from_secret"].(string); ok {
+			return Secret(secret)
+		}
+	}
+	return value
+}
+
+// templateKey matches family and check names ignoring case, spaces can be written as '_' or '-' in file names.
+func templateKey(family, check string) string {
+	replacer := strings.NewReplacer(" ", "_", "-", "_")
+	return strings.ToLower(replacer.Replace(family) + "/" + replacer.Replace(check))
+}
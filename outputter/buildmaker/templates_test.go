@@ -0,0 +1,121 @@
+package buildmaker
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTemplates writes files relative to a new template directory.
+func writeTemplates(t *testing.T, files map[string]string) string {
+	t.Helper()
+	directory := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(directory, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return directory
+}
+
+func TestTemplatesApply(t *testing.T) {
+	directory := writeTemplates(t, map[string]string{
+		"docker/docker_build.tmpl": `settings:
+  repo: registry.internal/{{ .ScannerFamily }}
+  password:
+    from_secret: registry_password
+`,
+		// a template can use the fields of the generated build that are only set on some steps
+		"golang/go_test.tmpl": `commands:
+  - go test {{ if .Build.When }}{{ index .Build.When.Branch 0 }}{{ end }} ./...
+`,
+		imageMapFileName: "golang: mirror.internal/golang\nplugins/docker:latest: mirror.internal/docker:20\n",
+	})
+	st, err := loadTemplates(directory, map[string][]string{"Docker": {"Docker Build"}, "Golang": {"Go Test"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	build, err := st.apply("Docker", "Docker Build", Build{
+		Name:     "docker build",
+		Image:    "plugins/docker:latest",
+		Settings: map[string]interface{}{"repo": "organization/image", "dockerfile": "Dockerfile"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if build.Settings["repo"] != "registry.internal/Docker" || build.Settings["dockerfile"] != "Dockerfile" {
+		t.Errorf("got settings %v", build.Settings)
+	}
+	if build.Settings["password"] != Secret("registry_password") {
+		t.Errorf("the password is not read from a secret: %#v", build.Settings["password"])
+	}
+	if build.Image != "mirror.internal/docker:20" {
+		t.Errorf("an exact image mapping gives '%s'", build.Image)
+	}
+	// the tag is kept when only the image name is mapped
+	if image := st.mapImage("golang:1.21-alpine"); image != "mirror.internal/golang:1.21-alpine" {
+		t.Errorf("the image name mapping gives '%s'", image)
+	}
+	if image := st.mapImage("node:20"); image != "node:20" {
+		t.Errorf("an unmapped image gives '%s'", image)
+	}
+}
+
+func TestLoadTemplatesErrors(t *testing.T) {
+	checks := map[string][]string{"Docker": {"Docker Build"}, "Golang": nil}
+	tests := map[string]map[string]string{
+		"unknown check":      {"docker/docker_push.tmpl": "name: push\n"},
+		"parse error":        {"docker/docker_build.tmpl": "name: {{ .Build.Name\n"},
+		"missing field":      {"docker/docker_build.tmpl": "name: {{ .Build.Missing }}\n"},
+		"not a step":         {"docker/docker_build.tmpl": "- name\n"},
+		"empty image to map": {imageMapFileName: "golang: ''\n"},
+		"unknown family":     {"golnag/go_test.tmpl": "name: test\n"},
+		"service":            {"docker/docker_build.tmpl": "service: true\n"},
+		"platform":           {"docker/docker_build.tmpl": "platform:\n  os: linux\n  arch: arm64\n"},
+	}
+	for name, files := range tests {
+		if _, err := loadTemplates(writeTemplates(t, files), checks); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	// the checks of families that are not scanned are not known
+	if _, err := loadTemplates(writeTemplates(t, map[string]string{"golang/go_fuzz.tmpl": "name: fuzz\n"}), checks); err != nil {
+		t.Errorf("unexpected error for a family that is not scanned: %s", err)
+	}
+}
+
+func TestMergeOverride(t *testing.T) {
+	build := Build{
+		Name: "postgres", Image: "postgres:16", Service: true, Language: LanguageGo, LanguageVersion: "1.21",
+		Environment: map[string]string{"POSTGRES_USER": "app", "POSTGRES_PASSWORD": "postgres"},
+		Ports:       []int{5432},
+	}
+	mergeOverride(&build, &Build{
+		LanguageVersion: "1.22",
+		Environment:     map[string]string{"POSTGRES_PASSWORD": "secret"},
+		Ports:           []int{5433},
+	})
+	if build.Language != LanguageGo || build.LanguageVersion != "1.22" {
+		t.Errorf("got language %s %s", build.Language, build.LanguageVersion)
+	}
+	if build.Environment["POSTGRES_USER"] != "app" || build.Environment["POSTGRES_PASSWORD"] != "secret" {
+		t.Errorf("the environment is not merged: %v", build.Environment)
+	}
+	if len(build.Ports) != 1 || build.Ports[0] != 5433 || !build.Service {
+		t.Errorf("got ports %v, service %t", build.Ports, build.Service)
+	}
+}
+
+func TestTemplateKey(t *testing.T) {
+	if key := templateKey("Docker", "docker-build"); key != templateKey("docker", "Docker Build") {
+		t.Errorf("'%s' and '%s' should match", key, templateKey("docker", "Docker Build"))
+	}
+	if key := templateKey("Golang", "Go Test"); !strings.HasPrefix(key, "golang/") {
+		t.Errorf("got key '%s'", key)
+	}
+}
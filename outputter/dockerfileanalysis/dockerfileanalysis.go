@@ -0,0 +1,98 @@
+package dockerfileanalysis
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tphoney/best_practice/outputter"
+	"github.com/tphoney/best_practice/types"
+)
+
+const (
+	Name = outputter.DockerfileAnalysis
+)
+
+type (
+	outputterConfig struct {
+		name             string
+		description      string
+		workingDirectory string
+	}
+
+	OutputFields struct {
+		// File is the dockerfile or compose file relative to the working directory.
+		File string `json:"file" yaml:"file"`
+		// Line is where the instruction starts, it is 0 for findings about the whole file.
+		Line        int    `json:"line" yaml:"line"`
+		Rule        string `json:"rule" yaml:"rule"`
+		Instruction string `json:"instruction,omitempty" yaml:"instruction,omitempty"`
+		HelpURL     string `json:"url,omitempty" yaml:"url,omitempty"`
+		// Template is a suggested rewrite of the dockerfile.
+		Template string `json:"template,omitempty" yaml:"template,omitempty"`
+	}
+)
+
+func New(opts ...Option) (types.Outputter, error) {
+	oc := new(outputterConfig)
+	oc.name = Name
+	oc.description = "Reports problems found in your Dockerfiles and compose files"
+	// apply options
+	for _, opt := range opts {
+		opt(oc)
+	}
+
+	return oc, nil
+}
+
+func (oc outputterConfig) Name() string {
+	return oc.name
+}
+
+func (oc outputterConfig) Description() string {
+	return oc.description
+}
+
+func (oc outputterConfig) Output(ctx context.Context, scanResults []types.Scanlet) error {
+	var results []types.Scanlet
+	for _, output := range scanResults {
+		if output.OutputRenderer == Name {
+			results = append(results, output)
+		}
+	}
+	if len(results) == 0 {
+		return nil
+	}
+	// report in file order, so the findings can be worked through from top to bottom
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i].Spec.(OutputFields), results[j].Spec.(OutputFields)
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Line < b.Line
+	})
+	fmt.Println("Dockerfile Analysis:")
+	for _, result := range results {
+		df := result.Spec.(OutputFields)
+		location := df.File
+		if df.Line > 0 {
+			location = fmt.Sprintf("%s:%d", df.File, df.Line)
+		}
+		fmt.Printf("- %s %s: %s\n", location, df.Rule, result.Description)
+		if df.Instruction != "" {
+			fmt.Printf("  Instruction: '%s'\n", df.Instruction)
+		}
+		if df.HelpURL != "" {
+			fmt.Printf("  Further Reading: '%s'\n", df.HelpURL)
+		}
+		if df.Template != "" {
+			fmt.Println("  Suggested Dockerfile:")
+			for _, line := range strings.Split(strings.TrimSuffix(df.Template, "\n"), "\n") {
+				fmt.Println(strings.TrimRight("    "+line, " "))
+			}
+		}
+	}
+	fmt.Println("")
+	return nil
+}
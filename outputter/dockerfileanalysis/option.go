@@ -0,0 +1,9 @@
+package dockerfileanalysis
+
+type Option func(*outputterConfig)
+
+func WithWorkingDirectory(i string) Option {
+	return func(p *outputterConfig) {
+		p.workingDirectory = i
+	}
+}
@@ -3,6 +3,7 @@ package dronebuildanalysis
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	"github.com/tphoney/best_practice/outputter"
 	"github.com/tphoney/best_practice/types"
@@ -14,16 +15,27 @@ const (
 
 type (
 	outputterConfig struct {
-		name         string
-		description  string
-		stdOutput    bool
-		outputToFile string
+		name             string
+		description      string
+		stdOutput        bool
+		outputToFile     string
+		workingDirectory string
+		fix              bool
 	}
 
 	OutputFields struct {
 		RawYaml string `json:"raw_yaml" yaml:"raw_yaml"`
 		Command string `json:"command" yaml:"command"`
 		HelpURL string `json:"url" yaml:"url"`
+		// FileName and FileContent suggest a file to add to the project, eg a linter config.
+		FileName    string `json:"file_name,omitempty" yaml:"file_name,omitempty"`
+		FileContent string `json:"file_content,omitempty" yaml:"file_content,omitempty"`
+		// the following are used by fix mode to apply the suggestion to the drone file
+		PipelineName string `json:"pipeline_name,omitempty" yaml:"pipeline_name,omitempty"`
+		StepName     string `json:"step_name,omitempty" yaml:"step_name,omitempty"`
+		Image        string `json:"image,omitempty" yaml:"image,omitempty"`
+		// Service adds RawYaml to the services of the pipeline instead of its steps.
+		Service bool `json:"service,omitempty" yaml:"service,omitempty"`
 	}
 )
 
@@ -69,10 +81,36 @@ func (oc outputterConfig) Output(ctx context.Context, scanResults []types.Scanle
 		if bp.HelpURL != "" {
 			fmt.Printf("  Further Reading: '%s'\n", bp.HelpURL)
 		}
-		if bp.RawYaml != "" {
+		if bp.RawYaml != "" && bp.Service {
+			fmt.Printf("  Drone services YAML: %s\n", bp.RawYaml)
+		} else if bp.RawYaml != "" {
 			fmt.Printf("  Drone build YAML: %s\n", bp.RawYaml)
 		}
+		if bp.FileContent != "" {
+			fmt.Printf("  Suggested '%s':\n", bp.FileName)
+			for _, line := range strings.Split(strings.TrimSuffix(bp.FileContent, "\n"), "\n") {
+				fmt.Println(strings.TrimRight("    "+line, " "))
+			}
+		}
 	}
 	fmt.Println("")
+	if oc.fix {
+		diff, changes, err := applyFixes(oc.workingDirectory, bestPracticeResults)
+		if err != nil {
+			return err
+		}
+		if len(changes) == 0 {
+			fmt.Println("No fixes applied to the Drone build file")
+			return nil
+		}
+		fmt.Printf("Applied the following fixes to '%s':\n", droneFileName)
+		for _, change := range changes {
+			fmt.Printf("- %s\n", change)
+		}
+		fmt.Printf("Diff written to '%s'\n", droneFileName+diffSuffix)
+		if oc.stdOutput {
+			fmt.Println(diff)
+		}
+	}
 	return nil
 }
@@ -0,0 +1,307 @@
+package dronebuildanalysis
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/tphoney/best_practice/outputter"
+	"github.com/tphoney/best_practice/types"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	droneFileName = ".drone.yml"
+	diffSuffix    = ".diff"
+)
+
+// stepPhases orders the kinds of step in a pipeline, a new step goes before the first existing step of a later kind. The
+// kind is guessed from the words in the name, image and commands of a step.
+var stepPhases = [][]string{
+	{"lint", "mod tidy", "work sync", "vet", "fmt", "rubocop", "checkstyle"},
+	{"test", "spec", "coverage", "verify"},
+	{"build", "compile", "package"},
+	{"plugins/", "docker", "publish", "release", "deploy", "push", "scan", "snyk"},
+}
+
+// servicePhase sorts services after the steps added at the end of a pipeline, so they go below the steps.
+var servicePhase = len(stepPhases) + 1
+
+// insertion is a new step waiting to be written into the drone file.
+type insertion struct {
+	edit  outputter.LineEdit
+	phase int
+}
+
+// applyFixes edits the drone file in place using the suggestions that carry enough information to be applied,
+// it returns a unified diff of the changes made. Only the lines of the changed steps are touched, so the rest of the
+// file keeps its layout and comments.
+func applyFixes(workingDirectory string, results []types.Scanlet) (diff string, changes []string, err error) {
+	droneFile := filepath.Join(workingDirectory, droneFileName)
+	original, err := os.ReadFile(droneFile)
+	if os.IsNotExist(err) {
+		fmt.Printf("Fixes can only be applied to '%s', starlark and jsonnet files are not changed\n", droneFileName)
+		return "", nil, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	documents, err := outputter.DecodeYAMLDocuments(original)
+	if err != nil {
+		return "", nil, fmt.Errorf("error reading %s '%s'", droneFile, err)
+	}
+	lines := strings.Split(string(original), "\n")
+	var edits []outputter.LineEdit
+	var insertions []insertion
+	// added steps are checked for duplicates, but new steps are never placed relative to them
+	added := map[*yaml.Node]bool{}
+	for _, result := range results {
+		bp := result.Spec.(OutputFields)
+		if bp.PipelineName == "" {
+			continue
+		}
+		pipeline := outputter.FindDronePipeline(documents, bp.PipelineName)
+		if pipeline == nil {
+			continue
+		}
+		stepsKey, steps := mappingEntry(pipeline, "steps")
+		if steps == nil || steps.Kind != yaml.SequenceNode {
+			continue
+		}
+		// update the image of an existing step
+		if bp.StepName != "" && bp.Image != "" {
+			step := outputter.FindDroneStep(steps, bp.StepName)
+			if step == nil {
+				continue
+			}
+			image := outputter.YAMLMappingValue(step, "image")
+			if image == nil || image.Value == bp.Image {
+				continue
+			}
+			edit, editErr := outputter.ScalarEdit(lines, image, bp.Image)
+			if editErr != nil {
+				fmt.Printf("unable to apply '%s': %s\n", result.Description, editErr)
+				continue
+			}
+			edits = append(edits, edit)
+			changes = append(changes, fmt.Sprintf("pipeline '%s' step '%s' image %s -> %s", bp.PipelineName, bp.StepName, image.Value, bp.Image))
+			image.Value = bp.Image
+			continue
+		}
+		if bp.RawYaml == "" {
+			continue
+		}
+		// add a missing service
+		if bp.Service {
+			newServices, parseErr := parseSteps(bp.RawYaml)
+			if parseErr != nil {
+				fmt.Printf("unable to apply '%s': %s\n", result.Description, parseErr)
+				continue
+			}
+			for _, newService := range newServices {
+				name := outputter.YAMLMappingValue(newService, "name")
+				if name == nil {
+					fmt.Printf("unable to apply '%s': the service has no name\n", result.Description)
+					continue
+				}
+				servicesKey, services := mappingEntry(pipeline, "services")
+				if services != nil && outputter.FindDroneStep(services, name.Value) != nil {
+					fmt.Printf("not applying '%s': pipeline '%s' already has a service named '%s'\n", result.Description, bp.PipelineName, name.Value)
+					continue
+				}
+				edit, layoutErr := serviceEdit(lines, stepsKey, steps, servicesKey, services, newService, added)
+				if layoutErr != nil {
+					fmt.Printf("unable to apply '%s': %s\n", result.Description, layoutErr)
+					continue
+				}
+				insertions = append(insertions, insertion{edit: edit, phase: servicePhase})
+				if services == nil {
+					services = &yaml.Node{Kind: yaml.SequenceNode}
+					pipeline.Content = append(pipeline.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "services"}, services)
+				}
+				services.Content = append(services.Content, newService)
+				added[newService] = true
+				changes = append(changes, fmt.Sprintf("pipeline '%s' added service '%s'", bp.PipelineName, name.Value))
+			}
+			continue
+		}
+		// insert a missing step
+		newSteps, parseErr := parseSteps(bp.RawYaml)
+		if parseErr != nil {
+			fmt.Printf("unable to apply '%s': %s\n", result.Description, parseErr)
+			continue
+		}
+		for _, newStep := range newSteps {
+			name := outputter.YAMLMappingValue(newStep, "name")
+			if name == nil {
+				fmt.Printf("unable to apply '%s': the step has no name\n", result.Description)
+				continue
+			}
+			if outputter.FindDroneStep(steps, name.Value) != nil {
+				fmt.Printf("not applying '%s': pipeline '%s' already has a step named '%s'\n", result.Description, bp.PipelineName, name.Value)
+				continue
+			}
+			stepLines, layoutErr := outputter.SequenceItemLines(lines, stepsKey, steps, newStep)
+			if layoutErr != nil {
+				fmt.Printf("unable to apply '%s': %s\n", result.Description, layoutErr)
+				continue
+			}
+			phase := stepPhase(newStep)
+			start := insertLine(steps, added, phase)
+			insertions = append(insertions, insertion{edit: outputter.LineEdit{Start: start, End: start, Lines: stepLines}, phase: phase})
+			steps.Content = append(steps.Content, newStep)
+			added[newStep] = true
+			changes = append(changes, fmt.Sprintf("pipeline '%s' added step '%s'", bp.PipelineName, name.Value))
+		}
+	}
+	if len(changes) == 0 {
+		return "", nil, nil
+	}
+	// steps inserted at the same place keep the order of their phases, steps of an unknown phase go last
+	sort.SliceStable(insertions, func(i, j int) bool {
+		return sortPhase(insertions[i].phase) < sortPhase(insertions[j].phase)
+	})
+	for i := range insertions {
+		edits = append(edits, insertions[i].edit)
+	}
+	updated := outputter.ApplyLineEdits(original, edits)
+	if _, err = outputter.DecodeYAMLDocuments(updated); err != nil {
+		return "", nil, fmt.Errorf("the fixed %s is not valid yaml '%s'", droneFileName, err)
+	}
+	diff, err = outputter.UnifiedDiff(droneFileName, original, updated)
+	if err != nil {
+		return "", nil, err
+	}
+	if err = os.WriteFile(droneFile, updated, 0o600); err != nil {
+		return "", nil, err
+	}
+	if err = os.WriteFile(droneFile+diffSuffix, []byte(diff), 0o600); err != nil {
+		return "", nil, err
+	}
+	return diff, changes, nil
+}
+
+// mappingEntry returns a key of a mapping and its value.
+func mappingEntry(mapping *yaml.Node, key string) (keyNode, value *yaml.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i], mapping.Content[i+1]
+		}
+	}
+	return nil, nil
+}
+
+// serviceEdit adds a service after the last service of a pipeline. A pipeline without services gets them after its
+// steps, laid out like the steps.
+func serviceEdit(lines []string, stepsKey, steps, servicesKey, services, service *yaml.Node, added map[*yaml.Node]bool) (outputter.LineEdit, error) {
+	if services != nil && servicesKey.Line > 0 {
+		serviceLines, err := outputter.SequenceItemLines(lines, servicesKey, services, service)
+		if err != nil {
+			return outputter.LineEdit{}, err
+		}
+		start := outputter.YAMLEndLine(lastOriginal(services, added))
+		return outputter.LineEdit{Start: start, End: start, Lines: serviceLines}, nil
+	}
+	serviceLines, err := outputter.SequenceItemLines(lines, stepsKey, steps, service)
+	if err != nil {
+		return outputter.LineEdit{}, err
+	}
+	if services == nil {
+		serviceLines = append([]string{strings.Repeat(" ", stepsKey.Column-1) + "services:"}, serviceLines...)
+	}
+	start := outputter.YAMLEndLine(lastOriginal(steps, added))
+	return outputter.LineEdit{Start: start, End: start, Lines: serviceLines}, nil
+}
+
+// lastOriginal returns the last item of a sequence that was in the drone file.
+func lastOriginal(sequence *yaml.Node, added map[*yaml.Node]bool) (last *yaml.Node) {
+	for _, item := range sequence.Content {
+		if !added[item] {
+			last = item
+		}
+	}
+	return last
+}
+
+// stepPhase returns the index of the kind of a step in stepPhases, or -1 when the kind can not be guessed.
+func stepPhase(step *yaml.Node) int {
+	var words []string
+	for _, key := range []string{"name", "image"} {
+		if value := outputter.YAMLMappingValue(step, key); value != nil {
+			words = append(words, value.Value)
+		}
+	}
+	if commands := outputter.YAMLMappingValue(step, "commands"); commands != nil {
+		for _, command := range commands.Content {
+			words = append(words, command.Value)
+		}
+	}
+	text := strings.ToLower(strings.Join(words, " "))
+	for phase, kinds := range stepPhases {
+		for _, kind := range kinds {
+			if strings.Contains(text, kind) {
+				return phase
+			}
+		}
+	}
+	return -1
+}
+
+func sortPhase(phase int) int {
+	if phase < 0 {
+		return len(stepPhases)
+	}
+	return phase
+}
+
+// insertLine returns the line a new step of a phase is inserted at: straight after the step before the first existing
+// step of a later phase, so blank lines and comments stay with the step below them, or after the last existing step.
+func insertLine(steps *yaml.Node, added map[*yaml.Node]bool, phase int) int {
+	var last *yaml.Node
+	for _, step := range steps.Content {
+		if added[step] {
+			continue
+		}
+		if phase >= 0 && stepPhase(step) > phase {
+			if last != nil {
+				break
+			}
+			line := step.Line - 1
+			if step.HeadComment != "" {
+				line -= strings.Count(step.HeadComment, "\n") + 1
+			}
+			return line
+		}
+		last = step
+	}
+	return outputter.YAMLEndLine(last)
+}
+
+// parseSteps turns a raw yaml snippet into step nodes, the snippets are indented differently by each scanner so we
+// let the yaml parser work out the indentation rather than relying on it.
+func parseSteps(rawYaml string) (steps []*yaml.Node, err error) {
+	var document yaml.Node
+	if err = yaml.Unmarshal([]byte(strings.Trim(rawYaml, "\n")), &document); err != nil {
+		return nil, err
+	}
+	if len(document.Content) == 0 {
+		return nil, fmt.Errorf("empty yaml snippet")
+	}
+	root := document.Content[0]
+	switch root.Kind {
+	case yaml.SequenceNode:
+		steps = root.Content
+	case yaml.MappingNode:
+		steps = []*yaml.Node{root}
+	default:
+		return nil, fmt.Errorf("yaml snippet is not a step")
+	}
+	for _, step := range steps {
+		if step.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("yaml snippet is not a step")
+		}
+	}
+	return steps, nil
+}
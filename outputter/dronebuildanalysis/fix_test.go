@@ -0,0 +1,264 @@
+package dronebuildanalysis
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tphoney/best_practice/types"
+)
+
+const testDroneFile = `# the build of the project
+kind: pipeline
+type: docker
+name: default
+
+steps:
+  - name: test
+    image: golang:1.20 # the go version of go.mod
+    commands:
+      - go test ./...
+---
+kind: secret
+name: token
+`
+
+func fixResult(description string, spec OutputFields) types.Scanlet {
+	return types.Scanlet{Name: "test", Description: description, Spec: spec}
+}
+
+func TestApplyFixes(t *testing.T) {
+	workingDirectory := t.TempDir()
+	droneFile := filepath.Join(workingDirectory, droneFileName)
+	if err := os.WriteFile(droneFile, []byte(testDroneFile), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	results := []types.Scanlet{
+		fixResult("update golang", OutputFields{PipelineName: "default", StepName: "test", Image: "golang:1.21"}),
+		fixResult("add lint", OutputFields{PipelineName: "default", RawYaml: `
+  - name: lint
+    image: golangci/golangci-lint
+    commands:
+      - golangci-lint run
+`}),
+		// these are not applied
+		fixResult("add test again", OutputFields{PipelineName: "default", RawYaml: "name: test\nimage: golang:1.21\n"}),
+		fixResult("add unnamed", OutputFields{PipelineName: "default", RawYaml: "image: alpine\n"}),
+		fixResult("add to another pipeline", OutputFields{PipelineName: "release", RawYaml: "name: release\n"}),
+		fixResult("no pipeline", OutputFields{RawYaml: "name: build\n"}),
+		fixResult("missing step", OutputFields{PipelineName: "default", StepName: "build", Image: "golang:1.21"}),
+	}
+	diff, changes, err := applyFixes(workingDirectory, results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"pipeline 'default' step 'test' image golang:1.20 -> golang:1.21",
+		"pipeline 'default' added step 'lint'",
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("got changes %v, want %v", changes, want)
+	}
+	for _, line := range []string{"-    image: golang:1.20 # the go version of go.mod", "+    image: golang:1.21 # the go version of go.mod", "+  - name: lint"} {
+		if !strings.Contains(diff, line) {
+			t.Errorf("the diff does not contain '%s':\n%s", line, diff)
+		}
+	}
+	fixed, err := os.ReadFile(droneFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the comments and the other documents are kept
+	for _, kept := range []string{"# the build of the project", "kind: secret"} {
+		if !strings.Contains(string(fixed), kept) {
+			t.Errorf("the fixed file lost '%s':\n%s", kept, fixed)
+		}
+	}
+	if saved, err := os.ReadFile(droneFile + diffSuffix); err != nil || string(saved) != diff {
+		t.Errorf("the diff was not saved: %v", err)
+	}
+	// applying the same fixes again changes nothing
+	if _, changes, err = applyFixes(workingDirectory, results); err != nil || len(changes) != 0 {
+		t.Errorf("applying twice made the changes %v, %v", changes, err)
+	}
+}
+
+func TestApplyFixesKeepsLayout(t *testing.T) {
+	workingDirectory := t.TempDir()
+	droneFile := filepath.Join(workingDirectory, droneFileName)
+	// the sequences are not indented and the mappings are indented by four
+	original := `kind: pipeline
+type: docker
+name: default
+
+steps:
+- name: test
+  image: "golang:1.20"
+  commands:
+  - go test ./...
+
+# publish the image
+- name: publish
+  image: plugins/docker
+  settings:
+      repo: organization/app
+      tags:
+      - latest
+`
+	if err := os.WriteFile(droneFile, []byte(original), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	results := []types.Scanlet{
+		fixResult("add lint", OutputFields{PipelineName: "default", RawYaml: `
+  - name: lint
+    image: golangci/golangci-lint
+    commands:
+      - golangci-lint run
+`}),
+		fixResult("add build", OutputFields{PipelineName: "default", RawYaml: "name: build\nimage: golang\ncommands: [go build]\n"}),
+		fixResult("update golang", OutputFields{PipelineName: "default", StepName: "test", Image: "golang:1.21"}),
+	}
+	if _, _, err := applyFixes(workingDirectory, results); err != nil {
+		t.Fatal(err)
+	}
+	// lint goes before the tests and build before the publish step and its comment
+	want := `kind: pipeline
+type: docker
+name: default
+
+steps:
+- name: lint
+  image: golangci/golangci-lint
+  commands:
+  - golangci-lint run
+- name: test
+  image: "golang:1.21"
+  commands:
+  - go test ./...
+- name: build
+  image: golang
+  commands: [go build]
+
+# publish the image
+- name: publish
+  image: plugins/docker
+  settings:
+      repo: organization/app
+      tags:
+      - latest
+`
+	if fixed, err := os.ReadFile(droneFile); err != nil || string(fixed) != want {
+		t.Errorf("got the drone file:\n%s\nwant:\n%s", fixed, want)
+	}
+}
+
+func TestApplyFixesServices(t *testing.T) {
+	workingDirectory := t.TempDir()
+	droneFile := filepath.Join(workingDirectory, droneFileName)
+	original := `kind: pipeline
+type: docker
+name: default
+
+steps:
+  - name: test
+    image: golang:1.21
+    commands:
+      - go test ./...
+---
+kind: pipeline
+type: docker
+name: integration
+
+steps:
+  - name: test
+    image: golang:1.21
+
+services:
+  - name: redis
+    image: redis:7
+`
+	if err := os.WriteFile(droneFile, []byte(original), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	postgres := "\n- name: postgres\n  image: postgres:16\n  environment:\n    POSTGRES_PASSWORD: \"postgres\""
+	results := []types.Scanlet{
+		fixResult("add postgres", OutputFields{PipelineName: "default", Service: true, RawYaml: postgres}),
+		fixResult("add redis", OutputFields{PipelineName: "default", Service: true, RawYaml: "- name: redis\n  image: redis:7\n"}),
+		fixResult("add postgres", OutputFields{PipelineName: "integration", Service: true, RawYaml: postgres}),
+		// these are not applied
+		fixResult("add redis again", OutputFields{PipelineName: "integration", Service: true, RawYaml: "- name: redis\n  image: redis:7\n"}),
+	}
+	_, changes, err := applyFixes(workingDirectory, results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 3 {
+		t.Errorf("got changes %v", changes)
+	}
+	want := `kind: pipeline
+type: docker
+name: default
+
+steps:
+  - name: test
+    image: golang:1.21
+    commands:
+      - go test ./...
+services:
+  - name: postgres
+    image: postgres:16
+    environment:
+      POSTGRES_PASSWORD: "postgres"
+  - name: redis
+    image: redis:7
+---
+kind: pipeline
+type: docker
+name: integration
+
+steps:
+  - name: test
+    image: golang:1.21
+
+services:
+  - name: redis
+    image: redis:7
+  - name: postgres
+    image: postgres:16
+    environment:
+      POSTGRES_PASSWORD: "postgres"
+`
+	if fixed, err := os.ReadFile(droneFile); err != nil || string(fixed) != want {
+		t.Errorf("got the drone file:\n%s\nwant:\n%s", fixed, want)
+	}
+}
+
+func TestApplyFixesWithoutDroneFile(t *testing.T) {
+	diff, changes, err := applyFixes(t.TempDir(), []types.Scanlet{
+		fixResult("add lint", OutputFields{PipelineName: "default", RawYaml: "name: lint\n"}),
+	})
+	if diff != "" || changes != nil || err != nil {
+		t.Errorf("got %s %v %v, want nothing to do", diff, changes, err)
+	}
+}
+
+func TestParseSteps(t *testing.T) {
+	tests := map[string]int{
+		"name: lint\nimage: alpine\n":               1,
+		"- name: lint\n- name: test\n":              2,
+		"\n    - name: lint\n      image: alpine\n": 1,
+	}
+	for rawYaml, want := range tests {
+		steps, err := parseSteps(rawYaml)
+		if err != nil || len(steps) != want {
+			t.Errorf("parseSteps(%q) = %d steps, %v, want %d steps", rawYaml, len(steps), err, want)
+		}
+	}
+	for _, rawYaml := range []string{"", "lint", "- lint\n", "name: [lint\n"} {
+		if _, err := parseSteps(rawYaml); err == nil {
+			t.Errorf("parseSteps(%q) expected an error", rawYaml)
+		}
+	}
+}
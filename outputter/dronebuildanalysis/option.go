@@ -13,3 +13,15 @@ func WithOutputToFile(i string) Option {
 		p.outputToFile = i
 	}
 }
+
+func WithWorkingDirectory(i string) Option {
+	return func(p *outputterConfig) {
+		p.workingDirectory = i
+	}
+}
+
+func WithFix(i bool) Option {
+	return func(p *outputterConfig) {
+		p.fix = i
+	}
+}
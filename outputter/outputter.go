@@ -11,6 +11,9 @@ const (
 	BuildMaker         = "build maker"
 	HarnessProduct     = "harness product"
 	DroneBuildAnalysis = "drone build analysis"
+	DockerfileAnalysis = "dockerfile analysis"
+	// Skipped is used for checks that did not run, they are listed after the other outputs.
+	Skipped = "skipped"
 )
 
 func RunOutput(ctx context.Context, outputters []types.Outputter, scanResults []types.Scanlet) (err error) {
@@ -22,10 +25,29 @@ func RunOutput(ctx context.Context, outputters []types.Outputter, scanResults []
 			fmt.Printf("error running output: %s\n", err)
 		}
 	}
+	printSkipped(scanResults)
 	// profit
 	return nil
 }
 
 func ListOutputterNames() []string {
-	return []string{BuildMaker, HarnessProduct, DroneBuildAnalysis}
+	return []string{BuildMaker, HarnessProduct, DroneBuildAnalysis, DockerfileAnalysis}
+}
+
+func printSkipped(scanResults []types.Scanlet) {
+	var skipped []types.Scanlet
+	for _, result := range scanResults {
+		if result.OutputRenderer == Skipped {
+			skipped = append(skipped, result)
+		}
+	}
+	if len(skipped) == 0 {
+		return
+	}
+	fmt.Println("++++++++++++++++++++++++++")
+	fmt.Println("Skipped checks:")
+	for _, result := range skipped {
+		fmt.Printf("- %s, %s: %s\n", result.ScannerFamily, result.Name, result.Description)
+	}
+	fmt.Println("")
 }
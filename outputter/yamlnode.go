@@ -0,0 +1,295 @@
+package outputter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"gopkg.in/yaml.v3"
+)
+
+// UnifiedDiff returns a unified diff between two versions of a file.
+func UnifiedDiff(fileName string, original, updated []byte) (string, error) {
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(original)),
+		B:        difflib.SplitLines(string(updated)),
+		FromFile: fileName,
+		ToFile:   fileName,
+		Context:  3, //nolint:gomnd
+	})
+}
+
+// DecodeYAMLDocuments reads every document of a yaml file as nodes, nodes keep comments and ordering.
+func DecodeYAMLDocuments(content []byte) (documents []*yaml.Node, err error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	for {
+		document := new(yaml.Node)
+		decodeErr := decoder.Decode(document)
+		if errors.Is(decodeErr, io.EOF) {
+			break
+		}
+		if decodeErr != nil {
+			return nil, decodeErr
+		}
+		documents = append(documents, document)
+	}
+	return documents, nil
+}
+
+// EncodeYAMLDocuments writes the documents back out, restoring the blank lines of the original file where it can.
+func EncodeYAMLDocuments(original []byte, documents []*yaml.Node) ([]byte, error) {
+	originalLines := strings.Split(string(original), "\n")
+	var buf bytes.Buffer
+	for i, document := range documents {
+		var docBuf bytes.Buffer
+		encoder := yaml.NewEncoder(&docBuf)
+		encoder.SetIndent(2) //nolint:gomnd
+		if err := encoder.Encode(document); err != nil {
+			return nil, err
+		}
+		if err := encoder.Close(); err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+		buf.WriteString(restoreBlankLines(docBuf.String(), blankLineKeys(originalLines, document)))
+	}
+	return buf.Bytes(), nil
+}
+
+// blankLineKeys returns the top level keys of a document that were preceded by a blank line, the yaml encoder drops
+// blank lines so we put them back to keep the diff small.
+func blankLineKeys(originalLines []string, document *yaml.Node) map[string]bool {
+	keys := map[string]bool{}
+	if len(document.Content) == 0 || document.Content[0].Kind != yaml.MappingNode {
+		return keys
+	}
+	root := document.Content[0]
+	for i := 0; i < len(root.Content); i += 2 {
+		key := root.Content[i]
+		line := key.Line - 2
+		if len(key.HeadComment) > 0 {
+			line -= strings.Count(key.HeadComment, "\n") + 1
+		}
+		if line >= 0 && line < len(originalLines) && strings.TrimSpace(originalLines[line]) == "" {
+			keys[key.Value] = true
+		}
+	}
+	return keys
+}
+
+func restoreBlankLines(document string, keys map[string]bool) string {
+	lines := strings.Split(document, "\n")
+	output := make([]string, 0, len(lines))
+	for i, line := range lines {
+		if i > 0 && !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "#") {
+			key := strings.SplitN(line, ":", 2)[0] //nolint:gomnd
+			if keys[key] {
+				output = append(output, "")
+			}
+		}
+		output = append(output, line)
+	}
+	return strings.Join(output, "\n")
+}
+
+// FindDronePipeline returns the pipeline with the given name.
+func FindDronePipeline(documents []*yaml.Node, pipelineName string) *yaml.Node {
+	for _, document := range documents {
+		if len(document.Content) == 0 {
+			continue
+		}
+		root := document.Content[0]
+		kind := YAMLMappingValue(root, "kind")
+		name := YAMLMappingValue(root, "name")
+		if kind != nil && kind.Value == "pipeline" && name != nil && name.Value == pipelineName {
+			return root
+		}
+	}
+	return nil
+}
+
+// FindDroneStep returns the step with the given name from a steps sequence.
+func FindDroneStep(steps *yaml.Node, stepName string) *yaml.Node {
+	for _, step := range steps.Content {
+		name := YAMLMappingValue(step, "name")
+		if name != nil && name.Value == stepName {
+			return step
+		}
+	}
+	return nil
+}
+
+// YAMLMappingValue returns the value of a key in a mapping node.
+func YAMLMappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// LineEdit replaces the lines of a file from Start up to End, counting from zero, with Lines. An edit with Start equal
+// to End inserts its lines.
+type LineEdit struct {
+	Start, End int
+	Lines      []string
+}
+
+// ApplyLineEdits applies edits to the original content, leaving every other line as it was. Edits at the same line are
+// applied in the order they are given, insertions before replacements.
+func ApplyLineEdits(original []byte, edits []LineEdit) []byte {
+	sorted := append([]LineEdit(nil), edits...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Start != sorted[j].Start {
+			return sorted[i].Start < sorted[j].Start
+		}
+		return sorted[i].Start == sorted[i].End && sorted[j].Start != sorted[j].End
+	})
+	lines := strings.Split(string(original), "\n")
+	output := make([]string, 0, len(lines))
+	next := 0
+	for _, edit := range sorted {
+		if edit.Start < next {
+			continue
+		}
+		output = append(output, lines[next:edit.Start]...)
+		output = append(output, edit.Lines...)
+		next = edit.End
+	}
+	output = append(output, lines[next:]...)
+	return []byte(strings.Join(output, "\n"))
+}
+
+// YAMLEndLine returns the last line a node spans, block scalars end on the last line of their value.
+func YAMLEndLine(node *yaml.Node) int {
+	end := node.Line
+	if node.Kind == yaml.ScalarNode && node.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0 {
+		end += strings.Count(strings.TrimSuffix(node.Value, "\n"), "\n") + 1
+	}
+	for _, child := range node.Content {
+		if childEnd := YAMLEndLine(child); childEnd > end {
+			end = childEnd
+		}
+	}
+	return end
+}
+
+// ScalarEdit returns the edit that changes the value of a scalar node, keeping its quoting and anything after it on the
+// line such as a comment.
+func ScalarEdit(lines []string, node *yaml.Node, value string) (LineEdit, error) {
+	index := node.Line - 1
+	start := node.Column - 1
+	if node.Kind != yaml.ScalarNode || index < 0 || index >= len(lines) || start < 0 || start > len(lines[index]) {
+		return LineEdit{}, fmt.Errorf("unable to find the value on line %d", node.Line)
+	}
+	old, replacement := node.Value, value
+	switch node.Style {
+	case yaml.DoubleQuotedStyle:
+		old, replacement = `"`+old+`"`, `"`+value+`"`
+	case yaml.SingleQuotedStyle:
+		old, replacement = "'"+old+"'", "'"+value+"'"
+	}
+	line := lines[index]
+	if !strings.HasPrefix(line[start:], old) {
+		return LineEdit{}, fmt.Errorf("unable to find '%s' on line %d", node.Value, node.Line)
+	}
+	return LineEdit{Start: index, End: index + 1, Lines: []string{line[:start] + replacement + line[start+len(old):]}}, nil
+}
+
+// SequenceItemLines writes a node as a new item of a block sequence, laid out like the first item of the sequence.
+// Nested sequences are indented or not the way the sequence is under its key.
+func SequenceItemLines(lines []string, key, sequence, item *yaml.Node) ([]string, error) {
+	if sequence.Kind != yaml.SequenceNode || sequence.Style&yaml.FlowStyle != 0 || len(sequence.Content) == 0 {
+		return nil, fmt.Errorf("'%s' is not a block sequence with items", key.Value)
+	}
+	first := sequence.Content[0]
+	index := first.Line - 1
+	if index < 0 || index >= len(lines) || first.Column-1 > len(lines[index]) {
+		return nil, fmt.Errorf("unable to find the items of '%s'", key.Value)
+	}
+	itemColumn := first.Column - 1
+	dashColumn := strings.LastIndex(lines[index][:itemColumn], "-")
+	if dashColumn < 0 {
+		return nil, fmt.Errorf("unable to find the items of '%s'", key.Value)
+	}
+	indented := dashColumn > key.Column-1
+	indent := dashColumn - (key.Column - 1)
+	if !indented {
+		// the items do not say how deep the file indents, so look at the nested mappings
+		if indent = mappingIndent(sequence); indent == 0 {
+			indent = 2 //nolint:gomnd
+		}
+	}
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(indent)
+	if err := encoder.Encode(item); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	itemLines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if !indented {
+		itemLines = dedentSequences(itemLines, indent)
+	}
+	for i := range itemLines {
+		if i == 0 {
+			itemLines[i] = strings.Repeat(" ", dashColumn) + "-" + strings.Repeat(" ", itemColumn-dashColumn-1) + itemLines[i]
+		} else if itemLines[i] != "" {
+			itemLines[i] = strings.Repeat(" ", itemColumn) + itemLines[i]
+		}
+	}
+	return itemLines, nil
+}
+
+// mappingIndent returns how far nested mappings are indented under their key in a node, or zero when it has none.
+func mappingIndent(node *yaml.Node) int {
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if value.Kind == yaml.MappingNode && value.Style&yaml.FlowStyle == 0 && value.Line > key.Line && value.Column > key.Column {
+				return value.Column - key.Column
+			}
+		}
+	}
+	for _, child := range node.Content {
+		if indent := mappingIndent(child); indent != 0 {
+			return indent
+		}
+	}
+	return 0
+}
+
+// dedentSequences moves the block sequences the encoder indents under their key back to the column of the key.
+func dedentSequences(lines []string, indent int) []string {
+	for i := 0; i+1 < len(lines); i++ {
+		if !strings.HasSuffix(lines[i], ":") {
+			continue
+		}
+		keyColumn := len(lines[i]) - len(strings.TrimLeft(lines[i], " -"))
+		next := strings.TrimLeft(lines[i+1], " ")
+		if len(lines[i+1])-len(next) != keyColumn+indent || !strings.HasPrefix(next, "-") {
+			continue
+		}
+		for j := i + 1; j < len(lines); j++ {
+			if strings.TrimSpace(lines[j]) != "" && len(lines[j])-len(strings.TrimLeft(lines[j], " ")) < keyColumn+indent {
+				break
+			}
+			if len(lines[j]) >= indent {
+				lines[j] = lines[j][indent:]
+			}
+		}
+	}
+	return lines
+}
@@ -8,12 +8,15 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/tphoney/best_practice/outputter"
 	"github.com/tphoney/best_practice/outputter/buildmaker"
+	"github.com/tphoney/best_practice/outputter/dockerfileanalysis"
 	"github.com/tphoney/best_practice/outputter/dronebuildanalysis"
 	"github.com/tphoney/best_practice/outputter/harnessproduct"
 	"github.com/tphoney/best_practice/scanner"
+	"github.com/tphoney/best_practice/scanner/compose"
 	"github.com/tphoney/best_practice/scanner/docker"
 	"github.com/tphoney/best_practice/scanner/dronescanner"
 	"github.com/tphoney/best_practice/scanner/golang"
@@ -21,6 +24,7 @@ import (
 	"github.com/tphoney/best_practice/scanner/javascript"
 	"github.com/tphoney/best_practice/scanner/ruby"
 	"github.com/tphoney/best_practice/types"
+	"golang.org/x/exp/slices"
 )
 
 // Args provides plugin execution arguments.
@@ -33,6 +37,44 @@ type Args struct {
 	RequestedScanners []string `envconfig:"PLUGIN_REQUESTED_SCANNERS"`
 	RequestedOutputs  []string `envconfig:"PLUGIN_REQUESTED_OUTPUTS"`
 	WorkingDirectory  string   `envconfig:"PLUGIN_WORKING_DIRECTORY"`
+	// Fix applies the drone build analysis suggestions to the drone file.
+	Fix bool `envconfig:"PLUGIN_FIX"`
+	// BuildTargets selects the build files the build maker generates, defaults to drone and cie.
+	BuildTargets []string `envconfig:"PLUGIN_BUILD_TARGETS"`
+	// Merge adds the generated steps to an existing drone file, instead of creating .drone.yml.new.
+	Merge bool `envconfig:"PLUGIN_MERGE"`
+	// TemplateDirectory holds step templates and an image mapping used by the build maker.
+	TemplateDirectory string `envconfig:"PLUGIN_TEMPLATE_DIRECTORY"`
+	// ImageDigests is a yaml file of image digests, generated images are pinned to them.
+	ImageDigests string `envconfig:"PLUGIN_IMAGE_DIGESTS"`
+	// DroneStarlark writes matrix builds as a drone starlark file instead of yaml.
+	DroneStarlark bool `envconfig:"PLUGIN_DRONE_STARLARK"`
+	// Registry settings used to look for newer image tags, the URL replaces Docker Hub.
+	RegistryURL      string        `envconfig:"PLUGIN_REGISTRY_URL"`
+	RegistryUsername string        `envconfig:"PLUGIN_REGISTRY_USERNAME"`
+	RegistryPassword string        `envconfig:"PLUGIN_REGISTRY_PASSWORD"`
+	RegistryTimeout  time.Duration `envconfig:"PLUGIN_REGISTRY_TIMEOUT"`
+	// RegistryCacheEnabled keeps the registry tags between runs in RegistryCache, for RegistryCacheTTL, setting
+	// RegistryCache enables it too. RegistryCachePreload is a yaml file of image tags added to the cache.
+	RegistryCacheEnabled bool          `envconfig:"PLUGIN_REGISTRY_CACHE_ENABLED"`
+	RegistryCache        string        `envconfig:"PLUGIN_REGISTRY_CACHE"`
+	RegistryCacheTTL     time.Duration `envconfig:"PLUGIN_REGISTRY_CACHE_TTL"`
+	RegistryCachePreload string        `envconfig:"PLUGIN_REGISTRY_CACHE_PRELOAD"`
+	// PinDigests recommends pinning images to their digest.
+	PinDigests bool `envconfig:"PLUGIN_PIN_DIGESTS"`
+	// Offline skips every check that needs the network.
+	Offline bool `envconfig:"PLUGIN_OFFLINE"`
+	// ModuleProxy is the GOPROXY used to look for retracted and deprecated go modules.
+	ModuleProxy string `envconfig:"PLUGIN_MODULE_PROXY"`
+
+	// CIE pipeline settings used by the build maker.
+	CIEOrg                 string `envconfig:"PLUGIN_CIE_ORG"`
+	CIEProject             string `envconfig:"PLUGIN_CIE_PROJECT"`
+	CIEDockerConnector     string `envconfig:"PLUGIN_CIE_DOCKER_CONNECTOR"`
+	CIECodebaseConnector   string `envconfig:"PLUGIN_CIE_CODEBASE_CONNECTOR"`
+	CIERepoName            string `envconfig:"PLUGIN_CIE_REPO_NAME"`
+	CIEKubernetesConnector string `envconfig:"PLUGIN_CIE_KUBERNETES_CONNECTOR"`
+	CIENamespace           string `envconfig:"PLUGIN_CIE_NAMESPACE"`
 }
 
 // Exec executes the plugin.
@@ -50,41 +92,61 @@ func Exec(ctx context.Context, args *Args) error { //nolint:gocyclo
 	if len(args.RequestedScanners) == 0 {
 		args.RequestedScanners = scanner.ListScannersNames()
 	}
+	droneContext := droneConfigContext(&args.Pipeline)
 	scanners := make([]types.Scanner, 0)
 	for _, scannerName := range args.RequestedScanners {
 		switch scannerName {
 		case scanner.DockerScannerName:
-			d, err := docker.New(docker.WithWorkingDirectory(args.WorkingDirectory))
+			d, err := docker.New(docker.WithWorkingDirectory(args.WorkingDirectory), docker.WithDroneContext(droneContext),
+				docker.WithRegistry(docker.RegistryConfig{
+					URL:          args.RegistryURL,
+					Username:     args.RegistryUsername,
+					Password:     args.RegistryPassword,
+					Timeout:      args.RegistryTimeout,
+					Cache:        args.RegistryCacheEnabled,
+					CachePath:    args.RegistryCache,
+					CacheTTL:     args.RegistryCacheTTL,
+					CachePreload: args.RegistryCachePreload,
+				}),
+				docker.WithOffline(args.Offline),
+				docker.WithDigestPinning(args.PinDigests))
 			if err != nil {
 				return err
 			}
 			scanners = append(scanners, d)
+		case scanner.ComposeScannerName:
+			c, err := compose.New(compose.WithWorkingDirectory(args.WorkingDirectory), compose.WithDroneContext(droneContext))
+			if err != nil {
+				return err
+			}
+			scanners = append(scanners, c)
 		case scanner.DroneScannerName:
-			d, err := dronescanner.New(dronescanner.WithWorkingDirectory(args.WorkingDirectory))
+			d, err := dronescanner.New(dronescanner.WithWorkingDirectory(args.WorkingDirectory), dronescanner.WithDroneContext(droneContext))
 			if err != nil {
 				return err
 			}
 			scanners = append(scanners, d)
 		case scanner.GolangScannerName:
-			g, err := golang.New(golang.WithWorkingDirectory(args.WorkingDirectory))
+			g, err := golang.New(golang.WithWorkingDirectory(args.WorkingDirectory), golang.WithDroneContext(droneContext),
+				golang.WithOffline(args.Offline), golang.WithModuleProxy(args.ModuleProxy))
 			if err != nil {
 				return err
 			}
 			scanners = append(scanners, g)
 		case scanner.JavascriptScannerName:
-			j, err := javascript.New(javascript.WithWorkingDirectory(args.WorkingDirectory))
+			j, err := javascript.New(javascript.WithWorkingDirectory(args.WorkingDirectory), javascript.WithDroneContext(droneContext))
 			if err != nil {
 				return err
 			}
 			scanners = append(scanners, j)
 		case scanner.JavaScannerName:
-			j, err := java.New(java.WithWorkingDirectory(args.WorkingDirectory))
+			j, err := java.New(java.WithWorkingDirectory(args.WorkingDirectory), java.WithDroneContext(droneContext))
 			if err != nil {
 				return err
 			}
 			scanners = append(scanners, j)
 		case scanner.RubyScannerName:
-			r, err := ruby.New(ruby.WithWorkingDirectory(args.WorkingDirectory))
+			r, err := ruby.New(ruby.WithWorkingDirectory(args.WorkingDirectory), ruby.WithDroneContext(droneContext))
 			if err != nil {
 				return err
 			}
@@ -100,16 +162,52 @@ func Exec(ctx context.Context, args *Args) error { //nolint:gocyclo
 	if len(args.RequestedOutputs) == 0 {
 		args.RequestedOutputs = outputter.ListOutputterNames()
 	}
+	if len(args.BuildTargets) == 0 {
+		args.BuildTargets = []string{buildmaker.TargetDrone, buildmaker.TargetCIE}
+	}
+	// templates are checked against every scanner family, the checks are only known for the requested scanners
+	availableChecks := map[string][]string{}
+	for _, name := range scanner.ListScannersNames() {
+		availableChecks[name] = nil
+	}
+	for i := range scanners {
+		availableChecks[scanners[i].Name()] = scanners[i].AvailableChecks()
+	}
 	outputters := make([]types.Outputter, 0)
 	for _, outputName := range args.RequestedOutputs {
 		switch outputName {
 		case outputter.BuildMaker:
-			db, _ := buildmaker.New(buildmaker.WithWorkingDirectory(args.WorkingDirectory), buildmaker.WithStdOutput(false), buildmaker.WithOutputToFile(true),
-				buildmaker.WithDroneOutput(true), buildmaker.WithCIEOutput(true))
+			db, err := buildmaker.New(buildmaker.WithWorkingDirectory(args.WorkingDirectory), buildmaker.WithStdOutput(false), buildmaker.WithOutputToFile(true),
+				buildmaker.WithDroneOutput(slices.Contains(args.BuildTargets, buildmaker.TargetDrone)),
+				buildmaker.WithCIEOutput(slices.Contains(args.BuildTargets, buildmaker.TargetCIE)),
+				buildmaker.WithGitHubOutput(slices.Contains(args.BuildTargets, buildmaker.TargetGitHub)),
+				buildmaker.WithGitLabOutput(slices.Contains(args.BuildTargets, buildmaker.TargetGitLab)),
+				buildmaker.WithJenkinsOutput(slices.Contains(args.BuildTargets, buildmaker.TargetJenkins)),
+				buildmaker.WithDroneMerge(args.Merge),
+				buildmaker.WithDroneStarlark(args.DroneStarlark),
+				buildmaker.WithCIEConfig(buildmaker.CIEConfig{
+					OrgIdentifier:       args.CIEOrg,
+					ProjectIdentifier:   args.CIEProject,
+					DockerConnector:     args.CIEDockerConnector,
+					CodebaseConnector:   args.CIECodebaseConnector,
+					RepoName:            args.CIERepoName,
+					KubernetesConnector: args.CIEKubernetesConnector,
+					Namespace:           args.CIENamespace,
+				}),
+				buildmaker.WithTemplateDirectory(args.TemplateDirectory),
+				buildmaker.WithAvailableChecks(availableChecks),
+				buildmaker.WithImageDigests(args.ImageDigests))
+			if err != nil {
+				return err
+			}
 			outputters = append(outputters, db)
 		case outputter.DroneBuildAnalysis:
-			bp, _ := dronebuildanalysis.New(dronebuildanalysis.WithStdOutput(true))
+			bp, _ := dronebuildanalysis.New(dronebuildanalysis.WithStdOutput(true), dronebuildanalysis.WithWorkingDirectory(args.WorkingDirectory),
+				dronebuildanalysis.WithFix(args.Fix))
 			outputters = append(outputters, bp)
+		case outputter.DockerfileAnalysis:
+			da, _ := dockerfileanalysis.New(dockerfileanalysis.WithWorkingDirectory(args.WorkingDirectory))
+			outputters = append(outputters, da)
 		case outputter.HarnessProduct:
 			hp, _ := harnessproduct.New()
 			outputters = append(outputters, hp)
@@ -145,3 +243,41 @@ func Exec(ctx context.Context, args *Args) error { //nolint:gocyclo
 	// profit
 	return nil
 }
+
+// droneConfigContext passes the build and repository metadata to starlark and jsonnet drone files.
+func droneConfigContext(pipeline *Pipeline) *dronescanner.ConfigContext {
+	branch := pipeline.Build.Branch
+	if branch == "" {
+		branch = pipeline.Commit.Branch
+	}
+	return &dronescanner.ConfigContext{
+		Build: dronescanner.BuildContext{
+			Event:        pipeline.Build.Event,
+			Action:       pipeline.Build.Action,
+			Branch:       branch,
+			Source:       pipeline.Commit.Source,
+			Target:       pipeline.Commit.Target,
+			Ref:          pipeline.Commit.Ref,
+			Commit:       pipeline.Commit.Rev,
+			Before:       pipeline.Commit.Before,
+			After:        pipeline.Commit.After,
+			Message:      pipeline.Commit.Message,
+			Link:         pipeline.Build.Link,
+			AuthorLogin:  pipeline.Commit.Author.Username,
+			AuthorName:   pipeline.Commit.Author.Name,
+			AuthorEmail:  pipeline.Commit.Author.Email,
+			AuthorAvatar: pipeline.Commit.Author.Avatar,
+		},
+		Repo: dronescanner.RepoContext{
+			Name:       pipeline.Repo.Name,
+			Namespace:  pipeline.Repo.Namespace,
+			Slug:       pipeline.Repo.Slug,
+			Branch:     pipeline.Repo.Branch,
+			Link:       pipeline.Repo.Link,
+			GitHTTPURL: pipeline.Git.HTTPURL,
+			GitSSHURL:  pipeline.Git.SSHURL,
+			Private:    pipeline.Repo.Private,
+			Visibility: pipeline.Repo.Visibility,
+		},
+	}
+}
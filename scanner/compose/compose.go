@@ -0,0 +1,217 @@
+package compose
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/tphoney/best_practice/outputter"
+	"github.com/tphoney/best_practice/outputter/buildmaker"
+	"github.com/tphoney/best_practice/outputter/dronebuildanalysis"
+	"github.com/tphoney/best_practice/scanner"
+	"github.com/tphoney/best_practice/scanner/dronescanner"
+	"github.com/tphoney/best_practice/types"
+	"golang.org/x/exp/slices"
+)
+
+type scannerConfig struct {
+	name             string
+	description      string
+	workingDirectory string
+	checksToRun      []string
+	runAll           bool
+	droneContext     *dronescanner.ConfigContext
+}
+
+const (
+	Name          = scanner.ComposeScannerName
+	ServicesCheck = "Compose services"
+	DroneCheck    = "Compose Drone build"
+
+	servicesURL = "https://docs.drone.io/pipeline/docker/syntax/services/"
+)
+
+// testPattern matches the commands of steps that run tests.
+var testPattern = regexp.MustCompile(`\b(test|rspec|pytest|verify)\b`)
+
+func New(opts ...Option) (types.Scanner, error) {
+	sc := new(scannerConfig)
+	sc.name = Name
+	sc.description = "checks for the services in docker compose files that the tests depend on"
+	sc.runAll = true
+	// apply options
+	for _, opt := range opts {
+		opt(sc)
+	}
+
+	return sc, nil
+}
+
+func (sc *scannerConfig) Name() string {
+	return sc.name
+}
+
+func (sc *scannerConfig) Description() string {
+	return sc.description
+}
+
+func (sc *scannerConfig) AvailableChecks() []string {
+	return []string{ServicesCheck, DroneCheck}
+}
+
+func (sc *scannerConfig) Scan(ctx context.Context, requestedOutputs []string) (returnVal []types.Scanlet, err error) {
+	services, files := sc.services()
+	if len(services) == 0 {
+		// nothing to see here, lets leave
+		return returnVal, nil
+	}
+	if sc.runAll || slices.Contains(requestedOutputs, ServicesCheck) {
+		outputResults := servicesCheck(services, files)
+		returnVal = append(returnVal, outputResults...)
+	}
+	if sc.runAll || slices.Contains(requestedOutputs, DroneCheck) {
+		outputResults, err := sc.droneCheck(services)
+		if err == nil {
+			returnVal = append(returnVal, outputResults...)
+		}
+	}
+
+	return returnVal, nil
+}
+
+// services returns the services that run a published image, services that compose builds are the project itself.
+// When more than one compose file has a service with the same name the first one is used, files maps each service to
+// the file it is in.
+func (sc *scannerConfig) services() (services []Service, files map[string]string) {
+	files = map[string]string{}
+	for _, path := range FindFiles(sc.workingDirectory) {
+		file, err := ParseFile(sc.workingDirectory, path)
+		if err != nil {
+			fmt.Printf("%s\n", err)
+			continue
+		}
+		for _, service := range file.Services {
+			if service.Build || service.Image == "" || strings.Contains(service.Image, "$") {
+				continue
+			}
+			if _, found := files[service.Name]; found {
+				continue
+			}
+			files[service.Name] = path
+			services = append(services, service)
+		}
+	}
+	return services, files
+}
+
+func servicesCheck(services []Service, files map[string]string) (outputResults []types.Scanlet) {
+	for i := range services {
+		service := &services[i]
+		outputResults = append(outputResults, types.Scanlet{
+			Name:           ServicesCheck,
+			ScannerFamily:  Name,
+			Description:    fmt.Sprintf("start %s from %s as a service for the tests", service.Name, files[service.Name]),
+			OutputRenderer: buildmaker.Name,
+			Spec: buildmaker.OutputFields{
+				Build: buildmaker.Build{
+					Name:        service.Name,
+					Image:       service.Image,
+					Service:     true,
+					Environment: service.Environment,
+					Ports:       service.Ports,
+				},
+				CLI:     fmt.Sprintf("docker compose up -d %s", service.Name),
+				HelpURL: servicesURL,
+			},
+		})
+	}
+	return outputResults
+}
+
+// droneCheck suggests adding the compose services to the drone pipelines that run tests.
+func (sc *scannerConfig) droneCheck(services []Service) (outputResults []types.Scanlet, err error) {
+	pipelines, err := dronescanner.ReadDroneConfig(sc.workingDirectory, sc.droneContext)
+	if err != nil {
+		return outputResults, err
+	}
+	for i := range pipelines {
+		if !runsTests(&pipelines[i]) {
+			continue
+		}
+		for j := range services {
+			if hasService(&pipelines[i], &services[j]) {
+				continue
+			}
+			outputResults = append(outputResults, types.Scanlet{
+				Name:           DroneCheck,
+				ScannerFamily:  Name,
+				Description:    fmt.Sprintf("pipeline '%s' should start the %s service for the tests", pipelines[i].Name, services[j].Name),
+				OutputRenderer: outputter.DroneBuildAnalysis,
+				Spec: dronebuildanalysis.OutputFields{
+					HelpURL:      servicesURL,
+					RawYaml:      serviceYaml(&services[j]),
+					PipelineName: pipelines[i].Name,
+					Service:      true,
+				},
+			})
+		}
+	}
+	return outputResults, nil
+}
+
+func runsTests(pipeline *dronescanner.DronePipeline) bool {
+	for i := range pipeline.Steps {
+		for _, command := range pipeline.Steps[i].Commands {
+			if testPattern.MatchString(command) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hasService matches the services of a pipeline by name or by image, detached steps are services too.
+func hasService(pipeline *dronescanner.DronePipeline, service *Service) bool {
+	name := imageName(service.Image)
+	candidates := append([]dronescanner.Steps{}, pipeline.Services...)
+	for i := range pipeline.Steps {
+		if pipeline.Steps[i].Detach {
+			candidates = append(candidates, pipeline.Steps[i])
+		}
+	}
+	for i := range candidates {
+		if candidates[i].Name == service.Name || imageName(candidates[i].Image) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// imageName strips the tag from an image, eg 'postgres' for 'postgres:15'.
+func imageName(image string) string {
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[:i]
+	}
+	return image
+}
+
+// serviceYaml is an item of the services of a drone pipeline.
+func serviceYaml(service *Service) string {
+	yaml := fmt.Sprintf(`
+- name: %s
+  image: %s`, service.Name, service.Image)
+	if len(service.Environment) > 0 {
+		keys := make([]string, 0, len(service.Environment))
+		for key := range service.Environment {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		yaml += "\n  environment:"
+		for _, key := range keys {
+			yaml += fmt.Sprintf("\n    %s: %q", key, service.Environment[key])
+		}
+	}
+	return yaml
+}
@@ -0,0 +1,101 @@
+package compose
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tphoney/best_practice/outputter/buildmaker"
+	"github.com/tphoney/best_practice/outputter/dronebuildanalysis"
+	"github.com/tphoney/best_practice/types"
+)
+
+const testDroneFile = `kind: pipeline
+type: docker
+name: default
+
+steps:
+  - name: test
+    image: golang:1.21
+    commands:
+      - go test ./...
+`
+
+func TestDroneCheck(t *testing.T) {
+	workingDir := writeFiles(t, map[string]string{"docker-compose.yml": testCompose, ".drone.yml": testDroneFile})
+	sc := &scannerConfig{workingDirectory: workingDir, runAll: true}
+	results, err := sc.Scan(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var suggestions []dronebuildanalysis.OutputFields
+	for _, result := range results {
+		if result.Name == DroneCheck {
+			suggestions = append(suggestions, result.Spec.(dronebuildanalysis.OutputFields))
+		}
+	}
+	if len(suggestions) != 2 {
+		t.Fatalf("got %d drone suggestions, want one for postgres and redis", len(suggestions))
+	}
+	want := "\n- name: postgres\n  image: postgres:16\n  environment:\n    POSTGRES_PASSWORD: \"postgres\"\n    POSTGRES_USER: \"app\""
+	if suggestions[0].RawYaml != want || suggestions[0].PipelineName != "default" || !suggestions[0].Service {
+		t.Errorf("got the suggestion %+v", suggestions[0])
+	}
+	// fix mode adds the services to the pipeline
+	fixer, _ := dronebuildanalysis.New(dronebuildanalysis.WithWorkingDirectory(workingDir), dronebuildanalysis.WithFix(true))
+	if err = fixer.Output(context.Background(), results); err != nil {
+		t.Fatal(err)
+	}
+	fixed, err := os.ReadFile(filepath.Join(workingDir, ".drone.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"services:", "  - name: postgres", "      POSTGRES_USER: \"app\"", "  - name: redis"} {
+		if !strings.Contains(string(fixed), line+"\n") {
+			t.Errorf("the fixed drone file does not contain '%s':\n%s", line, fixed)
+		}
+	}
+}
+
+// TestServicesBuild checks the compose services are started next to the tests in every generated build, and that the
+// tests wait for them.
+func TestServicesBuild(t *testing.T) {
+	workingDir := writeFiles(t, map[string]string{"docker-compose.yml": testCompose})
+	sc := &scannerConfig{workingDirectory: workingDir, runAll: true}
+	results, err := sc.Scan(context.Background(), []string{ServicesCheck})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results = append(results, types.Scanlet{
+		Name: "test", ScannerFamily: "Golang", OutputRenderer: buildmaker.Name,
+		Spec: buildmaker.OutputFields{Build: buildmaker.Build{
+			Name: "go test", Image: "golang:1.21", Phase: buildmaker.PhaseTest, Commands: []string{"go test ./..."},
+		}},
+	})
+	maker, err := buildmaker.New(buildmaker.WithWorkingDirectory(workingDir), buildmaker.WithOutputToFile(true),
+		buildmaker.WithDroneOutput(true), buildmaker.WithCIEOutput(true), buildmaker.WithGitHubOutput(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = maker.Output(context.Background(), results); err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string][]string{
+		".drone.yml":               {"services:", "image: postgres:16", "image: redis:7", "until nc -z postgres 5432"},
+		".cie.yml":                 {"type: Background", "image: postgres:16", "until nc -z postgres 5432"},
+		".github/workflows/ci.yml": {"services:", "image: postgres:16", "- 5432:5432", "until nc -z localhost 5432"},
+	}
+	for name, want := range tests {
+		content, err := os.ReadFile(filepath.Join(workingDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, text := range want {
+			if !strings.Contains(string(content), text) {
+				t.Errorf("%s does not contain '%s':\n%s", name, text, content)
+			}
+		}
+	}
+}
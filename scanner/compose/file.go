@@ -0,0 +1,160 @@
+package compose
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/tphoney/best_practice/outputter"
+	"github.com/tphoney/best_practice/scanner"
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+)
+
+// Filenames are the file names docker compose looks for.
+var Filenames = []string{"docker-compose*.yml", "docker-compose*.yaml", "compose.yml", "compose.yaml"}
+
+// defaultPattern matches an interpolated variable with a default value, eg '${POSTGRES_USER:-postgres}'.
+var defaultPattern = regexp.MustCompile(`\$\{[A-Za-z_][A-Za-z0-9_]*:?-([^}]*)\}`)
+
+type (
+	File struct {
+		// Path is relative to the working directory
+		Path     string
+		Services []Service
+	}
+
+	Service struct {
+		Name  string
+		Image string
+		// ImageLine is the line of the image value
+		ImageLine int
+		// Build is set when compose builds the image of the service itself
+		Build       bool
+		Environment map[string]string
+		// Ports are the tcp ports the container listens on, from both ports and expose
+		Ports []int
+	}
+)
+
+// FindFiles returns the compose files relative to the working directory.
+func FindFiles(workingDir string) (files []string) {
+	for _, pattern := range Filenames {
+		matches, err := scanner.FindMatchingFiles(workingDir, pattern, true)
+		if err != nil {
+			continue
+		}
+		for _, match := range matches {
+			if relative, err := filepath.Rel(workingDir, match); err == nil {
+				files = append(files, relative)
+			}
+		}
+	}
+	return files
+}
+
+// ParseFile reads the services of a compose file, in the order they are written.
+func ParseFile(workingDir, path string) (*File, error) {
+	content, err := os.ReadFile(filepath.Join(workingDir, path))
+	if err != nil {
+		return nil, err
+	}
+	var document yaml.Node
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return nil, fmt.Errorf("error parsing '%s': %s", path, err)
+	}
+	compose := &File{Path: path}
+	if len(document.Content) == 0 {
+		return compose, nil
+	}
+	services := outputter.YAMLMappingValue(document.Content[0], "services")
+	if services == nil || services.Kind != yaml.MappingNode {
+		return compose, nil
+	}
+	for i := 0; i+1 < len(services.Content); i += 2 {
+		node := services.Content[i+1]
+		service := Service{
+			Name:        services.Content[i].Value,
+			Build:       outputter.YAMLMappingValue(node, "build") != nil,
+			Environment: environment(outputter.YAMLMappingValue(node, "environment")),
+		}
+		if image := outputter.YAMLMappingValue(node, "image"); image != nil {
+			service.Image = image.Value
+			service.ImageLine = image.Line
+		}
+		for _, key := range []string{"ports", "expose"} {
+			if ports := outputter.YAMLMappingValue(node, key); ports != nil {
+				for _, port := range ports.Content {
+					if number := containerPort(port); number > 0 && !slices.Contains(service.Ports, number) {
+						service.Ports = append(service.Ports, number)
+					}
+				}
+			}
+		}
+		compose.Services = append(compose.Services, service)
+	}
+	return compose, nil
+}
+
+// environment reads both the mapping and the 'KEY=value' list syntax, variables that are passed through from the
+// host have no value and are left out.
+func environment(node *yaml.Node) map[string]string {
+	if node == nil {
+		return nil
+	}
+	env := map[string]string{}
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if value := node.Content[i+1]; value.Tag != "!!null" {
+				env[node.Content[i].Value] = interpolateDefaults(value.Value)
+			}
+		}
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			if key, value, found := strings.Cut(item.Value, "="); found {
+				env[key] = interpolateDefaults(value)
+			}
+		}
+	}
+	if len(env) == 0 {
+		return nil
+	}
+	return env
+}
+
+// interpolateDefaults replaces variables that have a default with the default, CI has no .env file to read them from.
+func interpolateDefaults(value string) string {
+	return defaultPattern.ReplaceAllString(value, "$1")
+}
+
+// containerPort returns the tcp port inside the container, eg 5432 for '15432:5432' or '127.0.0.1:15432:5432/tcp'.
+// Port ranges and udp ports are ignored.
+func containerPort(node *yaml.Node) int {
+	value := node.Value
+	if node.Kind == yaml.MappingNode {
+		if protocol := outputter.YAMLMappingValue(node, "protocol"); protocol != nil && protocol.Value != "tcp" {
+			return 0
+		}
+		target := outputter.YAMLMappingValue(node, "target")
+		if target == nil {
+			return 0
+		}
+		value = target.Value
+	}
+	value, protocol, _ := strings.Cut(value, "/")
+	if protocol != "" && protocol != "tcp" {
+		return 0
+	}
+	if i := strings.LastIndex(value, ":"); i >= 0 {
+		value = value[i+1:]
+	}
+	port, err := strconv.Atoi(value)
+	if err != nil {
+		return 0
+	}
+	return port
+}
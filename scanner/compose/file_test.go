@@ -0,0 +1,74 @@
+package compose
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	directory := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(directory, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return directory
+}
+
+const testCompose = `services:
+  app:
+    build: .
+    depends_on: [postgres]
+  postgres:
+    image: postgres:16
+    ports:
+      - "15432:5432"
+      - 127.0.0.1:5433:5433/tcp
+      - 53:53/udp
+      - target: 5434
+    expose:
+      - "5432"
+    environment:
+      POSTGRES_USER: ${POSTGRES_USER:-app}
+      POSTGRES_PASSWORD: postgres
+      FROM_HOST:
+  redis:
+    image: redis:7
+    environment:
+      - REDIS_ARGS=--save ""
+      - FROM_HOST
+`
+
+func TestParseFile(t *testing.T) {
+	workingDir := writeFiles(t, map[string]string{"docker-compose.yml": testCompose})
+	file, err := ParseFile(workingDir, "docker-compose.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Service{
+		{Name: "app", Build: true},
+		{
+			Name: "postgres", Image: "postgres:16", ImageLine: 6,
+			Environment: map[string]string{"POSTGRES_USER": "app", "POSTGRES_PASSWORD": "postgres"},
+			// udp ports are not waited on, the same port is listed once
+			Ports: []int{5432, 5433, 5434},
+		},
+		{Name: "redis", Image: "redis:7", ImageLine: 19, Environment: map[string]string{"REDIS_ARGS": `--save ""`}},
+	}
+	if !reflect.DeepEqual(file.Services, want) {
+		t.Errorf("got services %+v, want %+v", file.Services, want)
+	}
+}
+
+func TestParseFileErrors(t *testing.T) {
+	workingDir := writeFiles(t, map[string]string{"compose.yml": "services: [", "empty.yml": ""})
+	if _, err := ParseFile(workingDir, "compose.yml"); err == nil {
+		t.Error("expected an error for invalid yaml")
+	}
+	if file, err := ParseFile(workingDir, "empty.yml"); err != nil || len(file.Services) != 0 {
+		t.Errorf("got %v, %v for an empty file", file, err)
+	}
+}
@@ -0,0 +1,39 @@
+package compose
+
+import (
+	"github.com/tphoney/best_practice/scanner/dronescanner"
+	"golang.org/x/exp/slices"
+)
+
+type Option func(*scannerConfig)
+
+func WithChecksToRun(i []string) Option {
+	return func(p *scannerConfig) {
+		if len(i) > 0 {
+			validChecks := []string{}
+			// only add valid checks
+			for _, check := range i {
+				if slices.Contains(p.AvailableChecks(), check) {
+					validChecks = append(validChecks, check)
+				}
+			}
+			p.runAll = false
+			p.checksToRun = validChecks
+		} else {
+			p.runAll = true
+		}
+	}
+}
+
+func WithWorkingDirectory(i string) Option {
+	return func(p *scannerConfig) {
+		p.workingDirectory = i
+	}
+}
+
+// WithDroneContext sets the build and repository values used to evaluate starlark and jsonnet drone files.
+func WithDroneContext(i *dronescanner.ConfigContext) Option {
+	return func(p *scannerConfig) {
+		p.droneContext = i
+	}
+}
@@ -0,0 +1,164 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultCacheTTL  = 24 * time.Hour
+	cacheFolder      = "best_practice"
+	cacheFileName    = "registry-tags.json"
+	cacheFilePerms   = 0o644
+	cacheFolderPerms = 0o755
+)
+
+type (
+	// tagCache keeps the tags of each image and the digests of the tags, on disk when it has a path so they are
+	// shared between runs.
+	tagCache struct {
+		path    string
+		ttl     time.Duration
+		entries map[string]cacheEntry
+		changed bool
+		// now is the time entries are checked against the TTL
+		now func() time.Time
+	}
+
+	// cacheEntry holds the tags of an image, or the digest of an 'image:tag'.
+	cacheEntry struct {
+		Fetched time.Time `json:"fetched"`
+		Tags    []string  `json:"tags,omitempty"`
+		Digest  string    `json:"digest,omitempty"`
+	}
+)
+
+// defaultCachePath is in the user cache folder, it is empty if there is none and the cache is only kept in memory. It is
+// only used when the persistent cache is turned on.
+func defaultCachePath() string {
+	folder, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(folder, cacheFolder, cacheFileName)
+}
+
+// loadTagCache reads the cache file, a missing or broken cache is treated as empty.
+func loadTagCache(path string, ttl time.Duration) *tagCache {
+	if ttl == 0 {
+		ttl = defaultCacheTTL
+	}
+	cache := &tagCache{path: path, ttl: ttl, entries: map[string]cacheEntry{}, now: time.Now}
+	if path == "" {
+		return cache
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return cache
+	}
+	if err := json.Unmarshal(content, &cache.entries); err != nil {
+		fmt.Printf("ignoring registry cache '%s': %s\n", path, err)
+		cache.entries = map[string]cacheEntry{}
+	}
+	return cache
+}
+
+// preload adds the tags from a yaml or json file that maps each image to its tags, eg 'golang: ["1.21", "1.22"]'.
+// The entries are as old as the file, so they are refreshed once the TTL has passed when we are online.
+func (c *tagCache) preload(path string, key func(string) string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var images map[string][]string
+	if err := yaml.Unmarshal(content, &images); err != nil {
+		return fmt.Errorf("error reading registry cache preload '%s': %s", path, err)
+	}
+	for name, tags := range images {
+		if existing, ok := c.entries[key(name)]; ok && existing.Fetched.After(info.ModTime()) {
+			continue
+		}
+		c.entries[key(name)] = cacheEntry{Fetched: info.ModTime(), Tags: tags}
+		c.changed = true
+	}
+	return nil
+}
+
+// get returns the cached tags, stale entries are only used when they can not be refreshed.
+func (c *tagCache) get(key string, allowStale bool) ([]string, bool) {
+	entry, ok := c.lookup(key, allowStale)
+	if !ok || entry.Digest != "" {
+		return nil, false
+	}
+	return entry.Tags, true
+}
+
+func (c *tagCache) put(key string, tags []string) {
+	c.entries[key] = cacheEntry{Fetched: c.now(), Tags: tags}
+	c.changed = true
+}
+
+// getDigest returns the cached digest of an 'image:tag', stale entries are only used when they can not be refreshed.
+func (c *tagCache) getDigest(key string, allowStale bool) (string, bool) {
+	entry, ok := c.lookup(key, allowStale)
+	if !ok || entry.Digest == "" {
+		return "", false
+	}
+	return entry.Digest, true
+}
+
+func (c *tagCache) putDigest(key, digest string) {
+	c.entries[key] = cacheEntry{Fetched: c.now(), Digest: digest}
+	c.changed = true
+}
+
+func (c *tagCache) lookup(key string, allowStale bool) (cacheEntry, bool) {
+	entry, ok := c.entries[key]
+	if !ok || (!allowStale && c.now().Sub(entry.Fetched) > c.ttl) {
+		return cacheEntry{}, false
+	}
+	return entry, true
+}
+
+// save writes the cache if it changed, it is written to a temporary file first so other runs never read half of it.
+func (c *tagCache) save() error {
+	if c.path == "" || !c.changed {
+		return nil
+	}
+	content, err := json.Marshal(c.entries)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), cacheFolderPerms); err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp(filepath.Dir(c.path), cacheFileName)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(content); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(temp.Name(), cacheFilePerms); err != nil {
+		return err
+	}
+	if err := os.Rename(temp.Name(), c.path); err != nil {
+		return err
+	}
+	c.changed = false
+	return nil
+}
@@ -0,0 +1,85 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTagCacheTTL(t *testing.T) {
+	now := time.Date(2024, time.January, 1, 12, 0, 0, 0, time.UTC)
+	cache := loadTagCache("", time.Hour)
+	cache.now = func() time.Time { return now }
+	cache.put("golang", []string{"1.21"})
+	cache.putDigest("golang:1.21", "sha256:abc")
+	now = now.Add(2 * time.Hour)
+	if _, ok := cache.get("golang", false); ok {
+		t.Error("an expired entry was used")
+	}
+	if _, ok := cache.getDigest("golang:1.21", false); ok {
+		t.Error("an expired digest was used")
+	}
+	// expired entries are used when they can not be refreshed
+	if tags, ok := cache.get("golang", true); !ok || !reflect.DeepEqual(tags, []string{"1.21"}) {
+		t.Errorf("got stale tags %v", tags)
+	}
+	if digest, ok := cache.getDigest("golang:1.21", true); !ok || digest != "sha256:abc" {
+		t.Errorf("got stale digest '%s'", digest)
+	}
+	// digests are not tags
+	if _, ok := cache.get("golang:1.21", true); ok {
+		t.Error("a digest was read as tags")
+	}
+}
+
+func TestTagCacheCorruptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), cacheFileName)
+	if err := os.WriteFile(path, []byte(`{"golang": [`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cache := loadTagCache(path, time.Hour)
+	if len(cache.entries) != 0 {
+		t.Errorf("expected an empty cache, got %v", cache.entries)
+	}
+	// the broken file is replaced when the cache is saved
+	cache.put("golang", []string{"1.21"})
+	if err := cache.save(); err != nil {
+		t.Fatal(err)
+	}
+	if tags, ok := loadTagCache(path, time.Hour).get("golang", false); !ok || !reflect.DeepEqual(tags, []string{"1.21"}) {
+		t.Errorf("got tags %v after saving", tags)
+	}
+}
+
+func TestTagCacheOfflineMiss(t *testing.T) {
+	sc := testUpdateScanner(t.TempDir(), true)
+	if _, err := sc.imageTags(context.Background(), "golang"); !errors.Is(err, errOffline) {
+		t.Errorf("got %v for tags that are not cached, want %v", err, errOffline)
+	}
+	if _, err := sc.imageDigest(context.Background(), "golang", "1.21"); !errors.Is(err, errOffline) {
+		t.Errorf("got %v for a digest that is not cached, want %v", err, errOffline)
+	}
+}
+
+func TestDefaultCacheIsOptIn(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	scanner, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path := scanner.(*scannerConfig).tagCache.path; path != "" {
+		t.Errorf("the cache is saved to '%s' without being turned on", path)
+	}
+	scanner, err = New(WithRegistry(RegistryConfig{Cache: true}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path := scanner.(*scannerConfig).tagCache.path; filepath.Base(path) != cacheFileName {
+		t.Errorf("the cache is saved to '%s'", path)
+	}
+}
@@ -2,16 +2,16 @@ package docker
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"net/http"
+	"path/filepath"
 	"strings"
 
-	"github.com/Masterminds/semver"
 	"github.com/tphoney/best_practice/outputter"
 	"github.com/tphoney/best_practice/outputter/buildmaker"
+	"github.com/tphoney/best_practice/outputter/dockerfileanalysis"
 	"github.com/tphoney/best_practice/outputter/dronebuildanalysis"
 	"github.com/tphoney/best_practice/scanner"
+	"github.com/tphoney/best_practice/scanner/compose"
 	"github.com/tphoney/best_practice/scanner/dronescanner"
 	"github.com/tphoney/best_practice/types"
 	"golang.org/x/exp/slices"
@@ -23,6 +23,12 @@ type scannerConfig struct {
 	workingDirectory string
 	checksToRun      []string
 	runAll           bool
+	droneContext     *dronescanner.ConfigContext
+	registryConfig   RegistryConfig
+	registry         *registryClient
+	tagCache         *tagCache
+	offline          bool
+	pinDigests       bool
 }
 
 const (
@@ -31,6 +37,9 @@ const (
 	BuildCheck        = "Docker build"
 	SecurityScanCheck = "Docker security scan"
 	DroneCheck        = "Docker Drone build"
+	ImageUpdateCheck  = "Docker image updates"
+	LintCheck         = "Dockerfile lint"
+	EfficiencyCheck   = "Dockerfile efficiency"
 )
 
 func New(opts ...Option) (types.Scanner, error) {
@@ -42,6 +51,17 @@ func New(opts ...Option) (types.Scanner, error) {
 	for _, opt := range opts {
 		opt(sc)
 	}
+	sc.registry = newRegistryClient(sc.registryConfig)
+	cachePath := sc.registryConfig.CachePath
+	if cachePath == "" && sc.registryConfig.Cache {
+		cachePath = defaultCachePath()
+	}
+	sc.tagCache = loadTagCache(cachePath, sc.registryConfig.CacheTTL)
+	if sc.registryConfig.CachePreload != "" {
+		if err := sc.tagCache.preload(sc.registryConfig.CachePreload, sc.registry.cacheKey); err != nil {
+			return nil, err
+		}
+	}
 
 	return sc, nil
 }
@@ -55,16 +75,23 @@ func (sc *scannerConfig) Description() string {
 }
 
 func (sc *scannerConfig) AvailableChecks() []string {
-	return []string{BuildCheck, SecurityScanCheck}
+	return []string{BuildCheck, SecurityScanCheck, LintCheck, EfficiencyCheck, ImageUpdateCheck}
 }
 
 func (sc *scannerConfig) Scan(ctx context.Context, requestedOutputs []string) (returnVal []types.Scanlet, err error) {
 	// lets look for any java files.
 	dockerFileMatches, err := scanner.FindMatchingFiles(sc.workingDirectory, dockerFilename, true)
-	if err != nil || len(dockerFileMatches) == 0 {
+	composeFiles := compose.FindFiles(sc.workingDirectory)
+	if err != nil || (len(dockerFileMatches) == 0 && len(composeFiles) == 0) {
 		// nothing to see here, lets leave
 		return returnVal, nil
 	}
+	// build files reference the dockerfile relative to the repository root
+	for i := range dockerFileMatches {
+		if relative, relErr := filepath.Rel(sc.workingDirectory, dockerFileMatches[i]); relErr == nil {
+			dockerFileMatches[i] = relative
+		}
+	}
 
 	if sc.runAll || slices.Contains(requestedOutputs, BuildCheck) {
 		outputResults := sc.buildCheck(dockerFileMatches)
@@ -74,8 +101,17 @@ func (sc *scannerConfig) Scan(ctx context.Context, requestedOutputs []string) (r
 		outputResults := sc.securityCheck(dockerFileMatches)
 		returnVal = append(returnVal, outputResults...)
 	}
+	if sc.runAll || slices.Contains(requestedOutputs, LintCheck) || slices.Contains(requestedOutputs, EfficiencyCheck) {
+		outputResults := sc.dockerfileCheck(dockerFileMatches,
+			sc.runAll || slices.Contains(requestedOutputs, LintCheck), sc.runAll || slices.Contains(requestedOutputs, EfficiencyCheck))
+		returnVal = append(returnVal, outputResults...)
+	}
+	if sc.runAll || slices.Contains(requestedOutputs, ImageUpdateCheck) {
+		outputResults := sc.imageUpdateCheck(ctx, dockerFileMatches, composeFiles)
+		returnVal = append(returnVal, outputResults...)
+	}
 	if (sc.runAll || slices.Contains(requestedOutputs, DroneCheck)) && len(dockerFileMatches) > 0 {
-		outputResults, err := sc.droneBuildCheck()
+		outputResults, err := sc.droneBuildCheck(ctx, dockerFileMatches, sc.runAll || slices.Contains(requestedOutputs, ImageUpdateCheck))
 		if err == nil {
 			returnVal = append(returnVal, outputResults...)
 		}
@@ -85,55 +121,98 @@ func (sc *scannerConfig) Scan(ctx context.Context, requestedOutputs []string) (r
 }
 
 func (sc *scannerConfig) buildCheck(dockerFiles []string) (outputResults []types.Scanlet) {
+	images, others := platformImages(sc.workingDirectory, dockerFiles)
 	// lets check for the build system
-	for i := range dockerFiles {
-		testResult := types.Scanlet{
+	for i := range others {
+		outputResults = append(outputResults, dockerBuildResult(others[i], "add docker build step, we can upload to acr/dockerhub/ecr/gcr/heroku", nil))
+	}
+	// images built for several platforms get a build for each platform and a manifest that joins them
+	for i := range images {
+		for j := range images[i].builds {
+			build := &images[i].builds[j]
+			outputResults = append(outputResults, dockerBuildResult(build.dockerfile, fmt.Sprintf("add docker build step for %s", build.platform.String()), build))
+		}
+		outputResults = append(outputResults, types.Scanlet{
 			Name:           BuildCheck,
 			ScannerFamily:  Name,
-			Description:    "add docker build step, we can upload to acr/dockerhub/ecr/gcr/heroku",
+			Description:    fmt.Sprintf("add a manifest step that joins the %s images into a multi platform image", images[i].dir),
 			OutputRenderer: buildmaker.Name,
 			Spec: buildmaker.OutputFields{
-				Build: buildmaker.Build{
-					Name:  fmt.Sprintf("docker build %s", dockerFiles[i]),
-					Image: "plugins/docker",
-					DroneAppend: fmt.Sprintf(`  privileged: true
-    settings:
-      repo: organization/docker-image-name
-      dry_run: true                       # TODO remove this in production
-      auto_tag: true
-      dockerfile: %s
-      username:
-        from_secret: docker_username
-      password:
-        from_secret: docker_password`, dockerFiles[i]),
-				},
-				CLI:     fmt.Sprintf("docker build  --rm --no-cache -t organization/docker-image-name:latest -f %s .", dockerFiles[i]),
-				HelpURL: "https://plugins.drone.io/plugins/docker",
+				Build:   images[i].manifestBuild(),
+				CLI:     "docker manifest create organization/docker-image-name:latest organization/docker-image-name:linux-amd64",
+				HelpURL: manifestURL,
 			},
-		}
-		outputResults = append(outputResults, testResult)
+		})
 	}
 	return outputResults
 }
 
+// dockerBuildResult builds a dockerfile. The image of a platform build is tagged with the platform and pushed, so the
+// manifest can join it.
+func dockerBuildResult(dockerFile, description string, platformBuild *platformBuild) types.Scanlet {
+	build := buildmaker.Build{
+		Name:       fmt.Sprintf("docker build %s", dockerFile),
+		Phase:      buildmaker.PhasePackage,
+		Image:      "plugins/docker",
+		Privileged: true,
+		Settings: map[string]interface{}{
+			"repo":       "organization/docker-image-name",
+			"dry_run":    true, // TODO remove this in production
+			"auto_tag":   true,
+			"dockerfile": dockerFile,
+			"username":   buildmaker.Secret("docker_username"),
+			"password":   buildmaker.Secret("docker_password"),
+		},
+	}
+	if platformBuild != nil {
+		platform := platformBuild.platform
+		build.Platform = &platform
+		build.Settings["auto_tag_suffix"] = platformBuild.tagSuffix()
+		build.Binaries = platformBuild.binaries
+		build.When = pushEvents()
+		delete(build.Settings, "dry_run")
+	}
+	return types.Scanlet{
+		Name:           BuildCheck,
+		ScannerFamily:  Name,
+		Description:    description,
+		OutputRenderer: buildmaker.Name,
+		Spec: buildmaker.OutputFields{
+			Build:   build,
+			CLI:     fmt.Sprintf("docker build  --rm --no-cache -t organization/docker-image-name:latest -f %s .", dockerFile),
+			HelpURL: "https://plugins.drone.io/plugins/docker",
+		},
+	}
+}
+
 func (sc *scannerConfig) securityCheck(dockerFiles []string) (outputResults []types.Scanlet) {
+	// the scan builds the image on the runner, so only the linux/amd64 image of a multi platform build is scanned
+	images, dockerFiles := platformImages(sc.workingDirectory, dockerFiles)
+	for i := range images {
+		for j := range images[i].builds {
+			if images[i].builds[j].platform.Arch == "amd64" {
+				dockerFiles = append(dockerFiles, images[i].builds[j].dockerfile)
+			}
+		}
+	}
 	// lets check for the build system
 	for i := range dockerFiles {
 		testResult := types.Scanlet{
-			Name:           BuildCheck,
+			Name:           SecurityScanCheck,
 			ScannerFamily:  Name,
 			Description:    "run snyk security scan",
 			OutputRenderer: buildmaker.Name,
 			Spec: buildmaker.OutputFields{
 				Build: buildmaker.Build{
-					Name:  fmt.Sprintf("docker scan %s", dockerFiles[i]),
-					Image: "plugins/drone-snyk",
-					DroneAppend: fmt.Sprintf(`  privileged: true
-    settings:
-      dockerfile: %s
-      image: organization/docker-image-name
-      snyk:
-        from_secret: snyk_token`, dockerFiles[i]),
+					Name:       fmt.Sprintf("docker scan %s", dockerFiles[i]),
+					Phase:      buildmaker.PhaseScan,
+					Image:      "plugins/drone-snyk",
+					Privileged: true,
+					Settings: map[string]interface{}{
+						"dockerfile": dockerFiles[i],
+						"image":      "organization/docker-image-name",
+						"snyk":       buildmaker.Secret("snyk_token"),
+					},
 				},
 				CLI:     fmt.Sprintf("docker scan drone-plugins/drone-snyk --file= %s", dockerFiles[i]),
 				HelpURL: "snyk.io/help/",
@@ -144,18 +223,58 @@ func (sc *scannerConfig) securityCheck(dockerFiles []string) (outputResults []ty
 	return outputResults
 }
 
-func (sc *scannerConfig) droneBuildCheck() (outputResults []types.Scanlet, err error) {
-	pipelines, err := dronescanner.ReadDroneFile(sc.workingDirectory, dronescanner.DroneFileLocation)
+// dockerfileCheck parses each dockerfile once for the lint and efficiency checks.
+func (sc *scannerConfig) dockerfileCheck(dockerFiles []string, lint, efficiency bool) (outputResults []types.Scanlet) {
+	for i := range dockerFiles {
+		df, err := parseDockerfile(sc.workingDirectory, dockerFiles[i])
+		if err != nil {
+			fmt.Printf("error parsing '%s': %s\n", dockerFiles[i], err)
+			continue
+		}
+		if lint {
+			outputResults = append(outputResults, dockerfileResults(LintCheck, dockerFiles[i], lintDockerfile(df))...)
+		}
+		if efficiency {
+			outputResults = append(outputResults, dockerfileResults(EfficiencyCheck, dockerFiles[i], efficiencyFindings(sc.workingDirectory, df))...)
+		}
+	}
+	return outputResults
+}
+
+func dockerfileResults(check, dockerFile string, findings []finding) (outputResults []types.Scanlet) {
+	for _, found := range findings {
+		testResult := types.Scanlet{
+			Name:           check,
+			ScannerFamily:  Name,
+			Description:    found.description,
+			OutputRenderer: outputter.DockerfileAnalysis,
+			Spec: dockerfileanalysis.OutputFields{
+				File:        dockerFile,
+				Line:        found.line,
+				Rule:        found.rule,
+				Instruction: found.instruction,
+				HelpURL:     found.helpURL,
+				Template:    found.template,
+			},
+		}
+		outputResults = append(outputResults, testResult)
+	}
+	return outputResults
+}
+
+func (sc *scannerConfig) droneBuildCheck(ctx context.Context, dockerFiles []string, imageUpdates bool) (outputResults []types.Scanlet, err error) {
+	pipelines, err := dronescanner.ReadDroneConfig(sc.workingDirectory, sc.droneContext)
 	if err != nil {
 		return outputResults, err
 	}
 	foundDockerPlugin := false
 	foundSnykPlugin := false
+	foundManifestPlugin := false
 	foundDockerScanCommand := false
 	foundDockerBuildCommand := false
-	var imagesWithTag []*image
 	// iterate over the pipelines
 	for i := range pipelines {
+		var imagesWithTag []*image
 		for j := range pipelines[i].Steps {
 			// check for plugins
 			if strings.Contains(pipelines[i].Steps[j].Image, "plugins/docker") {
@@ -164,6 +283,9 @@ func (sc *scannerConfig) droneBuildCheck() (outputResults []types.Scanlet, err e
 			if strings.Contains(pipelines[i].Steps[j].Image, "plugins/drone-snyk") {
 				foundSnykPlugin = true
 			}
+			if strings.Contains(pipelines[i].Steps[j].Image, "plugins/manifest") {
+				foundManifestPlugin = true
+			}
 			commands := pipelines[i].Steps[j].Commands
 			// check for images with tagged versions
 			if strings.Contains(pipelines[i].Steps[j].Image, ":") {
@@ -189,8 +311,9 @@ func (sc *scannerConfig) droneBuildCheck() (outputResults []types.Scanlet, err e
 				Description:    fmt.Sprintf("pipeline '%s' should use the drone docker plugin", pipelines[i].Name),
 				OutputRenderer: outputter.DroneBuildAnalysis,
 				Spec: dronebuildanalysis.OutputFields{
-					HelpURL: "https://plugins.drone.io/plugins/docker",
-					Command: "docker build  --rm --no-cache -t organization/docker-image-name:latest -f Dockerfile .",
+					PipelineName: pipelines[i].Name,
+					HelpURL:      "https://plugins.drone.io/plugins/docker",
+					Command:      "docker build  --rm --no-cache -t organization/docker-image-name:latest -f Dockerfile .",
 					RawYaml: `
   - name: build docker
     image: plugins/docker
@@ -214,8 +337,9 @@ func (sc *scannerConfig) droneBuildCheck() (outputResults []types.Scanlet, err e
 				Description:    fmt.Sprintf("pipeline '%s' should use the drone snyk plugin", pipelines[i].Name),
 				OutputRenderer: outputter.DroneBuildAnalysis,
 				Spec: dronebuildanalysis.OutputFields{
-					HelpURL: "snyk.io/help/",
-					Command: "docker scan drone-plugins/drone-snyk --file=Dockerfile",
+					PipelineName: pipelines[i].Name,
+					HelpURL:      "snyk.io/help/",
+					Command:      "docker scan drone-plugins/drone-snyk --file=Dockerfile",
 					RawYaml: `
   - name: scan image
     image: plugins/drone-snyk
@@ -229,86 +353,64 @@ func (sc *scannerConfig) droneBuildCheck() (outputResults []types.Scanlet, err e
 			}
 			outputResults = append(outputResults, bestPracticeResult)
 		}
+		if !imageUpdates {
+			continue
+		}
 		// check for images with tagged versions
-		containerErr := getContainerUpdates(imagesWithTag)
+		skipped, containerErr := sc.getContainerUpdates(ctx, imagesWithTag)
 		if containerErr != nil {
-			fmt.Printf("error getting container updates: %s", containerErr)
+			fmt.Printf("error getting container updates: %s\n", containerErr)
+		}
+		if len(skipped) > 0 {
+			outputResults = append(outputResults, scanner.SkippedCheck(ImageUpdateCheck, Name,
+				fmt.Sprintf("pipeline '%s' images %s were not checked, offline and not in the registry cache", pipelines[i].Name, strings.Join(skipped, ", "))))
 		}
 		for k := range imagesWithTag {
-			if imagesWithTag[k].updatedImage != "" {
+			if recommended := imagesWithTag[k].recommended(); recommended != "" {
 				bestPracticeResult := types.Scanlet{
-					Name:          BuildCheck,
-					ScannerFamily: Name,
-					Description: fmt.Sprintf("pipeline '%s' step `%s` update image from %s to %s",
-						pipelines[i].Name, imagesWithTag[k].stepName, imagesWithTag[k].image, imagesWithTag[k].updatedImage),
+					Name:           ImageUpdateCheck,
+					ScannerFamily:  Name,
+					Description:    fmt.Sprintf("pipeline '%s' step `%s` %s", pipelines[i].Name, imagesWithTag[k].stepName, imagesWithTag[k].change()),
 					OutputRenderer: outputter.DroneBuildAnalysis,
 					Spec: dronebuildanalysis.OutputFields{
-						HelpURL: "https://docs.docker.com/engine/reference/commandline/pull/",
-						Command: fmt.Sprintf("docker pull %s", imagesWithTag[k].updatedImage),
-						RawYaml: fmt.Sprintf(`image: %s`, imagesWithTag[k].updatedImage),
+						PipelineName: pipelines[i].Name,
+						StepName:     imagesWithTag[k].stepName,
+						Image:        recommended,
+						HelpURL:      "https://docs.docker.com/engine/reference/commandline/pull/",
+						Command:      fmt.Sprintf("docker pull %s", recommended),
+						RawYaml:      fmt.Sprintf(`image: %s`, recommended),
 					},
 				}
 				outputResults = append(outputResults, bestPracticeResult)
 			}
 		}
 	}
-	return outputResults, err
-}
-
-type image struct {
-	image        string
-	updatedImage string
-	stepName     string
-}
-
-type dockerTags []struct {
-	Layer string `json:"layer"`
-	Name  string `json:"name"`
-}
-
-func getContainerUpdates(images []*image) (err error) {
-	ctx := context.Background()
-	for i := range images {
-		// split the name and tag
-		split := strings.Split(images[i].image, ":")
-		if len(split) != 2 { //nolint:gomnd
-			return fmt.Errorf("image name is not in the format 'image:tag'")
-		}
-		name, currentTag := split[0], split[1]
-		currentSemver, currentErr := scanner.ReturnVersionObject(currentTag)
-		if currentErr != nil {
-			return currentErr
-		}
-		// get the docker tags for the image
-		req, reqErr := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("https://registry.hub.docker.com/v1/repositories/%s/tags", name), http.NoBody)
-		if reqErr != nil {
-			return reqErr
-		}
-		resp, doErr := http.DefaultClient.Do(req)
-		if doErr != nil {
-			return doErr
-		}
-		var tags dockerTags
-		err = json.NewDecoder(resp.Body).Decode(&tags)
-		resp.Body.Close()
-		if err != nil {
-			return err
-		}
-		// find the tag in the list of tags
-		var semVers []*semver.Version
-		for j := range tags {
-			// convert to a semver
-			version, err := scanner.ReturnVersionObject(tags[j].Name)
-			if err == nil {
-				semVers = append(semVers, version)
-			}
-		}
-		// iterate over the semvers and find any that are greater than the tag
-		for j := range semVers {
-			if semVers[j].GreaterThan(currentSemver) && strings.Contains(currentTag, semVers[j].Prerelease()) {
-				images[i].updatedImage = fmt.Sprintf("%s:%s", name, semVers[j].String())
-			}
+	// the manifest is usually in a pipeline of its own, that depends on the pipeline of each platform
+	if images, _ := platformImages(sc.workingDirectory, dockerFiles); len(images) > 0 && !foundManifestPlugin {
+		spec := fmt.Sprintf("spec: %s", images[0].manifest)
+		if images[0].manifest == "" {
+			spec = "target: organization/docker-image-name:latest\n      template: organization/docker-image-name:OS-ARCHVARIANT"
 		}
+		outputResults = append(outputResults, types.Scanlet{
+			Name:           BuildCheck,
+			ScannerFamily:  Name,
+			Description:    fmt.Sprintf("the %s images are built for several platforms, add a pipeline with the drone manifest plugin", images[0].dir),
+			OutputRenderer: outputter.DroneBuildAnalysis,
+			Spec: dronebuildanalysis.OutputFields{
+				HelpURL: manifestURL,
+				RawYaml: fmt.Sprintf(`
+  - name: manifest
+    image: plugins/manifest
+    settings:
+      auto_tag: true
+      ignore_missing: true
+      %s
+      username:
+        from_secret: docker_username
+      password:
+        from_secret: docker_password`, spec),
+			},
+		})
 	}
-	return nil
+	return outputResults, err
 }
@@ -0,0 +1,165 @@
+package docker
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/moby/buildkit/frontend/dockerfile/parser"
+)
+
+type (
+	// dockerfile is a parsed Dockerfile, split into its build stages.
+	dockerfile struct {
+		// path is relative to the working directory
+		path string
+		// metaArgs are the ARG instructions before the first FROM, they can be used in FROM
+		metaArgs []instruction
+		stages   []stage
+	}
+
+	stage struct {
+		name string
+		// image is the base image with the meta args expanded, it is the name of the stage when building on one
+		image        string
+		platform     string
+		from         instruction
+		instructions []instruction
+	}
+
+	instruction struct {
+		// command is lower case, eg 'run'
+		command  string
+		args     []string
+		flags    []string
+		heredocs []string
+		line     int
+		original string
+	}
+)
+
+var argPattern = regexp.MustCompile(`\$\{(\w+)(?::?[-+]([^}]*))?\}|\$(\w+)`)
+
+// parseDockerfile reads a dockerfile with the buildkit parser.
+func parseDockerfile(workingDir, path string) (*dockerfile, error) {
+	file, err := os.Open(filepath.Join(workingDir, path))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	result, err := parser.Parse(file)
+	if err != nil {
+		return nil, err
+	}
+	df := &dockerfile{path: path}
+	for _, node := range result.AST.Children {
+		inst := instruction{
+			command:  strings.ToLower(node.Value),
+			flags:    node.Flags,
+			line:     node.StartLine,
+			original: node.Original,
+		}
+		for next := node.Next; next != nil; next = next.Next {
+			inst.args = append(inst.args, next.Value)
+		}
+		for _, heredoc := range node.Heredocs {
+			inst.heredocs = append(inst.heredocs, heredoc.Content)
+		}
+		switch {
+		case inst.command == "from":
+			st := stage{from: inst}
+			if len(inst.args) > 0 {
+				st.image = df.expand(inst.args[0])
+			}
+			// FROM image AS name
+			if len(inst.args) == 3 && strings.EqualFold(inst.args[1], "as") { //nolint:gomnd
+				st.name = strings.ToLower(inst.args[2])
+			}
+			for _, flag := range inst.flags {
+				if strings.HasPrefix(flag, "--platform=") {
+					st.platform = strings.TrimPrefix(flag, "--platform=")
+				}
+			}
+			df.stages = append(df.stages, st)
+		case len(df.stages) == 0:
+			if inst.command == "arg" {
+				df.metaArgs = append(df.metaArgs, inst)
+			}
+		default:
+			df.stages[len(df.stages)-1].instructions = append(df.stages[len(df.stages)-1].instructions, inst)
+		}
+	}
+	return df, nil
+}
+
+// expand replaces the meta args in a FROM value with their defaults.
+func (df *dockerfile) expand(s string) string {
+	defaults := map[string]string{}
+	for _, arg := range df.metaArgs {
+		for _, value := range arg.args {
+			if key, value, found := strings.Cut(value, "="); found {
+				defaults[key] = strings.Trim(value, `"'`)
+			}
+		}
+	}
+	return argPattern.ReplaceAllStringFunc(s, func(match string) string {
+		groups := argPattern.FindStringSubmatch(match)
+		name := groups[1] + groups[3]
+		if value, ok := defaults[name]; ok {
+			return value
+		}
+		// ${VAR:-default}
+		if groups[2] != "" {
+			return groups[2]
+		}
+		return match
+	})
+}
+
+// isStage returns true if the image is an earlier stage of the dockerfile.
+func (df *dockerfile) isStage(image string, before int) bool {
+	return df.stageIndex(image, before) >= 0
+}
+
+// stageIndex returns the index of the earlier stage with the name, or -1 if the image is not a stage.
+func (df *dockerfile) stageIndex(image string, before int) int {
+	for i := before - 1; i >= 0; i-- {
+		if df.stages[i].name != "" && df.stages[i].name == strings.ToLower(image) {
+			return i
+		}
+	}
+	return -1
+}
+
+// finalStage is the stage that is shipped when no target is given.
+func (df *dockerfile) finalStage() *stage {
+	if len(df.stages) == 0 {
+		return nil
+	}
+	return &df.stages[len(df.stages)-1]
+}
+
+// shellCommand returns the command line of a RUN instruction, including heredocs.
+func (inst *instruction) shellCommand() string {
+	return strings.Join(append(append([]string{}, inst.args...), inst.heredocs...), " ")
+}
+
+// hasFlag returns true if the instruction has the flag, eg '--mount=type=cache'.
+func (inst *instruction) hasFlag(prefix string) bool {
+	for _, flag := range inst.flags {
+		if strings.HasPrefix(flag, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitImage splits an image reference into its name, tag and digest.
+func splitImage(ref string) (name, tag, digest string) {
+	name, digest, _ = strings.Cut(ref, "@")
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, tag = name[:i], name[i+1:]
+	}
+	return name, tag, digest
+}
@@ -0,0 +1,153 @@
+package docker
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// testDockerfile writes the files into a new working directory and parses its Dockerfile.
+func testDockerfile(t *testing.T, files map[string]string) (workingDir string, df *dockerfile) {
+	t.Helper()
+	workingDir = t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(workingDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	df, err := parseDockerfile(workingDir, "Dockerfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return workingDir, df
+}
+
+// findingRules returns the rules of the findings with the line they were found on, sorted.
+func findingRules(findings []finding) (rules []string) {
+	for _, found := range findings {
+		rules = append(rules, found.rule+"@"+strconv.Itoa(found.line))
+	}
+	sort.Strings(rules)
+	return rules
+}
+
+func TestParseDockerfile(t *testing.T) {
+	_, df := testDockerfile(t, map[string]string{"Dockerfile": `ARG GO_VERSION=1.21
+ARG BASE
+FROM --platform=$BUILDPLATFORM golang:${GO_VERSION}-alpine AS Build
+RUN <<EOF
+go build -o /app .
+EOF
+
+FROM ${BASE:-alpine:3.19}
+COPY --from=build /app /app
+`})
+	if len(df.metaArgs) != 2 || len(df.stages) != 2 {
+		t.Fatalf("got %d meta args and %d stages", len(df.metaArgs), len(df.stages))
+	}
+	build := df.stages[0]
+	if build.name != "build" || build.image != "golang:1.21-alpine" || build.platform != "$BUILDPLATFORM" {
+		t.Errorf("got build stage %q %q %q", build.name, build.image, build.platform)
+	}
+	// the heredoc is part of the command, so the rules see what it runs
+	if command := build.instructions[0].shellCommand(); !strings.Contains(command, "go build -o /app .") {
+		t.Errorf("the heredoc is read as %q", command)
+	}
+	final := df.finalStage()
+	if final.image != "alpine:3.19" || final.instructions[0].line != 9 || !final.instructions[0].hasFlag("--from=build") {
+		t.Errorf("got final stage %+v", final)
+	}
+	if !df.isStage("BUILD", 1) || df.isStage("build", 0) {
+		t.Error("stage names are matched ignoring case, and only before the stage")
+	}
+}
+
+func TestSplitImage(t *testing.T) {
+	tests := map[string][3]string{
+		"golang":                            {"golang", "", ""},
+		"golang:1.21":                       {"golang", "1.21", ""},
+		"localhost:5000/app":                {"localhost:5000/app", "", ""},
+		"localhost:5000/app:v1@sha256:abcd": {"localhost:5000/app", "v1", "sha256:abcd"},
+	}
+	for ref, want := range tests {
+		name, tag, digest := splitImage(ref)
+		if got := [3]string{name, tag, digest}; got != want {
+			t.Errorf("splitImage(%s) = %v, want %v", ref, got, want)
+		}
+	}
+}
+
+func TestLintDockerfile(t *testing.T) {
+	tests := []struct {
+		name       string
+		dockerfile string
+		want       []string
+	}{
+		{
+			name: "good",
+			dockerfile: `FROM golang:1.21 AS build
+RUN go build -o /app .
+FROM alpine:3.19@sha256:0123
+RUN apt-get update && apt-get install -y --no-install-recommends ca-certificates && rm -rf /var/lib/apt/lists/*
+COPY --from=build /app /app
+USER nobody
+HEALTHCHECK CMD ["/app", "health"]
+`,
+		},
+		{
+			name: "unpinned and root",
+			dockerfile: `FROM ubuntu
+FROM node:latest
+USER root
+HEALTHCHECK CMD ["true"]
+`,
+			want: []string{"DL3002@3", "DL3006@1", "DL3007@2"},
+		},
+		{
+			name: "apt, add and curl",
+			dockerfile: `FROM debian:12
+RUN apt-get install -y curl
+ADD . /src
+ADD https://example.com/tool.tar.gz /tmp/
+RUN curl -fsSL https://example.com/install.sh | bash
+USER 1000
+HEALTHCHECK CMD ["true"]
+`,
+			want: []string{"DL3009@2", "DL3015@2", "DL3020@3", "curl-pipe-shell@5"},
+		},
+		{
+			name: "secrets and no healthcheck",
+			dockerfile: `ARG NPM_TOKEN
+FROM node:20 AS base
+ENV API_KEY=abc LOG_LEVEL=debug
+USER node
+FROM base
+`,
+			// the final stage is built on base, so it has the user of base
+			want: []string{"no-healthcheck@5", "secret-in-build@1", "secret-in-build@3"},
+		},
+	}
+	for _, test := range tests {
+		_, df := testDockerfile(t, map[string]string{"Dockerfile": test.dockerfile})
+		if got := findingRules(lintDockerfile(df)); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got findings %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestDockerfileResults(t *testing.T) {
+	results := dockerfileResults(LintCheck, "docker/Dockerfile", []finding{
+		hadolintFinding("DL3006", "always tag the version of image 'ubuntu'", &instruction{line: 1, original: "FROM ubuntu"}),
+	})
+	if len(results) != 1 || results[0].Name != LintCheck || results[0].ScannerFamily != Name {
+		t.Fatalf("got results %+v", results)
+	}
+}
@@ -0,0 +1,232 @@
+package docker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/moby/buildkit/frontend/dockerfile/dockerignore"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	dockerignoreFile = ".dockerignore"
+	buildKitURL      = "https://docs.docker.com/build/cache/"
+	multiStageURL    = "https://docs.docker.com/build/building/multi-stage/"
+	ruleCacheBust    = "copy-before-install"
+	ruleIgnore       = "no-dockerignore"
+	ruleSingleStage  = "single-stage-build"
+	ruleCacheMount   = "no-cache-mount"
+	ruleRewrite      = "multi-stage-rewrite"
+)
+
+type cacheMount struct {
+	pattern *regexp.Regexp
+	target  string
+}
+
+var (
+	// dependencyPattern matches the commands that download dependencies
+	dependencyPattern = regexp.MustCompile(`\bgo (mod download|build|install)\b|\bnpm (ci|install|i)\b|\byarn\b|\bpnpm install\b|` +
+		`\bpip3? install\b|\bbundle install\b|\bmvnw?\b|\bgradlew?\b`)
+	compilerPattern = regexp.MustCompile(`\b(build-essential|gcc|g\+\+|make|musl-dev|openjdk-\d+-jdk)\b`)
+	// compilerImages are the sdk images that should only be used to build
+	compilerImages = []string{"golang", "maven", "gradle", "rust", "gcc", "openjdk"}
+	// ignoredFolders should not be sent to the docker daemon, they are big and change all the time
+	ignoredFolders = []string{"node_modules", ".git", "vendor"}
+	cacheMounts    = map[string]cacheMount{
+		"go":     {regexp.MustCompile(`\bgo (mod download|build|install|test)\b`), "/go/pkg/mod"},
+		"npm":    {regexp.MustCompile(`\bnpm (ci|install|i)\b`), "/root/.npm"},
+		"maven":  {regexp.MustCompile(`\bmvnw?\b`), "/root/.m2"},
+		"gradle": {regexp.MustCompile(`\bgradlew?\b`), "/root/.gradle"},
+	}
+)
+
+// efficiencyFindings looks for the things that make images big or builds slow, and suggests a multi-stage rewrite.
+func efficiencyFindings(workingDir string, df *dockerfile) (findings []finding) {
+	if found, ok := dockerignoreFinding(workingDir, df); ok {
+		findings = append(findings, found)
+	}
+	for i := range df.stages {
+		if found, ok := cacheBustFinding(&df.stages[i]); ok {
+			findings = append(findings, found)
+		}
+		for j := range df.stages[i].instructions {
+			if found, ok := cacheMountFinding(&df.stages[i].instructions[j]); ok {
+				findings = append(findings, found)
+			}
+		}
+	}
+	singleStage, ok := singleStageFinding(df)
+	if ok {
+		findings = append(findings, singleStage)
+	}
+	// only suggest a rewrite when it fixes something
+	if len(findings) == 0 {
+		return findings
+	}
+	if language, template := rewriteTemplate(workingDir, df); template != "" {
+		findings = append(findings, finding{
+			rule:        ruleRewrite,
+			description: fmt.Sprintf("a multi-stage %s build that caches dependencies and ships a small runtime image", language),
+			template:    template,
+			helpURL:     multiStageURL,
+		})
+	}
+	return findings
+}
+
+// cacheBustFinding reports copying the whole build context before downloading dependencies, any change to the source
+// then downloads them again.
+func cacheBustFinding(st *stage) (found finding, ok bool) {
+	var copyAll *instruction
+	for i := range st.instructions {
+		inst := &st.instructions[i]
+		switch inst.command {
+		case "copy", "add":
+			if copyAll == nil && !inst.hasFlag("--from") && copiesContext(inst) {
+				copyAll = inst
+			}
+		case "run":
+			if !dependencyPattern.MatchString(inst.shellCommand()) {
+				continue
+			}
+			// dependencies installed before the source is copied are cached
+			if copyAll == nil {
+				return found, false
+			}
+			return finding{
+				rule:        ruleCacheBust,
+				description: "copy the dependency files (eg go.mod, package.json, pom.xml) and install the dependencies before copying the whole context, so they are only downloaded when they change",
+				line:        copyAll.line,
+				instruction: copyAll.original,
+				helpURL:     buildKitURL,
+			}, true
+		}
+	}
+	return found, false
+}
+
+// copiesContext returns true if the instruction copies the whole build context.
+func copiesContext(inst *instruction) bool {
+	if len(inst.args) < 2 { //nolint:gomnd
+		return false
+	}
+	for _, source := range inst.args[:len(inst.args)-1] {
+		if source == "." || source == "./" || source == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func cacheMountFinding(inst *instruction) (found finding, ok bool) {
+	if inst.command != "run" || inst.hasFlag("--mount=type=cache") {
+		return found, false
+	}
+	command := inst.shellCommand()
+	for _, tool := range []string{"go", "npm", "maven", "gradle"} {
+		if cacheMounts[tool].pattern.MatchString(command) {
+			return finding{
+				rule: ruleCacheMount,
+				description: fmt.Sprintf("use a buildkit cache mount to keep the %s cache between builds, 'RUN --mount=type=cache,target=%s'",
+					tool, cacheMounts[tool].target),
+				line:        inst.line,
+				instruction: inst.original,
+				helpURL:     buildKitURL,
+			}, true
+		}
+	}
+	return found, false
+}
+
+// singleStageFinding reports images that ship the compiler they were built with.
+func singleStageFinding(df *dockerfile) (found finding, ok bool) {
+	if len(df.stages) != 1 {
+		return found, false
+	}
+	st := &df.stages[0]
+	name, _, _ := splitImage(st.image)
+	shipsCompiler := slices.Contains(compilerImages, filepath.Base(name))
+	for i := range st.instructions {
+		if st.instructions[i].command == "run" && compilerPattern.MatchString(st.instructions[i].shellCommand()) {
+			shipsCompiler = true
+		}
+	}
+	if !shipsCompiler {
+		return found, false
+	}
+	return finding{
+		rule:        ruleSingleStage,
+		description: "the image ships the compiler it was built with, build in one stage and copy the output into a small runtime image",
+		line:        st.from.line,
+		instruction: st.from.original,
+		helpURL:     multiStageURL,
+	}, true
+}
+
+// goVendored is true for go modules that vendor their dependencies.
+func goVendored(workingDir string) bool {
+	for _, file := range []string{"go.mod", filepath.Join("vendor", "modules.txt")} {
+		if _, err := os.Stat(filepath.Join(workingDir, file)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// dockerignoreFinding reports big folders in the build context that are not in the .dockerignore file, buildkit
+// also reads '<Dockerfile>.dockerignore' next to the dockerfile.
+func dockerignoreFinding(workingDir string, df *dockerfile) (found finding, ok bool) {
+	var present []string
+	for _, folder := range ignoredFolders {
+		if folder == "vendor" && goVendored(workingDir) {
+			// go builds with -mod=vendor when the modules are vendored, the image needs the folder
+			continue
+		}
+		if info, err := os.Stat(filepath.Join(workingDir, folder)); err == nil && info.IsDir() {
+			present = append(present, folder)
+		}
+	}
+	if len(present) == 0 {
+		return found, false
+	}
+	var patterns []string
+	for _, location := range []string{df.path + dockerignoreFile, dockerignoreFile} {
+		file, err := os.Open(filepath.Join(workingDir, location))
+		if err != nil {
+			continue
+		}
+		patterns, err = dockerignore.ReadAll(file)
+		file.Close()
+		if err == nil {
+			break
+		}
+	}
+	var missing []string
+	for _, folder := range present {
+		if !ignored(patterns, folder) {
+			missing = append(missing, folder)
+		}
+	}
+	if len(missing) == 0 {
+		return found, false
+	}
+	return finding{
+		rule:        ruleIgnore,
+		description: fmt.Sprintf("add %s to .dockerignore, they are sent to the docker daemon on every build", strings.Join(missing, ", ")),
+		helpURL:     "https://docs.docker.com/engine/reference/builder/#dockerignore-file",
+	}, true
+}
+
+func ignored(patterns []string, folder string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.TrimPrefix(strings.TrimSuffix(pattern, "/**"), "**/")
+		if matched, err := filepath.Match(pattern, folder); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+package docker
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEfficiencyFindings(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  []string
+	}{
+		{
+			name: "efficient",
+			files: map[string]string{
+				"Dockerfile": `FROM golang:1.21 AS build
+COPY go.mod go.sum ./
+RUN --mount=type=cache,target=/go/pkg/mod go mod download
+COPY . .
+RUN --mount=type=cache,target=/go/pkg/mod go build -o /app .
+FROM scratch
+COPY --from=build /app /app
+`,
+				".git/HEAD":     "ref: refs/heads/main\n",
+				".dockerignore": ".git\n",
+			},
+		},
+		{
+			name: "single stage go",
+			files: map[string]string{
+				"Dockerfile": `FROM golang:1.21
+COPY . .
+RUN go build -o /app .
+`,
+				"node_modules/left-pad/index.js": "",
+				".git/HEAD":                      "ref: refs/heads/main\n",
+				".dockerignore":                  "**/.git\n",
+			},
+			want: []string{"copy-before-install@2", "multi-stage-rewrite@0", "no-cache-mount@3", "no-dockerignore@0", "single-stage-build@1"},
+		},
+		{
+			name: "compiler packages",
+			files: map[string]string{
+				"Dockerfile":   "FROM debian:12\nRUN apt-get install -y build-essential && make\n",
+				"package.json": "{}\n",
+			},
+			// the language of the rewrite comes from the files when the dockerfile does not say
+			want: []string{"multi-stage-rewrite@0", "single-stage-build@1"},
+		},
+	}
+	for _, test := range tests {
+		workingDir, df := testDockerfile(t, test.files)
+		if got := findingRules(efficiencyFindings(workingDir, df)); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got findings %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestDockerignoreFinding(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		missing string
+	}{
+		{
+			name:    "no dockerignore",
+			files:   map[string]string{"vendor/modules.txt": "", "node_modules/a.js": ""},
+			missing: "node_modules, vendor",
+		},
+		{
+			// go builds need a vendor folder when the modules are vendored
+			name:  "vendored go",
+			files: map[string]string{"go.mod": "module example.com/app\n", "vendor/modules.txt": ""},
+		},
+		{
+			// buildkit reads the dockerignore of the dockerfile first
+			name: "dockerfile dockerignore",
+			files: map[string]string{
+				"node_modules/a.js":       "",
+				"Dockerfile.dockerignore": "node_modules/**\n",
+				dockerignoreFile:          "dist\n",
+			},
+		},
+	}
+	for _, test := range tests {
+		test.files["Dockerfile"] = "FROM alpine:3.19\n"
+		workingDir, df := testDockerfile(t, test.files)
+		found, ok := dockerignoreFinding(workingDir, df)
+		if ok != (test.missing != "") || !strings.Contains(found.description, test.missing) {
+			t.Errorf("%s: got finding '%s', want the missing folders '%s'", test.name, found.description, test.missing)
+		}
+	}
+}
+
+func TestRewriteTemplate(t *testing.T) {
+	tests := []struct {
+		name       string
+		dockerfile string
+		language   string
+		contains   string
+	}{
+		{name: "go image", dockerfile: "FROM golang:1.22-alpine\nRUN go build .\n", language: "go", contains: "FROM golang:1.22-alpine AS build"},
+		{name: "node command", dockerfile: "FROM ubuntu:22.04\nRUN npm ci\n", language: "node", contains: "FROM node:20 AS build"},
+		// the runtime image has the java version of the build image
+		{name: "maven jdk", dockerfile: "FROM maven:3-eclipse-temurin-21\n", language: "maven", contains: "FROM eclipse-temurin:21-jre"},
+		{name: "gradle default", dockerfile: "FROM alpine:3.19\nRUN ./gradlew build\n", language: "gradle", contains: "FROM eclipse-temurin:17-jre"},
+		{name: "unknown", dockerfile: "FROM alpine:3.19\nRUN make\n"},
+	}
+	for _, test := range tests {
+		workingDir, df := testDockerfile(t, map[string]string{"Dockerfile": test.dockerfile})
+		language, template := rewriteTemplate(workingDir, df)
+		if language != test.language || !strings.Contains(template, test.contains) {
+			t.Errorf("%s: got language '%s' and template\n%s\nwant language '%s' and '%s'", test.name, language, template, test.language, test.contains)
+		}
+	}
+}
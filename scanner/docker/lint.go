@@ -0,0 +1,201 @@
+package docker
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	hadolintURL = "https://github.com/hadolint/hadolint/wiki/"
+	// rules that hadolint does not have
+	ruleHealthcheck = "no-healthcheck"
+	ruleSecret      = "secret-in-build"
+	ruleCurlPipe    = "curl-pipe-shell"
+)
+
+var (
+	aptInstallPattern = regexp.MustCompile(`apt-get\s+(-\S+\s+)*install`)
+	curlPipePattern   = regexp.MustCompile(`(curl|wget)\s[^|;&]*\|\s*(sudo\s+)?(ba|da|z)?sh\b`)
+	secretPattern     = regexp.MustCompile(`(?i)(passw(or)?d|secret|token|api_?key|access_?key|private_?key|credential)`)
+	archiveSuffixes   = []string{".tar", ".tar.gz", ".tgz", ".tar.bz2", ".tbz2", ".tar.xz", ".txz"}
+)
+
+type finding struct {
+	rule        string
+	description string
+	line        int
+	instruction string
+	helpURL     string
+	// template is a suggested dockerfile
+	template string
+}
+
+func hadolintFinding(rule, description string, inst *instruction) finding {
+	return finding{
+		rule:        rule,
+		description: description,
+		line:        inst.line,
+		instruction: inst.original,
+		helpURL:     hadolintURL + rule,
+	}
+}
+
+// lintDockerfile checks a dockerfile against the hadolint rules that matter most, and a few of our own.
+func lintDockerfile(df *dockerfile) (findings []finding) {
+	for i := range df.metaArgs {
+		findings = append(findings, secretFindings(&df.metaArgs[i])...)
+	}
+	for i := range df.stages {
+		st := &df.stages[i]
+		findings = append(findings, fromFindings(df, i)...)
+		final := i == len(df.stages)-1
+		for j := range st.instructions {
+			inst := &st.instructions[j]
+			switch inst.command {
+			case "run":
+				findings = append(findings, runFindings(inst, final)...)
+			case "add":
+				if addIsCopy(inst) {
+					findings = append(findings, hadolintFinding("DL3020", "use COPY instead of ADD for files and folders", inst))
+				}
+			case "env", "arg":
+				findings = append(findings, secretFindings(inst)...)
+			}
+		}
+	}
+	final := df.finalStage()
+	if final == nil {
+		return findings
+	}
+	if user := lastUser(df, len(df.stages)-1); user == nil {
+		findings = append(findings, hadolintFinding("DL3002", "there is no USER instruction, the container runs as root", &final.from))
+	} else if name, _, _ := strings.Cut(user.args[0], ":"); name == "root" || name == "0" {
+		findings = append(findings, hadolintFinding("DL3002", "the last USER should not be root", user))
+	}
+	if !hasInstruction(df, len(df.stages)-1, "healthcheck") {
+		findings = append(findings, finding{
+			rule:        ruleHealthcheck,
+			description: "there is no HEALTHCHECK, docker and compose can not tell when the container is ready or has stopped working",
+			line:        final.from.line,
+			instruction: final.from.original,
+			helpURL:     "https://docs.docker.com/engine/reference/builder/#healthcheck",
+		})
+	}
+	return findings
+}
+
+func fromFindings(df *dockerfile, index int) (findings []finding) {
+	st := &df.stages[index]
+	// unresolved args and stages are not images we can check
+	if st.image == "" || st.image == "scratch" || strings.Contains(st.image, "$") || df.isStage(st.image, index) {
+		return nil
+	}
+	_, tag, digest := splitImage(st.image)
+	switch {
+	case digest != "":
+	case tag == "":
+		findings = append(findings, hadolintFinding("DL3006", fmt.Sprintf("always tag the version of image '%s'", st.image), &st.from))
+	case tag == "latest":
+		findings = append(findings, hadolintFinding("DL3007", fmt.Sprintf("pin image '%s' to a version, latest changes without warning", st.image), &st.from))
+	}
+	return findings
+}
+
+func runFindings(inst *instruction, final bool) (findings []finding) {
+	command := inst.shellCommand()
+	if aptInstallPattern.MatchString(command) {
+		if !strings.Contains(command, "--no-install-recommends") {
+			findings = append(findings, hadolintFinding("DL3015", "use 'apt-get install --no-install-recommends' to avoid installing extra packages", inst))
+		}
+		// only the final stage is shipped, so the package lists only matter there
+		if final && !strings.Contains(command, "rm -rf /var/lib/apt/lists") && !inst.hasFlag("--mount=type=cache") {
+			findings = append(findings, hadolintFinding("DL3009", "delete the apt-get lists after installing, 'rm -rf /var/lib/apt/lists/*'", inst))
+		}
+	}
+	if curlPipePattern.MatchString(command) {
+		findings = append(findings, finding{
+			rule:        ruleCurlPipe,
+			description: "piping a download into a shell runs whatever the server returns, download the script, check its checksum then run it",
+			line:        inst.line,
+			instruction: inst.original,
+			helpURL:     "https://docs.docker.com/develop/develop-images/dockerfile_best-practices/#run",
+		})
+	}
+	return findings
+}
+
+// addIsCopy returns true if ADD is only used for local files, which COPY does more predictably.
+func addIsCopy(inst *instruction) bool {
+	if len(inst.args) < 2 { //nolint:gomnd
+		return false
+	}
+	for _, source := range inst.args[:len(inst.args)-1] {
+		if strings.Contains(source, "://") || strings.HasPrefix(source, "git@") {
+			return false
+		}
+		for _, suffix := range archiveSuffixes {
+			if strings.HasSuffix(source, suffix) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// secretFindings reports ENV and ARG values that look like secrets, they are kept in the image history.
+func secretFindings(inst *instruction) (findings []finding) {
+	var names []string
+	if inst.command == "env" {
+		// ENV is parsed into key value pairs
+		for i := 0; i < len(inst.args); i += 2 {
+			names = append(names, inst.args[i])
+		}
+	} else {
+		for _, arg := range inst.args {
+			name, _, _ := strings.Cut(arg, "=")
+			names = append(names, name)
+		}
+	}
+	for _, name := range names {
+		if secretPattern.MatchString(name) {
+			findings = append(findings, finding{
+				rule: ruleSecret,
+				description: fmt.Sprintf("%s '%s' looks like a secret, it is stored in the image, use 'RUN --mount=type=secret' instead",
+					strings.ToUpper(inst.command), name),
+				line:        inst.line,
+				instruction: inst.original,
+				helpURL:     "https://docs.docker.com/build/building/secrets/",
+			})
+		}
+	}
+	return findings
+}
+
+// lastUser returns the USER that applies at the end of a stage, following the stages it is built on.
+func lastUser(df *dockerfile, index int) *instruction {
+	st := &df.stages[index]
+	for i := len(st.instructions) - 1; i >= 0; i-- {
+		if st.instructions[i].command == "user" && len(st.instructions[i].args) > 0 {
+			return &st.instructions[i]
+		}
+	}
+	if parent := df.stageIndex(st.image, index); parent >= 0 {
+		return lastUser(df, parent)
+	}
+	return nil
+}
+
+// hasInstruction returns true if a stage, or a stage it is built on, has the instruction.
+func hasInstruction(df *dockerfile, index int, command string) bool {
+	st := &df.stages[index]
+	for i := range st.instructions {
+		if st.instructions[i].command == command {
+			return true
+		}
+	}
+	if parent := df.stageIndex(st.image, index); parent >= 0 {
+		return hasInstruction(df, parent, command)
+	}
+	return false
+}
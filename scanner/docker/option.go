@@ -1,6 +1,9 @@
 package docker
 
-import "golang.org/x/exp/slices"
+import (
+	"github.com/tphoney/best_practice/scanner/dronescanner"
+	"golang.org/x/exp/slices"
+)
 
 type Option func(*scannerConfig)
 
@@ -27,3 +30,31 @@ func WithWorkingDirectory(i string) Option {
 		p.workingDirectory = i
 	}
 }
+
+// WithDroneContext sets the build and repository values used to evaluate starlark and jsonnet drone files.
+func WithDroneContext(i *dronescanner.ConfigContext) Option {
+	return func(p *scannerConfig) {
+		p.droneContext = i
+	}
+}
+
+// WithRegistry sets the registry and credentials used to look for newer image tags.
+func WithRegistry(i RegistryConfig) Option {
+	return func(p *scannerConfig) {
+		p.registryConfig = i
+	}
+}
+
+// WithOffline skips the checks that need the network, cached registry tags are still used.
+func WithOffline(i bool) Option {
+	return func(p *scannerConfig) {
+		p.offline = i
+	}
+}
+
+// WithDigestPinning recommends pinning images to the digest of their tag.
+func WithDigestPinning(i bool) Option {
+	return func(p *scannerConfig) {
+		p.pinDigests = i
+	}
+}
@@ -0,0 +1,163 @@
+package docker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/tphoney/best_practice/outputter/buildmaker"
+)
+
+const (
+	manifestTemplate = "manifest.tmpl"
+	manifestURL      = "https://plugins.drone.io/plugins/manifest"
+)
+
+// platformPattern matches dockerfiles named after their platform, eg 'Dockerfile.linux.arm64' or
+// 'Dockerfile.linux.arm.v7'.
+var platformPattern = regexp.MustCompile(`^Dockerfile\.(linux)\.(amd64|arm64|arm|386|ppc64le|s390x|riscv64)(?:\.(v\d+))?$`)
+
+type (
+	// platformImage is an image that is built for several platforms, from a dockerfile for each platform.
+	platformImage struct {
+		dir    string
+		builds []platformBuild
+		// manifest is the path of the manifest template, it is empty if there is none
+		manifest string
+	}
+
+	platformBuild struct {
+		dockerfile string
+		platform   buildmaker.Platform
+		// binaries are the release binaries the image copies in
+		binaries []string
+	}
+)
+
+// releaseFolder is where the build steps write the binaries that the platform images copy in.
+const releaseFolder = "release/"
+
+// tagSuffix is the tag suffix of the image for a platform, it matches the 'OS-ARCHVARIANT' manifest template.
+func (pb *platformBuild) tagSuffix() string {
+	return fmt.Sprintf("%s-%s%s", pb.platform.OS, pb.platform.Arch, pb.platform.Variant)
+}
+
+// releaseBinaries returns the release binaries the final stage of a dockerfile copies in from the build context.
+func releaseBinaries(workingDir, dockerFile string) (binaries []string) {
+	df, err := parseDockerfile(workingDir, dockerFile)
+	if err != nil || df.finalStage() == nil {
+		return nil
+	}
+	for _, inst := range df.finalStage().instructions {
+		if (inst.command != "copy" && inst.command != "add") || len(inst.args) < 2 || copiesFromStage(&inst) { //nolint:gomnd
+			continue
+		}
+		for _, source := range inst.args[:len(inst.args)-1] {
+			if source = strings.TrimPrefix(source, "./"); strings.HasPrefix(source, releaseFolder) {
+				binaries = append(binaries, source)
+			}
+		}
+	}
+	return binaries
+}
+
+func copiesFromStage(inst *instruction) bool {
+	for _, flag := range inst.flags {
+		if strings.HasPrefix(flag, "--from=") {
+			return true
+		}
+	}
+	return false
+}
+
+// platformImages groups the dockerfiles of each folder that are named after a platform. A plain 'Dockerfile' next to
+// them is the linux/amd64 build. The other dockerfiles are returned as they are.
+func platformImages(workingDir string, dockerFiles []string) (images []platformImage, others []string) {
+	var dirs []string
+	byDir := map[string][]string{}
+	for _, dockerFile := range dockerFiles {
+		dir := filepath.Dir(dockerFile)
+		if _, found := byDir[dir]; !found {
+			dirs = append(dirs, dir)
+		}
+		byDir[dir] = append(byDir[dir], dockerFile)
+	}
+	for _, dir := range dirs {
+		img := platformImage{dir: dir}
+		var plain string
+		var rest []string
+		hasAmd64 := false
+		for _, dockerFile := range byDir[dir] {
+			match := platformPattern.FindStringSubmatch(filepath.Base(dockerFile))
+			switch {
+			case match != nil:
+				img.builds = append(img.builds, platformBuild{
+					dockerfile: dockerFile,
+					platform:   buildmaker.Platform{OS: match[1], Arch: match[2], Variant: match[3]},
+				})
+				hasAmd64 = hasAmd64 || match[2] == "amd64"
+			case filepath.Base(dockerFile) == "Dockerfile" && plain == "":
+				plain = dockerFile
+			default:
+				rest = append(rest, dockerFile)
+			}
+		}
+		if plain != "" && !hasAmd64 {
+			img.builds = append([]platformBuild{{dockerfile: plain, platform: buildmaker.Platform{OS: "linux", Arch: "amd64"}}}, img.builds...)
+			plain = ""
+		}
+		if _, err := os.Stat(filepath.Join(workingDir, dir, manifestTemplate)); err == nil {
+			img.manifest = filepath.Join(dir, manifestTemplate)
+		}
+		if len(img.builds) < 2 && (img.manifest == "" || len(img.builds) == 0) { //nolint:gomnd
+			// a single platform is a normal build
+			others = append(others, byDir[dir]...)
+			continue
+		}
+		if plain != "" {
+			rest = append(rest, plain)
+		}
+		for i := range img.builds {
+			img.builds[i].binaries = releaseBinaries(workingDir, img.builds[i].dockerfile)
+		}
+		images = append(images, img)
+		others = append(others, rest...)
+	}
+	return images, others
+}
+
+// manifestBuild joins the images of every platform into one multi platform image, with the manifest template if
+// there is one.
+func (img *platformImage) manifestBuild() buildmaker.Build {
+	settings := map[string]interface{}{
+		"auto_tag":       true,
+		"ignore_missing": true,
+		"username":       buildmaker.Secret("docker_username"),
+		"password":       buildmaker.Secret("docker_password"),
+	}
+	if img.manifest != "" {
+		settings["spec"] = img.manifest
+	} else {
+		platforms := make([]string, 0, len(img.builds))
+		for i := range img.builds {
+			platforms = append(platforms, img.builds[i].platform.String())
+		}
+		settings["target"] = "organization/docker-image-name:latest"
+		settings["template"] = "organization/docker-image-name:OS-ARCHVARIANT"
+		settings["platforms"] = platforms
+	}
+	return buildmaker.Build{
+		Name:     fmt.Sprintf("docker manifest %s", img.dir),
+		Phase:    buildmaker.PhaseManifest,
+		Image:    "plugins/manifest",
+		Settings: settings,
+		When:     pushEvents(),
+	}
+}
+
+// pushEvents are the events the platform images are pushed on, the manifest joins the pushed images.
+func pushEvents() *buildmaker.Condition {
+	return &buildmaker.Condition{Event: []string{"push", "tag"}}
+}
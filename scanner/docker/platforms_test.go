@@ -0,0 +1,141 @@
+package docker
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/tphoney/best_practice/outputter/buildmaker"
+)
+
+const testPlatformDockerfile = `FROM alpine:3
+COPY --from=builder /etc/ssl/certs /etc/ssl/certs
+COPY ./release/linux/arm/plugin /bin/
+ENTRYPOINT ["/bin/plugin"]
+`
+
+// writeFiles writes the files into a new working directory.
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	workingDir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(workingDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return workingDir
+}
+
+func TestPlatformImages(t *testing.T) {
+	tests := []struct {
+		files     map[string]string
+		platforms []string
+		manifest  string
+		others    []string
+	}{
+		{
+			files: map[string]string{
+				"docker/Dockerfile.linux.amd64":  "FROM alpine:3\n",
+				"docker/Dockerfile.linux.arm64":  "FROM alpine:3\n",
+				"docker/Dockerfile.linux.arm.v7": "FROM alpine:3\n",
+				"docker/manifest.tmpl":           "",
+			},
+			platforms: []string{"linux/amd64", "linux/arm/v7", "linux/arm64"},
+			manifest:  "docker/manifest.tmpl",
+		},
+		// a plain dockerfile next to the platform dockerfiles is the linux/amd64 build
+		{
+			files:     map[string]string{"Dockerfile": "FROM alpine:3\n", "Dockerfile.linux.arm64": "FROM alpine:3\n"},
+			platforms: []string{"linux/amd64", "linux/arm64"},
+		},
+		{
+			files: map[string]string{
+				"Dockerfile": "FROM alpine:3\n", "Dockerfile.linux.amd64": "FROM alpine:3\n", "Dockerfile.linux.arm64": "FROM alpine:3\n",
+			},
+			platforms: []string{"linux/amd64", "linux/arm64"},
+			others:    []string{"Dockerfile"},
+		},
+		// a single platform is a normal build, unless it has a manifest
+		{
+			files:  map[string]string{"Dockerfile.linux.arm64": "FROM alpine:3\n"},
+			others: []string{"Dockerfile.linux.arm64"},
+		},
+		{
+			files:     map[string]string{"Dockerfile.linux.arm64": "FROM alpine:3\n", "manifest.tmpl": ""},
+			platforms: []string{"linux/arm64"},
+			manifest:  "manifest.tmpl",
+		},
+		// the suffix has to be a known platform
+		{
+			files:  map[string]string{"Dockerfile.linux.mips": "FROM alpine:3\n", "Dockerfile.dev": "FROM alpine:3\n"},
+			others: []string{"Dockerfile.dev", "Dockerfile.linux.mips"},
+		},
+	}
+	for _, test := range tests {
+		workingDir := writeFiles(t, test.files)
+		var dockerFiles []string
+		for name := range test.files {
+			if name != manifestTemplate && filepath.Base(name) != manifestTemplate {
+				dockerFiles = append(dockerFiles, name)
+			}
+		}
+		// the scanner finds the dockerfiles in walk order
+		sort.Strings(dockerFiles)
+		images, others := platformImages(workingDir, dockerFiles)
+		var platforms []string
+		manifest := ""
+		for i := range images {
+			for j := range images[i].builds {
+				platforms = append(platforms, images[i].builds[j].platform.String())
+			}
+			manifest = images[i].manifest
+		}
+		if !reflect.DeepEqual(platforms, test.platforms) || manifest != test.manifest || !reflect.DeepEqual(others, test.others) {
+			t.Errorf("platformImages(%v) = %v '%s' %v, want %v '%s' %v", dockerFiles, platforms, manifest, others, test.platforms, test.manifest, test.others)
+		}
+	}
+}
+
+func TestPlatformBuildResults(t *testing.T) {
+	workingDir := writeFiles(t, map[string]string{
+		"Dockerfile.linux.amd64":  "FROM alpine:3\nCOPY release/linux/amd64/plugin /bin/\n",
+		"Dockerfile.linux.arm.v7": testPlatformDockerfile,
+	})
+	sc := &scannerConfig{workingDirectory: workingDir}
+	results := sc.buildCheck([]string{"Dockerfile.linux.amd64", "Dockerfile.linux.arm.v7"})
+	if len(results) != 3 { //nolint:gomnd
+		t.Fatalf("got %d results, want 3", len(results))
+	}
+	tests := []struct {
+		suffix   string
+		binaries []string
+	}{
+		{suffix: "linux-amd64", binaries: []string{"release/linux/amd64/plugin"}},
+		// the certificates are copied from a stage, not the build context
+		{suffix: "linux-armv7", binaries: []string{"release/linux/arm/plugin"}},
+	}
+	for i, test := range tests {
+		build := results[i].Spec.(buildmaker.OutputFields).Build
+		if suffix := build.Settings["auto_tag_suffix"]; suffix != test.suffix {
+			t.Errorf("build '%s' is tagged '%v', want '%s'", build.Name, suffix, test.suffix)
+		}
+		if !reflect.DeepEqual(build.Binaries, test.binaries) {
+			t.Errorf("build '%s' copies in %v, want %v", build.Name, build.Binaries, test.binaries)
+		}
+		// the manifest joins the pushed images
+		if _, dryRun := build.Settings["dry_run"]; dryRun || build.When == nil {
+			t.Errorf("build '%s' does not push on %+v", build.Name, build.When)
+		}
+	}
+	manifest := results[2].Spec.(buildmaker.OutputFields).Build
+	want := []string{"linux/amd64", "linux/arm/v7"}
+	if manifest.Phase != buildmaker.PhaseManifest || !reflect.DeepEqual(manifest.Settings["platforms"], want) {
+		t.Errorf("got manifest %+v, want platforms %v", manifest, want)
+	}
+}
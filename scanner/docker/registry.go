@@ -0,0 +1,273 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	dockerHubHost          = "docker.io"
+	dockerHubRegistry      = "https://registry-1.docker.io"
+	defaultRegistryTimeout = 30 * time.Second
+	// maxTagPages stops a registry that keeps returning next links
+	maxTagPages = 100
+	tagPageSize = 1000
+	// manifestTypes prefers the image index, so the digest works on every platform
+	manifestTypes = "application/vnd.oci.image.index.v1+json, application/vnd.docker.distribution.manifest.list.v2+json, " +
+		"application/vnd.oci.image.manifest.v1+json, application/vnd.docker.distribution.manifest.v2+json"
+)
+
+var (
+	linkPattern      = regexp.MustCompile(`<([^>]+)>\s*;\s*rel="?next"?`)
+	challengePattern = regexp.MustCompile(`(\w+)="([^"]*)"`)
+)
+
+type (
+	// RegistryConfig sets where image tags are looked up.
+	RegistryConfig struct {
+		// URL replaces Docker Hub for images without a registry host, eg a mirror or a local registry.
+		URL      string
+		Username string
+		Password string
+		Timeout  time.Duration
+		// Cache keeps the tags between runs in CachePath, which defaults to the user cache folder. Without it the tags
+		// are only cached for the run, and setting CachePath turns it on.
+		Cache     bool
+		CachePath string
+		CacheTTL  time.Duration
+		// CachePreload is a yaml file of image tags that is added to the cache, for runners without network access.
+		CachePreload string
+	}
+
+	// registryClient lists tags with the OCI distribution v2 API, it works with Docker Hub and private registries.
+	registryClient struct {
+		config RegistryConfig
+		client *http.Client
+		// tokens are the bearer tokens for each registry and repository
+		tokens map[string]string
+	}
+
+	tagList struct {
+		Name string   `json:"name"`
+		Tags []string `json:"tags"`
+	}
+
+	tokenResponse struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+)
+
+func newRegistryClient(config RegistryConfig) *registryClient {
+	if config.Timeout == 0 {
+		config.Timeout = defaultRegistryTimeout
+	}
+	return &registryClient{
+		config: config,
+		client: &http.Client{Timeout: config.Timeout},
+		tokens: map[string]string{},
+	}
+}
+
+// repository returns the registry url and repository path of an image name, eg 'golang' is 'library/golang' on
+// Docker Hub.
+func (rc *registryClient) repository(name string) (registry, repository string) {
+	host, path, found := strings.Cut(name, "/")
+	if !found || (!strings.ContainsAny(host, ".:") && host != "localhost") {
+		host, path = dockerHubHost, name
+	}
+	if host == dockerHubHost && !strings.Contains(path, "/") {
+		path = "library/" + path
+	}
+	switch {
+	case host == dockerHubHost && rc.config.URL != "":
+		registry = strings.TrimSuffix(rc.config.URL, "/")
+	case host == dockerHubHost:
+		registry = dockerHubRegistry
+	case strings.HasPrefix(host, "localhost") || strings.HasPrefix(host, "127.0.0.1"):
+		registry = "http://" + host
+	default:
+		registry = "https://" + host
+	}
+	return registry, path
+}
+
+// cacheKey is the same for every way of writing an image name, eg 'golang' and 'docker.io/library/golang'.
+func (rc *registryClient) cacheKey(name string) string {
+	registry, repository := rc.repository(name)
+	return registry + "/" + repository
+}
+
+// listTags returns every tag of an image, following the pagination links of the registry.
+func (rc *registryClient) listTags(ctx context.Context, name string) (tags []string, err error) {
+	registry, repository := rc.repository(name)
+	next := fmt.Sprintf("%s/v2/%s/tags/list?n=%d", registry, repository, tagPageSize)
+	for page := 0; next != "" && page < maxTagPages; page++ {
+		resp, err := rc.get(ctx, http.MethodGet, next, "application/json", registry, repository)
+		if err != nil {
+			return nil, err
+		}
+		var list tagList
+		err = json.NewDecoder(resp.Body).Decode(&list)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error reading tags of '%s': %s", name, err)
+		}
+		tags = append(tags, list.Tags...)
+		next = ""
+		if match := linkPattern.FindStringSubmatch(resp.Header.Get("Link")); match != nil {
+			link, err := resp.Request.URL.Parse(match[1])
+			if err != nil {
+				return nil, err
+			}
+			next = link.String()
+		}
+	}
+	return tags, nil
+}
+
+// digest returns the digest of a tag, for multi platform images it is the digest of the image index.
+func (rc *registryClient) digest(ctx context.Context, name, tag string) (string, error) {
+	registry, repository := rc.repository(name)
+	location := fmt.Sprintf("%s/v2/%s/manifests/%s", registry, repository, tag)
+	resp, err := rc.get(ctx, http.MethodHead, location, manifestTypes, registry, repository)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return "", fmt.Errorf("registry did not return a digest for '%s:%s'", name, tag)
+	}
+	return digest, nil
+}
+
+// get makes a request to the registry, answering an authentication challenge if there is one.
+func (rc *registryClient) get(ctx context.Context, method, location, accept, registry, repository string) (*http.Response, error) {
+	tokenKey := registry + "/" + repository
+	resp, err := rc.do(ctx, method, location, accept, rc.tokens[tokenKey])
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		authorization, err := rc.authorize(ctx, challenge, registry, repository)
+		if err != nil {
+			return nil, err
+		}
+		rc.tokens[tokenKey] = authorization
+		if resp, err = rc.do(ctx, method, location, accept, authorization); err != nil {
+			return nil, err
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("registry returned '%s' for '%s'", resp.Status, location)
+	}
+	return resp, nil
+}
+
+func (rc *registryClient) do(ctx context.Context, method, location, accept, authorization string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, location, http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	return rc.client.Do(req)
+}
+
+// hasCredentials is true when the configured credentials belong to the registry, they are for the registry in URL or
+// for Docker Hub when there is none. Other registries get anonymous requests.
+func (rc *registryClient) hasCredentials(registry string) bool {
+	if rc.config.Username == "" {
+		return false
+	}
+	configured := dockerHubRegistry
+	if rc.config.URL != "" {
+		configured = rc.config.URL
+	}
+	return registryHost(configured) == registryHost(registry)
+}
+
+// registryHost is the host and port of a registry url, the url may leave out the scheme.
+func registryHost(registry string) string {
+	if !strings.Contains(registry, "://") {
+		registry = "https://" + registry
+	}
+	parsed, err := url.Parse(registry)
+	if err != nil {
+		return registry
+	}
+	return strings.ToLower(parsed.Host)
+}
+
+// authorize returns the authorization header for a 'WWW-Authenticate' challenge, registries either ask for basic
+// auth or for a bearer token from their token service.
+func (rc *registryClient) authorize(ctx context.Context, challenge, registry, repository string) (string, error) {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	credentials := rc.hasCredentials(registry)
+	if strings.EqualFold(scheme, "basic") {
+		if !credentials {
+			return "", fmt.Errorf("registry needs credentials for '%s'", repository)
+		}
+		req, _ := http.NewRequest(http.MethodGet, "/", http.NoBody)
+		req.SetBasicAuth(rc.config.Username, rc.config.Password)
+		return req.Header.Get("Authorization"), nil
+	}
+	if !strings.EqualFold(scheme, "bearer") {
+		return "", fmt.Errorf("unsupported registry authentication '%s'", challenge)
+	}
+	values := map[string]string{}
+	for _, match := range challengePattern.FindAllStringSubmatch(params, -1) {
+		values[match[1]] = match[2]
+	}
+	realm, err := url.Parse(values["realm"])
+	if err != nil || values["realm"] == "" {
+		return "", fmt.Errorf("registry authentication has no realm '%s'", challenge)
+	}
+	query := realm.Query()
+	if values["service"] != "" {
+		query.Set("service", values["service"])
+	}
+	scope := values["scope"]
+	if scope == "" {
+		scope = fmt.Sprintf("repository:%s:pull", repository)
+	}
+	query.Set("scope", scope)
+	realm.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), http.NoBody)
+	if err != nil {
+		return "", err
+	}
+	if credentials {
+		req.SetBasicAuth(rc.config.Username, rc.config.Password)
+	}
+	resp, err := rc.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512)) //nolint:gomnd
+		return "", fmt.Errorf("registry token request failed '%s' %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var token tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	return "Bearer " + token.Token, nil
+}
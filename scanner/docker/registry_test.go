@@ -0,0 +1,136 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testRegistry serves the tags of a single repository, pages holds the tags of each page.
+func testRegistry(t *testing.T, repository string, pages [][]string, authorized func(r *http.Request) bool, challenge string) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/"+repository+"/tags/list", func(w http.ResponseWriter, r *http.Request) {
+		if authorized != nil && !authorized(r) {
+			w.Header().Set("WWW-Authenticate", strings.ReplaceAll(challenge, "{host}", "http://"+r.Host))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		page := 0
+		if r.URL.Query().Get("last") != "" {
+			page = 1
+		}
+		if page+1 < len(pages) {
+			w.Header().Set("Link", "</v2/"+repository+"/tags/list?n=1000&last=x>; rel=\"next\"")
+		}
+		_ = json.NewEncoder(w).Encode(tagList{Name: repository, Tags: pages[page]})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query().Get("scope"); got != "repository:"+repository+":pull" {
+			t.Errorf("token scope is '%s'", got)
+		}
+		if _, _, ok := r.BasicAuth(); ok {
+			t.Errorf("token request for '%s' has credentials", r.Host)
+		}
+		_ = json.NewEncoder(w).Encode(tokenResponse{Token: "secret-token"})
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func imageName(server *httptest.Server, repository string) string {
+	return strings.TrimPrefix(server.URL, "http://") + "/" + repository
+}
+
+func TestListTagsTokenChallenge(t *testing.T) {
+	server := testRegistry(t, "team/app", [][]string{{"1.0", "1.1"}}, func(r *http.Request) bool {
+		return r.Header.Get("Authorization") == "Bearer secret-token"
+	}, `Bearer realm="{host}/token",service="test"`)
+	client := newRegistryClient(RegistryConfig{})
+	tags, err := client.listTags(context.Background(), imageName(server, "team/app"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tags, []string{"1.0", "1.1"}) {
+		t.Errorf("got tags %v", tags)
+	}
+}
+
+func TestListTagsPagination(t *testing.T) {
+	server := testRegistry(t, "team/app", [][]string{{"1.0", "1.1"}, {"1.2"}}, nil, "")
+	client := newRegistryClient(RegistryConfig{})
+	tags, err := client.listTags(context.Background(), imageName(server, "team/app"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tags, []string{"1.0", "1.1", "1.2"}) {
+		t.Errorf("got tags %v", tags)
+	}
+}
+
+func TestListTagsBasicAuth(t *testing.T) {
+	server := testRegistry(t, "library/golang", [][]string{{"1.21"}}, func(r *http.Request) bool {
+		username, password, ok := r.BasicAuth()
+		return ok && username == "user" && password == "pass"
+	}, `Basic realm="registry"`)
+	// images without a host are looked up on the configured registry
+	client := newRegistryClient(RegistryConfig{URL: server.URL, Username: "user", Password: "pass"})
+	tags, err := client.listTags(context.Background(), "golang")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tags, []string{"1.21"}) {
+		t.Errorf("got tags %v", tags)
+	}
+}
+
+func TestListTagsUnauthorizedWithoutCredentials(t *testing.T) {
+	server := testRegistry(t, "team/app", [][]string{{"1.0"}}, func(r *http.Request) bool {
+		return false
+	}, `Basic realm="registry"`)
+	client := newRegistryClient(RegistryConfig{})
+	_, err := client.listTags(context.Background(), imageName(server, "team/app"))
+	if err == nil || !strings.Contains(err.Error(), "needs credentials") {
+		t.Errorf("expected a credentials error, got %v", err)
+	}
+}
+
+func TestCredentialsOnlyForConfiguredRegistry(t *testing.T) {
+	// the token handler fails the test if it is sent credentials
+	server := testRegistry(t, "team/app", [][]string{{"1.0"}}, func(r *http.Request) bool {
+		return r.Header.Get("Authorization") == "Bearer secret-token"
+	}, `Bearer realm="{host}/token",service="test"`)
+	client := newRegistryClient(RegistryConfig{URL: "https://registry.internal", Username: "user", Password: "pass"})
+	if _, err := client.listTags(context.Background(), imageName(server, "team/app")); err != nil {
+		t.Fatal(err)
+	}
+	if !client.hasCredentials("https://registry.internal") {
+		t.Error("expected credentials for the configured registry")
+	}
+	if client.hasCredentials(dockerHubRegistry) {
+		t.Error("expected no credentials for docker hub when another registry is configured")
+	}
+}
+
+func TestNewerTagSkipsNonSemverTags(t *testing.T) {
+	tags := []string{"latest", "bookworm", "1.20", "1.21", "1.21-alpine", "1.22rc1", "nightly-2024"}
+	tests := []struct {
+		current string
+		want    string
+	}{
+		{current: "1.20", want: "1.21"},
+		{current: "1.20-alpine", want: "1.21-alpine"},
+		{current: "latest", want: ""},
+		{current: "bookworm", want: ""},
+	}
+	for _, test := range tests {
+		if got := newerTag(test.current, tags); got != test.want {
+			t.Errorf("newerTag(%s) = '%s', want '%s'", test.current, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package docker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+const (
+	goTemplate = `# syntax=docker/dockerfile:1
+FROM %s AS build
+WORKDIR /src
+COPY go.mod go.sum ./
+RUN --mount=type=cache,target=/go/pkg/mod go mod download
+COPY . .
+RUN --mount=type=cache,target=/go/pkg/mod --mount=type=cache,target=/root/.cache/go-build \
+    CGO_ENABLED=0 go build -o /out/app .
+
+FROM gcr.io/distroless/static-debian12:nonroot
+COPY --from=build /out/app /app
+USER nonroot
+ENTRYPOINT ["/app"]
+`
+	nodeTemplate = `# syntax=docker/dockerfile:1
+FROM %[1]s AS build
+WORKDIR /app
+COPY package.json package-lock.json ./
+RUN --mount=type=cache,target=/root/.npm npm ci
+COPY . .
+RUN npm run build && npm prune --omit=dev
+
+FROM %[1]s
+ENV NODE_ENV=production
+WORKDIR /app
+COPY --from=build /app/package.json ./
+COPY --from=build /app/node_modules ./node_modules
+COPY --from=build /app/dist ./dist
+USER node
+CMD ["node", "dist/index.js"]
+`
+	mavenTemplate = `# syntax=docker/dockerfile:1
+FROM %s AS build
+WORKDIR /src
+COPY pom.xml ./
+RUN --mount=type=cache,target=/root/.m2 mvn -B dependency:go-offline
+COPY src ./src
+RUN --mount=type=cache,target=/root/.m2 mvn -B package -DskipTests
+
+FROM eclipse-temurin:%s-jre
+COPY --from=build /src/target/*.jar /app/app.jar
+USER 1000
+ENTRYPOINT ["java", "-jar", "/app/app.jar"]
+`
+	gradleTemplate = `# syntax=docker/dockerfile:1
+FROM %s AS build
+WORKDIR /src
+COPY settings.gradle* build.gradle* ./
+RUN --mount=type=cache,target=/root/.gradle gradle dependencies --no-daemon
+COPY src ./src
+RUN --mount=type=cache,target=/root/.gradle gradle build -x test --no-daemon
+
+FROM eclipse-temurin:%s-jre
+COPY --from=build /src/build/libs/*.jar /app/app.jar
+USER 1000
+ENTRYPOINT ["java", "-jar", "/app/app.jar"]
+`
+	defaultJavaVersion = "17"
+)
+
+type rewrite struct {
+	language string
+	// image is the build image used when the dockerfile does not have one
+	image    string
+	template string
+	// files show the language is used when the dockerfile does not say
+	files   []string
+	command *regexp.Regexp
+}
+
+var (
+	rewrites = []rewrite{
+		{"go", "golang:1.21", goTemplate, []string{"go.mod"}, regexp.MustCompile(`\bgo (build|mod)\b`)},
+		{"node", "node:20", nodeTemplate, []string{"package.json"}, regexp.MustCompile(`\b(npm|yarn)\b`)},
+		{"maven", "maven:3-eclipse-temurin-" + defaultJavaVersion, mavenTemplate, []string{"pom.xml"}, regexp.MustCompile(`\bmvnw?\b`)},
+		{"gradle", "gradle:jdk" + defaultJavaVersion, gradleTemplate, []string{"build.gradle", "build.gradle.kts"}, regexp.MustCompile(`\bgradlew?\b`)},
+	}
+	// imageLanguages maps the official build images to their language
+	imageLanguages = map[string]string{"golang": "go", "node": "node", "maven": "maven", "gradle": "gradle"}
+	jdkPattern     = regexp.MustCompile(`(?:temurin-|jdk-?)(\d+)`)
+)
+
+// rewriteTemplate returns a multi-stage dockerfile for the language the dockerfile builds, it uses the build image of
+// the dockerfile so the version stays the same.
+func rewriteTemplate(workingDir string, df *dockerfile) (language, template string) {
+	image := ""
+	for i := range df.stages {
+		name, _, _ := splitImage(df.stages[i].image)
+		if lang, ok := imageLanguages[filepath.Base(name)]; ok {
+			language, image = lang, df.stages[i].image
+			break
+		}
+	}
+	if language == "" {
+		language = commandLanguage(df)
+	}
+	if language == "" {
+		language = fileLanguage(workingDir)
+	}
+	for i := range rewrites {
+		if rewrites[i].language != language {
+			continue
+		}
+		if image == "" {
+			image = rewrites[i].image
+		}
+		switch language {
+		case "maven", "gradle":
+			javaVersion := defaultJavaVersion
+			if match := jdkPattern.FindStringSubmatch(image); match != nil {
+				javaVersion = match[1]
+			}
+			return language, fmt.Sprintf(rewrites[i].template, image, javaVersion)
+		default:
+			return language, fmt.Sprintf(rewrites[i].template, image)
+		}
+	}
+	return "", ""
+}
+
+func commandLanguage(df *dockerfile) string {
+	for i := range df.stages {
+		for j := range df.stages[i].instructions {
+			command := df.stages[i].instructions[j].shellCommand()
+			for k := range rewrites {
+				if df.stages[i].instructions[j].command == "run" && rewrites[k].command.MatchString(command) {
+					return rewrites[k].language
+				}
+			}
+		}
+	}
+	return ""
+}
+
+func fileLanguage(workingDir string) string {
+	for i := range rewrites {
+		for _, file := range rewrites[i].files {
+			if _, err := os.Stat(filepath.Join(workingDir, file)); err == nil {
+				return rewrites[i].language
+			}
+		}
+	}
+	return ""
+}
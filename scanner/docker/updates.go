@@ -0,0 +1,281 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Masterminds/semver"
+	"github.com/tphoney/best_practice/outputter"
+	"github.com/tphoney/best_practice/outputter/dockerfileanalysis"
+	"github.com/tphoney/best_practice/scanner"
+	"github.com/tphoney/best_practice/scanner/compose"
+	"github.com/tphoney/best_practice/types"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	ruleImageUpdate = "image-update"
+	rulePinDigest   = "pin-digest"
+	pullURL         = "https://docs.docker.com/engine/reference/commandline/pull/"
+	pullDigestURL   = pullURL + "#pull-an-image-by-digest-immutable-identifier"
+)
+
+var (
+	// tagPattern splits a tag into its version and variant, eg '1.21-alpine'
+	tagPattern = regexp.MustCompile(`^v?(\d+(?:\.\d+){0,2})(?:-(.+))?$`)
+	// alpinePattern matches variants that include the alpine release, eg 'alpine3.18'
+	alpinePattern = regexp.MustCompile(`^(.*alpine)\d+(\.\d+)*$`)
+	errOffline    = errors.New("offline")
+)
+
+type (
+	image struct {
+		image        string
+		updatedImage string
+		// digest is set when images are pinned to digests
+		digest   string
+		stepName string
+		// file, line and instruction are where the image is in a dockerfile or compose file
+		file        string
+		line        int
+		instruction string
+	}
+
+	tagVersion struct {
+		version *semver.Version
+		// parts is the precision of the tag, '1.21' has 2 parts
+		parts   int
+		variant string
+		// release is the variant without its release, eg 'alpine' for 'alpine3.18', it is empty for other variants
+		release string
+	}
+)
+
+// recommended returns the image to use, it is empty if the image does not need to change.
+func (img *image) recommended() string {
+	ref := img.image
+	if img.updatedImage != "" {
+		ref = img.updatedImage
+	}
+	if img.digest != "" {
+		ref += "@" + img.digest
+	}
+	if ref == img.image {
+		return ""
+	}
+	return ref
+}
+
+// change describes what should change about the image.
+func (img *image) change() string {
+	if img.updatedImage != "" {
+		return fmt.Sprintf("update image from %s to %s", img.image, img.recommended())
+	}
+	return fmt.Sprintf("pin image %s to %s", img.image, img.recommended())
+}
+
+func parseTag(tag string) (tv tagVersion, ok bool) {
+	match := tagPattern.FindStringSubmatch(tag)
+	if match == nil {
+		return tv, false
+	}
+	version, err := scanner.ReturnVersionObject(match[1])
+	if err != nil {
+		return tv, false
+	}
+	tv = tagVersion{
+		version: version,
+		parts:   strings.Count(match[1], ".") + 1,
+		variant: match[2],
+	}
+	if release := alpinePattern.FindStringSubmatch(match[2]); release != nil {
+		tv.release = release[1]
+	}
+	return tv, true
+}
+
+// sameVariant returns true if the tags have the same variant, a variant with an alpine release can move to a newer
+// release.
+func (tv *tagVersion) sameVariant(other *tagVersion) bool {
+	return tv.variant == other.variant || (tv.release != "" && tv.release == other.release)
+}
+
+// newerTag returns the newest tag with the same variant and precision, eg '1.20-alpine' is updated to '1.21-alpine'
+// and not to '1.21.1-alpine', '1.21-alpine3.18' or '1.21-bullseye'. It is empty if there is no newer tag.
+func newerTag(current string, tags []string) string {
+	currentVersion, ok := parseTag(current)
+	if !ok {
+		return ""
+	}
+	newest, newestTag := currentVersion.version, ""
+	for _, tag := range tags {
+		candidate, ok := parseTag(tag)
+		if !ok || candidate.parts != currentVersion.parts || !currentVersion.sameVariant(&candidate) {
+			continue
+		}
+		if candidate.version.GreaterThan(newest) {
+			newest, newestTag = candidate.version, tag
+		}
+	}
+	return newestTag
+}
+
+// getContainerUpdates looks for a newer version of each image, images that can not be checked are skipped. In offline
+// mode only cached tags are used, the images that are not cached are returned.
+func (sc *scannerConfig) getContainerUpdates(ctx context.Context, images []*image) (skipped []string, err error) {
+	var failures []string
+	for i := range images {
+		name, currentTag, digest := splitImage(images[i].image)
+		if currentTag == "" || digest != "" {
+			continue
+		}
+		tag := currentTag
+		// tags like 'alpine' or 'stable' have no version to compare, but can still be pinned
+		if _, versioned := parseTag(currentTag); versioned {
+			tags, err := sc.imageTags(ctx, name)
+			if errors.Is(err, errOffline) {
+				skipped = appendUnique(skipped, images[i].image)
+				continue
+			}
+			if err != nil {
+				failures = append(failures, err.Error())
+				continue
+			}
+			if newer := newerTag(currentTag, tags); newer != "" {
+				tag = newer
+				images[i].updatedImage = fmt.Sprintf("%s:%s", name, newer)
+			}
+		}
+		if sc.pinDigests {
+			images[i].digest, err = sc.imageDigest(ctx, name, tag)
+			if errors.Is(err, errOffline) {
+				skipped = appendUnique(skipped, images[i].image)
+			} else if err != nil {
+				failures = append(failures, err.Error())
+			}
+		}
+	}
+	if err := sc.tagCache.save(); err != nil {
+		failures = append(failures, fmt.Sprintf("error saving the registry cache: %s", err))
+	}
+	if len(failures) > 0 {
+		return skipped, fmt.Errorf("%s", strings.Join(failures, ", "))
+	}
+	return skipped, nil
+}
+
+// imageTags returns the tags of an image from the cache, or from the registry when the cache is stale. The stale
+// tags are used when the registry can not be reached.
+func (sc *scannerConfig) imageTags(ctx context.Context, name string) ([]string, error) {
+	key := sc.registry.cacheKey(name)
+	if tags, ok := sc.tagCache.get(key, sc.offline); ok {
+		return tags, nil
+	}
+	if sc.offline {
+		return nil, errOffline
+	}
+	tags, err := sc.registry.listTags(ctx, name)
+	if err != nil {
+		// stale tags are better than no check at all
+		if stale, ok := sc.tagCache.get(key, true); ok {
+			return stale, nil
+		}
+		return nil, err
+	}
+	sc.tagCache.put(key, tags)
+	return tags, nil
+}
+
+// imageDigest returns the digest of a tag, digests are cached next to the tags as 'image:tag'.
+func (sc *scannerConfig) imageDigest(ctx context.Context, name, tag string) (string, error) {
+	key := sc.registry.cacheKey(name) + ":" + tag
+	if digest, ok := sc.tagCache.getDigest(key, sc.offline); ok {
+		return digest, nil
+	}
+	if sc.offline {
+		return "", errOffline
+	}
+	digest, err := sc.registry.digest(ctx, name, tag)
+	if err != nil {
+		if stale, ok := sc.tagCache.getDigest(key, true); ok {
+			return stale, nil
+		}
+		return "", err
+	}
+	sc.tagCache.putDigest(key, digest)
+	return digest, nil
+}
+
+// imageUpdateCheck recommends newer tags, or digests, for the FROM images in dockerfiles and the images in compose
+// files.
+func (sc *scannerConfig) imageUpdateCheck(ctx context.Context, dockerFiles, composeFiles []string) (outputResults []types.Scanlet) {
+	var images []*image
+	for _, path := range dockerFiles {
+		df, err := parseDockerfile(sc.workingDirectory, path)
+		if err != nil {
+			// parse errors are reported by the lint check
+			continue
+		}
+		for i := range df.stages {
+			st := &df.stages[i]
+			if st.image == "" || st.image == "scratch" || strings.Contains(st.image, "$") || df.isStage(st.image, i) {
+				continue
+			}
+			images = append(images, &image{image: st.image, file: path, line: st.from.line, instruction: st.from.original})
+		}
+	}
+	for _, path := range composeFiles {
+		file, err := compose.ParseFile(sc.workingDirectory, path)
+		if err != nil {
+			fmt.Printf("%s\n", err)
+			continue
+		}
+		for _, service := range file.Services {
+			if service.Image == "" || strings.Contains(service.Image, "$") {
+				continue
+			}
+			images = append(images, &image{image: service.Image, file: path, line: service.ImageLine, instruction: "image: " + service.Image})
+		}
+	}
+	skipped, err := sc.getContainerUpdates(ctx, images)
+	if err != nil {
+		fmt.Printf("error getting container updates: %s\n", err)
+	}
+	if len(skipped) > 0 {
+		outputResults = append(outputResults, scanner.SkippedCheck(ImageUpdateCheck, Name,
+			fmt.Sprintf("images %s were not checked, offline and not in the registry cache", strings.Join(skipped, ", "))))
+	}
+	for _, img := range images {
+		if img.recommended() == "" {
+			continue
+		}
+		rule, helpURL := ruleImageUpdate, pullURL
+		if img.updatedImage == "" {
+			rule, helpURL = rulePinDigest, pullDigestURL
+		}
+		outputResults = append(outputResults, types.Scanlet{
+			Name:           ImageUpdateCheck,
+			ScannerFamily:  Name,
+			Description:    img.change(),
+			OutputRenderer: outputter.DockerfileAnalysis,
+			Spec: dockerfileanalysis.OutputFields{
+				File:        img.file,
+				Line:        img.line,
+				Rule:        rule,
+				Instruction: img.instruction,
+				HelpURL:     helpURL,
+			},
+		})
+	}
+	return outputResults
+}
+
+func appendUnique(list []string, item string) []string {
+	if slices.Contains(list, item) {
+		return list
+	}
+	return append(list, item)
+}
@@ -0,0 +1,146 @@
+package docker
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tphoney/best_practice/outputter"
+	"github.com/tphoney/best_practice/outputter/dockerfileanalysis"
+)
+
+func TestParseTag(t *testing.T) {
+	tests := []struct {
+		tag     string
+		ok      bool
+		parts   int
+		variant string
+		release string
+	}{
+		{tag: "1.21", ok: true, parts: 2},
+		{tag: "v1.21.3-alpine3.18", ok: true, parts: 3, variant: "alpine3.18", release: "alpine"},
+		{tag: "20-bookworm", ok: true, parts: 1, variant: "bookworm"},
+		{tag: "latest"},
+		{tag: "1.22rc1"},
+	}
+	for _, test := range tests {
+		got, ok := parseTag(test.tag)
+		if ok != test.ok || got.parts != test.parts || got.variant != test.variant || got.release != test.release {
+			t.Errorf("parseTag(%s) = %+v %t, want %+v", test.tag, got, ok, test)
+		}
+	}
+}
+
+func TestNewerTagVariants(t *testing.T) {
+	tags := []string{"1.20-alpine3.18", "1.21-alpine3.19", "1.21-bookworm", "1.21.1-alpine3.19", "1.22.0"}
+	tests := map[string]string{
+		// the alpine release can move on with the version
+		"1.20-alpine3.18": "1.21-alpine3.19",
+		"1.20-bullseye":   "",
+		// a tag with a patch version only moves to tags with a patch version
+		"1.21.0": "1.22.0",
+		"1.22":   "",
+	}
+	for current, want := range tests {
+		if got := newerTag(current, tags); got != want {
+			t.Errorf("newerTag(%s) = '%s', want '%s'", current, got, want)
+		}
+	}
+}
+
+// testUpdateScanner returns a scanner that looks up images on the registry, with a cache that is not saved.
+func testUpdateScanner(workingDir string, offline bool) *scannerConfig {
+	return &scannerConfig{
+		workingDirectory: workingDir,
+		registry:         newRegistryClient(RegistryConfig{}),
+		tagCache:         loadTagCache("", time.Hour),
+		offline:          offline,
+	}
+}
+
+func TestImageUpdateCheck(t *testing.T) {
+	server := testRegistry(t, "team/go", [][]string{{"1.20", "1.21", "1.21-alpine", "latest"}}, nil, "")
+	base := imageName(server, "team/go")
+	workingDir, _ := testDockerfile(t, map[string]string{"Dockerfile": `FROM ` + base + `:1.20 AS build
+FROM build AS test
+FROM ` + base + `:1.21-alpine
+FROM scratch
+`})
+	sc := testUpdateScanner(workingDir, false)
+	results := sc.imageUpdateCheck(context.Background(), []string{"Dockerfile"}, nil)
+	// the stages and images that are up to date are not updated
+	if len(results) != 1 {
+		t.Fatalf("got results %+v", results)
+	}
+	spec, ok := results[0].Spec.(dockerfileanalysis.OutputFields)
+	if !ok || results[0].OutputRenderer != outputter.DockerfileAnalysis {
+		t.Fatalf("got result %+v", results[0])
+	}
+	if spec.Line != 1 || spec.Rule != ruleImageUpdate || results[0].Description != "update image from "+base+":1.20 to "+base+":1.21" {
+		t.Errorf("got result '%s' %+v", results[0].Description, spec)
+	}
+	// the tags are cached for the next run
+	if tags, ok := sc.tagCache.get(sc.registry.cacheKey(base), false); !ok || len(tags) != 4 {
+		t.Errorf("got cached tags %v", tags)
+	}
+}
+
+func TestImageTagsStaleCache(t *testing.T) {
+	server := testRegistry(t, "team/app", [][]string{{"1.0"}}, nil, "")
+	name := imageName(server, "team/app")
+	sc := testUpdateScanner(t.TempDir(), false)
+	key := sc.registry.cacheKey(name)
+	sc.tagCache.entries[key] = cacheEntry{Fetched: time.Now().Add(-48 * time.Hour), Tags: []string{"0.9"}}
+	// a stale entry is refreshed from the registry
+	tags, err := sc.imageTags(context.Background(), name)
+	if err != nil || !reflect.DeepEqual(tags, []string{"1.0"}) {
+		t.Errorf("got tags %v, %v", tags, err)
+	}
+	// and used when the registry can not be reached
+	server.Close()
+	sc.tagCache.entries[key] = cacheEntry{Fetched: time.Now().Add(-48 * time.Hour), Tags: []string{"0.9"}}
+	tags, err = sc.imageTags(context.Background(), name)
+	if err != nil || !reflect.DeepEqual(tags, []string{"0.9"}) {
+		t.Errorf("got tags %v, %v when the registry is down", tags, err)
+	}
+}
+
+func TestImageUpdateCheckOffline(t *testing.T) {
+	workingDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(workingDir, "Dockerfile"), []byte("FROM golang:1.20\nFROM node:18\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	sc := testUpdateScanner(workingDir, true)
+	sc.tagCache.put(sc.registry.cacheKey("golang"), []string{"1.20", "1.21"})
+	results := sc.imageUpdateCheck(context.Background(), []string{"Dockerfile"}, nil)
+	var descriptions []string
+	for i := range results {
+		descriptions = append(descriptions, results[i].Description)
+	}
+	want := []string{
+		"images node:18 were not checked, offline and not in the registry cache",
+		"update image from golang:1.20 to golang:1.21",
+	}
+	if !reflect.DeepEqual(descriptions, want) {
+		t.Errorf("got results %v, want %v", descriptions, want)
+	}
+}
+
+func TestRecommendedImage(t *testing.T) {
+	tests := []struct {
+		img  image
+		want string
+	}{
+		{img: image{image: "golang:1.21"}, want: ""},
+		{img: image{image: "golang:1.20", updatedImage: "golang:1.21"}, want: "golang:1.21"},
+		{img: image{image: "golang:1.21", digest: "sha256:abc"}, want: "golang:1.21@sha256:abc"},
+	}
+	for _, test := range tests {
+		if got := test.img.recommended(); got != test.want {
+			t.Errorf("recommended(%+v) = '%s', want '%s'", test.img, got, test.want)
+		}
+	}
+}
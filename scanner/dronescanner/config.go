@@ -0,0 +1,147 @@
+package dronescanner
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	DroneStarlarkLocation = ".drone.star"
+	DroneJsonnetLocation  = ".drone.jsonnet"
+	defaultBranch         = "main"
+)
+
+// droneConfigLocations are the drone configuration files we can read, in the order they are looked for.
+var droneConfigLocations = []string{DroneFileLocation, DroneStarlarkLocation, DroneJsonnetLocation}
+
+type (
+	// ConfigContext holds the build and repository values that starlark and jsonnet configuration can read, it is
+	// 'ctx.build' and 'ctx.repo' in starlark and the 'build.*' and 'repo.*' external variables in jsonnet.
+	ConfigContext struct {
+		Build BuildContext `json:"build"`
+		Repo  RepoContext  `json:"repo"`
+	}
+
+	BuildContext struct {
+		Event        string `json:"event"`
+		Action       string `json:"action"`
+		Branch       string `json:"branch"`
+		Source       string `json:"source"`
+		Target       string `json:"target"`
+		Ref          string `json:"ref"`
+		Commit       string `json:"commit"`
+		Before       string `json:"before"`
+		After        string `json:"after"`
+		Message      string `json:"message"`
+		Link         string `json:"link"`
+		AuthorLogin  string `json:"author_login"`
+		AuthorName   string `json:"author_name"`
+		AuthorEmail  string `json:"author_email"`
+		AuthorAvatar string `json:"author_avatar"`
+	}
+
+	RepoContext struct {
+		Name       string `json:"name"`
+		Namespace  string `json:"namespace"`
+		Slug       string `json:"slug"`
+		Branch     string `json:"branch"`
+		Link       string `json:"link"`
+		GitHTTPURL string `json:"git_http_url"`
+		GitSSHURL  string `json:"git_ssh_url"`
+		Private    bool   `json:"private"`
+		Visibility string `json:"visibility"`
+	}
+)
+
+// withDefaults fills in the values a build would have when running locally, as a push to the default branch.
+func (c *ConfigContext) withDefaults(workingDir string) ConfigContext {
+	var config ConfigContext
+	if c != nil {
+		config = *c
+	}
+	if config.Repo.Name == "" {
+		config.Repo.Name = filepath.Base(workingDir)
+	}
+	if config.Repo.Slug == "" {
+		config.Repo.Slug = config.Repo.Name
+		if config.Repo.Namespace != "" {
+			config.Repo.Slug = config.Repo.Namespace + "/" + config.Repo.Name
+		}
+	}
+	if config.Repo.Branch == "" {
+		config.Repo.Branch = defaultBranch
+	}
+	if config.Repo.Visibility == "" {
+		config.Repo.Visibility = "public"
+		if config.Repo.Private {
+			config.Repo.Visibility = "private"
+		}
+	}
+	if config.Build.Event == "" {
+		config.Build.Event = "push"
+	}
+	if config.Build.Branch == "" {
+		config.Build.Branch = config.Repo.Branch
+	}
+	if config.Build.Source == "" {
+		config.Build.Source = config.Build.Branch
+	}
+	if config.Build.Target == "" {
+		config.Build.Target = config.Build.Branch
+	}
+	if config.Build.Ref == "" {
+		config.Build.Ref = "refs/heads/" + config.Build.Branch
+	}
+	return config
+}
+
+// values returns the context as maps of 'build' and 'repo' values.
+func (c *ConfigContext) values() (map[string]map[string]interface{}, error) {
+	content, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	var values map[string]map[string]interface{}
+	err = json.Unmarshal(content, &values)
+	return values, err
+}
+
+// FindDroneFile returns the drone configuration file in the working directory, it is empty if there is none.
+func FindDroneFile(workingDir string) string {
+	for _, location := range droneConfigLocations {
+		if _, err := os.Stat(filepath.Join(workingDir, location)); err == nil {
+			return location
+		}
+	}
+	return ""
+}
+
+// ReadDroneConfig reads the drone configuration in the working directory whatever its format.
+func ReadDroneConfig(workingDir string, configContext *ConfigContext) (pipelines []DronePipeline, err error) {
+	location := FindDroneFile(workingDir)
+	if location == "" {
+		return nil, fmt.Errorf("no drone configuration found in '%s'", workingDir)
+	}
+	return ReadDroneFile(workingDir, location, configContext)
+}
+
+// pipelinesFromDocuments converts the documents generated by starlark or jsonnet into pipelines.
+func pipelinesFromDocuments(documents []interface{}) (pipelines []DronePipeline, err error) {
+	for _, document := range documents {
+		// yaml is a superset of json, so the yaml tags are used for both
+		content, err := json.Marshal(document)
+		if err != nil {
+			return nil, err
+		}
+		var pipeline DronePipeline
+		if err = yaml.Unmarshal(content, &pipeline); err != nil {
+			return nil, err
+		}
+		pipelines = append(pipelines, pipeline)
+	}
+	return pipelines, nil
+}
@@ -0,0 +1,107 @@
+package dronescanner
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	directory := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(directory, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return directory
+}
+
+const testStarlark = `load("steps.star", "test_step")
+
+def main(ctx):
+    steps = [test_step(ctx.build.branch)]
+    if ctx.build.event == "tag":
+        steps.append({"name": "release", "image": "goreleaser/goreleaser"})
+    return {
+        "kind": "pipeline",
+        "type": "docker",
+        "name": ctx.repo.name,
+        "steps": steps,
+    }
+`
+
+const testStarlarkSteps = `def test_step(branch):
+    return {"name": "test " + branch, "image": "golang:1.21", "commands": ["go test ./..."]}
+`
+
+func TestEvaluateStarlark(t *testing.T) {
+	directory := writeFiles(t, map[string]string{DroneStarlarkLocation: testStarlark, "steps.star": testStarlarkSteps})
+	// without a context the build is a push to the default branch
+	pipelines, err := ReadDroneConfig(directory, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pipelines) != 1 || pipelines[0].Name != filepath.Base(directory) {
+		t.Fatalf("got pipelines %+v", pipelines)
+	}
+	if steps := pipelines[0].Steps; len(steps) != 1 || steps[0].Name != "test main" || steps[0].Commands[0] != "go test ./..." {
+		t.Errorf("got steps %+v", steps)
+	}
+	pipelines, err = ReadDroneConfig(directory, &ConfigContext{Build: BuildContext{Event: "tag", Branch: "release"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if steps := pipelines[0].Steps; len(steps) != 2 || steps[0].Name != "test release" || steps[1].Name != "release" {
+		t.Errorf("got steps %+v for a tag", steps)
+	}
+}
+
+func TestEvaluateStarlarkErrors(t *testing.T) {
+	tests := map[string]string{
+		"syntax error":   "def main(ctx)\n",
+		"no main":        "pipeline = {}\n",
+		"runs forever":   "def main(ctx):\n    for i in range(1000000000):\n        pass\n",
+		"missing module": "load(\"missing.star\", \"x\")\ndef main(ctx):\n    return {}\n",
+	}
+	for name, content := range tests {
+		if _, err := EvaluateStarlark(t.TempDir(), DroneStarlarkLocation, []byte(content), nil); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestEvaluateJsonnet(t *testing.T) {
+	directory := writeFiles(t, map[string]string{
+		DroneJsonnetLocation: `local steps = import 'steps.libsonnet';
+function(ctx) [{
+  kind: 'pipeline',
+  type: 'docker',
+  name: std.extVar('repo.name'),
+  steps: [steps.test(ctx.build.branch)],
+}]
+`,
+		"steps.libsonnet": `{ test(branch):: { name: 'test ' + branch, image: 'golang:1.21' } }`,
+	})
+	pipelines, err := ReadDroneConfig(directory, &ConfigContext{Repo: RepoContext{Name: "app", Branch: "trunk"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pipelines) != 1 || pipelines[0].Name != "app" || len(pipelines[0].Steps) != 1 || pipelines[0].Steps[0].Name != "test trunk" {
+		t.Errorf("got pipelines %+v", pipelines)
+	}
+}
+
+func TestFindDroneFile(t *testing.T) {
+	// the yaml file is used when there is more than one
+	directory := writeFiles(t, map[string]string{DroneFileLocation: "kind: pipeline\n", DroneStarlarkLocation: testStarlark})
+	if location := FindDroneFile(directory); location != DroneFileLocation {
+		t.Errorf("found '%s'", location)
+	}
+	if location := FindDroneFile(t.TempDir()); location != "" {
+		t.Errorf("found '%s' in an empty directory", location)
+	}
+	if _, err := ReadDroneConfig(t.TempDir(), nil); err == nil {
+		t.Error("expected an error without a drone file")
+	}
+}
@@ -24,6 +24,7 @@ type scannerConfig struct {
 	workingDirectory string
 	checksToRun      []string
 	runAll           bool
+	droneContext     *ConfigContext
 }
 
 const (
@@ -60,13 +61,13 @@ func (sc *scannerConfig) AvailableChecks() []string {
 }
 
 func (sc *scannerConfig) Scan(ctx context.Context, requestedOutputs []string) (returnVal []types.Scanlet, err error) {
-	// lets look for a go.mod file in the directory
-	_, err = os.Stat(filepath.Join(sc.workingDirectory, DroneFileLocation))
-	if err != nil {
+	// lets look for a drone file in the directory
+	droneFile := FindDroneFile(sc.workingDirectory)
+	if droneFile == "" {
 		// nothing to see here, lets leave
 		return returnVal, nil
 	}
-	pipelines, err := ReadDroneFile(sc.workingDirectory, DroneFileLocation)
+	pipelines, err := ReadDroneFile(sc.workingDirectory, droneFile, sc.droneContext)
 	if err != nil {
 		return returnVal, err
 	}
@@ -138,7 +139,19 @@ func droneVolumesCheck(pipelines []DronePipeline) (match bool, outputResults []t
 	return match, outputResults
 }
 
-func ReadDroneFile(workingDir, droneFileLocation string) (pipelines []DronePipeline, err error) {
+// ReadDroneFile reads a drone yaml file, starlark and jsonnet files are evaluated with the context.
+func ReadDroneFile(workingDir, droneFileLocation string, configContext *ConfigContext) (pipelines []DronePipeline, err error) {
+	switch filepath.Ext(droneFileLocation) {
+	case ".star", ".jsonnet":
+		content, err := os.ReadFile(filepath.Join(workingDir, droneFileLocation))
+		if err != nil {
+			return pipelines, err
+		}
+		if filepath.Ext(droneFileLocation) == ".star" {
+			return EvaluateStarlark(workingDir, droneFileLocation, content, configContext)
+		}
+		return EvaluateJsonnet(workingDir, droneFileLocation, content, configContext)
+	}
 	file, fileErr := os.Open(filepath.Join(workingDir, droneFileLocation))
 	if fileErr != nil {
 		return pipelines, fileErr
@@ -165,10 +178,11 @@ func ReadDroneFile(workingDir, droneFileLocation string) (pipelines []DronePipel
 
 // DronePipeline
 type DronePipeline struct {
-	Kind  string  `yaml:"kind"`
-	Type  string  `yaml:"type"`
-	Name  string  `yaml:"name"`
-	Steps []Steps `yaml:"steps"`
+	Kind     string  `yaml:"kind"`
+	Type     string  `yaml:"type"`
+	Name     string  `yaml:"name"`
+	Steps    []Steps `yaml:"steps"`
+	Services []Steps `yaml:"services"`
 }
 
 // Steps
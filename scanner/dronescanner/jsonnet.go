@@ -0,0 +1,45 @@
+package dronescanner
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/go-jsonnet"
+)
+
+// EvaluateJsonnet evaluates a drone jsonnet configuration and returns the pipelines it generates. The context is
+// available as the 'build.*' and 'repo.*' external variables, and as the 'ctx' argument if the file is a function.
+// Files are imported relative to the working directory.
+func EvaluateJsonnet(workingDir, fileName string, content []byte, configContext *ConfigContext) ([]DronePipeline, error) {
+	config := configContext.withDefaults(workingDir)
+	values, err := config.values()
+	if err != nil {
+		return nil, err
+	}
+	vm := jsonnet.MakeVM()
+	vm.Importer(&jsonnet.FileImporter{JPaths: []string{workingDir}})
+	for group, fields := range values {
+		for key, value := range fields {
+			vm.ExtVar(fmt.Sprintf("%s.%s", group, key), fmt.Sprint(value))
+		}
+	}
+	ctx, err := json.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+	vm.TLACode("ctx", string(ctx))
+	output, err := vm.EvaluateAnonymousSnippet(fileName, string(content))
+	if err != nil {
+		return nil, fmt.Errorf("error evaluating %s '%s'", fileName, err)
+	}
+	var result interface{}
+	if err = json.Unmarshal([]byte(output), &result); err != nil {
+		return nil, fmt.Errorf("error evaluating %s '%s'", fileName, err)
+	}
+	// the file is either a single pipeline or a list of them
+	documents, ok := result.([]interface{})
+	if !ok {
+		documents = []interface{}{result}
+	}
+	return pipelinesFromDocuments(documents)
+}
@@ -27,3 +27,10 @@ func WithWorkingDirectory(i string) Option {
 		p.workingDirectory = i
 	}
 }
+
+// WithDroneContext sets the build and repository values used to evaluate starlark and jsonnet drone files.
+func WithDroneContext(i *ConfigContext) Option {
+	return func(p *scannerConfig) {
+		p.droneContext = i
+	}
+}
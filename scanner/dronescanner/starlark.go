@@ -0,0 +1,159 @@
+package dronescanner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.starlark.net/lib/json"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// maxStarlarkSteps stops a configuration that never finishes.
+const maxStarlarkSteps = 1000000
+
+// EvaluateStarlark runs the main function of a drone starlark configuration and returns the pipelines it generates.
+// Files loaded with load() are read from the working directory.
+func EvaluateStarlark(workingDir, fileName string, content []byte, configContext *ConfigContext) ([]DronePipeline, error) {
+	config := configContext.withDefaults(workingDir)
+	values, err := config.values()
+	if err != nil {
+		return nil, err
+	}
+	predeclared := starlark.StringDict{
+		"struct": starlark.NewBuiltin("struct", starlarkstruct.Make),
+		"json":   json.Module,
+	}
+	thread := &starlark.Thread{Name: fileName, Load: starlarkLoader(workingDir, predeclared)}
+	thread.SetMaxExecutionSteps(maxStarlarkSteps)
+	globals, err := starlark.ExecFile(thread, fileName, content, predeclared)
+	if err != nil {
+		return nil, fmt.Errorf("error evaluating %s '%s'", fileName, err)
+	}
+	main, ok := globals["main"].(starlark.Callable)
+	if !ok {
+		return nil, fmt.Errorf("%s does not have a main function", fileName)
+	}
+	ctx := starlarkstruct.FromStringDict(starlark.String("context"), starlark.StringDict{
+		"build": toStarlarkStruct(values["build"]),
+		"repo":  toStarlarkStruct(values["repo"]),
+	})
+	result, err := starlark.Call(thread, main, starlark.Tuple{ctx}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error evaluating %s '%s'", fileName, err)
+	}
+	converted, err := fromStarlark(result)
+	if err != nil {
+		return nil, fmt.Errorf("error evaluating %s '%s'", fileName, err)
+	}
+	// main returns either a single pipeline or a list of them
+	documents, ok := converted.([]interface{})
+	if !ok {
+		documents = []interface{}{converted}
+	}
+	return pipelinesFromDocuments(documents)
+}
+
+// starlarkLoader loads modules relative to the working directory, each module is only run once.
+func starlarkLoader(workingDir string, predeclared starlark.StringDict) func(*starlark.Thread, string) (starlark.StringDict, error) {
+	loaded := map[string]starlark.StringDict{}
+	var load func(*starlark.Thread, string) (starlark.StringDict, error)
+	load = func(_ *starlark.Thread, module string) (starlark.StringDict, error) {
+		if strings.HasPrefix(module, "@") {
+			return nil, fmt.Errorf("loading '%s' is not supported, only local files can be loaded", module)
+		}
+		if globals, ok := loaded[module]; ok {
+			if globals == nil {
+				return nil, fmt.Errorf("cycle in load of '%s'", module)
+			}
+			return globals, nil
+		}
+		content, err := os.ReadFile(filepath.Join(workingDir, filepath.Clean("/"+module)))
+		if err != nil {
+			return nil, err
+		}
+		loaded[module] = nil
+		thread := &starlark.Thread{Name: module, Load: load}
+		thread.SetMaxExecutionSteps(maxStarlarkSteps)
+		globals, err := starlark.ExecFile(thread, module, content, predeclared)
+		loaded[module] = globals
+		return globals, err
+	}
+	return load
+}
+
+func toStarlarkStruct(values map[string]interface{}) *starlarkstruct.Struct {
+	dict := starlark.StringDict{}
+	for key, value := range values {
+		switch v := value.(type) {
+		case bool:
+			dict[key] = starlark.Bool(v)
+		case string:
+			dict[key] = starlark.String(v)
+		default:
+			dict[key] = starlark.String(fmt.Sprint(v))
+		}
+	}
+	return starlarkstruct.FromStringDict(starlark.String("struct"), dict)
+}
+
+// fromStarlark converts a starlark value into the equivalent go value.
+func fromStarlark(value starlark.Value) (interface{}, error) {
+	switch v := value.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		return bool(v), nil
+	case starlark.Int:
+		i, ok := v.Int64()
+		if !ok {
+			return nil, fmt.Errorf("integer %s is too large", v)
+		}
+		return i, nil
+	case starlark.Float:
+		return float64(v), nil
+	case starlark.String:
+		return string(v), nil
+	case starlark.Indexable:
+		// lists and tuples
+		list := make([]interface{}, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			item, err := fromStarlark(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+		}
+		return list, nil
+	case *starlark.Dict:
+		dict := make(map[string]interface{}, v.Len())
+		for _, item := range v.Items() {
+			key, ok := item[0].(starlark.String)
+			if !ok {
+				return nil, fmt.Errorf("dictionary key %s is not a string", item[0])
+			}
+			converted, err := fromStarlark(item[1])
+			if err != nil {
+				return nil, err
+			}
+			dict[string(key)] = converted
+		}
+		return dict, nil
+	case *starlarkstruct.Struct:
+		fields := starlark.StringDict{}
+		v.ToStringDict(fields)
+		dict := make(map[string]interface{}, len(fields))
+		for key, field := range fields {
+			converted, err := fromStarlark(field)
+			if err != nil {
+				return nil, err
+			}
+			dict[key] = converted
+		}
+		return dict, nil
+	default:
+		return nil, fmt.Errorf("unsupported starlark type %s", value.Type())
+	}
+}
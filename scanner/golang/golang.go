@@ -3,8 +3,7 @@ package golang
 import (
 	"context"
 	"fmt"
-	"os"
-	"path/filepath"
+	"path"
 	"strings"
 
 	"github.com/tphoney/best_practice/outputter"
@@ -23,17 +22,31 @@ type scannerConfig struct {
 	workingDirectory string
 	checksToRun      []string
 	runAll           bool
+	droneContext     *dronescanner.ConfigContext
+	offline          bool
+	moduleProxy      string
 }
 
 const (
-	goModLocation  = "go.mod"
-	goLintLocation = ".golangci.yml"
-	Name           = scanner.GolangScannerName
-	ModCheck       = "Golang mod"
-	LintCheck      = "Golang lint"
-	MainCheck      = "Golang main"
-	testCheck      = "Golang test"
-	DroneCheck     = "Golang Drone build"
+	goModLocation    = "go.mod"
+	Name             = scanner.GolangScannerName
+	ModCheck         = "Golang mod"
+	ModFileCheck     = "Golang mod file"
+	ModDepsCheck     = "Golang mod dependencies"
+	WorkSyncCheck    = "Golang work sync"
+	LintCheck        = "Golang lint"
+	LintConfigCheck  = "Golang lint config"
+	MainCheck        = "Golang main"
+	testCheck        = "Golang test"
+	FuzzCheck        = "Golang fuzz"
+	BenchCheck       = "Golang benchmark"
+	IntegrationCheck = "Golang integration"
+	ReleaseCheck     = "Golang release"
+	DroneCheck       = "Golang Drone build"
+
+	modReferenceURL = "https://go.dev/ref/mod"
+	coverageFile    = "coverage.out"
+	unitTestCommand = "go test -race -coverprofile=" + coverageFile + " ./..."
 )
 
 func New(opts ...Option) (types.Scanner, error) {
@@ -58,46 +71,73 @@ func (sc *scannerConfig) Description() string {
 }
 
 func (sc *scannerConfig) AvailableChecks() []string {
-	return []string{ModCheck, LintCheck, MainCheck, testCheck, DroneCheck}
+	return []string{ModCheck, ModFileCheck, ModDepsCheck, WorkSyncCheck, LintCheck, LintConfigCheck, MainCheck, testCheck, FuzzCheck, BenchCheck,
+		IntegrationCheck, ReleaseCheck, DroneCheck}
 }
 
 func (sc *scannerConfig) Scan(ctx context.Context, requestedOutputs []string) (returnVal []types.Scanlet, err error) {
-	// lets look for a go.mod file in the directory
-	_, err = os.Stat(filepath.Join(sc.workingDirectory, goModLocation))
+	// lets look for go.work or go.mod files in the directory
+	dirs, workspace, err := findModules(sc.workingDirectory)
 	if err != nil {
+		fmt.Printf("%s\n", err)
+	}
+	if len(dirs) == 0 {
 		// nothing to see here, lets leave
 		return returnVal, nil
 	}
-	// check the mod file
-	if sc.runAll || slices.Contains(requestedOutputs, ModCheck) {
-		match, outputResults := sc.modCheck()
-		if match {
-			returnVal = append(returnVal, outputResults...)
-		}
+	modules := make([]*workspaceModule, 0, len(dirs))
+	for _, dir := range dirs {
+		modules = append(modules, readWorkspaceModule(sc.workingDirectory, dir))
 	}
-	// check for go linter
-	if sc.runAll || slices.Contains(requestedOutputs, LintCheck) {
-		match, lintResult := sc.lintCheck()
-		if match {
-			returnVal = append(returnVal, lintResult...)
-		}
+	if workspace {
+		useWorkspace(modules)
 	}
-	// find test files
-	if sc.runAll || slices.Contains(requestedOutputs, testCheck) {
-		match, testResult := sc.unitTestCheck()
-		if match {
-			returnVal = append(returnVal, testResult...)
+	for _, mod := range modules {
+		// check the mod file
+		if sc.runAll || slices.Contains(requestedOutputs, ModCheck) {
+			returnVal = append(returnVal, sc.modCheck(mod)...)
+		}
+		if mod.module != nil && (sc.runAll || slices.Contains(requestedOutputs, ModFileCheck)) {
+			returnVal = append(returnVal, sc.modFileCheck(mod)...)
+		}
+		if mod.module != nil && (sc.runAll || slices.Contains(requestedOutputs, ModDepsCheck)) {
+			returnVal = append(returnVal, sc.modDepsCheck(ctx, mod)...)
+		}
+		// check for go linter
+		if sc.runAll || slices.Contains(requestedOutputs, LintCheck) {
+			returnVal = append(returnVal, sc.lintCheck(mod)...)
+		}
+		if sc.runAll || slices.Contains(requestedOutputs, LintConfigCheck) {
+			returnVal = append(returnVal, sc.lintConfigCheck(mod)...)
+		}
+		// find test files
+		suite := findTests(sc.workingDirectory, mod)
+		if sc.runAll || slices.Contains(requestedOutputs, testCheck) {
+			returnVal = append(returnVal, sc.unitTestCheck(mod, &suite)...)
+		}
+		if sc.runAll || slices.Contains(requestedOutputs, FuzzCheck) {
+			returnVal = append(returnVal, sc.fuzzCheck(mod, &suite)...)
+		}
+		if sc.runAll || slices.Contains(requestedOutputs, BenchCheck) {
+			returnVal = append(returnVal, sc.benchmarkCheck(mod, &suite)...)
+		}
+		if sc.runAll || slices.Contains(requestedOutputs, IntegrationCheck) {
+			returnVal = append(returnVal, sc.integrationCheck(mod, &suite)...)
 		}
 	}
-	// find the main.go file
+	if workspace && (sc.runAll || slices.Contains(requestedOutputs, WorkSyncCheck)) {
+		returnVal = append(returnVal, sc.workSyncCheck(modules)...)
+	}
+	// find the main packages
 	if sc.runAll || slices.Contains(requestedOutputs, MainCheck) {
-		match, mainResult := sc.mainCheck()
-		if match {
-			returnVal = append(returnVal, mainResult...)
-		}
+		returnVal = append(returnVal, sc.mainCheck(modules)...)
+	}
+	// releases are built for the whole repository
+	if sc.runAll || slices.Contains(requestedOutputs, ReleaseCheck) {
+		returnVal = append(returnVal, sc.releaseCheck(modules[0])...)
 	}
 	if sc.runAll || slices.Contains(requestedOutputs, DroneCheck) {
-		droneResult, err := sc.droneCheck()
+		droneResult, err := sc.droneCheck(modules[0], workspace)
 		if err == nil {
 			returnVal = append(returnVal, droneResult...)
 		}
@@ -105,116 +145,141 @@ func (sc *scannerConfig) Scan(ctx context.Context, requestedOutputs []string) (r
 	return returnVal, nil
 }
 
-func (sc *scannerConfig) modCheck() (match bool, outputResults []types.Scanlet) {
-	// if go mod file does exist
-	_, err := os.Stat(filepath.Join(sc.workingDirectory, goModLocation))
-	if err == nil {
-		droneBuildResult := types.Scanlet{
-			Name:           ModCheck,
-			ScannerFamily:  Name,
-			Description:    "run go mod",
-			OutputRenderer: buildmaker.Name,
-			Spec: buildmaker.OutputFields{
-				Build: buildmaker.Build{
-					Name:     "go mod",
-					Image:    "golang:1",
-					Commands: []string{"go mod tidy", `diff go.mod go.mod.bak || (echo "go.mod is not up to date" && exit 1)`},
-				},
-				CLI:     "go mod tidy",
-				HelpURL: "https://go.dev/ref/mod#go-mod-tidy",
+func (sc *scannerConfig) modCheck(mod *workspaceModule) (outputResults []types.Scanlet) {
+	droneBuildResult := types.Scanlet{
+		Name:           ModCheck,
+		ScannerFamily:  Name,
+		Description:    mod.description("run go mod"),
+		OutputRenderer: buildmaker.Name,
+		Spec: buildmaker.OutputFields{
+			Build: buildmaker.Build{
+				Name:             mod.stepName("go mod"),
+				Phase:            buildmaker.PhaseDeps,
+				Cache:            buildmaker.CacheGo,
+				Image:            "golang:1",
+				Language:         buildmaker.LanguageGo,
+				LanguageVersion:  mod.goVersion,
+				LanguageVersions: mod.goVersions,
+				Commands:         mod.commands("go mod tidy", `diff go.mod go.mod.bak || (echo "go.mod is not up to date" && exit 1)`),
 			},
-		}
-		outputResults = append(outputResults, droneBuildResult)
-		return true, outputResults
+			CLI:     mod.cli("go mod tidy"),
+			HelpURL: "https://go.dev/ref/mod#go-mod-tidy",
+		},
 	}
-	return false, outputResults
+	return append(outputResults, droneBuildResult)
 }
 
-func (sc *scannerConfig) lintCheck() (match bool, outputResults []types.Scanlet) {
-	// if golang lint file does exist
-	_, err := os.Stat(filepath.Join(sc.workingDirectory, goLintLocation))
-	if err != nil {
-		droneBuildResult := types.Scanlet{
-			Name:           LintCheck,
-			ScannerFamily:  Name,
-			Description:    "run go lint as part of the build",
-			OutputRenderer: buildmaker.Name,
-			Spec: buildmaker.OutputFields{
-				Build: buildmaker.Build{
-					Name:     "go lint",
-					Image:    "golangci/golangci-lint",
-					Commands: []string{"golangci-lint run --timeout 500s"},
-				},
-				CLI:     "golangci-lint run",
-				HelpURL: "https://golangci-lint.run.googlesource.com/golangci-lint",
-			},
+// lintCheck runs golangci-lint whether or not there is a config, lintConfigCheck looks at how it is configured. The
+// timeout of the config is used when it sets one.
+func (sc *scannerConfig) lintCheck(mod *workspaceModule) (outputResults []types.Scanlet) {
+	command := "golangci-lint run --timeout 500s"
+	if file := findLintConfig(sc.workingDirectory, mod.dir); file != "" {
+		// errors reading the config are reported by lintConfigCheck
+		if config, err := readLintConfig(sc.workingDirectory, file); err == nil && (config.Run.Timeout != "" || config.Run.Deadline != "") {
+			command = "golangci-lint run"
 		}
-		outputResults = append(outputResults, droneBuildResult)
-		return true, outputResults
 	}
-	return false, outputResults
+	droneBuildResult := types.Scanlet{
+		Name:           LintCheck,
+		ScannerFamily:  Name,
+		Description:    mod.description("run go lint as part of the build"),
+		OutputRenderer: buildmaker.Name,
+		Spec: buildmaker.OutputFields{
+			Build: buildmaker.Build{
+				Name:             mod.stepName("go lint"),
+				Phase:            buildmaker.PhaseLint,
+				Cache:            buildmaker.CacheGo,
+				Image:            "golangci/golangci-lint",
+				Language:         buildmaker.LanguageGo,
+				LanguageVersion:  mod.goVersion,
+				LanguageVersions: mod.goVersions,
+				Commands:         mod.commands(command),
+			},
+			CLI:     mod.cli("golangci-lint run"),
+			HelpURL: "https://golangci-lint.run.googlesource.com/golangci-lint",
+		},
+	}
+	return append(outputResults, droneBuildResult)
 }
 
-func (sc *scannerConfig) mainCheck() (match bool, outputResults []types.Scanlet) {
-	matches, err := scanner.FindMatchingFiles(sc.workingDirectory, "main.go", true)
-	if err == nil && len(matches) > 0 {
-		// we use the first one found
-		mainLocation := strings.TrimPrefix(matches[0], sc.workingDirectory)
-		mainLocation = strings.TrimSuffix(mainLocation, "main.go")
-		if len(mainLocation) == 1 {
-			// dont do anything if main is in the working directory
-			mainLocation = ""
-		} else {
-			mainLocation = "." + mainLocation
+// mainCheck builds every package main, the steps are named after the binary they build.
+func (sc *scannerConfig) mainCheck(modules []*workspaceModule) (outputResults []types.Scanlet) {
+	mains := map[*workspaceModule][]mainPackage{}
+	binaries := map[string]int{}
+	for _, mod := range modules {
+		mains[mod] = mainPackages(sc.workingDirectory, mod)
+		for i := range mains[mod] {
+			binaries[mains[mod][i].binary]++
 		}
-
-		droneBuildResult := types.Scanlet{
-			Name:           LintCheck,
-			ScannerFamily:  Name,
-			Description:    "run go build",
-			OutputRenderer: buildmaker.Name,
-			Spec: buildmaker.OutputFields{
-				Build: buildmaker.Build{
-					Name:     "go build",
-					Image:    "golang:1",
-					Commands: []string{fmt.Sprintf("go build %s", mainLocation)},
+	}
+	for _, mod := range modules {
+		for i := range mains[mod] {
+			main := &mains[mod][i]
+			name := main.binary
+			if binaries[name] > 1 {
+				// two binaries with the same name, tell the steps apart by folder
+				name = path.Join(mod.dir, main.dir)
+			}
+			command := fmt.Sprintf("go build %s", main.buildPath())
+			droneBuildResult := types.Scanlet{
+				Name:           MainCheck,
+				ScannerFamily:  Name,
+				Description:    fmt.Sprintf("run go build for %s", name),
+				OutputRenderer: buildmaker.Name,
+				Spec: buildmaker.OutputFields{
+					Build: buildmaker.Build{
+						Name:             fmt.Sprintf("go build %s", name),
+						Phase:            buildmaker.PhaseBuild,
+						Cache:            buildmaker.CacheGo,
+						Image:            "golang:1",
+						Language:         buildmaker.LanguageGo,
+						LanguageVersion:  mod.goVersion,
+						LanguageVersions: mod.goVersions,
+						Commands:         mod.commands(command),
+					},
+					CLI:     mod.cli(command),
+					HelpURL: "https://pkg.go.dev/cmd/go#hdr-Compile_packages_and_dependencies",
 				},
-				CLI:     fmt.Sprintf("go build %s", mainLocation),
-				HelpURL: "https://pkg.go.dev/cmd/go#hdr-Build_constraints",
-			},
+			}
+			outputResults = append(outputResults, droneBuildResult)
 		}
-		outputResults = append(outputResults, droneBuildResult)
-		return true, outputResults
 	}
-	return false, outputResults
+	return outputResults
 }
 
-func (sc *scannerConfig) unitTestCheck() (match bool, outputResults []types.Scanlet) {
-	matches, err := scanner.FindMatchingFiles(sc.workingDirectory, "*_test.go", true)
-	if err == nil && len(matches) > 0 {
+// unitTestCheck runs the tests with the race detector, the coverage profile is kept as an artifact.
+func (sc *scannerConfig) unitTestCheck(mod *workspaceModule, suite *testSuite) (outputResults []types.Scanlet) {
+	if suite.unit {
 		droneBuildResult := types.Scanlet{
 			Name:           testCheck,
 			ScannerFamily:  Name,
-			Description:    "run go unit tests",
+			Description:    mod.description("run go unit tests with the race detector and a coverage profile"),
 			OutputRenderer: buildmaker.Name,
 			Spec: buildmaker.OutputFields{
 				Build: buildmaker.Build{
-					Name:     "go unit tests",
-					Image:    "golang:1",
-					Commands: []string{"go test ./..."},
+					Name:             mod.stepName("go unit tests"),
+					Phase:            buildmaker.PhaseTest,
+					Cache:            buildmaker.CacheGo,
+					Image:            "golang:1",
+					Language:         buildmaker.LanguageGo,
+					LanguageVersion:  mod.goVersion,
+					LanguageVersions: mod.goVersions,
+					Commands:         mod.commands(unitTestCommand, "go tool cover -func="+coverageFile+" | tail -n 1"),
+					Artifacts:        []string{path.Join(mod.dir, coverageFile)},
 				},
-				CLI:     "go test ./...",
+				CLI:     mod.cli(unitTestCommand),
 				HelpURL: "https://golang.org/cmd/go/#hdr-Testing_tools",
 			},
 		}
 		outputResults = append(outputResults, droneBuildResult)
-		return true, outputResults
 	}
-	return false, outputResults
+	return outputResults
 }
 
-func (sc *scannerConfig) droneCheck() (outputResults []types.Scanlet, err error) {
-	pipelines, err := dronescanner.ReadDroneFile(sc.workingDirectory, dronescanner.DroneFileLocation)
+// droneCheck looks for the go steps missing from the drone pipelines, the images are for the version of the first
+// module.
+func (sc *scannerConfig) droneCheck(mod *workspaceModule, workspace bool) (outputResults []types.Scanlet, err error) {
+	pipelines, err := dronescanner.ReadDroneConfig(sc.workingDirectory, sc.droneContext)
 	if err != nil {
 		return outputResults, err
 	}
@@ -224,6 +289,7 @@ func (sc *scannerConfig) droneCheck() (outputResults []types.Scanlet, err error)
 		foundGoUnit := false
 		foundGoBuild := false
 		foundGoMod := false
+		foundWorkSync := false
 		for j := range pipelines[i].Steps {
 			commands := pipelines[i].Steps[j].Commands
 			for k := range commands {
@@ -239,6 +305,9 @@ func (sc *scannerConfig) droneCheck() (outputResults []types.Scanlet, err error)
 				if strings.Contains(commands[k], "go test") {
 					foundGoUnit = true
 				}
+				if strings.Contains(commands[k], "go work sync") {
+					foundWorkSync = true
+				}
 			}
 		}
 		if !foundGoMod && foundGoBuild {
@@ -248,14 +317,15 @@ func (sc *scannerConfig) droneCheck() (outputResults []types.Scanlet, err error)
 				Description:    fmt.Sprintf("pipeline '%s' should check mod file is up to date", pipelines[i].Name),
 				OutputRenderer: outputter.DroneBuildAnalysis,
 				Spec: dronebuildanalysis.OutputFields{
-					HelpURL: "https://go.dev/ref/mod#go-mod-tidy",
-					Command: "go mod tidy",
-					RawYaml: `
+					PipelineName: pipelines[i].Name,
+					HelpURL:      "https://go.dev/ref/mod#go-mod-tidy",
+					Command:      "go mod tidy",
+					RawYaml: fmt.Sprintf(`
   - name: go mod tidy
-    image: golang:1
+    image: %s
     commands:
       - go mod tidy
-      - diff go.mod go.mod.bak || (echo "go.mod is not up to date" && exit 1)`,
+      - diff go.mod go.mod.bak || (echo "go.mod is not up to date" && exit 1)`, mod.goImage()),
 				},
 			}
 			outputResults = append(outputResults, bestPracticeResult)
@@ -267,8 +337,9 @@ func (sc *scannerConfig) droneCheck() (outputResults []types.Scanlet, err error)
 				Description:    fmt.Sprintf("pipeline '%s' should check go lint", pipelines[i].Name),
 				OutputRenderer: outputter.DroneBuildAnalysis,
 				Spec: dronebuildanalysis.OutputFields{
-					HelpURL: "https://golangci-lint.run.googlesource.com/golangci-lint",
-					Command: "golangci-lint run",
+					PipelineName: pipelines[i].Name,
+					HelpURL:      "https://golangci-lint.run.googlesource.com/golangci-lint",
+					Command:      "golangci-lint run",
 					RawYaml: `
   - name: golangci-lint
     image: golangci/golangci-lint
@@ -285,13 +356,34 @@ func (sc *scannerConfig) droneCheck() (outputResults []types.Scanlet, err error)
 				Description:    fmt.Sprintf("pipeline '%s' should check go unit tests", pipelines[i].Name),
 				OutputRenderer: outputter.DroneBuildAnalysis,
 				Spec: dronebuildanalysis.OutputFields{
-					HelpURL: "https://golang.org/cmd/go/#hdr-Testing_tools",
-					Command: "go test ./...",
-					RawYaml: `
+					PipelineName: pipelines[i].Name,
+					HelpURL:      "https://golang.org/cmd/go/#hdr-Testing_tools",
+					Command:      unitTestCommand,
+					RawYaml: fmt.Sprintf(`
   - name: go unit tests
-    image: golang:1
+    image: %s
     commands:
-      - go test ./...`,
+      - %s`, mod.goImage(), unitTestCommand),
+				},
+			}
+			outputResults = append(outputResults, bestPracticeResult)
+		}
+		if !foundWorkSync && foundGoBuild && workspace {
+			bestPracticeResult := types.Scanlet{
+				Name:           DroneCheck,
+				ScannerFamily:  Name,
+				Description:    fmt.Sprintf("pipeline '%s' should check the workspace modules are in sync", pipelines[i].Name),
+				OutputRenderer: outputter.DroneBuildAnalysis,
+				Spec: dronebuildanalysis.OutputFields{
+					PipelineName: pipelines[i].Name,
+					HelpURL:      "https://go.dev/ref/mod#go-work-sync",
+					Command:      "go work sync",
+					RawYaml: fmt.Sprintf(`
+  - name: go work sync
+    image: %s
+    commands:
+      - go work sync
+      - git diff --exit-code || (echo "go work sync changed the workspace modules" && exit 1)`, mod.goImage()),
 				},
 			}
 			outputResults = append(outputResults, bestPracticeResult)
@@ -299,3 +391,12 @@ func (sc *scannerConfig) droneCheck() (outputResults []types.Scanlet, err error)
 	}
 	return outputResults, err
 }
+
+// goImage is the golang image for the go directive, so suggested steps build with the version the module declares.
+func (m *workspaceModule) goImage() string {
+	parts := strings.Split(m.goVersion, ".")
+	if len(parts) < 2 { //nolint:gomnd
+		return "golang:1"
+	}
+	return fmt.Sprintf("golang:%s.%s", parts[0], parts[1])
+}
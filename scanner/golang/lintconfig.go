@@ -0,0 +1,208 @@
+package golang
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/tphoney/best_practice/outputter"
+	"github.com/tphoney/best_practice/outputter/dronebuildanalysis"
+	"github.com/tphoney/best_practice/types"
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	lintConfigURL = "https://golangci-lint.run/usage/configuration/"
+	// largeModuleFiles is the number of go files above which golangci-lint may need longer than its default timeout
+	largeModuleFiles = 500
+)
+
+var (
+	// lintConfigNames are the config files golangci-lint looks for, in its order.
+	lintConfigNames = []string{".golangci.yml", ".golangci.yaml", ".golangci.toml", ".golangci.json"}
+	// essentialLinters find real bugs, they should not be turned off.
+	essentialLinters = []string{"errcheck", "govet", "staticcheck"}
+	// defaultLinters are enabled when the config does not say otherwise.
+	defaultLinters = []string{"errcheck", "gosimple", "govet", "ineffassign", "staticcheck", "unused"}
+	// deprecatedLinters have been removed from golangci-lint, the value is what replaces them.
+	deprecatedLinters = map[string]string{
+		"deadcode":         "unused",
+		"exhaustivestruct": "exhaustruct",
+		"execinquery":      "",
+		"exportloopref":    "copyloopvar",
+		"gas":              "gosec",
+		"golint":           "revive",
+		"gomnd":            "mnd",
+		"ifshort":          "",
+		"interfacer":       "",
+		"maligned":         "govet with fieldalignment",
+		"megacheck":        "staticcheck",
+		"nosnakecase":      "revive with var-naming",
+		"scopelint":        "copyloopvar",
+		"structcheck":      "unused",
+		"tenv":             "usetesting",
+		"varcheck":         "unused",
+	}
+)
+
+// baselineLintConfig is the config we suggest when there is none, it adds a few linters to the default set.
+const baselineLintConfig = `version: "2"
+run:
+  timeout: 5m
+linters:
+  default: standard
+  enable:
+    - bodyclose
+    - gosec
+    - misspell
+    - revive
+    - unconvert
+formatters:
+  enable:
+    - gofmt
+    - goimports
+`
+
+type (
+	// lintConfig is the part of the golangci-lint config we check, version 2 configs use default instead of
+	// disable-all and enable-all.
+	lintConfig struct {
+		Version string `yaml:"version" toml:"version"`
+		Run     struct {
+			Timeout string `yaml:"timeout" toml:"timeout"`
+			// Deadline is the old name of the timeout
+			Deadline string `yaml:"deadline" toml:"deadline"`
+		} `yaml:"run" toml:"run"`
+		Linters struct {
+			Default    string   `yaml:"default" toml:"default"`
+			DisableAll bool     `yaml:"disable-all" toml:"disable-all"`
+			EnableAll  bool     `yaml:"enable-all" toml:"enable-all"`
+			Enable     []string `yaml:"enable" toml:"enable"`
+			Disable    []string `yaml:"disable" toml:"disable"`
+		} `yaml:"linters" toml:"linters"`
+	}
+)
+
+// findLintConfig returns the config file of a module, a config in the module folder is used before one for the
+// whole repository. The path is relative to the working directory.
+func findLintConfig(workingDir, dir string) string {
+	folders := []string{dir}
+	if dir != "." {
+		folders = append(folders, ".")
+	}
+	for _, folder := range folders {
+		for _, name := range lintConfigNames {
+			if _, err := os.Stat(filepath.Join(workingDir, folder, name)); err == nil {
+				return path.Join(folder, name)
+			}
+		}
+	}
+	return ""
+}
+
+// readLintConfig parses a yaml, toml or json config, json is read as yaml.
+func readLintConfig(workingDir, file string) (config lintConfig, err error) {
+	content, err := os.ReadFile(filepath.Join(workingDir, file))
+	if err != nil {
+		return config, err
+	}
+	if strings.HasSuffix(file, ".toml") {
+		err = toml.Unmarshal(content, &config)
+	} else {
+		err = yaml.Unmarshal(content, &config)
+	}
+	if err != nil {
+		return config, fmt.Errorf("error reading golangci-lint config '%s': %s", file, err)
+	}
+	return config, nil
+}
+
+// enabled works out if a linter runs with this config.
+func (c *lintConfig) enabled(linter string) bool {
+	if slices.Contains(c.Linters.Disable, linter) {
+		return false
+	}
+	if slices.Contains(c.Linters.Enable, linter) {
+		return true
+	}
+	switch {
+	case c.Linters.EnableAll || c.Linters.Default == "all":
+		return true
+	case c.Linters.DisableAll || c.Linters.Default == "none":
+		return false
+	case c.Linters.Default == "fast":
+		// the type checking linters are not fast
+		return false
+	}
+	return slices.Contains(defaultLinters, linter)
+}
+
+// lintConfigCheck suggests a baseline config when there is none, or reports the problems with the config.
+func (sc *scannerConfig) lintConfigCheck(mod *workspaceModule) (outputResults []types.Scanlet) {
+	file := findLintConfig(sc.workingDirectory, mod.dir)
+	if file == "" {
+		return append(outputResults, types.Scanlet{
+			Name:           LintConfigCheck,
+			ScannerFamily:  Name,
+			Description:    mod.description("add a golangci-lint config, so everyone runs the same linters"),
+			OutputRenderer: outputter.DroneBuildAnalysis,
+			Spec: dronebuildanalysis.OutputFields{
+				HelpURL:     lintConfigURL,
+				FileName:    path.Join(mod.dir, lintConfigNames[0]),
+				FileContent: baselineLintConfig,
+			},
+		})
+	}
+	config, err := readLintConfig(sc.workingDirectory, file)
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		return outputResults
+	}
+	listed := append(append([]string{}, config.Linters.Enable...), config.Linters.Disable...)
+	sort.Strings(listed)
+	for _, linter := range listed {
+		if _, deprecated := deprecatedLinters[linter]; !deprecated {
+			continue
+		}
+		description := fmt.Sprintf("%s lists the deprecated linter '%s', newer versions of golangci-lint fail on it", file, linter)
+		if replacement := deprecatedLinters[linter]; replacement != "" {
+			description += fmt.Sprintf(", use %s instead", replacement)
+		}
+		outputResults = append(outputResults, lintConfigResult(description))
+	}
+	var disabled []string
+	for _, linter := range essentialLinters {
+		if !config.enabled(linter) {
+			disabled = append(disabled, linter)
+		}
+	}
+	if len(disabled) > 0 {
+		outputResults = append(outputResults, lintConfigResult(fmt.Sprintf("%s disables %s, these linters find real bugs",
+			file, strings.Join(disabled, ", "))))
+	}
+	// version 2 has no timeout by default
+	if config.Version != "2" && config.Run.Timeout == "" && config.Run.Deadline == "" {
+		if files := len(moduleFiles(sc.workingDirectory, mod.dir, "*.go")); files > largeModuleFiles {
+			outputResults = append(outputResults, lintConfigResult(fmt.Sprintf(
+				"%s has no run timeout, with %d go files golangci-lint may not finish within the default of 1 minute", file, files)))
+		}
+	}
+	return outputResults
+}
+
+func lintConfigResult(description string) types.Scanlet {
+	return types.Scanlet{
+		Name:           LintConfigCheck,
+		ScannerFamily:  Name,
+		Description:    description,
+		OutputRenderer: outputter.DroneBuildAnalysis,
+		Spec: dronebuildanalysis.OutputFields{
+			HelpURL: lintConfigURL,
+		},
+	}
+}
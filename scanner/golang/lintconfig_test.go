@@ -0,0 +1,113 @@
+package golang
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tphoney/best_practice/outputter/buildmaker"
+	"github.com/tphoney/best_practice/outputter/dronebuildanalysis"
+)
+
+func TestFindLintConfig(t *testing.T) {
+	workingDir := writeFiles(t, map[string]string{
+		".golangci.toml":     "",
+		"api/.golangci.yaml": "",
+		"api/.golangci.json": "",
+		"web/go.mod":         "module example.com/web\n",
+	})
+	// a module config is used before the repository config, in the order golangci-lint looks for them
+	for dir, want := range map[string]string{".": ".golangci.toml", "api": "api/.golangci.yaml", "web": ".golangci.toml"} {
+		if got := findLintConfig(workingDir, dir); got != want {
+			t.Errorf("findLintConfig(%s) = '%s', want '%s'", dir, got, want)
+		}
+	}
+	if got := findLintConfig(t.TempDir(), "."); got != "" {
+		t.Errorf("found '%s' without a config", got)
+	}
+}
+
+func TestReadLintConfig(t *testing.T) {
+	workingDir := writeFiles(t, map[string]string{
+		".golangci.yml":  "run:\n  deadline: 2m\nlinters:\n  disable-all: true\n  enable:\n    - govet\n",
+		".golangci.toml": "[run]\ntimeout = \"5m\"\n[linters]\nenable-all = true\ndisable = [\"errcheck\"]\n",
+		".golangci.json": `{"version": "2", "linters": {"default": "none", "enable": ["staticcheck"]}}`,
+		"broken.yml":     "linters: [\n",
+	})
+	tests := []struct {
+		file    string
+		timeout string
+		enabled []string
+	}{
+		{file: ".golangci.yml", timeout: "2m", enabled: []string{"govet"}},
+		{file: ".golangci.toml", timeout: "5m", enabled: []string{"govet", "staticcheck", "gosec"}},
+		// json is read as yaml
+		{file: ".golangci.json", enabled: []string{"staticcheck"}},
+	}
+	for _, test := range tests {
+		config, err := readLintConfig(workingDir, test.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if timeout := config.Run.Timeout + config.Run.Deadline; timeout != test.timeout {
+			t.Errorf("%s: got timeout '%s', want '%s'", test.file, timeout, test.timeout)
+		}
+		var enabled []string
+		for _, linter := range []string{"errcheck", "govet", "staticcheck", "gosec"} {
+			if config.enabled(linter) {
+				enabled = append(enabled, linter)
+			}
+		}
+		if !reflect.DeepEqual(enabled, test.enabled) {
+			t.Errorf("%s: got enabled linters %v, want %v", test.file, enabled, test.enabled)
+		}
+	}
+	if _, err := readLintConfig(workingDir, "broken.yml"); err == nil {
+		t.Error("expected an error for a broken config")
+	}
+}
+
+func TestLintConfigCheck(t *testing.T) {
+	workingDir := writeFiles(t, map[string]string{
+		"api/go.mod":        "module example.com/api\n",
+		"web/go.mod":        "module example.com/web\n",
+		"web/.golangci.yml": "linters:\n  enable:\n    - golint\n    - varcheck\n  disable:\n    - errcheck\n",
+	})
+	sc := &scannerConfig{workingDirectory: workingDir}
+	// a module without a config gets the baseline config
+	results := sc.lintConfigCheck(&workspaceModule{dir: "api"})
+	if len(results) != 1 {
+		t.Fatalf("got results %+v", results)
+	}
+	if spec := results[0].Spec.(dronebuildanalysis.OutputFields); spec.FileName != "api/.golangci.yml" || spec.FileContent != baselineLintConfig {
+		t.Errorf("got suggested config %+v", spec)
+	}
+	var descriptions []string
+	for _, result := range sc.lintConfigCheck(&workspaceModule{dir: "web"}) {
+		descriptions = append(descriptions, result.Description)
+	}
+	want := []string{
+		"web/.golangci.yml lists the deprecated linter 'golint', newer versions of golangci-lint fail on it, use revive instead",
+		"web/.golangci.yml lists the deprecated linter 'varcheck', newer versions of golangci-lint fail on it, use unused instead",
+		"web/.golangci.yml disables errcheck, these linters find real bugs",
+	}
+	if !reflect.DeepEqual(descriptions, want) {
+		t.Errorf("got results\n%s\nwant\n%s", strings.Join(descriptions, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestLintCheckTimeout(t *testing.T) {
+	workingDir := writeFiles(t, map[string]string{
+		"api/.golangci.yml": "run:\n  timeout: 10m\n",
+		"web/go.mod":        "module example.com/web\n",
+	})
+	sc := &scannerConfig{workingDirectory: workingDir}
+	// the timeout of the config is not overridden
+	for dir, want := range map[string]string{"api": "golangci-lint run", "web": "golangci-lint run --timeout 500s"} {
+		results := sc.lintCheck(&workspaceModule{dir: dir})
+		commands := results[0].Spec.(buildmaker.OutputFields).Build.Commands
+		if !reflect.DeepEqual(commands, []string{"cd " + dir, want}) {
+			t.Errorf("lint commands of %s are %v, want '%s'", dir, commands, want)
+		}
+	}
+}
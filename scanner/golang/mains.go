@@ -0,0 +1,90 @@
+package golang
+
+import (
+	"go/build/constraint"
+	"go/parser"
+	"go/token"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/mod/module"
+)
+
+// toolsFile is the usual name for the file that pins the versions of go tools, it is package main but not a binary.
+const toolsFile = "tools.go"
+
+// mainPackage is a folder with a package main, binary is the name go build gives the executable.
+type mainPackage struct {
+	dir    string
+	binary string
+}
+
+// mainPackages finds every package main in the module, in folder order. Test files, tools.go and files with build
+// constraints are skipped, as are vendor and testdata folders and nested modules.
+func mainPackages(workingDir string, mod *workspaceModule) (mains []mainPackage) {
+	moduleDir := filepath.Join(workingDir, mod.dir)
+	dirs := map[string]bool{}
+	fileSet := token.NewFileSet()
+	for _, file := range moduleFiles(workingDir, mod.dir, "*.go") {
+		dir, err := filepath.Rel(moduleDir, filepath.Dir(file))
+		name := filepath.Base(file)
+		if err != nil || dirs[dir] || strings.HasSuffix(name, "_test.go") || name == toolsFile {
+			continue
+		}
+		parsed, err := parser.ParseFile(fileSet, file, nil, parser.PackageClauseOnly|parser.ParseComments)
+		if err != nil || parsed.Name.Name != "main" {
+			continue
+		}
+		constrained := false
+		for _, group := range parsed.Comments {
+			if group.Pos() > parsed.Package {
+				break
+			}
+			for _, comment := range group.List {
+				if constraint.IsGoBuild(comment.Text) || constraint.IsPlusBuild(comment.Text) {
+					constrained = true
+				}
+			}
+		}
+		if !constrained {
+			dirs[dir] = true
+		}
+	}
+	modulePath := ""
+	if mod.module != nil && mod.module.file.Module != nil {
+		modulePath = mod.module.file.Module.Mod.Path
+	}
+	for dir := range dirs {
+		binary := filepath.Base(dir)
+		if dir == "." {
+			binary = moduleBinary(modulePath, moduleDir)
+		}
+		mains = append(mains, mainPackage{dir: filepath.ToSlash(dir), binary: binary})
+	}
+	sort.Slice(mains, func(i, j int) bool {
+		return mains[i].dir < mains[j].dir
+	})
+	return mains
+}
+
+// moduleBinary is the name of the executable built from the module root, a major version suffix is not used.
+func moduleBinary(modulePath, workingDir string) string {
+	if modulePath == "" {
+		return filepath.Base(workingDir)
+	}
+	binary := path.Base(modulePath)
+	if prefix, _, ok := module.SplitPathVersion(modulePath); ok && prefix != modulePath && strings.HasPrefix(binary, "v") {
+		binary = path.Base(prefix)
+	}
+	return binary
+}
+
+// buildPath is the package path for go build, eg './cmd/server'.
+func (m *mainPackage) buildPath() string {
+	if m.dir == "." {
+		return "."
+	}
+	return "./" + m.dir
+}
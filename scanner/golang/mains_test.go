@@ -0,0 +1,63 @@
+package golang
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMainPackages(t *testing.T) {
+	workingDir := writeFiles(t, map[string]string{
+		"go.mod":                   "module example.com/app/v2\n\ngo 1.21\n",
+		"main.go":                  "package main\n\nfunc main() {}\n",
+		"cmd/server/main.go":       "// the server\npackage main\n",
+		"cmd/server/handlers.go":   "package main\n",
+		"cmd/client/client.go":     "package main\n",
+		"internal/lib/lib.go":      "package lib\n",
+		"tools.go":                 "//go:build tools\n\npackage main\n",
+		"scripts/gen/gen.go":       "//go:build ignore\n\npackage main\n",
+		"example/main_test.go":     "package main\n",
+		"vendor/x/main.go":         "package main\n",
+		"testdata/bin/main.go":     "package main\n",
+		"plugins/go.mod":           "module example.com/plugins\n",
+		"plugins/cmd/tool/main.go": "package main\n",
+		"broken/main.go":           "pack age main\n",
+	})
+	mod := readWorkspaceModule(workingDir, ".")
+	want := []mainPackage{
+		// the module root is named after the module, without the major version
+		{dir: ".", binary: "app"},
+		{dir: "cmd/client", binary: "client"},
+		{dir: "cmd/server", binary: "server"},
+	}
+	if got := mainPackages(workingDir, mod); !reflect.DeepEqual(got, want) {
+		t.Errorf("got main packages %+v, want %+v", got, want)
+	}
+	// the nested module has its own main packages
+	plugins := readWorkspaceModule(workingDir, "plugins")
+	if got := mainPackages(workingDir, plugins); !reflect.DeepEqual(got, []mainPackage{{dir: "cmd/tool", binary: "tool"}}) {
+		t.Errorf("got nested main packages %+v", got)
+	}
+}
+
+func TestModuleBinary(t *testing.T) {
+	tests := map[string]string{
+		"example.com/app":     "app",
+		"example.com/app/v3":  "app",
+		"gopkg.in/yaml.v3":    "yaml.v3",
+		"example.com/vault/v": "v",
+		"":                    "project",
+	}
+	for modulePath, want := range tests {
+		if got := moduleBinary(modulePath, "/src/project"); got != want {
+			t.Errorf("moduleBinary(%s) = '%s', want '%s'", modulePath, got, want)
+		}
+	}
+}
+
+func TestBuildPath(t *testing.T) {
+	for dir, want := range map[string]string{".": ".", "cmd/server": "./cmd/server"} {
+		if got := (&mainPackage{dir: dir}).buildPath(); got != want {
+			t.Errorf("buildPath(%s) = '%s', want '%s'", dir, got, want)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package golang
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/tphoney/best_practice/outputter"
+	"github.com/tphoney/best_practice/outputter/dronebuildanalysis"
+	"github.com/tphoney/best_practice/scanner"
+	"github.com/tphoney/best_practice/types"
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+)
+
+const goSumLocation = "go.sum"
+
+// goModule is the parsed mod file, with the hashes from go.sum.
+type goModule struct {
+	file   *modfile.File
+	hasSum bool
+	// sums are the 'path version' entries of go.sum, the hash of a mod file has a '/go.mod' version suffix.
+	sums map[string]bool
+	// workspace are the paths of the modules in go.work, they are used from disk rather than downloaded.
+	workspace map[string]bool
+}
+
+// readGoModule parses the mod file and go.sum, a missing go.sum is not an error.
+func readGoModule(workingDir string) (*goModule, error) {
+	path := filepath.Join(workingDir, goModLocation)
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	file, err := modfile.Parse(path, content, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing '%s': %s", path, err)
+	}
+	mod := &goModule{file: file, sums: map[string]bool{}}
+	sum, err := os.ReadFile(filepath.Join(workingDir, goSumLocation))
+	if err != nil {
+		return mod, nil
+	}
+	mod.hasSum = true
+	for _, line := range strings.Split(string(sum), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 3 { //nolint:gomnd
+			mod.sums[fields[0]+" "+fields[1]] = true
+		}
+	}
+	return mod, nil
+}
+
+// versions returns the go directive, and every version the module supports. A toolchain newer than the go directive
+// means the module is built with both, a custom toolchain such as 'default' is not a version.
+func (m *goModule) versions() (version string, versions []string) {
+	if m.file.Go == nil {
+		return "", nil
+	}
+	version = m.file.Go.Version
+	toolchain := ""
+	if m.file.Toolchain != nil {
+		toolchain = strings.TrimPrefix(m.file.Toolchain.Name, "go")
+	}
+	return version, scanner.SortVersions([]string{version, toolchain})
+}
+
+// localReplaces returns the replace directives that point at a directory CI will not have, directories outside of
+// the repository or ones that do not hold a module. The reason says which, dir is the module folder in the repository.
+func (m *goModule) localReplaces(workingDir, dir string) (replaces []*modfile.Replace, reasons []string) {
+	for _, replace := range m.file.Replace {
+		if replace.New.Version != "" {
+			// replaced by another module, it is downloaded like any other
+			continue
+		}
+		target := filepath.FromSlash(replace.New.Path)
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.FromSlash(dir), target)
+		}
+		switch {
+		case filepath.IsAbs(target):
+			reasons = append(reasons, "is an absolute path")
+		case target == ".." || strings.HasPrefix(target, ".."+string(filepath.Separator)):
+			reasons = append(reasons, "is outside of the repository")
+		default:
+			if _, err := os.Stat(filepath.Join(workingDir, target, goModLocation)); err == nil {
+				continue
+			}
+			reasons = append(reasons, "does not contain a go.mod file")
+		}
+		replaces = append(replaces, replace)
+	}
+	return replaces, reasons
+}
+
+// missingSums returns the requirements without a hash in go.sum, the build fails for them with 'missing go.sum entry'.
+// Replaced requirements are checked against their replacement.
+func (m *goModule) missingSums() (missing []module.Version) {
+	replaced := map[string]module.Version{}
+	for _, replace := range m.file.Replace {
+		replaced[replace.Old.String()] = replace.New
+	}
+	for _, require := range m.file.Require {
+		mod := require.Mod
+		if replacement, found := replaced[mod.String()]; found {
+			mod = replacement
+		} else if replacement, found := replaced[mod.Path]; found {
+			mod = replacement
+		}
+		if mod.Version == "" || m.workspace[mod.Path] {
+			// a local directory has no hash
+			continue
+		}
+		if !m.sums[mod.Path+" "+mod.Version+"/go.mod"] {
+			missing = append(missing, require.Mod)
+		}
+	}
+	return missing
+}
+
+// directRequirements are the requirements the module imports itself, the indirect ones are up to its dependencies.
+// Replaced requirements are left out, they are not downloaded from the module proxy.
+func (m *goModule) directRequirements() (requirements []module.Version) {
+	replaced := map[string]bool{}
+	for _, replace := range m.file.Replace {
+		replaced[replace.Old.Path] = true
+	}
+	for _, require := range m.file.Require {
+		if !require.Indirect && !replaced[require.Mod.Path] && !m.workspace[require.Mod.Path] {
+			requirements = append(requirements, require.Mod)
+		}
+	}
+	return requirements
+}
+
+// modFileCheck looks for the problems in the mod file that only show up in CI, a replace directive pointing at a
+// directory on the developers machine or a go.sum that is missing or out of date.
+func (sc *scannerConfig) modFileCheck(mod *workspaceModule) (outputResults []types.Scanlet) {
+	replaces, reasons := mod.module.localReplaces(sc.workingDirectory, mod.dir)
+	for i, replace := range replaces {
+		outputResults = append(outputResults, types.Scanlet{
+			Name:          ModFileCheck,
+			ScannerFamily: Name,
+			Description: fmt.Sprintf("local replace directive for '%s' in %s will fail in CI, '%s' %s", replace.Old.String(),
+				path.Join(mod.dir, goModLocation), replace.New.Path, reasons[i]),
+			OutputRenderer: outputter.DroneBuildAnalysis,
+			Spec: dronebuildanalysis.OutputFields{
+				HelpURL: modReferenceURL + "#go-mod-file-replace",
+				Command: mod.cli(fmt.Sprintf("go mod edit -dropreplace=%s", replace.Old.String())),
+			},
+		})
+	}
+	description := ""
+	sumFile := path.Join(mod.dir, goSumLocation)
+	if missing := mod.module.missingSums(); len(missing) > 0 {
+		description = fmt.Sprintf("%s has no entry for %s, the build will fail with 'missing go.sum entry'", sumFile, modulesList(missing))
+		if !mod.module.hasSum {
+			description = fmt.Sprintf("%s is missing, the build will fail with 'missing go.sum entry'", sumFile)
+		}
+	}
+	if description != "" {
+		outputResults = append(outputResults, types.Scanlet{
+			Name:           ModFileCheck,
+			ScannerFamily:  Name,
+			Description:    description,
+			OutputRenderer: outputter.DroneBuildAnalysis,
+			Spec: dronebuildanalysis.OutputFields{
+				HelpURL: modReferenceURL + "#go-sum-files",
+				Command: mod.cli("go mod tidy"),
+			},
+		})
+	}
+	return outputResults
+}
+
+// modDepsCheck asks the module proxy whether the direct requirements are retracted or deprecated.
+func (sc *scannerConfig) modDepsCheck(ctx context.Context, mod *workspaceModule) (outputResults []types.Scanlet) {
+	requirements := mod.module.directRequirements()
+	if len(requirements) == 0 {
+		return outputResults
+	}
+	if sc.offline {
+		return append(outputResults, scanner.SkippedCheck(ModDepsCheck, Name,
+			fmt.Sprintf("%d dependencies in %s were not checked for retracted or deprecated versions, offline", len(requirements),
+				path.Join(mod.dir, goModLocation))))
+	}
+	proxy := newModuleProxy(sc.moduleProxy)
+	if proxy == nil {
+		return append(outputResults, scanner.SkippedCheck(ModDepsCheck, Name,
+			fmt.Sprintf("%d dependencies in %s were not checked for retracted or deprecated versions, GOPROXY does not name a proxy",
+				len(requirements), path.Join(mod.dir, goModLocation))))
+	}
+	private := 0
+	for _, requirement := range requirements {
+		if proxy.isPrivate(requirement.Path) {
+			private++
+			continue
+		}
+		status, err := proxy.status(ctx, requirement)
+		if err != nil {
+			fmt.Printf("error checking go module '%s': %s\n", requirement.Path, err)
+			continue
+		}
+		if status.retracted != "" {
+			outputResults = append(outputResults, types.Scanlet{
+				Name:           ModDepsCheck,
+				ScannerFamily:  Name,
+				Description:    fmt.Sprintf("dependency '%s' is retracted: %s", requirement.String(), status.retracted),
+				OutputRenderer: outputter.DroneBuildAnalysis,
+				Spec: dronebuildanalysis.OutputFields{
+					HelpURL: modReferenceURL + "#go-mod-file-retract",
+					Command: mod.cli(fmt.Sprintf("go get %s@latest", requirement.Path)),
+				},
+			})
+		}
+		if status.deprecated != "" {
+			outputResults = append(outputResults, types.Scanlet{
+				Name:           ModDepsCheck,
+				ScannerFamily:  Name,
+				Description:    fmt.Sprintf("dependency '%s' is deprecated: %s", requirement.Path, status.deprecated),
+				OutputRenderer: outputter.DroneBuildAnalysis,
+				Spec: dronebuildanalysis.OutputFields{
+					HelpURL: modReferenceURL + "#go-mod-file-module-deprecation",
+				},
+			})
+		}
+	}
+	if private > 0 {
+		outputResults = append(outputResults, scanner.SkippedCheck(ModDepsCheck, Name,
+			fmt.Sprintf("%d dependencies in %s match GOPRIVATE or GONOPROXY and were not checked", private, path.Join(mod.dir, goModLocation))))
+	}
+	return outputResults
+}
+
+func modulesList(mods []module.Version) string {
+	names := make([]string, 0, len(mods))
+	for _, mod := range mods {
+		names = append(names, mod.String())
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,160 @@
+package golang
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/mod/module"
+)
+
+// writeFiles writes files relative to a new working directory.
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	workingDir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(workingDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return workingDir
+}
+
+const testGoMod = `module example.com/app
+
+go 1.21
+
+toolchain go1.21.5
+
+require (
+	example.com/lib v1.2.0
+	example.com/forked v1.0.0
+	example.com/indirect v0.1.0 // indirect
+	example.com/local v0.0.0
+	example.com/sibling v0.0.0
+)
+
+replace example.com/forked => example.com/fork v1.0.1
+
+replace example.com/local => ../local
+
+replace example.com/sibling => ./sibling
+`
+
+func TestReadGoModule(t *testing.T) {
+	workingDir := writeFiles(t, map[string]string{
+		"go.mod":         testGoMod,
+		"sibling/go.mod": "module example.com/sibling\n",
+		"go.sum": `example.com/lib v1.2.0 h1:abc=
+example.com/lib v1.2.0/go.mod h1:def=
+example.com/fork v1.0.1/go.mod h1:ghi=
+`,
+	})
+	mod, err := readGoModule(workingDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the go directive is the minimum version, the toolchain is the version the go command builds with
+	if version, versions := mod.versions(); version != "1.21" || !reflect.DeepEqual(versions, []string{"1.21", "1.21.5"}) {
+		t.Errorf("got version %s %v", version, versions)
+	}
+	replaces, reasons := mod.localReplaces(workingDir, ".")
+	if len(replaces) != 1 || replaces[0].Old.Path != "example.com/local" || reasons[0] != "is outside of the repository" {
+		t.Errorf("got local replaces %v %v", replaces, reasons)
+	}
+	// the local replaces have no hash, the replaced module is checked against its replacement
+	if missing := mod.missingSums(); !reflect.DeepEqual(missing, []module.Version{{Path: "example.com/indirect", Version: "v0.1.0"}}) {
+		t.Errorf("got missing sums %v", missing)
+	}
+	if requirements := mod.directRequirements(); !reflect.DeepEqual(requirements, []module.Version{{Path: "example.com/lib", Version: "v1.2.0"}}) {
+		t.Errorf("got direct requirements %v", requirements)
+	}
+}
+
+func TestGoModuleVersions(t *testing.T) {
+	tests := map[string][]string{
+		"module a\n":                                nil,
+		"module a\ngo 1.20\n":                       {"1.20"},
+		"module a\ngo 1.21\ntoolchain default\n":    {"1.21"},
+		"module a\ngo 1.22.0\ntoolchain go1.22.3\n": {"1.22.0", "1.22.3"},
+	}
+	for content, want := range tests {
+		mod, err := readGoModule(writeFiles(t, map[string]string{"go.mod": content}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		version, versions := mod.versions()
+		if (len(want) == 0 && version != "") || (len(want) > 0 && version != want[0]) || !reflect.DeepEqual(versions, want) {
+			t.Errorf("versions(%q) = '%s' %v, want %v", content, version, versions, want)
+		}
+		if mod.hasSum {
+			t.Error("a module without go.sum has no sums")
+		}
+	}
+}
+
+func TestNewModuleProxy(t *testing.T) {
+	t.Setenv("GOPRIVATE", "example.com/private,*.internal")
+	t.Setenv("GONOPROXY", "")
+	tests := map[string]string{
+		"":                                      defaultModuleProxy,
+		"https://proxy.internal/,direct":        "https://proxy.internal",
+		"https://a.internal|https://b.internal": "https://a.internal",
+		"off":                                   "",
+		"direct":                                "",
+	}
+	for configured, want := range tests {
+		t.Setenv("GOPROXY", "")
+		proxy := newModuleProxy(configured)
+		if (proxy == nil && want != "") || (proxy != nil && proxy.url != want) {
+			t.Errorf("newModuleProxy(%s) = %+v, want '%s'", configured, proxy, want)
+		}
+	}
+	proxy := newModuleProxy("")
+	for path, want := range map[string]bool{"example.com/private/lib": true, "git.internal/lib": true, "example.com/lib": false} {
+		if got := proxy.isPrivate(path); got != want {
+			t.Errorf("isPrivate(%s) = %t, want %t", path, got, want)
+		}
+	}
+}
+
+func TestModuleProxyStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/example.com/lib/@v/list":
+			_, _ = w.Write([]byte("v1.0.0\nv1.1.0\nv1.2.0-rc.1\nv2.0.0+incompatible\n"))
+		case "/example.com/lib/@v/v1.1.0.mod":
+			_, _ = w.Write([]byte("// Deprecated: use example.com/lib/v2\nmodule example.com/lib\n\nretract v1.0.0 // data loss\n"))
+		case "/example.com/untagged/@v/list":
+		case "/example.com/untagged/@latest":
+			_, _ = w.Write([]byte(`{"Version":"v0.0.0-20240101000000-abcdefabcdef"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	proxy := newModuleProxy(server.URL)
+	status, err := proxy.status(context.Background(), module.Version{Path: "example.com/lib", Version: "v1.0.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.retracted != "data loss" || status.deprecated != "use example.com/lib/v2" {
+		t.Errorf("got status %+v", status)
+	}
+	// without tagged versions the proxy is asked for the latest version
+	latest, err := proxy.latestVersion(context.Background(), "example.com/untagged")
+	if err != nil || latest != "v0.0.0-20240101000000-abcdefabcdef" {
+		t.Errorf("got latest version '%s', %v", latest, err)
+	}
+	if _, err := proxy.status(context.Background(), module.Version{Path: "example.com/missing", Version: "v1.0.0"}); err == nil {
+		t.Error("expected an error for a module the proxy does not have")
+	}
+}
@@ -1,6 +1,9 @@
 package golang
 
-import "golang.org/x/exp/slices"
+import (
+	"github.com/tphoney/best_practice/scanner/dronescanner"
+	"golang.org/x/exp/slices"
+)
 
 type Option func(*scannerConfig)
 
@@ -27,3 +30,24 @@ func WithWorkingDirectory(i string) Option {
 		p.workingDirectory = i
 	}
 }
+
+// WithDroneContext sets the build and repository values used to evaluate starlark and jsonnet drone files.
+func WithDroneContext(i *dronescanner.ConfigContext) Option {
+	return func(p *scannerConfig) {
+		p.droneContext = i
+	}
+}
+
+// WithOffline skips the checks that need the network.
+func WithOffline(i bool) Option {
+	return func(p *scannerConfig) {
+		p.offline = i
+	}
+}
+
+// WithModuleProxy sets the GOPROXY used to look for retracted and deprecated dependencies.
+func WithModuleProxy(i string) Option {
+	return func(p *scannerConfig) {
+		p.moduleProxy = i
+	}
+}
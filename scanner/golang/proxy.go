@@ -0,0 +1,171 @@
+package golang
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+const (
+	defaultModuleProxy = "https://proxy.golang.org"
+	moduleProxyTimeout = 30 * time.Second
+)
+
+type (
+	// moduleProxy reads module information from a GOPROXY, see https://go.dev/ref/mod#goproxy-protocol.
+	moduleProxy struct {
+		url    string
+		client *http.Client
+		// private are the GONOPROXY or GOPRIVATE patterns
+		private string
+	}
+
+	moduleInfo struct {
+		Version string `json:"Version"`
+	}
+
+	// moduleStatus is why a requirement should be changed, either field may be empty.
+	moduleStatus struct {
+		retracted  string
+		deprecated string
+	}
+)
+
+// newModuleProxy uses the configured proxy, or the first proxy in GOPROXY like the go command does. It is nil when
+// GOPROXY does not name a proxy, eg 'off' or 'direct'.
+func newModuleProxy(configured string) *moduleProxy {
+	if configured == "" {
+		configured = os.Getenv("GOPROXY")
+	}
+	if configured == "" {
+		configured = defaultModuleProxy
+	}
+	first := strings.FieldsFunc(configured, func(r rune) bool { return r == ',' || r == '|' })
+	if len(first) == 0 || first[0] == "off" || first[0] == "direct" {
+		return nil
+	}
+	// GONOPROXY defaults to GOPRIVATE
+	private := os.Getenv("GONOPROXY")
+	if private == "" {
+		private = os.Getenv("GOPRIVATE")
+	}
+	return &moduleProxy{
+		url:     strings.TrimSuffix(first[0], "/"),
+		client:  &http.Client{Timeout: moduleProxyTimeout},
+		private: private,
+	}
+}
+
+// isPrivate is true for modules the go command does not fetch from the proxy, their paths are not sent to it.
+func (p *moduleProxy) isPrivate(path string) bool {
+	return p.private != "" && module.MatchPrefixPatterns(p.private, path)
+}
+
+// status checks a requirement against the mod file of the latest version of the module, that is where a module is
+// deprecated and where versions are retracted.
+func (p *moduleProxy) status(ctx context.Context, mod module.Version) (status moduleStatus, err error) {
+	latest, err := p.latestModFile(ctx, mod.Path)
+	if err != nil {
+		return status, err
+	}
+	if latest.Module != nil && latest.Module.Deprecated != "" {
+		status.deprecated = latest.Module.Deprecated
+	}
+	for _, retract := range latest.Retract {
+		if semver.Compare(mod.Version, retract.Low) >= 0 && semver.Compare(mod.Version, retract.High) <= 0 {
+			status.retracted = retract.Rationale
+			if status.retracted == "" {
+				status.retracted = "no reason given"
+			}
+			break
+		}
+	}
+	return status, nil
+}
+
+func (p *moduleProxy) latestModFile(ctx context.Context, path string) (*modfile.File, error) {
+	escapedPath, err := module.EscapePath(path)
+	if err != nil {
+		return nil, err
+	}
+	latest, err := p.latestVersion(ctx, escapedPath)
+	if err != nil {
+		return nil, err
+	}
+	escapedVersion, err := module.EscapeVersion(latest)
+	if err != nil {
+		return nil, err
+	}
+	content, err := p.get(ctx, fmt.Sprintf("%s/%s/@v/%s.mod", p.url, escapedPath, escapedVersion))
+	if err != nil {
+		return nil, err
+	}
+	// the mod file of a dependency may use directives we do not know, only the main module is strict
+	return modfile.ParseLax(path+"@"+latest+"/go.mod", content, nil)
+}
+
+// latestVersion is the newest release in the version list, prereleases and +incompatible versions are only used when
+// there is nothing else. Like the go command, @latest is only asked for modules without tagged versions as proxies do
+// not have to serve it.
+func (p *moduleProxy) latestVersion(ctx context.Context, escapedPath string) (string, error) {
+	content, err := p.get(ctx, fmt.Sprintf("%s/%s/@v/list", p.url, escapedPath))
+	if err != nil {
+		return "", err
+	}
+	// candidates are in order of preference
+	candidates := make([]string, 3) //nolint:gomnd
+	for _, version := range strings.Fields(string(content)) {
+		if !semver.IsValid(version) {
+			continue
+		}
+		preference := 0
+		switch {
+		case semver.Build(version) == "+incompatible":
+			preference = 2
+		case semver.Prerelease(version) != "":
+			preference = 1
+		}
+		if semver.Compare(version, candidates[preference]) > 0 {
+			candidates[preference] = version
+		}
+	}
+	for _, latest := range candidates {
+		if latest != "" {
+			return latest, nil
+		}
+	}
+	content, err = p.get(ctx, fmt.Sprintf("%s/%s/@latest", p.url, escapedPath))
+	if err != nil {
+		return "", err
+	}
+	var info moduleInfo
+	if err = json.Unmarshal(content, &info); err != nil {
+		return "", fmt.Errorf("error reading latest version of '%s': %s", escapedPath, err)
+	}
+	return info.Version, nil
+}
+
+func (p *moduleProxy) get(ctx context.Context, url string) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	response, err := p.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("module proxy returned '%s' for '%s'", response.Status, url)
+	}
+	return io.ReadAll(response.Body)
+}
@@ -0,0 +1,369 @@
+package golang
+
+import (
+	"bufio"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/tphoney/best_practice/outputter"
+	"github.com/tphoney/best_practice/outputter/buildmaker"
+	"github.com/tphoney/best_practice/outputter/dronebuildanalysis"
+	"github.com/tphoney/best_practice/scanner"
+	"github.com/tphoney/best_practice/types"
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	checksumFile = "sha256sums.txt"
+	// releaseTag is the tag being built, it works the same in every build system
+	releaseTag = "$(git describe --tags --exact-match)"
+)
+
+var (
+	// goreleaserConfigNames are the config files goreleaser looks for, in its order.
+	goreleaserConfigNames = []string{".goreleaser.yml", ".goreleaser.yaml", "goreleaser.yml", "goreleaser.yaml"}
+	koConfigNames         = []string{".ko.yaml", ".ko.yml"}
+	// releaseRefs limits the release steps to tags.
+	releaseRefs = []string{"refs/tags/*"}
+	// outputPattern finds the file go build writes, eg '-o release/linux-amd64-plugin'.
+	outputPattern = regexp.MustCompile(`\s-o[\s=]+["']?([^\s"';]+)`)
+	// stampPattern finds version stamping, eg '-ldflags "-X main.version=v1.0.0"'.
+	stampPattern = regexp.MustCompile(`-ldflags[\s=]+["']?[^\n]*-X[\s=]`)
+)
+
+type (
+	// releaseTooling is what the repository uses to build its releases.
+	releaseTooling struct {
+		goreleaser string
+		// giteaURL is set when goreleaser publishes to gitea.
+		giteaURL string
+		ko       string
+		scripts  []buildScript
+	}
+
+	// buildScript is a shell script that cross compiles the binaries, outputs are the folders it writes them to.
+	buildScript struct {
+		file    string
+		outputs []string
+		stamped bool
+	}
+
+	goreleaserConfig struct {
+		GiteaURLs struct {
+			API string `yaml:"api"`
+		} `yaml:"gitea_urls"`
+	}
+
+	// releaseHost is where the releases are uploaded, url is only set for gitea.
+	releaseHost struct {
+		gitea bool
+		url   string
+	}
+)
+
+// findReleaseTooling looks for goreleaser and ko configs in the repository root, and for shell scripts that set GOOS
+// and GOARCH for go build.
+func findReleaseTooling(workingDir string) (tooling releaseTooling) {
+	for _, name := range goreleaserConfigNames {
+		if content, err := os.ReadFile(filepath.Join(workingDir, name)); err == nil {
+			tooling.goreleaser = name
+			var config goreleaserConfig
+			if err = yaml.Unmarshal(content, &config); err != nil {
+				fmt.Printf("error reading goreleaser config '%s': %s\n", name, err)
+			}
+			tooling.giteaURL = strings.TrimSuffix(strings.TrimSuffix(config.GiteaURLs.API, "/"), "/api/v1")
+			break
+		}
+	}
+	for _, name := range koConfigNames {
+		if _, err := os.Stat(filepath.Join(workingDir, name)); err == nil {
+			tooling.ko = name
+			break
+		}
+	}
+	matches, err := scanner.FindMatchingFiles(workingDir, "*.sh", true)
+	if err != nil {
+		return tooling
+	}
+	for _, match := range matches {
+		file, err := filepath.Rel(workingDir, match)
+		if err != nil || ignoredFolder(filepath.Dir(file)) {
+			continue
+		}
+		content, err := os.ReadFile(match)
+		if err != nil {
+			continue
+		}
+		text := string(content)
+		if !strings.Contains(text, "GOOS") || !strings.Contains(text, "GOARCH") || !strings.Contains(text, "go build") {
+			continue
+		}
+		script := buildScript{file: filepath.ToSlash(file), stamped: stampPattern.MatchString(text)}
+		for _, output := range outputPattern.FindAllStringSubmatch(text, -1) {
+			// binaries named after a loop variable still end up in the same folder
+			folder := path.Dir(output[1])
+			if folder != "." && !strings.Contains(folder, "$") && !slices.Contains(script.outputs, folder) {
+				script.outputs = append(script.outputs, folder)
+			}
+		}
+		sort.Strings(script.outputs)
+		tooling.scripts = append(tooling.scripts, script)
+	}
+	return tooling
+}
+
+// findReleaseHost reads the origin remote from the git config, releases go to gitea for gitea, forgejo and codeberg
+// remotes and to github otherwise.
+func findReleaseHost(workingDir string) (host releaseHost) {
+	file, err := os.Open(filepath.Join(workingDir, ".git", "config"))
+	if err != nil {
+		return host
+	}
+	defer file.Close()
+	origin := false
+	lines := bufio.NewScanner(file)
+	for lines.Scan() {
+		line := strings.TrimSpace(lines.Text())
+		if strings.HasPrefix(line, "[") {
+			origin = line == `[remote "origin"]`
+			continue
+		}
+		key, value, found := strings.Cut(line, "=")
+		if !origin || !found || strings.TrimSpace(key) != "url" {
+			continue
+		}
+		hostname := remoteHost(strings.TrimSpace(value))
+		for _, name := range []string{"gitea", "forgejo", "codeberg"} {
+			if strings.Contains(hostname, name) {
+				return releaseHost{gitea: true, url: "https://" + hostname}
+			}
+		}
+		return host
+	}
+	return host
+}
+
+// remoteHost returns the host of a git remote, either a url or the scp like 'git@host:owner/repo.git'.
+func remoteHost(remote string) string {
+	if parsed, err := url.Parse(remote); err == nil && parsed.Host != "" {
+		return parsed.Hostname()
+	}
+	if _, rest, found := strings.Cut(remote, "@"); found {
+		remote = rest
+	}
+	host, _, _ := strings.Cut(remote, ":")
+	return host
+}
+
+// versionVariable finds a package level string variable called version in the main packages, it is what the
+// binaries are stamped with. The result is the flag for go build, eg 'main.version'.
+func versionVariable(workingDir string, mod *workspaceModule, mains []mainPackage) string {
+	fileSet := token.NewFileSet()
+	for i := range mains {
+		files, err := filepath.Glob(filepath.Join(workingDir, mod.dir, mains[i].dir, "*.go"))
+		if err != nil {
+			continue
+		}
+		for _, file := range files {
+			if strings.HasSuffix(file, "_test.go") {
+				continue
+			}
+			parsed, err := parser.ParseFile(fileSet, file, nil, parser.SkipObjectResolution)
+			if err != nil || parsed.Name.Name != "main" {
+				continue
+			}
+			for _, decl := range parsed.Decls {
+				gen, ok := decl.(*ast.GenDecl)
+				if !ok || gen.Tok != token.VAR {
+					continue
+				}
+				for _, spec := range gen.Specs {
+					for _, name := range spec.(*ast.ValueSpec).Names {
+						if strings.EqualFold(name.Name, "version") {
+							return "main." + name.Name
+						}
+					}
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// releaseCheck builds the releases on tags with the tooling the repository has. goreleaser publishes the release
+// itself, binaries from build scripts are uploaded with their checksums by the github or gitea release plugin.
+func (sc *scannerConfig) releaseCheck(mod *workspaceModule) (outputResults []types.Scanlet) {
+	tooling := findReleaseTooling(sc.workingDirectory)
+	host := findReleaseHost(sc.workingDirectory)
+	if tooling.goreleaser != "" {
+		secrets := map[string]buildmaker.Secret{"GITHUB_TOKEN": "github_token"}
+		if tooling.giteaURL != "" {
+			secrets = map[string]buildmaker.Secret{"GITEA_TOKEN": "gitea_token"}
+		}
+		outputResults = append(outputResults, types.Scanlet{
+			Name:           ReleaseCheck,
+			ScannerFamily:  Name,
+			Description:    fmt.Sprintf("release with goreleaser on tags, using %s", tooling.goreleaser),
+			OutputRenderer: buildmaker.Name,
+			Spec: buildmaker.OutputFields{
+				Build: buildmaker.Build{
+					Name:     "goreleaser",
+					Phase:    buildmaker.PhaseRelease,
+					Image:    "goreleaser/goreleaser",
+					Commands: []string{"goreleaser release --clean"},
+					Secrets:  secrets,
+					When:     &buildmaker.Condition{Ref: releaseRefs},
+				},
+				CLI:     "goreleaser release --snapshot --clean",
+				HelpURL: "https://goreleaser.com/ci/",
+			},
+		})
+	}
+	mains := mainPackages(sc.workingDirectory, mod)
+	if tooling.ko != "" && len(mains) > 0 {
+		paths := make([]string, 0, len(mains))
+		for i := range mains {
+			paths = append(paths, mains[i].buildPath())
+		}
+		build := fmt.Sprintf("ko build --base-import-paths --tags=%s,latest %s", releaseTag, strings.Join(paths, " "))
+		outputResults = append(outputResults, types.Scanlet{
+			Name:           ReleaseCheck,
+			ScannerFamily:  Name,
+			Description:    fmt.Sprintf("publish images with ko on tags, using %s", tooling.ko),
+			OutputRenderer: buildmaker.Name,
+			Spec: buildmaker.OutputFields{
+				Build: buildmaker.Build{
+					Name:            "ko build",
+					Phase:           buildmaker.PhaseRelease,
+					Cache:           buildmaker.CacheGo,
+					Image:           "golang:1",
+					Language:        buildmaker.LanguageGo,
+					LanguageVersion: mod.goVersion,
+					Commands: []string{
+						"go install github.com/google/ko@latest",
+						`echo "$DOCKER_PASSWORD" | ko login "$(echo "$KO_DOCKER_REPO" | cut -d/ -f1)" --username "$DOCKER_USERNAME" --password-stdin`,
+						build,
+					},
+					Secrets: map[string]buildmaker.Secret{
+						"KO_DOCKER_REPO":  "ko_docker_repo",
+						"DOCKER_USERNAME": "docker_username",
+						"DOCKER_PASSWORD": "docker_password",
+					},
+					When: &buildmaker.Condition{Ref: releaseRefs},
+				},
+				CLI:     fmt.Sprintf("ko build --local %s", strings.Join(paths, " ")),
+				HelpURL: "https://ko.build/configuration/",
+			},
+		})
+	}
+	if tooling.goreleaser != "" {
+		// goreleaser builds the binaries, the scripts are for local builds
+		return outputResults
+	}
+	for i := range tooling.scripts {
+		outputResults = append(outputResults, sc.buildScriptCheck(mod, mains, &tooling.scripts[i], host)...)
+	}
+	return outputResults
+}
+
+// buildScriptCheck runs a cross compiling script on tags, then uploads what it built with a checksum file.
+func (sc *scannerConfig) buildScriptCheck(mod *workspaceModule, mains []mainPackage, script *buildScript, host releaseHost) (outputResults []types.Scanlet) {
+	name := "build release binaries"
+	if script.file != path.Join("scripts", "build.sh") {
+		name = fmt.Sprintf("build release binaries %s", script.file)
+	}
+	commands := []string{"sh " + script.file}
+	var files []string
+	for _, folder := range script.outputs {
+		commands = append(commands, fmt.Sprintf("(cd %s && sha256sum * > %s)", folder, checksumFile))
+		files = append(files, folder+"/*")
+	}
+	outputResults = append(outputResults, types.Scanlet{
+		Name:           ReleaseCheck,
+		ScannerFamily:  Name,
+		Description:    fmt.Sprintf("cross compile the release binaries with %s on tags", script.file),
+		OutputRenderer: buildmaker.Name,
+		Spec: buildmaker.OutputFields{
+			Build: buildmaker.Build{
+				Name:            name,
+				Phase:           buildmaker.PhasePackage,
+				Cache:           buildmaker.CacheGo,
+				Image:           "golang:1",
+				Language:        buildmaker.LanguageGo,
+				LanguageVersion: mod.goVersion,
+				Commands:        commands,
+				Artifacts:       files,
+				When:            &buildmaker.Condition{Ref: releaseRefs},
+			},
+			CLI:     "sh " + script.file,
+			HelpURL: "https://go.dev/doc/install/source#environment",
+		},
+	})
+	if len(files) == 0 {
+		outputResults = append(outputResults, types.Scanlet{
+			Name:           ReleaseCheck,
+			ScannerFamily:  Name,
+			Description:    fmt.Sprintf("%s does not write the binaries to a folder, use 'go build -o release/<binary>' so they can be uploaded to a release", script.file),
+			OutputRenderer: outputter.DroneBuildAnalysis,
+			Spec: dronebuildanalysis.OutputFields{
+				HelpURL: "https://pkg.go.dev/cmd/go#hdr-Compile_packages_and_dependencies",
+			},
+		})
+	} else {
+		upload := buildmaker.Build{
+			Name:     "publish release to github",
+			Phase:    buildmaker.PhaseRelease,
+			Image:    "plugins/github-release",
+			Settings: map[string]interface{}{"files": files, "api_key": buildmaker.Secret("github_token")},
+			When:     &buildmaker.Condition{Ref: releaseRefs},
+		}
+		helpURL := "https://plugins.drone.io/plugins/github-release"
+		if host.gitea {
+			upload.Name = "publish release to gitea"
+			upload.Image = "plugins/gitea-release"
+			upload.Settings = map[string]interface{}{"base_url": host.url, "files": files, "api_key": buildmaker.Secret("gitea_token")}
+			helpURL = "https://plugins.drone.io/plugins/gitea-release"
+		}
+		outputResults = append(outputResults, types.Scanlet{
+			Name:           ReleaseCheck,
+			ScannerFamily:  Name,
+			Description:    fmt.Sprintf("upload the binaries built by %s and their checksums to the release", script.file),
+			OutputRenderer: buildmaker.Name,
+			Spec: buildmaker.OutputFields{
+				Build:   upload,
+				HelpURL: helpURL,
+			},
+		})
+	}
+	if !script.stamped {
+		variable := versionVariable(sc.workingDirectory, mod, mains)
+		description := fmt.Sprintf("%s does not stamp the version into the binaries", script.file)
+		if variable == "" {
+			variable = "main.version"
+			description += ", add a 'var version = \"dev\"' to the main package and set it with -ldflags"
+		} else {
+			description += fmt.Sprintf(", set %s with -ldflags", variable)
+		}
+		outputResults = append(outputResults, types.Scanlet{
+			Name:           ReleaseCheck,
+			ScannerFamily:  Name,
+			Description:    description,
+			OutputRenderer: outputter.DroneBuildAnalysis,
+			Spec: dronebuildanalysis.OutputFields{
+				Command: fmt.Sprintf(`go build -ldflags "-X %s=%s"`, variable, releaseTag),
+				HelpURL: "https://pkg.go.dev/cmd/link",
+			},
+		})
+	}
+	return outputResults
+}
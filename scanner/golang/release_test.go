@@ -0,0 +1,96 @@
+package golang
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindReleaseTooling(t *testing.T) {
+	workingDir := writeFiles(t, map[string]string{
+		"goreleaser.yml":  "gitea_urls:\n  api: https://gitea.example.com/api/v1/\n",
+		".goreleaser.yml": "project_name: app\n",
+		".ko.yml":         "defaultBaseImage: cgr.dev/chainguard/static\n",
+		"scripts/build.sh": `#!/bin/sh
+for arch in amd64 arm64; do
+  GOOS=linux GOARCH=$arch go build -ldflags "-X main.version=$VERSION" -o release/linux/$arch/app ./cmd/app
+  GOOS=windows GOARCH=$arch go build -o=dist/app-$arch.exe ./cmd/app
+  GOOS=darwin GOARCH=$arch go build -o app-darwin ./cmd/app
+done
+`,
+		// scripts that do not cross compile, or in folders go ignores, are not build scripts
+		"scripts/test.sh":     "go test ./...\n",
+		"vendor/x/build.sh":   "GOOS=linux GOARCH=amd64 go build -o out/x .\n",
+		"hack/build-local.sh": "GOOS=linux GOARCH=amd64 go build -o $OUT/app .\n",
+	})
+	tooling := findReleaseTooling(workingDir)
+	want := releaseTooling{
+		// goreleaser reads the first config in its order
+		goreleaser: ".goreleaser.yml",
+		ko:         ".ko.yml",
+		scripts: []buildScript{
+			{file: "hack/build-local.sh"},
+			// folders named after a variable are not known until the script runs
+			{file: "scripts/build.sh", outputs: []string{"dist"}, stamped: true},
+		},
+	}
+	if !reflect.DeepEqual(tooling, want) {
+		t.Errorf("got release tooling %+v, want %+v", tooling, want)
+	}
+}
+
+func TestFindReleaseToolingGitea(t *testing.T) {
+	workingDir := writeFiles(t, map[string]string{".goreleaser.yaml": "gitea_urls:\n  api: https://gitea.example.com/api/v1/\n"})
+	if tooling := findReleaseTooling(workingDir); tooling.goreleaser != ".goreleaser.yaml" || tooling.giteaURL != "https://gitea.example.com" {
+		t.Errorf("got release tooling %+v", tooling)
+	}
+}
+
+func TestFindReleaseHost(t *testing.T) {
+	tests := map[string]releaseHost{
+		"":                                 {},
+		"git@github.com:owner/repo.git":    {},
+		"https://codeberg.org/owner/repo":  {gitea: true, url: "https://codeberg.org"},
+		"git@gitea.example.com:owner/repo": {gitea: true, url: "https://gitea.example.com"},
+	}
+	for remote, want := range tests {
+		config := "[core]\n\tbare = false\n[remote \"upstream\"]\n\turl = https://gitea.com/upstream/repo\n"
+		if remote != "" {
+			config += "[remote \"origin\"]\n\turl = " + remote + "\n\tfetch = +refs/heads/*:refs/remotes/origin/*\n"
+		}
+		workingDir := writeFiles(t, map[string]string{".git/config": config})
+		// only the origin remote is where the releases go
+		if got := findReleaseHost(workingDir); got != want {
+			t.Errorf("findReleaseHost(%s) = %+v, want %+v", remote, got, want)
+		}
+	}
+}
+
+func TestRemoteHost(t *testing.T) {
+	tests := map[string]string{
+		"https://github.com/owner/repo.git":    "github.com",
+		"ssh://git@gitea.example.com:2222/o/r": "gitea.example.com",
+		"git@codeberg.org:owner/repo.git":      "codeberg.org",
+		"gitea.example.com:owner/repo.git":     "gitea.example.com",
+	}
+	for remote, want := range tests {
+		if got := remoteHost(remote); got != want {
+			t.Errorf("remoteHost(%s) = '%s', want '%s'", remote, got, want)
+		}
+	}
+}
+
+func TestVersionVariable(t *testing.T) {
+	workingDir := writeFiles(t, map[string]string{
+		"cmd/app/main.go":      "package main\n\nvar (\n\tcommit  string\n\tVersion = \"dev\"\n)\n\nfunc main() {}\n",
+		"cmd/app/main_test.go": "package main\n\nvar version string\n",
+		"cmd/tool/main.go":     "package main\n\nfunc main() {}\n",
+	})
+	mod := &workspaceModule{dir: "."}
+	mains := []mainPackage{{dir: "cmd/tool", binary: "tool"}, {dir: "cmd/app", binary: "app"}}
+	if got := versionVariable(workingDir, mod, mains); got != "main.Version" {
+		t.Errorf("versionVariable = '%s', want 'main.Version'", got)
+	}
+	if got := versionVariable(workingDir, mod, mains[:1]); got != "" {
+		t.Errorf("versionVariable = '%s' without a version variable", got)
+	}
+}
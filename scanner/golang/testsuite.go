@@ -0,0 +1,293 @@
+package golang
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build/constraint"
+	"go/parser"
+	"go/token"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/tphoney/best_practice/outputter"
+	"github.com/tphoney/best_practice/outputter/buildmaker"
+	"github.com/tphoney/best_practice/outputter/dronebuildanalysis"
+	"github.com/tphoney/best_practice/types"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	fuzzTime   = "30s"
+	benchCount = 6
+	// benchBase is where the base branch is checked out to run its benchmarks
+	benchBase = "/tmp/bench-base"
+)
+
+// integrationTags are the build tags that keep slow tests out of 'go test ./...'.
+var integrationTags = []string{"integration", "e2e"}
+
+// benchBaseVariables hold the branch a pull request targets in drone and harness, github, gitlab and jenkins.
+var benchBaseVariables = []string{"$DRONE_TARGET_BRANCH", "$GITHUB_BASE_REF", "$CI_MERGE_REQUEST_TARGET_BRANCH_NAME", "$CHANGE_TARGET"}
+
+type (
+	// testSuite is what the test files of a module contain, packages are build paths eg './parser'.
+	testSuite struct {
+		// unit is set when there are test files that go test runs without tags.
+		unit        bool
+		fuzzTargets []fuzzTarget
+		benchmarks  []string
+		testMains   []string
+		// tags are the integration tags the test files are built with.
+		tags []string
+	}
+
+	fuzzTarget struct {
+		pkg  string
+		name string
+	}
+)
+
+// findTests reads the test functions of every test file in the module. Fuzz targets and benchmarks behind build
+// tags are left out, they are not run by the steps we suggest.
+func findTests(workingDir string, mod *workspaceModule) (suite testSuite) {
+	moduleDir := filepath.Join(workingDir, mod.dir)
+	fileSet := token.NewFileSet()
+	for _, file := range moduleFiles(workingDir, mod.dir, "*_test.go") {
+		parsed, err := parser.ParseFile(fileSet, file, nil, parser.ParseComments|parser.SkipObjectResolution)
+		if err != nil {
+			continue
+		}
+		dir, err := filepath.Rel(moduleDir, filepath.Dir(file))
+		if err != nil {
+			continue
+		}
+		pkg := (&mainPackage{dir: filepath.ToSlash(dir)}).buildPath()
+		tags, constrained := buildTags(parsed)
+		for _, tag := range tags {
+			if slices.Contains(integrationTags, tag) && !slices.Contains(suite.tags, tag) {
+				suite.tags = append(suite.tags, tag)
+			}
+		}
+		if !constrained {
+			suite.unit = true
+		}
+		for _, decl := range parsed.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Recv != nil {
+				continue
+			}
+			switch testingParam(fn) {
+			case "F":
+				if !constrained && isTestName(fn.Name.Name, "Fuzz") {
+					suite.fuzzTargets = append(suite.fuzzTargets, fuzzTarget{pkg: pkg, name: fn.Name.Name})
+				}
+			case "B":
+				if !constrained && isTestName(fn.Name.Name, "Benchmark") && !slices.Contains(suite.benchmarks, pkg) {
+					suite.benchmarks = append(suite.benchmarks, pkg)
+				}
+			case "M":
+				if fn.Name.Name == "TestMain" && !slices.Contains(suite.testMains, pkg) {
+					suite.testMains = append(suite.testMains, pkg)
+				}
+			}
+		}
+	}
+	sort.Strings(suite.benchmarks)
+	sort.Strings(suite.testMains)
+	sort.Strings(suite.tags)
+	return suite
+}
+
+// buildTags returns the tags in the build constraints of a file, constrained is set when go test does not build the
+// file without extra tags on linux/amd64.
+func buildTags(file *ast.File) (tags []string, constrained bool) {
+	for _, group := range file.Comments {
+		if group.Pos() > file.Package {
+			break
+		}
+		for _, comment := range group.List {
+			if !constraint.IsGoBuild(comment.Text) && !constraint.IsPlusBuild(comment.Text) {
+				continue
+			}
+			expr, err := constraint.Parse(comment.Text)
+			if err != nil {
+				continue
+			}
+			tags = append(tags, constraintTags(expr)...)
+			if !expr.Eval(defaultTag) {
+				constrained = true
+			}
+		}
+	}
+	return tags, constrained
+}
+
+func constraintTags(expr constraint.Expr) []string {
+	switch e := expr.(type) {
+	case *constraint.TagExpr:
+		return []string{e.Tag}
+	case *constraint.NotExpr:
+		return constraintTags(e.X)
+	case *constraint.AndExpr:
+		return append(constraintTags(e.X), constraintTags(e.Y)...)
+	case *constraint.OrExpr:
+		return append(constraintTags(e.X), constraintTags(e.Y)...)
+	}
+	return nil
+}
+
+// defaultTag is true for the tags set when building on a linux/amd64 runner.
+func defaultTag(tag string) bool {
+	return tag == "linux" || tag == "amd64" || tag == "unix" || tag == "gc" || tag == "cgo" || strings.HasPrefix(tag, "go1.")
+}
+
+// testingParam returns 'T', 'B', 'F' or 'M' for functions that take a single *testing.X parameter.
+func testingParam(fn *ast.FuncDecl) string {
+	params := fn.Type.Params.List
+	if len(params) != 1 || len(params[0].Names) > 1 {
+		return ""
+	}
+	star, ok := params[0].Type.(*ast.StarExpr)
+	if !ok {
+		return ""
+	}
+	selector, ok := star.X.(*ast.SelectorExpr)
+	if !ok {
+		return ""
+	}
+	if pkg, ok := selector.X.(*ast.Ident); !ok || pkg.Name != "testing" {
+		return ""
+	}
+	return selector.Sel.Name
+}
+
+// isTestName follows the go test rule, the prefix is followed by nothing or by a character that is not lower case.
+func isTestName(name, prefix string) bool {
+	if len(name) < len(prefix) || name[:len(prefix)] != prefix {
+		return false
+	}
+	if len(name) == len(prefix) {
+		return true
+	}
+	next := name[len(prefix)]
+	return next < 'a' || next > 'z'
+}
+
+// fuzzCheck runs each fuzz target for a short time, go test can only fuzz one target at a time.
+func (sc *scannerConfig) fuzzCheck(mod *workspaceModule, suite *testSuite) (outputResults []types.Scanlet) {
+	if len(suite.fuzzTargets) == 0 {
+		return outputResults
+	}
+	commands := make([]string, 0, len(suite.fuzzTargets))
+	for _, target := range suite.fuzzTargets {
+		commands = append(commands, fmt.Sprintf("go test -run='^$' -fuzz='^%s$' -fuzztime=%s %s", target.name, fuzzTime, target.pkg))
+	}
+	return append(outputResults, types.Scanlet{
+		Name:           FuzzCheck,
+		ScannerFamily:  Name,
+		Description:    mod.description(fmt.Sprintf("run each fuzz target for %s", fuzzTime)),
+		OutputRenderer: buildmaker.Name,
+		Spec: buildmaker.OutputFields{
+			Build: buildmaker.Build{
+				Name:             mod.stepName("go fuzz tests"),
+				Phase:            buildmaker.PhaseTest,
+				Cache:            buildmaker.CacheGo,
+				Image:            "golang:1",
+				Language:         buildmaker.LanguageGo,
+				LanguageVersion:  mod.goVersion,
+				LanguageVersions: mod.goVersions,
+				Commands:         mod.commands(commands...),
+			},
+			CLI:     mod.cli(commands[0]),
+			HelpURL: "https://go.dev/doc/security/fuzz/",
+		},
+	})
+}
+
+// benchmarkCheck compares the benchmarks of a pull request with the branch it targets, benchstat reports the changes.
+func (sc *scannerConfig) benchmarkCheck(mod *workspaceModule, suite *testSuite) (outputResults []types.Scanlet) {
+	if len(suite.benchmarks) == 0 {
+		return outputResults
+	}
+	packages := strings.Join(suite.benchmarks, " ")
+	bench := fmt.Sprintf("go test -run='^$' -bench=. -benchmem -count=%d %s", benchCount, packages)
+	base := benchBase
+	if mod.dir != "." {
+		base += "-" + strings.ReplaceAll(mod.dir, "/", "-")
+	}
+	return append(outputResults, types.Scanlet{
+		Name:           BenchCheck,
+		ScannerFamily:  Name,
+		Description:    mod.description("compare the benchmarks of pull requests with their base branch"),
+		OutputRenderer: buildmaker.Name,
+		Spec: buildmaker.OutputFields{
+			Build: buildmaker.Build{
+				Name:             mod.stepName("go benchmarks"),
+				Phase:            buildmaker.PhaseTest,
+				Cache:            buildmaker.CacheGo,
+				Image:            "golang:1",
+				Language:         buildmaker.LanguageGo,
+				LanguageVersion:  mod.goVersion,
+				LanguageVersions: mod.goVersions,
+				When:             &buildmaker.Condition{Event: []string{"pull_request"}},
+				Commands: mod.commands(
+					"go install golang.org/x/perf/cmd/benchstat@latest",
+					bench+" > bench-new.txt",
+					// each ci system names the target branch of a pull request differently, the first one set is used
+					"git fetch --depth=1 origin \"$(echo "+strings.Join(benchBaseVariables, " ")+" main | cut -d' ' -f1)\"",
+					"git worktree add "+base+" FETCH_HEAD",
+					// the base branch may not have the benchmarks yet
+					fmt.Sprintf("(cd %s && %s) > bench-old.txt || true", path.Join(base, mod.dir), bench),
+					"benchstat bench-old.txt bench-new.txt",
+				),
+				Artifacts: []string{path.Join(mod.dir, "bench-new.txt")},
+			},
+			CLI:     mod.cli(bench),
+			HelpURL: "https://pkg.go.dev/golang.org/x/perf/cmd/benchstat",
+		},
+	})
+}
+
+// integrationCheck runs the tests behind integration build tags in their own step. Packages with a TestMain usually
+// set something up for their tests, we suggest moving those behind a tag.
+func (sc *scannerConfig) integrationCheck(mod *workspaceModule, suite *testSuite) (outputResults []types.Scanlet) {
+	if len(suite.tags) > 0 {
+		command := fmt.Sprintf("go test -race -count=1 -tags=%s ./...", strings.Join(suite.tags, ","))
+		return append(outputResults, types.Scanlet{
+			Name:           IntegrationCheck,
+			ScannerFamily:  Name,
+			Description:    mod.description(fmt.Sprintf("run the go tests tagged %s in their own step", strings.Join(suite.tags, ", "))),
+			OutputRenderer: buildmaker.Name,
+			Spec: buildmaker.OutputFields{
+				Build: buildmaker.Build{
+					Name:             mod.stepName("go integration tests"),
+					Phase:            buildmaker.PhaseTest,
+					Cache:            buildmaker.CacheGo,
+					Image:            "golang:1",
+					Language:         buildmaker.LanguageGo,
+					LanguageVersion:  mod.goVersion,
+					LanguageVersions: mod.goVersions,
+					Commands:         mod.commands(command),
+				},
+				CLI:     mod.cli(command),
+				HelpURL: "https://pkg.go.dev/cmd/go#hdr-Build_constraints",
+			},
+		})
+	}
+	if len(suite.testMains) > 0 {
+		outputResults = append(outputResults, types.Scanlet{
+			Name:          IntegrationCheck,
+			ScannerFamily: Name,
+			Description: mod.description(fmt.Sprintf("packages %s have a TestMain", strings.Join(suite.testMains, ", "))) +
+				", move the tests that need its set up behind an 'integration' build tag and run them in their own step",
+			OutputRenderer: outputter.DroneBuildAnalysis,
+			Spec: dronebuildanalysis.OutputFields{
+				HelpURL: "https://pkg.go.dev/testing#hdr-Main",
+				Command: mod.cli("go test -tags=integration ./..."),
+			},
+		})
+	}
+	return outputResults
+}
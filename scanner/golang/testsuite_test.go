@@ -0,0 +1,140 @@
+package golang
+
+import (
+	"go/parser"
+	"go/token"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tphoney/best_practice/outputter/buildmaker"
+)
+
+func TestFindTests(t *testing.T) {
+	workingDir := writeFiles(t, map[string]string{
+		"go.mod": "module example.com/app\n\ngo 1.21\n",
+		"app_test.go": `package app
+
+import "testing"
+
+func TestMain(m *testing.M) {}
+
+func FuzzParse(f *testing.F) {}
+
+// not a fuzz target, the name continues in lower case
+func Fuzzy(f *testing.F) {}
+
+func BenchmarkParse(b *testing.B) {}
+`,
+		"parser/parser_test.go": `package parser
+
+import "testing"
+
+func BenchmarkLex(b *testing.B) {}
+
+func BenchmarkParse(b *testing.B) {}
+`,
+		"store/store_test.go": `//go:build integration && !windows
+
+package store
+
+import "testing"
+
+func TestMain(m *testing.M) {}
+
+func BenchmarkStore(b *testing.B) {}
+`,
+		"api/api_test.go": `//go:build e2e || linux
+
+package api
+`,
+		"vendor/lib/lib_test.go": "package lib\n\nimport \"testing\"\n\nfunc FuzzLib(f *testing.F) {}\n",
+		"tools/go.mod":           "module example.com/tools\n",
+		"tools/tools_test.go":    "package tools\n\nimport \"testing\"\n\nfunc BenchmarkTool(b *testing.B) {}\n",
+	})
+	suite := findTests(workingDir, readWorkspaceModule(workingDir, "."))
+	want := testSuite{
+		unit:        true,
+		fuzzTargets: []fuzzTarget{{pkg: ".", name: "FuzzParse"}},
+		// benchmarks behind build tags are not run
+		benchmarks: []string{".", "./parser"},
+		testMains:  []string{".", "./store"},
+		// the api tests also run without tags on linux, but the tag is still used
+		tags: []string{"e2e", "integration"},
+	}
+	if !reflect.DeepEqual(suite, want) {
+		t.Errorf("got test suite %+v, want %+v", suite, want)
+	}
+}
+
+func TestBuildTags(t *testing.T) {
+	tests := []struct {
+		header      string
+		tags        []string
+		constrained bool
+	}{
+		{header: "package a\n"},
+		{header: "//go:build integration\n\npackage a\n", tags: []string{"integration"}, constrained: true},
+		{header: "//go:build linux && go1.21\n\npackage a\n", tags: []string{"linux", "go1.21"}},
+		{header: "//go:build !windows\n\npackage a\n", tags: []string{"windows"}},
+		{header: "// +build e2e\n\npackage a\n", tags: []string{"e2e"}, constrained: true},
+		// a build comment after the package clause is not a constraint
+		{header: "package a\n\n//go:build integration\n"},
+	}
+	for _, test := range tests {
+		file, err := parser.ParseFile(token.NewFileSet(), "a_test.go", test.header, parser.ParseComments)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tags, constrained := buildTags(file)
+		if !reflect.DeepEqual(tags, test.tags) || constrained != test.constrained {
+			t.Errorf("buildTags(%q) = %v %t, want %v %t", test.header, tags, constrained, test.tags, test.constrained)
+		}
+	}
+}
+
+func TestIsTestName(t *testing.T) {
+	tests := map[string]bool{"Benchmark": true, "BenchmarkParse": true, "Benchmark_parse": true, "Benchmarks": false, "Bench": false}
+	for name, want := range tests {
+		if got := isTestName(name, "Benchmark"); got != want {
+			t.Errorf("isTestName(%s) = %t, want %t", name, got, want)
+		}
+	}
+}
+
+func TestBenchmarkCheck(t *testing.T) {
+	sc := &scannerConfig{}
+	mod := &workspaceModule{dir: "api", goVersion: "1.21"}
+	results := sc.benchmarkCheck(mod, &testSuite{benchmarks: []string{"./parser"}})
+	if len(results) != 1 {
+		t.Fatalf("got results %+v", results)
+	}
+	commands := results[0].Spec.(buildmaker.OutputFields).Build.Commands
+	var fetch string
+	for _, command := range commands {
+		if strings.HasPrefix(command, "git fetch") {
+			fetch = command
+		}
+	}
+	if !strings.Contains(strings.Join(commands, "\n"), "git worktree add "+benchBase+"-api FETCH_HEAD") {
+		t.Errorf("the base branch of the module is not checked out:\n%s", strings.Join(commands, "\n"))
+	}
+	// the branch is the target of the pull request, or main when the ci system does not set one
+	branch := strings.TrimSuffix(strings.TrimPrefix(fetch, `git fetch --depth=1 origin "`), `"`)
+	for env, want := range map[string]string{"": "main", "GITHUB_BASE_REF": "develop", "DRONE_TARGET_BRANCH": "develop"} {
+		cmd := exec.Command("sh", "-c", "echo "+branch)
+		cmd.Env = []string{"PATH=" + os.Getenv("PATH")}
+		if env != "" {
+			cmd.Env = append(cmd.Env, env+"=develop")
+		}
+		output, err := cmd.Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.TrimSpace(string(output)); got != want {
+			t.Errorf("with %s set the benchmarks are compared with '%s', want '%s'", env, got, want)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package golang
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/tphoney/best_practice/outputter/buildmaker"
+	"github.com/tphoney/best_practice/scanner"
+	"github.com/tphoney/best_practice/types"
+	"golang.org/x/mod/modfile"
+)
+
+const goWorkLocation = "go.work"
+
+// workspaceModule is a module in the repository, the checks are run for each of them.
+type workspaceModule struct {
+	// dir is the module folder relative to the working directory, '.' for the root module.
+	dir string
+	// module is nil when the mod file can not be parsed.
+	module     *goModule
+	goVersion  string
+	goVersions []string
+}
+
+// findModules lists the modules in the go.work file, or every mod file in the repository when there is no workspace.
+// The root module comes first.
+func findModules(workingDir string) (dirs []string, workspace bool, err error) {
+	path := filepath.Join(workingDir, goWorkLocation)
+	if content, err := os.ReadFile(path); err == nil {
+		work, err := modfile.ParseWork(path, content, nil)
+		if err != nil {
+			return nil, true, fmt.Errorf("error parsing '%s': %s", path, err)
+		}
+		for _, use := range work.Use {
+			dirs = append(dirs, filepath.ToSlash(filepath.Clean(use.Path)))
+		}
+		sort.Strings(dirs)
+		return dirs, true, nil
+	}
+	mods, err := scanner.FindMatchingFiles(workingDir, goModLocation, true)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, mod := range mods {
+		dir, err := filepath.Rel(workingDir, filepath.Dir(mod))
+		if err != nil || ignoredFolder(dir) {
+			continue
+		}
+		dirs = append(dirs, filepath.ToSlash(dir))
+	}
+	sort.Strings(dirs)
+	return dirs, false, nil
+}
+
+// ignoredFolder is true for folders the go command ignores, and for vendored code.
+func ignoredFolder(dir string) bool {
+	for _, part := range strings.Split(filepath.ToSlash(dir), "/") {
+		if part == "vendor" || part == "testdata" || strings.HasPrefix(part, "_") {
+			return true
+		}
+	}
+	return false
+}
+
+// moduleFiles finds the files of a module, leaving out nested modules and the folders the go command ignores.
+func moduleFiles(workingDir, dir, pattern string) (files []string) {
+	moduleDir := filepath.Join(workingDir, dir)
+	matches, err := scanner.FindMatchingFiles(moduleDir, pattern, true)
+	if err != nil {
+		return files
+	}
+	nested := map[string]bool{}
+	for _, match := range matches {
+		folder, err := filepath.Rel(moduleDir, filepath.Dir(match))
+		if err != nil || ignoredFolder(folder) {
+			continue
+		}
+		if isNested(moduleDir, folder, nested) {
+			continue
+		}
+		files = append(files, match)
+	}
+	return files
+}
+
+// isNested is true when the folder, or one of its parents, holds another module. Results are kept in nested.
+func isNested(moduleDir, folder string, nested map[string]bool) bool {
+	if folder == "." {
+		return false
+	}
+	if found, checked := nested[folder]; checked {
+		return found
+	}
+	_, err := os.Stat(filepath.Join(moduleDir, folder, goModLocation))
+	found := err == nil || isNested(moduleDir, filepath.Dir(folder), nested)
+	nested[folder] = found
+	return found
+}
+
+func readWorkspaceModule(workingDir, dir string) *workspaceModule {
+	mod := &workspaceModule{dir: dir}
+	module, err := readGoModule(filepath.Join(workingDir, dir))
+	if err != nil {
+		// keep going, the other checks do not need the mod file parsed
+		fmt.Printf("%s\n", err)
+		return mod
+	}
+	mod.module = module
+	mod.goVersion, mod.goVersions = module.versions()
+	return mod
+}
+
+// useWorkspace tells each module which requirements go.work provides from disk.
+func useWorkspace(modules []*workspaceModule) {
+	paths := map[string]bool{}
+	for _, mod := range modules {
+		if mod.module != nil && mod.module.file.Module != nil {
+			paths[mod.module.file.Module.Mod.Path] = true
+		}
+	}
+	for _, mod := range modules {
+		if mod.module != nil {
+			mod.module.workspace = paths
+		}
+	}
+}
+
+// stepName tells apart the steps of each module, the root module keeps the plain name.
+func (m *workspaceModule) stepName(name string) string {
+	if m.dir == "." {
+		return name
+	}
+	return fmt.Sprintf("%s %s", name, m.dir)
+}
+
+// description says which module a check is for, when there is more than the root module.
+func (m *workspaceModule) description(description string) string {
+	if m.dir == "." {
+		return description
+	}
+	return fmt.Sprintf("%s in %s", description, m.dir)
+}
+
+// commands run the commands in the module folder.
+func (m *workspaceModule) commands(commands ...string) []string {
+	if m.dir == "." {
+		return commands
+	}
+	return append([]string{"cd " + m.dir}, commands...)
+}
+
+// cli is a command line run from the working directory.
+func (m *workspaceModule) cli(command string) string {
+	if m.dir == "." {
+		return command
+	}
+	return fmt.Sprintf("cd %s && %s", m.dir, command)
+}
+
+// workSyncCheck makes sure the requirements of the workspace modules match what 'go work sync' works out.
+func (sc *scannerConfig) workSyncCheck(modules []*workspaceModule) (outputResults []types.Scanlet) {
+	version, versions := "", []string(nil)
+	if len(modules) > 0 {
+		version, versions = modules[0].goVersion, modules[0].goVersions
+	}
+	return append(outputResults, types.Scanlet{
+		Name:           WorkSyncCheck,
+		ScannerFamily:  Name,
+		Description:    "run go work sync, to check the workspace modules are consistent",
+		OutputRenderer: buildmaker.Name,
+		Spec: buildmaker.OutputFields{
+			Build: buildmaker.Build{
+				Name:             "go work sync",
+				Phase:            buildmaker.PhaseDeps,
+				Cache:            buildmaker.CacheGo,
+				Image:            "golang:1",
+				Language:         buildmaker.LanguageGo,
+				LanguageVersion:  version,
+				LanguageVersions: versions,
+				Commands:         []string{"go work sync", `git diff --exit-code || (echo "go work sync changed the workspace modules" && exit 1)`},
+			},
+			CLI:     "go work sync",
+			HelpURL: "https://go.dev/ref/mod#go-work-sync",
+		},
+	})
+}
@@ -0,0 +1,63 @@
+package golang
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindModules(t *testing.T) {
+	files := map[string]string{
+		"go.mod":              "module example.com/app\n",
+		"tools/go.mod":        "module example.com/tools\n",
+		"api/v2/go.mod":       "module example.com/api/v2\n",
+		"vendor/x/go.mod":     "module example.com/x\n",
+		"testdata/mod/go.mod": "module example.com/testdata\n",
+		"_old/go.mod":         "module example.com/old\n",
+	}
+	dirs, workspace, err := findModules(writeFiles(t, files))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{".", "api/v2", "tools"}; workspace || !reflect.DeepEqual(dirs, want) {
+		t.Errorf("got modules %v %t, want %v", dirs, workspace, want)
+	}
+	// a workspace only has the modules it uses
+	files["go.work"] = "go 1.21\n\nuse (\n\t.\n\t./tools/\n)\n"
+	dirs, workspace, err = findModules(writeFiles(t, files))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{".", "tools"}; !workspace || !reflect.DeepEqual(dirs, want) {
+		t.Errorf("got workspace modules %v %t, want %v", dirs, workspace, want)
+	}
+	files["go.work"] = "go 1.21\nuse (\n"
+	if _, _, err = findModules(writeFiles(t, files)); err == nil {
+		t.Error("expected an error for a broken go.work")
+	}
+}
+
+func TestUseWorkspace(t *testing.T) {
+	workingDir := writeFiles(t, map[string]string{
+		"go.mod":     "module example.com/app\n\ngo 1.21\n\nrequire (\n\texample.com/lib v0.0.0\n\texample.com/other v1.0.0\n)\n",
+		"lib/go.mod": "module example.com/lib\n\ngo 1.21\n",
+	})
+	modules := []*workspaceModule{readWorkspaceModule(workingDir, "."), readWorkspaceModule(workingDir, "lib")}
+	useWorkspace(modules)
+	// the workspace modules come from disk, they have no hash and are not looked up on the proxy
+	if missing := modules[0].module.missingSums(); len(missing) != 1 || missing[0].Path != "example.com/other" {
+		t.Errorf("got missing sums %v", missing)
+	}
+	if requirements := modules[0].module.directRequirements(); len(requirements) != 1 || requirements[0].Path != "example.com/other" {
+		t.Errorf("got direct requirements %v", requirements)
+	}
+}
+
+func TestModuleCommands(t *testing.T) {
+	root, nested := &workspaceModule{dir: "."}, &workspaceModule{dir: "api"}
+	if root.stepName("go lint") != "go lint" || nested.stepName("go lint") != "go lint api" {
+		t.Errorf("got step names '%s' and '%s'", root.stepName("go lint"), nested.stepName("go lint"))
+	}
+	if !reflect.DeepEqual(nested.commands("go vet ./..."), []string{"cd api", "go vet ./..."}) || nested.cli("go vet ./...") != "cd api && go vet ./..." {
+		t.Errorf("got commands %v and cli '%s'", nested.commands("go vet ./..."), nested.cli("go vet ./..."))
+	}
+}
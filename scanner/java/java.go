@@ -2,8 +2,10 @@ package java
 
 import (
 	"context"
+	"encoding/xml"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 
 	"github.com/tphoney/best_practice/outputter"
@@ -17,12 +19,25 @@ import (
 	"golang.org/x/exp/slices"
 )
 
+var (
+	// javaVersionPattern is a plain java release, eg '17' or '1.8'
+	javaVersionPattern    = regexp.MustCompile(`^\d+(\.\d+)?$`)
+	gradleVersionPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`JavaLanguageVersion\.of\(\s*(\d+)\s*\)`),
+		regexp.MustCompile(`sourceCompatibility\s*=\s*['"]?(?:JavaVersion\.VERSION_)?([\d._]+)`),
+	}
+	gradleBuildFiles = []string{"build.gradle", "build.gradle.kts"}
+)
+
 type scannerConfig struct {
 	name             string
 	description      string
 	workingDirectory string
 	checksToRun      []string
 	runAll           bool
+	javaVersion      string
+	javaVersions     []string
+	droneContext     *dronescanner.ConfigContext
 }
 
 const (
@@ -31,6 +46,7 @@ const (
 	bazelBuildFile      = "BUILD.bazel"
 	gradleSettingsFile  = "settings.gradle"
 	mavenFolderLocation = ".mvn"
+	pomFile             = "pom.xml"
 
 	Name         = scanner.JavaScannerName
 	BuildCheck   = "Java build"
@@ -71,6 +87,7 @@ func (sc *scannerConfig) Scan(ctx context.Context, requestedOutputs []string) (r
 		// nothing to see here, lets leave
 		return returnVal, nil
 	}
+	sc.javaVersion, sc.javaVersions = readJavaVersions(sc.workingDirectory)
 	// check for test folders
 	testMatches, err := scanner.FindMatchingFolders(sc.workingDirectory, "test")
 	if err != nil || len(testMatches) == 0 {
@@ -117,10 +134,13 @@ func (sc *scannerConfig) Scan(ctx context.Context, requestedOutputs []string) (r
 		returnVal = append(returnVal, harnessProductResult)
 	}
 	// check for the various build systems
-	if sc.runAll || slices.Contains(requestedOutputs, BuildCheck) {
+	if sc.runAll || slices.Contains(requestedOutputs, BuildCheck) || slices.Contains(requestedOutputs, TestCheck) {
+		// each build system gives a test and a build scanlet, only keep the requested ones
 		_, outputResults := sc.buildCheck()
-		if len(outputResults) > 0 {
-			returnVal = append(returnVal, outputResults...)
+		for i := range outputResults {
+			if sc.runAll || slices.Contains(requestedOutputs, outputResults[i].Name) {
+				returnVal = append(returnVal, outputResults[i])
+			}
 		}
 	}
 	// check for android
@@ -139,6 +159,7 @@ func (sc *scannerConfig) Scan(ctx context.Context, requestedOutputs []string) (r
 					Build: buildmaker.Build{
 						Name:     "android sdk",
 						Image:    "androidsdk/android-31",
+						Phase:    buildmaker.PhaseBuild,
 						Commands: []string{"sdkmanager --list"},
 					},
 				},
@@ -161,7 +182,7 @@ func (sc *scannerConfig) buildCheck() (buildType []string, outputResults []types
 	_, err := os.Stat(filepath.Join(sc.workingDirectory, bazelBuildFile))
 	if err == nil {
 		testResult := types.Scanlet{
-			Name:           BuildCheck,
+			Name:           TestCheck,
 			ScannerFamily:  Name,
 			Description:    "run tests",
 			OutputRenderer: buildmaker.Name,
@@ -169,6 +190,7 @@ func (sc *scannerConfig) buildCheck() (buildType []string, outputResults []types
 				Build: buildmaker.Build{
 					Name:     "test",
 					Image:    "google/bazel",
+					Phase:    buildmaker.PhaseTest,
 					Commands: []string{"bazel test"},
 				},
 			},
@@ -183,6 +205,7 @@ func (sc *scannerConfig) buildCheck() (buildType []string, outputResults []types
 				Build: buildmaker.Build{
 					Name:     "bazel build",
 					Image:    "google/bazel",
+					Phase:    buildmaker.PhaseBuild,
 					Commands: []string{"bazel build"},
 				},
 			},
@@ -193,15 +216,21 @@ func (sc *scannerConfig) buildCheck() (buildType []string, outputResults []types
 	_, err = os.Stat(filepath.Join(sc.workingDirectory, mavenFolderLocation))
 	if err == nil {
 		testResult := types.Scanlet{
-			Name:           BuildCheck,
+			Name:           TestCheck,
 			ScannerFamily:  Name,
 			Description:    "run tests",
 			OutputRenderer: buildmaker.Name,
 			Spec: buildmaker.OutputFields{
 				Build: buildmaker.Build{
-					Name:     "test",
-					Image:    "maven",
-					Commands: []string{"mvn test"},
+					Name:             "test",
+					Image:            "maven",
+					Language:         buildmaker.LanguageJava,
+					LanguageVersion:  sc.javaVersion,
+					LanguageVersions: sc.javaVersions,
+					Phase:            buildmaker.PhaseTest,
+					Cache:            buildmaker.CacheMaven,
+					Commands:         []string{"mvn test"},
+					TestReports:      []string{"**/target/surefire-reports/*.xml"},
 				},
 			},
 		}
@@ -213,9 +242,14 @@ func (sc *scannerConfig) buildCheck() (buildType []string, outputResults []types
 			OutputRenderer: buildmaker.Name,
 			Spec: buildmaker.OutputFields{
 				Build: buildmaker.Build{
-					Name:     "maven build",
-					Image:    "maven",
-					Commands: []string{"mvn clean install"},
+					Name:             "maven build",
+					Image:            "maven",
+					Language:         buildmaker.LanguageJava,
+					LanguageVersion:  sc.javaVersion,
+					LanguageVersions: sc.javaVersions,
+					Phase:            buildmaker.PhaseBuild,
+					Cache:            buildmaker.CacheMaven,
+					Commands:         []string{"mvn clean install"},
 				},
 			},
 		}
@@ -226,15 +260,21 @@ func (sc *scannerConfig) buildCheck() (buildType []string, outputResults []types
 	_, err = os.Stat(filepath.Join(sc.workingDirectory, gradleSettingsFile))
 	if err == nil {
 		testResult := types.Scanlet{
-			Name:           BuildCheck,
+			Name:           TestCheck,
 			ScannerFamily:  Name,
 			Description:    "run tests",
 			OutputRenderer: buildmaker.Name,
 			Spec: buildmaker.OutputFields{
 				Build: buildmaker.Build{
-					Name:     "test",
-					Image:    "gradle",
-					Commands: []string{"./gradlew test"},
+					Name:             "test",
+					Image:            "gradle",
+					Language:         buildmaker.LanguageJava,
+					LanguageVersion:  sc.javaVersion,
+					LanguageVersions: sc.javaVersions,
+					Phase:            buildmaker.PhaseTest,
+					Cache:            buildmaker.CacheGradle,
+					Commands:         []string{"./gradlew test"},
+					TestReports:      []string{"**/build/test-results/test/*.xml"},
 				},
 			},
 		}
@@ -246,9 +286,14 @@ func (sc *scannerConfig) buildCheck() (buildType []string, outputResults []types
 			OutputRenderer: buildmaker.Name,
 			Spec: buildmaker.OutputFields{
 				Build: buildmaker.Build{
-					Name:     "gradle build",
-					Image:    "gradle",
-					Commands: []string{"./gradlew clean build"},
+					Name:             "gradle build",
+					Image:            "gradle",
+					Language:         buildmaker.LanguageJava,
+					LanguageVersion:  sc.javaVersion,
+					LanguageVersions: sc.javaVersions,
+					Phase:            buildmaker.PhaseBuild,
+					Cache:            buildmaker.CacheGradle,
+					Commands:         []string{"./gradlew clean build"},
 				},
 			},
 		}
@@ -259,15 +304,19 @@ func (sc *scannerConfig) buildCheck() (buildType []string, outputResults []types
 	_, err = os.Stat(filepath.Join(sc.workingDirectory, antBuildFile))
 	if err == nil {
 		testResult := types.Scanlet{
-			Name:           BuildCheck,
+			Name:           TestCheck,
 			ScannerFamily:  Name,
 			Description:    "run tests",
 			OutputRenderer: buildmaker.Name,
 			Spec: buildmaker.OutputFields{
 				Build: buildmaker.Build{
-					Name:     "test",
-					Image:    "frekele/ant",
-					Commands: []string{"ant -buildfile build.xml test"},
+					Name:             "test",
+					Image:            "frekele/ant",
+					Language:         buildmaker.LanguageJava,
+					LanguageVersion:  sc.javaVersion,
+					LanguageVersions: sc.javaVersions,
+					Phase:            buildmaker.PhaseTest,
+					Commands:         []string{"ant -buildfile build.xml test"},
 				},
 			},
 		}
@@ -279,9 +328,13 @@ func (sc *scannerConfig) buildCheck() (buildType []string, outputResults []types
 			OutputRenderer: buildmaker.Name,
 			Spec: buildmaker.OutputFields{
 				Build: buildmaker.Build{
-					Name:     "ant build",
-					Image:    "frekele/ant",
-					Commands: []string{"ant -buildfile build.xml"},
+					Name:             "ant build",
+					Image:            "frekele/ant",
+					Language:         buildmaker.LanguageJava,
+					LanguageVersion:  sc.javaVersion,
+					LanguageVersions: sc.javaVersions,
+					Phase:            buildmaker.PhaseBuild,
+					Commands:         []string{"ant -buildfile build.xml"},
 				},
 			},
 		}
@@ -292,7 +345,7 @@ func (sc *scannerConfig) buildCheck() (buildType []string, outputResults []types
 }
 
 func (sc *scannerConfig) droneCheck(hasAndroid bool) (outputResults []types.Scanlet, err error) {
-	pipelines, err := dronescanner.ReadDroneFile(sc.workingDirectory, dronescanner.DroneFileLocation)
+	pipelines, err := dronescanner.ReadDroneConfig(sc.workingDirectory, sc.droneContext)
 	if err != nil {
 		return outputResults, err
 	}
@@ -338,6 +391,7 @@ func (sc *scannerConfig) droneCheck(hasAndroid bool) (outputResults []types.Scan
 				Description:    "run bazel tests",
 				OutputRenderer: outputter.DroneBuildAnalysis,
 				Spec: dronebuildanalysis.OutputFields{
+					PipelineName: pipelines[i].Name,
 					RawYaml: `
   - name: test
     image: google/bazel
@@ -354,6 +408,7 @@ func (sc *scannerConfig) droneCheck(hasAndroid bool) (outputResults []types.Scan
 				Description:    "run bazel build",
 				OutputRenderer: outputter.DroneBuildAnalysis,
 				Spec: dronebuildanalysis.OutputFields{
+					PipelineName: pipelines[i].Name,
 					RawYaml: `
   - name: build
     image: google/bazel
@@ -370,6 +425,7 @@ func (sc *scannerConfig) droneCheck(hasAndroid bool) (outputResults []types.Scan
 				Description:    "run maven test",
 				OutputRenderer: outputter.DroneBuildAnalysis,
 				Spec: dronebuildanalysis.OutputFields{
+					PipelineName: pipelines[i].Name,
 					RawYaml: `
     - name: test
       image: maven
@@ -386,6 +442,7 @@ func (sc *scannerConfig) droneCheck(hasAndroid bool) (outputResults []types.Scan
 				Description:    "run maven build",
 				OutputRenderer: outputter.DroneBuildAnalysis,
 				Spec: dronebuildanalysis.OutputFields{
+					PipelineName: pipelines[i].Name,
 					RawYaml: `
     - name: build
       image: maven
@@ -402,6 +459,7 @@ func (sc *scannerConfig) droneCheck(hasAndroid bool) (outputResults []types.Scan
 				Description:    "run gradle test",
 				OutputRenderer: outputter.DroneBuildAnalysis,
 				Spec: dronebuildanalysis.OutputFields{
+					PipelineName: pipelines[i].Name,
 					RawYaml: `
     - name: test
       image: gradle/gradle
@@ -418,6 +476,7 @@ func (sc *scannerConfig) droneCheck(hasAndroid bool) (outputResults []types.Scan
 				Description:    "run gradle build",
 				OutputRenderer: outputter.DroneBuildAnalysis,
 				Spec: dronebuildanalysis.OutputFields{
+					PipelineName: pipelines[i].Name,
 					RawYaml: `
     - name: build
       image: gradle/gradle
@@ -434,6 +493,7 @@ func (sc *scannerConfig) droneCheck(hasAndroid bool) (outputResults []types.Scan
 				Description:    "run android tests and builds with the android sdk",
 				OutputRenderer: outputter.DroneBuildAnalysis,
 				Spec: dronebuildanalysis.OutputFields{
+					PipelineName: pipelines[i].Name,
 					RawYaml: `
     - name: build
       image: android/sdk
@@ -447,3 +507,84 @@ func (sc *scannerConfig) droneCheck(hasAndroid bool) (outputResults []types.Scan
 
 	return outputResults, err
 }
+
+// readJavaVersions returns the java release from the maven pom or the gradle toolchain, and every release the project
+// targets, eg gradle tasks for different toolchains. A pom only has the release of the project itself.
+func readJavaVersions(workingDirectory string) (version string, versions []string) {
+	if content, err := os.ReadFile(filepath.Join(workingDirectory, pomFile)); err == nil {
+		if version = readPomVersion(content); version != "" {
+			versions = append(versions, version)
+		}
+	}
+	for _, buildFile := range gradleBuildFiles {
+		content, err := os.ReadFile(filepath.Join(workingDirectory, buildFile))
+		if err != nil {
+			continue
+		}
+		for _, pattern := range gradleVersionPatterns {
+			for _, match := range pattern.FindAllStringSubmatch(string(content), -1) {
+				// JavaVersion.VERSION_1_8 is 1.8
+				found := strings.ReplaceAll(match[1], "_", ".")
+				if version == "" {
+					version = found
+				}
+				versions = append(versions, found)
+			}
+		}
+	}
+	return version, scanner.SortVersions(versions)
+}
+
+type (
+	// pomProject is the part of a pom that sets the java release, plugins other than the compiler are ignored.
+	pomProject struct {
+		Properties pomProperties `xml:"properties"`
+		Plugins    []pomPlugin   `xml:"build>plugins>plugin"`
+		Managed    []pomPlugin   `xml:"build>pluginManagement>plugins>plugin"`
+	}
+
+	pomProperties struct {
+		Release     string `xml:"maven.compiler.release"`
+		Source      string `xml:"maven.compiler.source"`
+		JavaVersion string `xml:"java.version"`
+	}
+
+	pomPlugin struct {
+		ArtifactID    string `xml:"artifactId"`
+		Configuration struct {
+			Release string `xml:"release"`
+			Source  string `xml:"source"`
+		} `xml:"configuration"`
+	}
+)
+
+// readPomVersion returns the release the compiler plugin builds for, the explicit release comes before the source
+// level. Values can be a property, eg '${java.version}'.
+func readPomVersion(content []byte) string {
+	var project pomProject
+	if err := xml.Unmarshal(content, &project); err != nil {
+		return ""
+	}
+	properties := map[string]string{
+		"maven.compiler.release": project.Properties.Release,
+		"maven.compiler.source":  project.Properties.Source,
+		"java.version":           project.Properties.JavaVersion,
+	}
+	candidates := []string{}
+	for _, plugin := range append(project.Plugins, project.Managed...) {
+		if plugin.ArtifactID == "maven-compiler-plugin" {
+			candidates = append(candidates, plugin.Configuration.Release, plugin.Configuration.Source)
+		}
+	}
+	candidates = append(candidates, project.Properties.Release, project.Properties.Source, project.Properties.JavaVersion)
+	for _, candidate := range candidates {
+		candidate = strings.TrimSpace(candidate)
+		if strings.HasPrefix(candidate, "${") && strings.HasSuffix(candidate, "}") {
+			candidate = strings.TrimSpace(properties[candidate[2:len(candidate)-1]])
+		}
+		if javaVersionPattern.MatchString(candidate) {
+			return candidate
+		}
+	}
+	return ""
+}
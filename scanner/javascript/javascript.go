@@ -6,7 +6,9 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/Masterminds/semver"
 	"github.com/tphoney/best_practice/outputter"
 
 	"github.com/tphoney/best_practice/outputter/buildmaker"
@@ -23,6 +25,23 @@ type scannerConfig struct {
 	workingDirectory string
 	checksToRun      []string
 	runAll           bool
+	nodeVersion      string
+	nodeVersions     []string
+	droneContext     *dronescanner.ConfigContext
+	// now is the time the node releases are checked against for their end of life
+	now func() time.Time
+}
+
+// nodeReleases are the node lts releases and the end of their support, oldest first. Releases past their end of life
+// are not tested against, see https://github.com/nodejs/release#release-schedule.
+var nodeReleases = []struct {
+	major string
+	eol   time.Time
+}{
+	{"18", time.Date(2025, time.April, 30, 0, 0, 0, 0, time.UTC)},
+	{"20", time.Date(2026, time.April, 30, 0, 0, 0, 0, time.UTC)},
+	{"22", time.Date(2027, time.April, 30, 0, 0, 0, 0, time.UTC)},
+	{"24", time.Date(2028, time.April, 30, 0, 0, 0, 0, time.UTC)},
 }
 
 const (
@@ -32,7 +51,9 @@ const (
 	TestCheck       = "Javascript test"
 	LintCheck       = "Javascript lint"
 	DroneCheck      = "Javascript Drone build"
-	nodeVersion     = "18"
+	nvmrcLocation   = ".nvmrc"
+	// defaultNodeVersion is used when the project does not declare one
+	defaultNodeVersion = "18"
 )
 
 func New(opts ...Option) (types.Scanner, error) {
@@ -40,6 +61,7 @@ func New(opts ...Option) (types.Scanner, error) {
 	sc.name = Name
 	sc.description = "checks for various javascript related best practices"
 	sc.runAll = true
+	sc.now = time.Now
 	// apply options
 	for _, opt := range opts {
 		opt(sc)
@@ -77,6 +99,7 @@ func (sc *scannerConfig) Scan(ctx context.Context, requestedChecks []string) (re
 	} else {
 		return returnVal, err
 	}
+	sc.nodeVersion, sc.nodeVersions = readNodeVersions(sc.workingDirectory, packageStruct, sc.now())
 	if sc.runAll || slices.Contains(requestedChecks, TestCheck) {
 		match, outputResults := sc.testCheck(scriptMap)
 		if match {
@@ -113,9 +136,14 @@ func (sc *scannerConfig) buildCheck(scriptMap map[string]interface{}) (match boo
 			OutputRenderer: buildmaker.Name,
 			Spec: buildmaker.OutputFields{
 				Build: buildmaker.Build{
-					Name:     "run npm build",
-					Image:    fmt.Sprintf("node:%s-alpine", nodeVersion),
-					Commands: []string{"npm run build"},
+					Name:             "run npm build",
+					Phase:            buildmaker.PhaseBuild,
+					Cache:            buildmaker.CacheNPM,
+					Image:            fmt.Sprintf("node:%s-alpine", sc.nodeVersion),
+					Language:         buildmaker.LanguageNode,
+					LanguageVersion:  sc.nodeVersion,
+					LanguageVersions: sc.nodeVersions,
+					Commands:         []string{"npm run build"},
 				},
 				CLI:     "npm run build",
 				HelpURL: "https://docs.npmjs.com/misc/build",
@@ -136,9 +164,14 @@ func (sc *scannerConfig) lintCheck(scriptMap map[string]interface{}) (match bool
 			OutputRenderer: buildmaker.Name,
 			Spec: buildmaker.OutputFields{
 				Build: buildmaker.Build{
-					Name:     "run npm lint",
-					Image:    fmt.Sprintf("node:%s-alpine", nodeVersion),
-					Commands: []string{"npm run lint"},
+					Name:             "run npm lint",
+					Phase:            buildmaker.PhaseLint,
+					Cache:            buildmaker.CacheNPM,
+					Image:            fmt.Sprintf("node:%s-alpine", sc.nodeVersion),
+					Language:         buildmaker.LanguageNode,
+					LanguageVersion:  sc.nodeVersion,
+					LanguageVersions: sc.nodeVersions,
+					Commands:         []string{"npm run lint"},
 				},
 				CLI:     "npm run lint",
 				HelpURL: "https://docs.npmjs.com/misc/lint",
@@ -159,9 +192,14 @@ func (sc *scannerConfig) testCheck(scriptMap map[string]interface{}) (match bool
 			OutputRenderer: buildmaker.Name,
 			Spec: buildmaker.OutputFields{
 				Build: buildmaker.Build{
-					Name:     "run npm test",
-					Image:    fmt.Sprintf("node:%s-alpine", nodeVersion),
-					Commands: []string{"npm run test"},
+					Name:             "run npm test",
+					Phase:            buildmaker.PhaseTest,
+					Cache:            buildmaker.CacheNPM,
+					Image:            fmt.Sprintf("node:%s-alpine", sc.nodeVersion),
+					Language:         buildmaker.LanguageNode,
+					LanguageVersion:  sc.nodeVersion,
+					LanguageVersions: sc.nodeVersions,
+					Commands:         []string{"npm run test"},
 				},
 				CLI:     "npm run test",
 				HelpURL: "https://docs.npmjs.com/misc/test",
@@ -175,7 +213,7 @@ func (sc *scannerConfig) testCheck(scriptMap map[string]interface{}) (match bool
 }
 
 func (sc *scannerConfig) droneCheck() (outputResults []types.Scanlet, err error) {
-	pipelines, err := dronescanner.ReadDroneFile(sc.workingDirectory, dronescanner.DroneFileLocation)
+	pipelines, err := dronescanner.ReadDroneConfig(sc.workingDirectory, sc.droneContext)
 	if err != nil {
 		return outputResults, err
 	}
@@ -205,12 +243,13 @@ func (sc *scannerConfig) droneCheck() (outputResults []types.Scanlet, err error)
 				Description:    fmt.Sprintf("pipeline '%s' should run npm build", pipelines[i].Name),
 				OutputRenderer: outputter.DroneBuildAnalysis,
 				Spec: dronebuildanalysis.OutputFields{
-					HelpURL: "https://docs.npmjs.com/misc/build",
+					PipelineName: pipelines[i].Name,
+					HelpURL:      "https://docs.npmjs.com/misc/build",
 					RawYaml: fmt.Sprintf(`
     - name: run npm build
       image: node:%s-alpine
       commands:
-        - npm run build`, nodeVersion),
+        - npm run build`, sc.nodeVersion),
 				},
 			}
 			outputResults = append(outputResults, bestPracticeResult)
@@ -222,12 +261,13 @@ func (sc *scannerConfig) droneCheck() (outputResults []types.Scanlet, err error)
 				Description:    fmt.Sprintf("pipeline '%s' should run npm lint", pipelines[i].Name),
 				OutputRenderer: outputter.DroneBuildAnalysis,
 				Spec: dronebuildanalysis.OutputFields{
-					HelpURL: "https://docs.npmjs.com/misc/lint",
+					PipelineName: pipelines[i].Name,
+					HelpURL:      "https://docs.npmjs.com/misc/lint",
 					RawYaml: fmt.Sprintf(`
-  - name: run npm build
+  - name: run npm lint
     image: node:%s-alpine
     commands:
-    - npm run lint`, nodeVersion),
+    - npm run lint`, sc.nodeVersion),
 				},
 			}
 			outputResults = append(outputResults, bestPracticeResult)
@@ -239,12 +279,13 @@ func (sc *scannerConfig) droneCheck() (outputResults []types.Scanlet, err error)
 				Description:    fmt.Sprintf("pipeline '%s' should run npm test", pipelines[i].Name),
 				OutputRenderer: outputter.DroneBuildAnalysis,
 				Spec: dronebuildanalysis.OutputFields{
-					HelpURL: "https://docs.npmjs.com/misc/test",
+					PipelineName: pipelines[i].Name,
+					HelpURL:      "https://docs.npmjs.com/misc/test",
 					RawYaml: fmt.Sprintf(`
-  - name: run npm build
+  - name: run npm test
     image: node:%s-alpine
     commands:
-      - npm run test`, nodeVersion),
+      - npm run test`, sc.nodeVersion),
 				},
 			}
 			outputResults = append(outputResults, bestPracticeResult)
@@ -252,3 +293,33 @@ func (sc *scannerConfig) droneCheck() (outputResults []types.Scanlet, err error)
 	}
 	return outputResults, err
 }
+
+// readNodeVersions returns the node version from .nvmrc or the engines field of package.json. If engines allows more
+// than one of the node releases supported at the time, they are all returned so the build can be run against each, and
+// the version is the oldest of them.
+func readNodeVersions(workingDirectory string, packageStruct map[string]interface{}, now time.Time) (version string, versions []string) {
+	engines, _ := packageStruct["engines"].(map[string]interface{})
+	constraint, _ := engines["node"].(string)
+	if constraints, err := semver.NewConstraint(constraint); err == nil {
+		for _, release := range nodeReleases {
+			if now.After(release.eol) {
+				continue
+			}
+			oldest, _ := semver.NewVersion(release.major + ".0.0")
+			newest, _ := semver.NewVersion(release.major + ".999.0")
+			if constraints.Check(oldest) || constraints.Check(newest) {
+				versions = append(versions, release.major)
+			}
+		}
+	}
+	if version = scanner.ReadVersionFile(filepath.Join(workingDirectory, nvmrcLocation)); version != "" {
+		return version, versions
+	}
+	if len(versions) > 0 {
+		return versions[0], versions
+	}
+	if version = scanner.FindVersion(constraint); version != "" {
+		return version, versions
+	}
+	return defaultNodeVersion, versions
+}
@@ -0,0 +1,45 @@
+package javascript
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReadNodeVersions(t *testing.T) {
+	// node 18 has reached its end of life, 20 has not
+	now := time.Date(2025, time.June, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		engine, version string
+		versions        []string
+	}{
+		{engine: "", version: defaultNodeVersion},
+		{engine: ">=16", version: "20", versions: []string{"20", "22", "24"}},
+		{engine: "^18 || ^20", version: "20", versions: []string{"20"}},
+		{engine: "^22.1.0", version: "22", versions: []string{"22"}},
+		// only end of life releases are allowed
+		{engine: "^16", version: "16"},
+	}
+	for _, test := range tests {
+		packageStruct := map[string]interface{}{"engines": map[string]interface{}{"node": test.engine}}
+		version, versions := readNodeVersions(t.TempDir(), packageStruct, now)
+		if version != test.version || !reflect.DeepEqual(versions, test.versions) {
+			t.Errorf("readNodeVersions(%s) = '%s' %v, want '%s' %v", test.engine, version, versions, test.version, test.versions)
+		}
+	}
+}
+
+func TestReadNodeVersionsNvmrc(t *testing.T) {
+	workingDirectory := t.TempDir()
+	if err := os.WriteFile(filepath.Join(workingDirectory, nvmrcLocation), []byte("v20.11.1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	packageStruct := map[string]interface{}{"engines": map[string]interface{}{"node": ">=20"}}
+	now := time.Date(2025, time.June, 1, 0, 0, 0, 0, time.UTC)
+	// .nvmrc is the version the project is developed with
+	if version, versions := readNodeVersions(workingDirectory, packageStruct, now); version != "20.11.1" || len(versions) != 3 {
+		t.Errorf("got version '%s' %v", version, versions)
+	}
+}
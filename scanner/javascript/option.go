@@ -1,6 +1,9 @@
 package javascript
 
-import "golang.org/x/exp/slices"
+import (
+	"github.com/tphoney/best_practice/scanner/dronescanner"
+	"golang.org/x/exp/slices"
+)
 
 type Option func(*scannerConfig)
 
@@ -27,3 +30,10 @@ func WithWorkingDirectory(i string) Option {
 		p.workingDirectory = i
 	}
 }
+
+// WithDroneContext sets the build and repository values used to evaluate starlark and jsonnet drone files.
+func WithDroneContext(i *dronescanner.ConfigContext) Option {
+	return func(p *scannerConfig) {
+		p.droneContext = i
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/tphoney/best_practice/outputter"
@@ -13,6 +14,7 @@ import (
 	"github.com/tphoney/best_practice/scanner/dronescanner"
 	"github.com/tphoney/best_practice/types"
 	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
 )
 
 type scannerConfig struct {
@@ -21,15 +23,26 @@ type scannerConfig struct {
 	workingDirectory string
 	checksToRun      []string
 	runAll           bool
+	rubyVersions     []string
+	droneContext     *dronescanner.ConfigContext
 }
 
+// rubyVersionKeys are the keys that list ruby versions in travis and github actions configuration.
+var rubyVersionKeys = []string{"rvm", "ruby", "ruby-version"}
+
 const (
-	Name        = scanner.RubyScannerName
-	BuildCheck  = "Ruby build"
-	TestCheck   = "Ruby test"
-	LintCheck   = "Ruby lint"
-	DroneCheck  = "Ruby Drone build"
-	rubyVersion = "latest"
+	Name       = scanner.RubyScannerName
+	BuildCheck = "Ruby build"
+	TestCheck  = "Ruby test"
+	LintCheck  = "Ruby lint"
+	DroneCheck = "Ruby Drone build"
+
+	rubyVersionLocation = ".ruby-version"
+	gemfileLocation     = "Gemfile"
+	travisLocation      = ".travis.yml"
+	workflowsLocation   = ".github/workflows"
+	// defaultRubyVersion is used when the project does not declare one
+	defaultRubyVersion = "latest"
 )
 
 func New(opts ...Option) (types.Scanner, error) {
@@ -64,6 +77,8 @@ func (sc *scannerConfig) Scan(ctx context.Context, requestedChecks []string) (re
 		// nothing to see here, lets leave
 		return returnVal, nil
 	}
+	rubyVersion := readRubyVersion(sc.workingDirectory)
+	sc.rubyVersions = readCIRubyVersions(sc.workingDirectory)
 	if sc.runAll || slices.Contains(requestedChecks, TestCheck) {
 		_, testpathErr := os.Stat(fmt.Sprintf("%s/spec", sc.workingDirectory))
 		if testpathErr == nil {
@@ -74,9 +89,14 @@ func (sc *scannerConfig) Scan(ctx context.Context, requestedChecks []string) (re
 				OutputRenderer: buildmaker.Name,
 				Spec: buildmaker.OutputFields{
 					Build: buildmaker.Build{
-						Name:     "run rspec",
-						Image:    fmt.Sprintf("ruby:%s", rubyVersion),
-						Commands: []string{"bundle install", "bundle exec rspec spec"},
+						Name:             "run rspec",
+						Phase:            buildmaker.PhaseTest,
+						Cache:            buildmaker.CacheBundler,
+						Image:            fmt.Sprintf("ruby:%s", rubyVersion),
+						Language:         buildmaker.LanguageRuby,
+						LanguageVersion:  rubyVersion,
+						LanguageVersions: sc.rubyVersions,
+						Commands:         []string{"bundle install", "bundle exec rspec spec"},
 					},
 					CLI:     "bundle exec rspec spec",
 					HelpURL: "https://docs.npmjs.com/misc/test",
@@ -117,9 +137,14 @@ func (sc *scannerConfig) buildCheck(rubyVersion string) (match bool, outputResul
 			OutputRenderer: buildmaker.Name,
 			Spec: buildmaker.OutputFields{
 				Build: buildmaker.Build{
-					Name:     "build with rake",
-					Image:    fmt.Sprintf("ruby:%s", rubyVersion),
-					Commands: []string{"bundle install", "bundle exec rake"},
+					Name:             "build with rake",
+					Phase:            buildmaker.PhaseBuild,
+					Cache:            buildmaker.CacheBundler,
+					Image:            fmt.Sprintf("ruby:%s", rubyVersion),
+					Language:         buildmaker.LanguageRuby,
+					LanguageVersion:  rubyVersion,
+					LanguageVersions: sc.rubyVersions,
+					Commands:         []string{"bundle install", "bundle exec rake"},
 				},
 				CLI:     "bundle exec rake build",
 				HelpURL: "https://bundler.io/man/bundle-exec.1.html",
@@ -141,9 +166,14 @@ func (sc *scannerConfig) lintCheck(rubyVersion string) (match bool, outputResult
 			OutputRenderer: buildmaker.Name,
 			Spec: buildmaker.OutputFields{
 				Build: buildmaker.Build{
-					Name:     "run rubocop",
-					Image:    fmt.Sprintf("ruby:%s", rubyVersion),
-					Commands: []string{"bundle install", "bundle exec rubocop"},
+					Name:             "run rubocop",
+					Phase:            buildmaker.PhaseLint,
+					Cache:            buildmaker.CacheBundler,
+					Image:            fmt.Sprintf("ruby:%s", rubyVersion),
+					Language:         buildmaker.LanguageRuby,
+					LanguageVersion:  rubyVersion,
+					LanguageVersions: sc.rubyVersions,
+					Commands:         []string{"bundle install", "bundle exec rubocop"},
 				},
 				CLI:     "rubocop",
 				HelpURL: "https://docs.rubygems.org/rubocop",
@@ -155,8 +185,10 @@ func (sc *scannerConfig) lintCheck(rubyVersion string) (match bool, outputResult
 	return false, outputResults
 }
 
+// droneCheck suggests steps without a PipelineName, so fix mode does not apply them, the snippets are still node steps
+// and need rewriting for ruby before they can be added to a drone file.
 func (sc *scannerConfig) droneCheck(nodeVersion string) (outputResults []types.Scanlet, err error) {
-	pipelines, err := dronescanner.ReadDroneFile(sc.workingDirectory, dronescanner.DroneFileLocation)
+	pipelines, err := dronescanner.ReadDroneConfig(sc.workingDirectory, sc.droneContext)
 	if err != nil {
 		return outputResults, err
 	}
@@ -233,3 +265,64 @@ func (sc *scannerConfig) droneCheck(nodeVersion string) (outputResults []types.S
 	}
 	return outputResults, err
 }
+
+// readRubyVersion returns the ruby version from .ruby-version or the ruby directive in the Gemfile.
+func readRubyVersion(workingDirectory string) string {
+	if version := scanner.ReadVersionFile(filepath.Join(workingDirectory, rubyVersionLocation)); version != "" {
+		return version
+	}
+	content, err := os.ReadFile(filepath.Join(workingDirectory, gemfileLocation))
+	if err != nil {
+		return defaultRubyVersion
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 1 && fields[0] == "ruby" {
+			if version := scanner.FindVersion(strings.Join(fields[1:], " ")); version != "" {
+				return version
+			}
+		}
+	}
+	return defaultRubyVersion
+}
+
+// readCIRubyVersions returns the ruby versions an existing travis or github actions configuration tests against.
+func readCIRubyVersions(workingDirectory string) []string {
+	files := []string{filepath.Join(workingDirectory, travisLocation)}
+	for _, pattern := range []string{"*.yml", "*.yaml"} {
+		matches, _ := filepath.Glob(filepath.Join(workingDirectory, workflowsLocation, pattern))
+		files = append(files, matches...)
+	}
+	var versions []string
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		var document yaml.Node
+		if err = yaml.Unmarshal(content, &document); err != nil {
+			continue
+		}
+		versions = append(versions, findRubyVersions(&document)...)
+	}
+	return scanner.SortVersions(versions)
+}
+
+func findRubyVersions(node *yaml.Node) (versions []string) {
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if slices.Contains(rubyVersionKeys, key.Value) && value.Kind == yaml.SequenceNode {
+				for _, item := range value.Content {
+					if version := scanner.FindVersion(item.Value); version != "" {
+						versions = append(versions, version)
+					}
+				}
+			}
+		}
+	}
+	for _, child := range node.Content {
+		versions = append(versions, findRubyVersions(child)...)
+	}
+	return versions
+}
@@ -4,10 +4,12 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/tphoney/best_practice/outputter"
 	"github.com/tphoney/best_practice/types"
 )
 
 const (
+	ComposeScannerName    = "Compose"
 	DockerScannerName     = "Docker"
 	DroneScannerName      = "Drone"
 	GolangScannerName     = "Golang"
@@ -29,5 +31,15 @@ func RunScanners(ctx context.Context, scannersToRun []types.Scanner, requestedOu
 
 func ListScannersNames() []string {
 	// run language scanners first, then scanners that may depend on them
-	return []string{GolangScannerName, JavaScannerName, JavascriptScannerName, RubyScannerName, DockerScannerName, DroneScannerName}
+	return []string{GolangScannerName, JavaScannerName, JavascriptScannerName, RubyScannerName, DockerScannerName, ComposeScannerName, DroneScannerName}
+}
+
+// SkippedCheck records a check that did not run, eg a check that needs the network in offline mode.
+func SkippedCheck(check, scannerFamily, reason string) types.Scanlet {
+	return types.Scanlet{
+		Name:           check,
+		ScannerFamily:  scannerFamily,
+		Description:    reason,
+		OutputRenderer: outputter.Skipped,
+	}
 }
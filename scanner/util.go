@@ -4,11 +4,15 @@ import (
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/Masterminds/semver"
 )
 
+var versionPattern = regexp.MustCompile(`\d+(\.\d+)*`)
+
 func FindMatchingFiles(workingDir, pattern string, ignoreHidden bool) ([]string, error) {
 	var matches []string
 	err := filepath.Walk(workingDir, func(path string, info os.FileInfo, err error) error {
@@ -85,3 +89,50 @@ func ReturnVersionObject(version string) (*semver.Version, error) {
 	}
 	return v, nil
 }
+
+// FindVersion returns the first version number in a version string or constraint, eg '>=18.2' or 'ruby-3.2.1'.
+func FindVersion(version string) string {
+	return versionPattern.FindString(version)
+}
+
+// ReadVersionFile returns the version in a file like .nvmrc or .ruby-version, it is empty if there is no version.
+func ReadVersionFile(filePath string) string {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return ""
+	}
+	return FindVersion(strings.SplitN(string(content), "\n", 2)[0]) //nolint:gomnd
+}
+
+// SortVersions removes duplicate and invalid versions, and sorts the rest oldest first.
+func SortVersions(versions []string) []string {
+	type parsed struct {
+		version  *semver.Version
+		original string
+	}
+	var found []parsed
+	for _, version := range versions {
+		v, err := semver.NewVersion(version)
+		if err != nil {
+			continue
+		}
+		duplicate := false
+		for i := range found {
+			if found[i].version.Equal(v) {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			found = append(found, parsed{version: v, original: version})
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		return found[i].version.LessThan(found[j].version)
+	})
+	sorted := make([]string, 0, len(found))
+	for i := range found {
+		sorted = append(sorted, found[i].original)
+	}
+	return sorted
+}